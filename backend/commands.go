@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/migrations"
+	"anonymous-messaging-backend/services"
+)
+
+const commandUsage = `用法:
+  server                          启动服务
+  server config check             校验配置并检查数据库和外部服务，输出中的密钥已隐藏
+  server migrate up [n]           执行未执行的迁移，指定 n 时最多执行 n 个
+  server migrate down [n]         回滚最近执行的 n 个迁移（默认1个）
+  server migrate status           查看迁移执行状态
+  server migrate force <version>  迁移失败并人工修复后，将记录标记为执行到 version`
+
+// runCommand 执行命令行子命令，返回进程退出码
+func runCommand(args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "config" && args[1] == "check":
+		return runConfigCheck()
+	case len(args) >= 2 && args[0] == "migrate":
+		return runMigrate(args[1:])
+	}
+
+	fmt.Fprintln(os.Stderr, commandUsage)
+	return 2
+}
+
+// runConfigCheck 校验配置、连接数据库并按当前模式检查短信、支付和开票服务
+func runConfigCheck() int {
+	cfg, err := config.Load()
+	for _, line := range cfg.Lines() {
+		fmt.Println("  " + line)
+	}
+
+	var validationErr *config.ValidationError
+	if errors.As(err, &validationErr) {
+		for _, problem := range validationErr.Problems {
+			fmt.Println("错误: " + problem)
+		}
+		return 1
+	}
+	for _, warning := range cfg.Warnings() {
+		fmt.Println("警告: " + warning)
+	}
+
+	config.App = cfg
+	db, err := config.OpenDatabase(cfg.Database)
+	if err != nil {
+		fmt.Printf("错误: 连接数据库失败: %v\n", err)
+		return 1
+	}
+	config.DB = db
+	fmt.Println("数据库连接正常")
+
+	failed := false
+	for _, check := range services.CheckIntegrations() {
+		if check.Error != nil {
+			failed = true
+			fmt.Printf("错误: %s (%s): %v\n", check.Name, check.Mode, check.Error)
+			continue
+		}
+		fmt.Printf("%s: %s\n", check.Name, check.Mode)
+	}
+	if failed {
+		return 1
+	}
+
+	fmt.Println("配置检查通过")
+	return 0
+}
+
+// runMigrate 执行迁移子命令，不会自动执行迁移以外的初始化
+func runMigrate(args []string) int {
+	var number int64
+	if len(args) > 2 || (args[0] == "force" && len(args) != 2) {
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n < 0 {
+			fmt.Fprintln(os.Stderr, commandUsage)
+			return 2
+		}
+		number = n
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("错误:", err)
+		return 1
+	}
+	db, err := config.OpenDatabase(cfg.Database)
+	if err != nil {
+		fmt.Printf("错误: 连接数据库失败: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		executed, err := migrations.Up(db, int(number))
+		for _, migration := range executed {
+			fmt.Printf("已执行 %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Println("错误:", err)
+			return 1
+		}
+		if len(executed) == 0 {
+			fmt.Println("数据库已是最新版本")
+		}
+	case "down":
+		reverted, err := migrations.Down(db, int(number))
+		for _, migration := range reverted {
+			fmt.Printf("已回滚 %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Println("错误:", err)
+			return 1
+		}
+	case "status":
+		statuses, err := migrations.Status(db)
+		if err != nil {
+			fmt.Println("错误:", err)
+			return 1
+		}
+		for _, status := range statuses {
+			state := "未执行"
+			switch {
+			case status.Dirty:
+				state = "未完成"
+			case status.Modified:
+				state = "已执行，文件已修改"
+			case status.Applied:
+				state = "已执行 " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s  %s\n", status.Version, status.Name, state)
+		}
+	case "force":
+		if err := migrations.Force(db, number); err != nil {
+			fmt.Println("错误:", err)
+			return 1
+		}
+		fmt.Printf("已将迁移记录标记为执行到 %d\n", number)
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 外部服务的运行模式：mock 本地模拟，live 调用真实服务
+const (
+	ModeMock = "mock"
+	ModeLive = "live"
+)
+
+const redactedValue = "******"
+
+// App 启动时加载的应用配置，由 main 在连接数据库前设置
+var App *AppConfig
+
+// AppConfig 应用配置，启动时从环境变量加载一次并校验
+type AppConfig struct {
+	Server   ServerConfig
+	Database DatabaseConfig
+	Auth     AuthConfig
+	Admin    AdminConfig
+	Account  AccountConfig
+	SMS      SMSConfig
+	Payment  PaymentConfig
+	Export   ExportConfig
+	Invoice  InvoiceConfig
+	Runtime  RuntimeConfig
+}
+
+type ServerConfig struct {
+	Port string
+}
+
+type DatabaseConfig struct {
+	Host        string
+	Port        string
+	User        string
+	Password    string
+	Name        string
+	AutoMigrate bool // 启动时自动执行迁移，生产环境应关闭并在部署前运行 migrate up
+}
+
+type AuthConfig struct {
+	JWTSecret       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+type AdminConfig struct {
+	JWTSecret         string
+	TokenTTL          time.Duration
+	BootstrapUsername string
+	BootstrapPassword string
+}
+
+type AccountConfig struct {
+	DeletionCoolingOff time.Duration
+}
+
+// SMSConfig 短信服务模式，阿里云密钥等属于运行时配置，可在后台修改
+type SMSConfig struct {
+	Mode        string // 为空时按是否配置了密钥自动选择
+	UplinkToken string
+}
+
+// PaymentConfig 微信支付模式，商户号等属于运行时配置，可在后台修改
+type PaymentConfig struct {
+	Mode string // 为空时按是否配置了商户号自动选择
+}
+
+type ExportConfig struct {
+	Dir     string
+	LinkTTL time.Duration
+	PDFFont string
+}
+
+type InvoiceConfig struct {
+	Provider string
+}
+
+type RuntimeConfig struct {
+	ConfigFile    string
+	EncryptionKey string
+}
+
+// ValidationError 配置校验失败，列出全部问题
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "配置校验失败: " + strings.Join(e.Problems, "; ")
+}
+
+// envLoader 读取环境变量并记录格式错误
+type envLoader struct {
+	problems []string
+}
+
+func (l *envLoader) problem(format string, args ...interface{}) {
+	l.problems = append(l.problems, fmt.Sprintf(format, args...))
+}
+
+func (l *envLoader) string(name, defaultValue string) string {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// duration 读取整数并乘以单位，min为允许的最小值
+func (l *envLoader) duration(name string, defaultValue int, min int, unit time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return time.Duration(defaultValue) * unit
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min {
+		l.problem("%s 必须是不小于%d的整数", name, min)
+		return time.Duration(defaultValue) * unit
+	}
+	return time.Duration(value) * unit
+}
+
+func (l *envLoader) bool(name string, defaultValue bool) bool {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		l.problem("%s 只能是 true 或 false", name)
+		return defaultValue
+	}
+	return value
+}
+
+func (l *envLoader) port(name, defaultValue string) string {
+	value := l.string(name, defaultValue)
+	if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+		l.problem("%s 不是有效的端口号", name)
+	}
+	return value
+}
+
+func (l *envLoader) mode(name string) string {
+	value := strings.ToLower(l.string(name, ""))
+	if value != "" && value != ModeMock && value != ModeLive {
+		l.problem("%s 只能是 %s 或 %s", name, ModeMock, ModeLive)
+		return ""
+	}
+	return value
+}
+
+// Load 从环境变量加载配置并校验，校验失败时同时返回已加载的配置和全部问题
+func Load() (*AppConfig, error) {
+	l := &envLoader{}
+
+	cfg := &AppConfig{
+		Server: ServerConfig{
+			Port: l.port("SERVER_PORT", "8081"),
+		},
+		Database: DatabaseConfig{
+			Host:        l.string("DB_HOST", ""),
+			Port:        l.port("DB_PORT", "3306"),
+			User:        l.string("DB_USER", ""),
+			Password:    os.Getenv("DB_PASSWORD"),
+			Name:        l.string("DB_NAME", ""),
+			AutoMigrate: l.bool("DB_AUTO_MIGRATE", false),
+		},
+		Auth: AuthConfig{
+			JWTSecret:       os.Getenv("JWT_SECRET"),
+			AccessTokenTTL:  l.duration("ACCESS_TOKEN_TTL_MINUTES", 15, 1, time.Minute),
+			RefreshTokenTTL: l.duration("REFRESH_TOKEN_TTL_DAYS", 30, 1, 24*time.Hour),
+		},
+		Admin: AdminConfig{
+			JWTSecret:         os.Getenv("ADMIN_JWT_SECRET"),
+			TokenTTL:          l.duration("ADMIN_TOKEN_TTL_HOURS", 8, 1, time.Hour),
+			BootstrapUsername: l.string("ADMIN_BOOTSTRAP_USERNAME", ""),
+			BootstrapPassword: os.Getenv("ADMIN_BOOTSTRAP_PASSWORD"),
+		},
+		Account: AccountConfig{
+			DeletionCoolingOff: l.duration("ACCOUNT_DELETION_COOLING_DAYS", 15, 0, 24*time.Hour),
+		},
+		SMS: SMSConfig{
+			Mode:        l.mode("SMS_MODE"),
+			UplinkToken: os.Getenv("SMS_UPLINK_TOKEN"),
+		},
+		Payment: PaymentConfig{
+			Mode: l.mode("PAYMENT_MODE"),
+		},
+		Export: ExportConfig{
+			Dir:     l.string("EXPORT_DIR", "exports"),
+			LinkTTL: l.duration("EXPORT_LINK_TTL_HOURS", 24, 1, time.Hour),
+			PDFFont: l.string("EXPORT_PDF_FONT", ""),
+		},
+		Invoice: InvoiceConfig{
+			Provider: l.string("INVOICE_PROVIDER", "fake"),
+		},
+		Runtime: RuntimeConfig{
+			ConfigFile:    l.string("CONFIG_FILE", ""),
+			EncryptionKey: os.Getenv("CONFIG_ENCRYPTION_KEY"),
+		},
+	}
+
+	cfg.validate(l)
+	if len(l.problems) > 0 {
+		return cfg, &ValidationError{Problems: l.problems}
+	}
+	return cfg, nil
+}
+
+// validate 检查字段之间的约束
+func (c *AppConfig) validate(l *envLoader) {
+	if c.Database.Host == "" {
+		l.problem("缺少 DB_HOST")
+	}
+	if c.Database.User == "" {
+		l.problem("缺少 DB_USER")
+	}
+	if c.Database.Name == "" {
+		l.problem("缺少 DB_NAME")
+	}
+
+	if c.Admin.JWTSecret != "" && c.Admin.JWTSecret == c.Auth.JWTSecret {
+		l.problem("ADMIN_JWT_SECRET 不能与 JWT_SECRET 相同")
+	}
+	if (c.Admin.BootstrapUsername == "") != (c.Admin.BootstrapPassword == "") {
+		l.problem("ADMIN_BOOTSTRAP_USERNAME 和 ADMIN_BOOTSTRAP_PASSWORD 需要同时配置")
+	}
+
+	if c.SMS.Mode == ModeLive && c.SMS.UplinkToken == "" {
+		l.problem("SMS_MODE=live 时必须配置 SMS_UPLINK_TOKEN")
+	}
+	if c.SMS.Mode == ModeLive || c.Payment.Mode == ModeLive {
+		if c.Auth.JWTSecret == "" {
+			l.problem("SMS_MODE=live 或 PAYMENT_MODE=live 时必须配置 JWT_SECRET")
+		}
+		if c.Admin.JWTSecret == "" {
+			l.problem("SMS_MODE=live 或 PAYMENT_MODE=live 时必须配置 ADMIN_JWT_SECRET")
+		}
+	}
+
+	if c.Export.PDFFont != "" {
+		if _, err := os.Stat(c.Export.PDFFont); err != nil {
+			l.problem("EXPORT_PDF_FONT 指定的字体文件不存在: %s", c.Export.PDFFont)
+		}
+	}
+	if c.Runtime.ConfigFile != "" {
+		if _, err := os.Stat(c.Runtime.ConfigFile); err != nil {
+			l.problem("CONFIG_FILE 指定的文件不存在: %s", c.Runtime.ConfigFile)
+		}
+	}
+}
+
+// Warnings 不影响启动但生产环境应当处理的配置
+func (c *AppConfig) Warnings() []string {
+	var warnings []string
+	if c.Auth.JWTSecret == "" {
+		warnings = append(warnings, "未配置 JWT_SECRET，使用随机密钥，服务重启后用户需要重新登录")
+	}
+	if c.Admin.JWTSecret == "" {
+		warnings = append(warnings, "未配置 ADMIN_JWT_SECRET，使用随机密钥，服务重启后管理员需要重新登录")
+	}
+	if c.SMS.UplinkToken == "" {
+		warnings = append(warnings, "未配置 SMS_UPLINK_TOKEN，上行短信推送将全部被拒绝")
+	}
+	if c.Runtime.EncryptionKey == "" {
+		warnings = append(warnings, "未配置 CONFIG_ENCRYPTION_KEY，不能在后台保存密钥类配置")
+	}
+	return warnings
+}
+
+// Redacted 返回隐藏了密钥的副本，用于日志和命令行输出
+func (c *AppConfig) Redacted() AppConfig {
+	redacted := *c
+	redacted.Database.Password = Redact(c.Database.Password)
+	redacted.Auth.JWTSecret = Redact(c.Auth.JWTSecret)
+	redacted.Admin.JWTSecret = Redact(c.Admin.JWTSecret)
+	redacted.Admin.BootstrapPassword = Redact(c.Admin.BootstrapPassword)
+	redacted.SMS.UplinkToken = Redact(c.SMS.UplinkToken)
+	redacted.Runtime.EncryptionKey = Redact(c.Runtime.EncryptionKey)
+	return redacted
+}
+
+// Lines 按 名称=值 列出配置，密钥已隐藏
+func (c *AppConfig) Lines() []string {
+	r := c.Redacted()
+	return []string{
+		"server.port=" + r.Server.Port,
+		fmt.Sprintf("database=%s@%s:%s/%s password=%s auto_migrate=%t", r.Database.User, r.Database.Host, r.Database.Port, r.Database.Name, r.Database.Password, r.Database.AutoMigrate),
+		fmt.Sprintf("auth.jwt_secret=%s access_ttl=%s refresh_ttl=%s", r.Auth.JWTSecret, r.Auth.AccessTokenTTL, r.Auth.RefreshTokenTTL),
+		fmt.Sprintf("admin.jwt_secret=%s token_ttl=%s bootstrap=%s", r.Admin.JWTSecret, r.Admin.TokenTTL, r.Admin.BootstrapUsername),
+		fmt.Sprintf("account.deletion_cooling_off=%s", r.Account.DeletionCoolingOff),
+		fmt.Sprintf("sms.mode=%s uplink_token=%s", modeLabel(r.SMS.Mode), r.SMS.UplinkToken),
+		fmt.Sprintf("payment.mode=%s", modeLabel(r.Payment.Mode)),
+		fmt.Sprintf("export.dir=%s link_ttl=%s pdf_font=%s", r.Export.Dir, r.Export.LinkTTL, r.Export.PDFFont),
+		"invoice.provider=" + r.Invoice.Provider,
+		fmt.Sprintf("runtime.config_file=%s encryption_key=%s", r.Runtime.ConfigFile, r.Runtime.EncryptionKey),
+	}
+}
+
+func (c *AppConfig) String() string {
+	return strings.Join(c.Lines(), ", ")
+}
+
+// ResolveMode 未显式指定模式时，已配置凭据则使用 live，否则使用 mock
+func ResolveMode(mode string, configured bool) string {
+	if mode != "" {
+		return mode
+	}
+	if configured {
+		return ModeLive
+	}
+	return ModeMock
+}
+
+// Redact 隐藏密钥，只保留是否已配置
+func Redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+func modeLabel(mode string) string {
+	if mode == "" {
+		return "auto"
+	}
+	return mode
+}
@@ -3,42 +3,45 @@ package config
 import (
 	"fmt"
 	"log"
-	"os"
 
-	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/migrations"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
-func ConnectDatabase() {
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-
+// OpenDatabase 连接数据库，gorm 打开时会检查连通性
+func OpenDatabase(cfg DatabaseConfig) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		user, password, host, port, dbname)
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
+
+	return gorm.Open(mysql.Open(dsn), &gorm.Config{})
+}
 
-	database, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+func ConnectDatabase(cfg DatabaseConfig) {
+	database, err := OpenDatabase(cfg)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	// 自动迁移数据库表结构
-	err = database.AutoMigrate(
-		&models.User{},
-		&models.Order{},
-		&models.Message{},
-		&models.Bill{},
-		&models.SystemConfig{},
-		&models.PaymentRecord{},
-		&models.RefundRecord{},
-	)
-	if err != nil {
-		log.Fatal("Failed to migrate database:", err)
+	// 开发环境可在启动时自动执行迁移，否则要求数据库已是最新版本
+	if cfg.AutoMigrate {
+		executed, err := migrations.Up(database, 0)
+		if err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+		for _, migration := range executed {
+			log.Printf("已执行迁移 %d_%s", migration.Version, migration.Name)
+		}
+	} else {
+		pending, err := migrations.Pending(database)
+		if err != nil {
+			log.Fatal("Failed to check database migrations:", err)
+		}
+		if pending > 0 {
+			log.Fatalf("数据库有%d个未执行的迁移，请先运行 migrate up", pending)
+		}
 	}
 
 	DB = database
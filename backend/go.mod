@@ -11,6 +11,9 @@ require (
 	github.com/wechatpay-apiv3/wechatpay-go v0.2.18
 	github.com/aliyun/alibaba-cloud-sdk-go v1.62.570
 	github.com/gin-contrib/cors v1.4.0
+	github.com/go-pdf/fpdf v0.9.0
+	github.com/xuri/excelize/v2 v2.8.0
+	golang.org/x/crypto v0.12.0
 	gorm.io/driver/mysql v1.5.2
 	gorm.io/gorm v1.25.5
 )
\ No newline at end of file
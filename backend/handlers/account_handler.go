@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	accountService *services.AccountService
+}
+
+type PhoneCodeRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type BindPhoneRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+type BindWechatRequest struct {
+	Code      string `json:"code" binding:"required"` // wx.login 返回的登录凭证
+	Nickname  string `json:"nickname"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+func NewAccountHandler() (*AccountHandler, error) {
+	accountService, err := services.NewAccountService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountHandler{
+		accountService: accountService,
+	}, nil
+}
+
+// SendPhoneCode 向待绑定的手机号发送验证码
+func (h *AccountHandler) SendPhoneCode(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req PhoneCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	if _, err := services.ParsePhoneNumber(req.Phone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.accountService.SendBindPhoneCode(userID, req.Phone); err != nil {
+		c.JSON(accountErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "验证码已发送",
+	})
+}
+
+// BindPhone 验证码校验通过后绑定手机号，号码已注册过账号时合并到当前账号
+func (h *AccountHandler) BindPhone(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req BindPhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	if _, err := services.ParsePhoneNumber(req.Phone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	user, merge, err := h.accountService.BindPhone(requestActor(c), userID, req.Phone, req.Code)
+	if err != nil {
+		c.JSON(accountErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "绑定成功",
+		"user":    user,
+		"merge":   merge,
+	})
+}
+
+// BindWechat 绑定微信，微信已注册过账号时合并到当前账号
+func (h *AccountHandler) BindWechat(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req BindWechatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	user, merge, err := h.accountService.BindWechat(requestActor(c), userID, req.Code, services.WechatIdentity{
+		Nickname:  req.Nickname,
+		AvatarURL: req.AvatarURL,
+	})
+	if err != nil {
+		c.JSON(accountErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "绑定成功",
+		"user":    user,
+		"merge":   merge,
+	})
+}
+
+// UnbindPhone 解绑手机号
+func (h *AccountHandler) UnbindPhone(c *gin.Context) {
+	h.unbind(c, h.accountService.UnbindPhone)
+}
+
+// UnbindWechat 解绑微信
+func (h *AccountHandler) UnbindWechat(c *gin.Context) {
+	h.unbind(c, h.accountService.UnbindWechat)
+}
+
+func (h *AccountHandler) unbind(c *gin.Context, unbind func(userID string) (*models.User, error)) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	user, err := unbind(userID)
+	if err != nil {
+		c.JSON(accountErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "解绑成功",
+		"user":    user,
+	})
+}
+
+func accountErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrVerificationTooFrequent), errors.Is(err, services.ErrVerificationLimit):
+		return http.StatusTooManyRequests
+	case errors.Is(err, services.ErrPhoneAlreadyBound), errors.Is(err, services.ErrWechatAlreadyBound),
+		errors.Is(err, services.ErrAccountConflict), errors.Is(err, services.ErrAccountNotMergeable):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrVerificationInvalid), errors.Is(err, services.ErrWechatCodeInvalid),
+		errors.Is(err, services.ErrIdentityNotBound), errors.Is(err, services.ErrLastIdentity):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrWechatLoginUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+// requestActor 当前请求的操作者，后台接口为管理员，其余为登录用户
+func requestActor(c *gin.Context) services.Actor {
+	actor := services.Actor{
+		Type:      "user",
+		ID:        c.GetString("user_id"),
+		RequestID: c.GetString("request_id"),
+		IPAddress: c.ClientIP(),
+	}
+	if adminID := c.GetString("admin_id"); adminID != "" {
+		actor.Type = "admin"
+		actor.ID = adminID
+	}
+	return actor
+}
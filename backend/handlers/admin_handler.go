@@ -0,0 +1,621 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	adminService      *services.AdminService
+	messageService    *services.MessageService
+	billService       *services.BillService
+	suspensionService *services.SuspensionService
+	auditService      *services.AuditService
+	configService     *services.ConfigService
+}
+
+type AdminLoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type AdjustBalanceRequest struct {
+	Amount float64 `json:"amount" binding:"required"` // 增加为正、扣减为负
+	Reason string  `json:"reason" binding:"required"`
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" binding:"required"`
+	Days   int    `json:"days"` // 0为永久停用
+}
+
+type LiftSuspensionRequest struct {
+	Note string `json:"note"`
+}
+
+type AdminRefundRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type ReviewAppealRequest struct {
+	Approve bool   `json:"approve"`
+	Reply   string `json:"reply"`
+}
+
+type CreateAdminRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Name     string `json:"name"`
+	Role     string `json:"role" binding:"required"`
+}
+
+type UpdateConfigRequest struct {
+	Value string `json:"value"` // 为空时清除数据库中的值
+}
+
+type UpdateAdminRequest struct {
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Status   string `json:"status"` // active/disabled
+}
+
+func NewAdminHandler(adminService *services.AdminService, messageService *services.MessageService, billService *services.BillService, suspensionService *services.SuspensionService, auditService *services.AuditService) *AdminHandler {
+	return &AdminHandler{
+		adminService:      adminService,
+		messageService:    messageService,
+		billService:       billService,
+		suspensionService: suspensionService,
+		auditService:      auditService,
+		configService:     services.RuntimeConfig(),
+	}
+}
+
+// Login 管理员登录
+func (h *AdminHandler) Login(c *gin.Context) {
+	var req AdminLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	result, err := h.adminService.Login(req.Username, req.Password, c.ClientIP())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrAdminInvalidLogin) || errors.Is(err, services.ErrAdminDisabled) {
+			status = http.StatusUnauthorized
+		} else if errors.Is(err, services.ErrAdminLoginThrottled) {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// GetProfile 获取当前管理员及其角色
+func (h *AdminHandler) GetProfile(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":   c.GetString("admin_id"),
+			"role": c.GetString("admin_role"),
+		},
+	})
+}
+
+// GetUsers 搜索用户，keyword匹配用户ID、手机号、微信OpenID或昵称
+func (h *AdminHandler) GetUsers(c *gin.Context) {
+	list, err := h.adminService.SearchUsers(services.UserFilter{
+		ListOptions: parseListOptions(c),
+		Keyword:     c.Query("keyword"),
+		Status:      c.Query("status"),
+	})
+	if err != nil {
+		respondListError(c, err, "获取用户列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, listResponse(list.Users, list.ListPage))
+}
+
+// GetUser 获取用户详情、余额对账结果和套餐余量
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	detail, err := h.adminService.GetUserDetail(c.Param("id"))
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    detail,
+	})
+}
+
+// GetUserBills 获取用户账单
+func (h *AdminHandler) GetUserBills(c *gin.Context) {
+	list, err := h.billService.ListBills(c.Param("id"), services.BillFilter{
+		ListOptions: parseListOptions(c),
+		Type:        c.Query("type"),
+	})
+	if err != nil {
+		respondListError(c, err, "获取账单失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, listResponse(list.Bills, list.ListPage))
+}
+
+// GetUserMessages 获取用户消息，筛选参数同用户端消息列表
+func (h *AdminHandler) GetUserMessages(c *gin.Context) {
+	list, err := h.messageService.ListMessages(c.Param("id"), services.MessageFilter{
+		ListOptions: parseListOptions(c),
+		Status:      c.Query("status"),
+		Direction:   c.Query("direction"),
+		Recipient:   c.Query("recipient"),
+		Query:       c.Query("q"),
+	})
+	if err != nil {
+		respondListError(c, err, "获取消息列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, listResponse(list.Messages, list.ListPage))
+}
+
+// AdjustBalance 人工调整用户余额，必须填写原因
+func (h *AdminHandler) AdjustBalance(c *gin.Context) {
+	adminID := c.GetString("admin_id")
+	if adminID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req AdjustBalanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误，金额和原因必填",
+		})
+		return
+	}
+
+	adjustment, err := h.adminService.AdjustBalance(requestActor(c), c.Param("id"), req.Amount, req.Reason)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "余额已调整",
+		"data":    adjustment,
+	})
+}
+
+// SuspendUser 停用用户
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	adminID := c.GetString("admin_id")
+	if adminID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	userID := c.Param("id")
+	suspension, err := h.suspensionService.Suspend(requestActor(c), userID, req.Reason, "manual", "", time.Duration(req.Days)*24*time.Hour)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "账号已停用",
+		"data":    suspension,
+	})
+}
+
+// LiftSuspension 解除用户的停用
+func (h *AdminHandler) LiftSuspension(c *gin.Context) {
+	adminID := c.GetString("admin_id")
+	if adminID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req LiftSuspensionRequest
+	c.ShouldBindJSON(&req) // 请求体可选
+
+	userID := c.Param("id")
+	if err := h.suspensionService.Lift(requestActor(c), userID, req.Note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "解除停用失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已解除停用",
+	})
+}
+
+// GetMessage 获取消息详情
+func (h *AdminHandler) GetMessage(c *gin.Context) {
+	message, err := h.adminService.GetMessage(c.Param("id"))
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    message,
+	})
+}
+
+// GetMessageHistory 获取任意用户消息的状态变更过程
+func (h *AdminHandler) GetMessageHistory(c *gin.Context) {
+	lifecycle, err := h.messageService.MessageLifecycle(c.Param("id"))
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    lifecycle,
+	})
+}
+
+// GetOrders 获取订单列表，可按 user_id、order_no、status 筛选
+func (h *AdminHandler) GetOrders(c *gin.Context) {
+	list, err := h.adminService.ListOrders(services.OrderFilter{
+		ListOptions: parseListOptions(c),
+		UserID:      c.Query("user_id"),
+		OrderNo:     c.Query("order_no"),
+		Status:      c.Query("status"),
+	})
+	if err != nil {
+		respondListError(c, err, "获取订单列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, listResponse(list.Orders, list.ListPage))
+}
+
+// GetOrder 获取订单详情及支付、退款记录
+func (h *AdminHandler) GetOrder(c *gin.Context) {
+	detail, err := h.adminService.GetOrderDetail(c.Param("id"))
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    detail,
+	})
+}
+
+// RefundOrder 对已支付订单发起整单退款
+func (h *AdminHandler) RefundOrder(c *gin.Context) {
+	adminID := c.GetString("admin_id")
+	if adminID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req AdminRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误，退款原因必填",
+		})
+		return
+	}
+
+	orderID := c.Param("id")
+	order, err := h.messageService.RefundOrder(requestActor(c), orderID, req.Reason)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "退款成功",
+		"data":    order,
+	})
+}
+
+// GetAppeals 获取停用申诉列表，status=pending 查看待处理的申诉
+func (h *AdminHandler) GetAppeals(c *gin.Context) {
+	list, err := h.adminService.ListAppeals(services.AppealFilter{
+		ListOptions: parseListOptions(c),
+		Status:      c.Query("status"),
+	})
+	if err != nil {
+		respondListError(c, err, "获取申诉列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, listResponse(list.Appeals, list.ListPage))
+}
+
+// ReviewAppeal 处理申诉，通过时解除停用
+func (h *AdminHandler) ReviewAppeal(c *gin.Context) {
+	var req ReviewAppealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	appeal, err := h.suspensionService.ReviewAppeal(requestActor(c), c.Param("id"), req.Approve, req.Reply)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "申诉已处理",
+		"data":    appeal,
+	})
+}
+
+// GetAdmins 获取管理员列表
+func (h *AdminHandler) GetAdmins(c *gin.Context) {
+	admins, err := h.adminService.ListAdmins()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    admins,
+	})
+}
+
+// CreateAdmin 创建管理员
+func (h *AdminHandler) CreateAdmin(c *gin.Context) {
+	var req CreateAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	admin, err := h.adminService.CreateAdmin(requestActor(c), services.AdminInput{
+		Username: req.Username,
+		Password: req.Password,
+		Name:     req.Name,
+		Role:     req.Role,
+	})
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "管理员已创建",
+		"data":    admin,
+	})
+}
+
+// UpdateAdmin 修改管理员的名称、角色、状态或密码
+func (h *AdminHandler) UpdateAdmin(c *gin.Context) {
+	adminID := c.GetString("admin_id")
+	if adminID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req UpdateAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	admin, err := h.adminService.UpdateAdmin(requestActor(c), c.Param("id"), services.AdminInput{
+		Password: req.Password,
+		Name:     req.Name,
+		Role:     req.Role,
+		Status:   req.Status,
+	})
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "管理员已更新",
+		"data":    admin,
+	})
+}
+
+// GetAuditLogs 查询审计日志，可按操作者、操作、对象和请求ID筛选
+func (h *AdminHandler) GetAuditLogs(c *gin.Context) {
+	list, err := h.auditService.ListLogs(services.AuditFilter{
+		ListOptions: parseListOptions(c),
+		ActorType:   c.Query("actor_type"),
+		ActorID:     c.Query("actor_id"),
+		Action:      c.Query("action"),
+		TargetType:  c.Query("target_type"),
+		TargetID:    c.Query("target_id"),
+		RequestID:   c.Query("request_id"),
+	})
+	if err != nil {
+		respondListError(c, err, "获取审计日志失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, listResponse(list.Logs, list.ListPage))
+}
+
+// VerifyAuditLogs 校验审计日志哈希链是否完整
+func (h *AdminHandler) VerifyAuditLogs(c *gin.Context) {
+	result, err := h.auditService.VerifyChain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// GetConfig 列出运行时配置的当前值和来源，密钥类配置不返回明文
+func (h *AdminHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    h.configService.ListItems(),
+	})
+}
+
+// UpdateConfig 修改数据库中的配置，立即生效
+func (h *AdminHandler) UpdateConfig(c *gin.Context) {
+	var req UpdateConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	item, err := h.configService.Update(requestActor(c), c.Param("key"), req.Value)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "配置已更新",
+		"data":    item,
+	})
+}
+
+// respondListError 列表参数错误返回原因，其他错误返回通用提示
+func respondListError(c *gin.Context, err error, fallback string) {
+	status := listErrorStatus(err)
+	message := fallback
+	if status == http.StatusBadRequest {
+		message = err.Error()
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"message": message,
+	})
+}
+
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrOrderNotFound),
+		errors.Is(err, services.ErrMessageNotFound), errors.Is(err, services.ErrAppealNotFound),
+		errors.Is(err, services.ErrAdminNotFound), errors.Is(err, services.ErrConfigUnknownKey):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrOrderNotRefundable), errors.Is(err, services.ErrUserInactive),
+		errors.Is(err, services.ErrAdminUsernameTaken), errors.Is(err, services.ErrInsufficientBalance):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrAdjustmentReason), errors.Is(err, services.ErrAdjustmentAmount),
+		errors.Is(err, services.ErrAdminInvalidRole), errors.Is(err, services.ErrAdminPasswordTooShort),
+		errors.Is(err, services.ErrAdminSelfUpdate), errors.Is(err, services.ErrConfigInvalidValue),
+		errors.Is(err, services.ErrConfigEncryptionKey):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -1,36 +1,115 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
-	"anonymous-messaging-backend/config"
 	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"anonymous-messaging-backend/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-type AuthHandler struct{}
+type AuthHandler struct {
+	users               repository.UserRepository
+	sessionService      *services.SessionService
+	suspensionService   *services.SuspensionService
+	verificationService *services.VerificationService
+	wechatAuthService   *services.WechatAuthService
+}
 
 type LoginRequest struct {
-	Phone        string `json:"phone"`
-	WechatOpenID string `json:"wechat_openid,omitempty"`
-	Nickname     string `json:"nickname,omitempty"`
-	AvatarURL    string `json:"avatar_url,omitempty"`
-	LoginType    string `json:"login_type"` // "phone" or "wechat"
+	Phone      string `json:"phone"`
+	Code       string `json:"code" binding:"required"` // 手机号登录为短信验证码，微信登录为 wx.login 返回的 code
+	Nickname   string `json:"nickname,omitempty"`
+	AvatarURL  string `json:"avatar_url,omitempty"`
+	LoginType  string `json:"login_type"` // "phone" or "wechat"
+	DeviceID   string `json:"device_id,omitempty"`
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+type LoginCodeRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type AppealRequest struct {
+	AppealToken string `json:"appeal_token" binding:"required"` // 登录被拒时返回的申诉令牌
+	Content     string `json:"content" binding:"required"`
+}
+
+type LogoutRequest struct {
+	All bool `json:"all"` // 退出所有设备
 }
 
 type LoginResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	User    models.User `json:"user,omitempty"`
-	Token   string      `json:"token,omitempty"`
+	Success      bool        `json:"success"`
+	Message      string      `json:"message"`
+	User         models.User `json:"user,omitempty"`
+	Token        string      `json:"token,omitempty"` // 访问令牌
+	RefreshToken string      `json:"refresh_token,omitempty"`
+	ExpiresIn    int64       `json:"expires_in,omitempty"`
+	SessionID    string      `json:"session_id,omitempty"`
 }
 
-func NewAuthHandler() *AuthHandler {
-	return &AuthHandler{}
+func NewAuthHandler(repos repository.Repositories, sessionService *services.SessionService, suspensionService *services.SuspensionService) (*AuthHandler, error) {
+	verificationService, err := services.NewVerificationService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthHandler{
+		users:               repos.Users,
+		sessionService:      sessionService,
+		suspensionService:   suspensionService,
+		verificationService: verificationService,
+		wechatAuthService:   services.NewWechatAuthService(),
+	}, nil
 }
 
+// SendLoginCode 发送手机号登录验证码
+func (h *AuthHandler) SendLoginCode(c *gin.Context) {
+	var req LoginCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	if _, err := services.ParsePhoneNumber(req.Phone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if _, err := h.verificationService.SendCode(req.Phone, services.VerifyPurposeLogin); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrVerificationTooFrequent) || errors.Is(err, services.ErrVerificationLimit) {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "验证码已发送",
+	})
+}
+
+// Login 手机号登录需先通过验证码校验，微信登录由服务端用 code 换取 openid
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -41,18 +120,24 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	var user models.User
+	var user *models.User
 	var err error
 
-	if req.LoginType == "wechat" && req.WechatOpenID != "" {
+	if req.LoginType == "wechat" {
 		// 微信登录
-		err = config.DB.Where("wechat_openid = ?", req.WechatOpenID).First(&user).Error
+		identity, err := h.wechatAuthService.Exchange(req.Code)
 		if err != nil {
-			// 用户不存在，创建新用户
-			user = models.User{
+			h.rejectCredential(c, err)
+			return
+		}
+
+		user, err = h.users.FindByWechatOpenID(identity.OpenID)
+		if err != nil {
+			// 用户不存在，创建新用户。手机号需通过验证码绑定，不使用客户端传入的号码
+			user = &models.User{
 				ID:            uuid.New().String(),
-				Phone:         req.Phone,
-				WechatOpenID:  req.WechatOpenID,
+				WechatOpenID:  &identity.OpenID,
+				WechatUnionID: identity.UnionID,
 				Nickname:      req.Nickname,
 				AvatarURL:     req.AvatarURL,
 				LoginType:     "wechat",
@@ -60,7 +145,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 				CreatedAt:     time.Now(),
 				UpdatedAt:     time.Now(),
 			}
-			if err := config.DB.Create(&user).Error; err != nil {
+			if err := h.users.Create(user); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"success": false,
 					"message": "创建用户失败",
@@ -69,19 +154,33 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			}
 		}
 	} else {
-		// 手机号登录
-		err = config.DB.Where("phone = ?", req.Phone).First(&user).Error
+		// 手机号登录，号码统一为绑定时使用的格式
+		phone, err := services.ParsePhoneNumber(req.Phone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		normalized, err := h.verificationService.VerifyCode(phone.String(), services.VerifyPurposeLogin, req.Code)
+		if err != nil {
+			h.rejectCredential(c, err)
+			return
+		}
+
+		user, err = h.users.FindByPhone(normalized)
 		if err != nil {
 			// 用户不存在，创建新用户
-			user = models.User{
+			user = &models.User{
 				ID:        uuid.New().String(),
-				Phone:     req.Phone,
+				Phone:     &normalized,
 				LoginType: "phone",
 				Status:    "active",
 				CreatedAt: time.Now(),
 				UpdatedAt: time.Now(),
 			}
-			if err := config.DB.Create(&user).Error; err != nil {
+			if err := h.users.Create(user); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"success": false,
 					"message": "创建用户失败",
@@ -91,17 +190,260 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		}
 	}
 
-	// 生成JWT token（这里简化处理，实际应该使用JWT）
-	token := uuid.New().String()
+	if err := h.suspensionService.CheckUser(user.ID); err != nil {
+		h.rejectLogin(c, err)
+		return
+	}
+
+	tokens, err := h.sessionService.CreateSession(user.ID, services.DeviceInfo{
+		DeviceID:   req.DeviceID,
+		DeviceName: req.DeviceName,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "登录失败",
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, LoginResponse{
-		Success: true,
-		Message: "登录成功",
-		User:    user,
-		Token:   token,
+		Success:      true,
+		Message:      "登录成功",
+		User:         *user,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		SessionID:    tokens.SessionID,
+	})
+}
+
+// rejectCredential 验证码或微信登录凭证校验失败时拒绝登录
+func (h *AuthHandler) rejectCredential(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrVerificationInvalid), errors.Is(err, services.ErrWechatCodeInvalid):
+		status = http.StatusUnauthorized
+	case errors.Is(err, services.ErrWechatLoginUnavailable):
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"message": err.Error(),
+	})
+}
+
+// rejectLogin 账号不可用时拒绝登录，停用的账号返回停用原因和申诉令牌
+func (h *AuthHandler) rejectLogin(c *gin.Context, err error) {
+	var suspended *services.SuspendedError
+	if !errors.As(err, &suspended) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"success": false,
+		"message": suspended.Error(),
+	}
+	if suspended.Suspension != nil {
+		response["suspension"] = suspended.Suspension
+		if appeal, err := h.suspensionService.LatestAppeal(suspended.Suspension.ID); err == nil {
+			response["appeal"] = appeal
+		}
+		if appealToken, err := h.suspensionService.IssueAppealToken(suspended.Suspension); err == nil {
+			response["appeal_token"] = appealToken
+		}
+	}
+	c.JSON(http.StatusForbidden, response)
+}
+
+// SubmitAppeal 停用账号凭申诉令牌提交申诉
+func (h *AuthHandler) SubmitAppeal(c *gin.Context) {
+	var req AppealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	appeal, err := h.suspensionService.SubmitAppeal(req.AppealToken, req.Content)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrInvalidToken):
+			status = http.StatusUnauthorized
+		case errors.Is(err, services.ErrSuspensionNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrAppealPending):
+			status = http.StatusConflict
+		case errors.Is(err, services.ErrAppealContentLength):
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "申诉已提交，请等待审核",
+		"data":    appeal,
+	})
+}
+
+// Refresh 使用刷新令牌换取新的访问令牌和刷新令牌
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	tokens, err := h.sessionService.Refresh(req.RefreshToken, services.DeviceInfo{
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "刷新令牌失败"
+		if isSessionError(err) {
+			status = http.StatusUnauthorized
+			message = err.Error()
+		} else if errors.Is(err, services.ErrUserSuspended) {
+			status = http.StatusForbidden
+			message = err.Error()
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"session_id":    tokens.SessionID,
+	})
+}
+
+// Logout 退出当前设备，all为true时退出所有设备
+func (h *AuthHandler) Logout(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req LogoutRequest
+	c.ShouldBindJSON(&req) // 请求体可选
+
+	var err error
+	if req.All {
+		err = h.sessionService.RevokeUserSessions(userID, "退出所有设备")
+	} else {
+		err = h.sessionService.RevokeSession(userID, c.GetString("session_id"), "退出登录")
+	}
+	if err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "退出登录失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已退出登录",
 	})
 }
 
+// GetSessions 获取用户已登录的设备
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	sessions, err := h.sessionService.ListSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取会话列表失败",
+		})
+		return
+	}
+
+	currentID := c.GetString("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sessions,
+	})
+}
+
+// RevokeSession 注销指定设备的会话
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	if err := h.sessionService.RevokeSession(userID, c.Param("id"), "用户注销设备"); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已注销该设备",
+	})
+}
+
+// isSessionError 令牌或会话无效，客户端需要重新登录
+func isSessionError(err error) bool {
+	return errors.Is(err, services.ErrInvalidToken) ||
+		errors.Is(err, services.ErrTokenExpired) ||
+		errors.Is(err, services.ErrSessionRevoked) ||
+		errors.Is(err, services.ErrRefreshTokenReused) ||
+		errors.Is(err, services.ErrUserInactive)
+}
+
 func (h *AuthHandler) GetUserInfo(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
@@ -112,8 +454,8 @@ func (h *AuthHandler) GetUserInfo(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+	user, err := h.users.FindByID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"message": "用户不存在",
@@ -1,17 +1,22 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
-	"anonymous-messaging-backend/config"
-	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"anonymous-messaging-backend/services"
 	"github.com/gin-gonic/gin"
 )
 
-type BillHandler struct{}
+type BillHandler struct {
+	billService *services.BillService
+}
 
-func NewBillHandler() *BillHandler {
-	return &BillHandler{}
+func NewBillHandler(repos repository.Repositories) *BillHandler {
+	return &BillHandler{
+		billService: services.NewBillService(repos),
+	}
 }
 
 func (h *BillHandler) GetBills(c *gin.Context) {
@@ -24,21 +29,27 @@ func (h *BillHandler) GetBills(c *gin.Context) {
 		return
 	}
 
-	var bills []models.Bill
-	if err := config.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&bills).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	list, err := h.billService.ListBills(userID, services.BillFilter{
+		ListOptions: parseListOptions(c),
+		Type:        c.Query("type"),
+	})
+	if err != nil {
+		status := listErrorStatus(err)
+		message := "获取账单失败"
+		if status == http.StatusBadRequest {
+			message = err.Error()
+		}
+		c.JSON(status, gin.H{
 			"success": false,
-			"message": "获取账单失败",
+			"message": message,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"data":    bills,
-	})
+	c.JSON(http.StatusOK, listResponse(list.Bills, list.ListPage))
 }
 
+// GetBillSummary 账单汇总报表，支持 start_date、end_date 和 group_by（day/week/month）
 func (h *BillHandler) GetBillSummary(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
@@ -49,23 +60,32 @@ func (h *BillHandler) GetBillSummary(c *gin.Context) {
 		return
 	}
 
-	var totalPayment, totalRefund float64
-
-	// 计算总支出
-	config.DB.Model(&models.Bill{}).
-		Where("user_id = ? AND type = ?", userID, "payment").
-		Select("COALESCE(SUM(amount), 0)").
-		Scan(&totalPayment)
-
-	// 计算总退款
-	config.DB.Model(&models.Bill{}).
-		Where("user_id = ? AND type = ?", userID, "refund").
-		Select("COALESCE(SUM(amount), 0)").
-		Scan(&totalRefund)
+	summary, err := h.billService.Summarize(userID, services.SummaryOptions{
+		StartDate: c.Query("start_date"),
+		EndDate:   c.Query("end_date"),
+		GroupBy:   c.Query("group_by"),
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "获取账单汇总失败"
+		switch {
+		case errors.Is(err, services.ErrInvalidDate), errors.Is(err, services.ErrInvalidDateRange),
+			errors.Is(err, services.ErrInvalidGroupBy), errors.Is(err, services.ErrSummaryRangeTooLong):
+			status = http.StatusBadRequest
+			message = err.Error()
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
 
+	// total_payment、total_refund 保留给旧版客户端
 	c.JSON(http.StatusOK, gin.H{
 		"success":       true,
-		"total_payment": totalPayment,
-		"total_refund":  totalRefund,
+		"total_payment": summary.Totals.Payment,
+		"total_refund":  summary.Totals.Refund,
+		"data":          summary,
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type CouponHandler struct {
+	couponService *services.CouponService
+}
+
+func NewCouponHandler(couponService *services.CouponService) *CouponHandler {
+	return &CouponHandler{
+		couponService: couponService,
+	}
+}
+
+// GetRedemptions 获取用户的优惠券使用记录
+func (h *CouponHandler) GetRedemptions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	redemptions, err := h.couponService.ListRedemptions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取优惠券记录失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    redemptions,
+	})
+}
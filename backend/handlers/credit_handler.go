@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"anonymous-messaging-backend/middleware"
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type CreditHandler struct {
+	creditService *services.CreditService
+}
+
+type PurchaseCreditRequest struct {
+	PackageID string `json:"package_id" binding:"required"`
+}
+
+func NewCreditHandler(creditService *services.CreditService) *CreditHandler {
+	return &CreditHandler{
+		creditService: creditService,
+	}
+}
+
+// GetPackages 获取可购买的短信套餐
+func (h *CreditHandler) GetPackages(c *gin.Context) {
+	packages, err := h.creditService.ListPackages()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取套餐列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    packages,
+	})
+}
+
+// GetBalance 获取用户剩余的套餐额度
+func (h *CreditHandler) GetBalance(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	balance, err := h.creditService.GetBalance(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取套餐额度失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    balance,
+	})
+}
+
+// Purchase 购买短信套餐
+func (h *CreditHandler) Purchase(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req PurchaseCreditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	grant, err := h.creditService.PurchasePackage(requestActor(c), userID, req.PackageID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrCreditPackageNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, services.ErrOrderCharged) {
+			middleware.KeepIdempotencyKey(c)
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "购买成功",
+		"data":    grant,
+	})
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type ExportHandler struct {
+	exportService *services.ExportService
+}
+
+type CreateExportRequest struct {
+	Kind      string `json:"kind" binding:"required"` // bills / orders / messages / statement
+	Format    string `json:"format"`                  // csv / xlsx / pdf，默认取该类型的第一种格式
+	StartDate string `json:"start_date"`              // 2006-01-02
+	EndDate   string `json:"end_date"`                // 2006-01-02，包含当天
+	Month     string `json:"month"`                   // 2006-01，仅对账单使用
+}
+
+func NewExportHandler(exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{
+		exportService: exportService,
+	}
+}
+
+func (h *ExportHandler) CreateExport(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req CreateExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	job, err := h.exportService.CreateExport(userID, services.ExportInput{
+		Kind:      req.Kind,
+		Format:    req.Format,
+		StartDate: req.StartDate,
+		EndDate:   req.EndDate,
+		Month:     req.Month,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "导出任务已创建",
+		"data":    job,
+	})
+}
+
+func (h *ExportHandler) GetExports(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	jobs, err := h.exportService.ListExports(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取导出任务失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    jobs,
+	})
+}
+
+func (h *ExportHandler) GetExport(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	job, err := h.exportService.GetExport(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    job,
+	})
+}
+
+// Download 通过下载令牌获取导出文件，链接本身即凭证，无需登录
+func (h *ExportHandler) Download(c *gin.Context) {
+	job, err := h.exportService.OpenDownload(c.Param("token"))
+	if err != nil {
+		status := http.StatusNotFound
+		switch {
+		case errors.Is(err, services.ErrExportExpired):
+			status = http.StatusGone
+		case errors.Is(err, services.ErrExportNotReady):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.FileAttachment(job.FilePath, job.FileName)
+}
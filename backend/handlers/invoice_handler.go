@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type InvoiceHandler struct {
+	invoiceService *services.InvoiceService
+}
+
+type InvoiceTitleRequest struct {
+	TitleType   string `json:"title_type" binding:"required"` // company / personal
+	Name        string `json:"name" binding:"required"`
+	TaxID       string `json:"tax_id"`
+	Address     string `json:"address"`
+	Phone       string `json:"phone"`
+	BankName    string `json:"bank_name"`
+	BankAccount string `json:"bank_account"`
+	Email       string `json:"email"`
+	IsDefault   bool   `json:"is_default"`
+}
+
+type InvoiceRequest struct {
+	TitleID     string   `json:"title_id" binding:"required"`
+	InvoiceType string   `json:"invoice_type"` // normal / special
+	OrderIDs    []string `json:"order_ids"`
+	Month       string   `json:"month"` // 2006-01，与 order_ids 二选一
+	Email       string   `json:"email"`
+}
+
+func NewInvoiceHandler() (*InvoiceHandler, error) {
+	invoiceService, err := services.NewInvoiceService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &InvoiceHandler{
+		invoiceService: invoiceService,
+	}, nil
+}
+
+func (h *InvoiceHandler) GetTitles(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	titles, err := h.invoiceService.ListTitles(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取发票抬头失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    titles,
+	})
+}
+
+func (h *InvoiceHandler) CreateTitle(c *gin.Context) {
+	h.saveTitle(c, "")
+}
+
+func (h *InvoiceHandler) UpdateTitle(c *gin.Context) {
+	h.saveTitle(c, c.Param("id"))
+}
+
+func (h *InvoiceHandler) saveTitle(c *gin.Context, titleID string) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req InvoiceTitleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	title, err := h.invoiceService.SaveTitle(userID, titleID, services.InvoiceTitleInput{
+		TitleType:   req.TitleType,
+		Name:        req.Name,
+		TaxID:       req.TaxID,
+		Address:     req.Address,
+		Phone:       req.Phone,
+		BankName:    req.BankName,
+		BankAccount: req.BankAccount,
+		Email:       req.Email,
+		IsDefault:   req.IsDefault,
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrInvoiceTitleNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    title,
+	})
+}
+
+func (h *InvoiceHandler) DeleteTitle(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	if err := h.invoiceService.DeleteTitle(userID, c.Param("id")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvoiceTitleNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "发票抬头已删除",
+	})
+}
+
+func (h *InvoiceHandler) GetInvoiceableOrders(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	orders, err := h.invoiceService.ListInvoiceableOrders(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取可开票订单失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    orders,
+	})
+}
+
+func (h *InvoiceHandler) RequestInvoice(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req InvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	invoice, err := h.invoiceService.RequestInvoice(userID, services.InvoiceRequestInput{
+		TitleID:     req.TitleID,
+		InvoiceType: req.InvoiceType,
+		OrderIDs:    req.OrderIDs,
+		Month:       req.Month,
+		Email:       req.Email,
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, services.ErrInvoiceTitleNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrOrderAlreadyInvoiced):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "开票申请已提交",
+		"data":    invoice,
+	})
+}
+
+func (h *InvoiceHandler) GetInvoices(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	invoices, err := h.invoiceService.ListInvoices(userID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取发票列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    invoices,
+	})
+}
+
+func (h *InvoiceHandler) GetInvoice(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	invoice, err := h.invoiceService.GetInvoice(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    invoice,
+	})
+}
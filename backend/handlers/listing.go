@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+// parseListOptions 读取 cursor、limit、start_date、end_date、include_total 查询参数
+func parseListOptions(c *gin.Context) services.ListOptions {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	return services.ListOptions{
+		Cursor:       c.Query("cursor"),
+		Limit:        limit,
+		StartDate:    c.Query("start_date"),
+		EndDate:      c.Query("end_date"),
+		IncludeTotal: c.Query("include_total") == "true",
+	}
+}
+
+// listResponse 在原有的 success/data 结构上附加分页信息
+func listResponse(data interface{}, page services.ListPage) gin.H {
+	resp := gin.H{
+		"success":  true,
+		"data":     data,
+		"has_more": page.HasMore,
+	}
+	if page.NextCursor != "" {
+		resp["next_cursor"] = page.NextCursor
+	}
+	if page.Total != nil {
+		resp["total"] = *page.Total
+	}
+	return resp
+}
+
+// listErrorStatus 参数错误返回400，其他返回500
+func listErrorStatus(err error) int {
+	if errors.Is(err, services.ErrInvalidCursor) || errors.Is(err, services.ErrInvalidDate) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
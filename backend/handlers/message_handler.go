@@ -1,23 +1,38 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
-	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/middleware"
 	"anonymous-messaging-backend/models"
 	"anonymous-messaging-backend/services"
 	"github.com/gin-gonic/gin"
 )
 
+// 批量发送上传文件大小上限
+const maxBatchFileSize = 5 << 20
+
 type MessageHandler struct {
 	messageService *services.MessageService
 }
 
 type SendMessageRequest struct {
-	Phone       string     `json:"phone" binding:"required"`
-	Content     string     `json:"content" binding:"required"`
-	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	Phone          string            `json:"phone" binding:"required"`
+	Content        string            `json:"content"`
+	TemplateID     string            `json:"template_id,omitempty"`
+	TemplateParams map[string]string `json:"template_params,omitempty"`
+	CouponCode     string            `json:"coupon_code,omitempty"`
+	ScheduledAt    *time.Time        `json:"scheduled_at,omitempty"`
+}
+
+type SendBatchRequest struct {
+	Content     string                    `json:"content"`
+	TemplateID  string                    `json:"template_id,omitempty"`
+	Recipients  []services.BatchRecipient `json:"recipients" binding:"required"`
+	CouponCode  string                    `json:"coupon_code,omitempty"`
+	ScheduledAt *time.Time                `json:"scheduled_at,omitempty"`
 }
 
 type SendMessageResponse struct {
@@ -26,15 +41,10 @@ type SendMessageResponse struct {
 	Data    models.Message `json:"data,omitempty"`
 }
 
-func NewMessageHandler() (*MessageHandler, error) {
-	messageService, err := services.NewMessageService()
-	if err != nil {
-		return nil, err
-	}
-
+func NewMessageHandler(messageService *services.MessageService) *MessageHandler {
 	return &MessageHandler{
 		messageService: messageService,
-	}, nil
+	}
 }
 
 func (h *MessageHandler) SendMessage(c *gin.Context) {
@@ -48,7 +58,7 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 	}
 
 	var req SendMessageRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Content == "" && req.TemplateID == "") {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "请求参数错误",
@@ -56,9 +66,33 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	message, err := h.messageService.SendMessage(userID, req.Phone, req.Content, req.ScheduledAt)
+	if _, err := services.ParsePhoneNumber(req.Phone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	message, err := h.messageService.SendMessage(userID, services.SendMessageInput{
+		Phone:          req.Phone,
+		Content:        req.Content,
+		TemplateID:     req.TemplateID,
+		TemplateParams: req.TemplateParams,
+		CouponCode:     req.CouponCode,
+		ScheduledAt:    req.ScheduledAt,
+		Actor:          requestActor(c),
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		if services.IsCouponError(err) || services.IsTemplateError(err) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, services.ErrUserSuspended) {
+			status = http.StatusForbidden
+		} else if errors.Is(err, services.ErrOrderCharged) {
+			middleware.KeepIdempotencyKey(c)
+		}
+		c.JSON(status, gin.H{
 			"success": false,
 			"message": err.Error(),
 		})
@@ -82,24 +116,36 @@ func (h *MessageHandler) GetMessages(c *gin.Context) {
 		return
 	}
 
-	var messages []models.Message
-	if err := config.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	list, err := h.messageService.ListMessages(userID, services.MessageFilter{
+		ListOptions: parseListOptions(c),
+		Status:      c.Query("status"),
+		Direction:   c.Query("direction"),
+		Recipient:   c.Query("recipient"),
+		Query:       c.Query("q"),
+	})
+	if err != nil {
+		status := listErrorStatus(err)
+		message := "获取消息列表失败"
+		if status == http.StatusBadRequest {
+			message = err.Error()
+		}
+		c.JSON(status, gin.H{
 			"success": false,
-			"message": "获取消息列表失败",
+			"message": message,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"data":    messages,
-	})
+	c.JSON(http.StatusOK, listResponse(list.Messages, list.ListPage))
 }
 
+// CalculateCost 计算发送费用，传入 coupon_code 时同时试算优惠（scope 为 message 或 batch）
 func (h *MessageHandler) CalculateCost(c *gin.Context) {
 	var req struct {
-		Content string `json:"content" binding:"required"`
+		Phone      string `json:"phone,omitempty"`
+		Content    string `json:"content" binding:"required"`
+		CouponCode string `json:"coupon_code,omitempty"`
+		Scope      string `json:"scope,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -110,10 +156,263 @@ func (h *MessageHandler) CalculateCost(c *gin.Context) {
 		return
 	}
 
-	cost := h.messageService.CalculateCost(req.Content)
+	cost, err := h.messageService.CalculateCost(req.Phone, req.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if req.CouponCode == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"cost":    cost,
+		})
+		return
+	}
+
+	if req.Scope == "" {
+		req.Scope = "message"
+	}
+	quote, err := h.messageService.QuoteCoupon(c.GetString("user_id"), req.CouponCode, cost, req.Scope)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"cost":    cost,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"cost":     cost,
+		"discount": quote.Discount,
+		"amount":   quote.Amount,
+		"coupon":   quote,
+	})
+}
+
+func (h *MessageHandler) GetCountries(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    services.ListCountries(),
+	})
+}
+
+// SendBatch 批量发送，支持JSON收件人数组或上传CSV/XLSX文件（multipart字段file）
+func (h *MessageHandler) SendBatch(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var input services.SendBatchInput
+	if c.ContentType() == "multipart/form-data" {
+		fileHeader, err := c.FormFile("file")
+		if err != nil || fileHeader.Size > maxBatchFileSize {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "请上传不超过5MB的CSV或XLSX文件",
+			})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "读取上传文件失败",
+			})
+			return
+		}
+		defer file.Close()
+
+		recipients, source, err := services.ParseRecipientFile(fileHeader.Filename, file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		input = services.SendBatchInput{
+			Content:    c.PostForm("content"),
+			TemplateID: c.PostForm("template_id"),
+			Recipients: recipients,
+			Source:     source,
+			CouponCode: c.PostForm("coupon_code"),
+		}
+		if scheduledAt := c.PostForm("scheduled_at"); scheduledAt != "" {
+			t, err := time.Parse(time.RFC3339, scheduledAt)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": "定时发送时间格式错误",
+				})
+				return
+			}
+			input.ScheduledAt = &t
+		}
+	} else {
+		var req SendBatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "请求参数错误",
+			})
+			return
+		}
+
+		input = services.SendBatchInput{
+			Content:     req.Content,
+			TemplateID:  req.TemplateID,
+			Recipients:  req.Recipients,
+			Source:      "json",
+			CouponCode:  req.CouponCode,
+			ScheduledAt: req.ScheduledAt,
+		}
+	}
+
+	input.Actor = requestActor(c)
+	result, err := h.messageService.SendBatch(userID, input)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrNoValidRecipients) || errors.Is(err, services.ErrBatchRecipientLimit) ||
+			services.IsTemplateError(err) || services.IsCouponError(err) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, services.ErrUserSuspended) {
+			status = http.StatusForbidden
+		} else if errors.Is(err, services.ErrOrderCharged) {
+			middleware.KeepIdempotencyKey(c)
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"data":    result,
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"cost":    cost,
+		"message": "批量任务已创建",
+		"data":    result,
 	})
-}
\ No newline at end of file
+}
+
+func (h *MessageHandler) GetBatches(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	batches, err := h.messageService.ListBatches(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取批量任务失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    batches,
+	})
+}
+
+func (h *MessageHandler) GetBatch(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	batch, err := h.messageService.GetBatch(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    batch,
+	})
+}
+
+func (h *MessageHandler) GetBatchItems(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	items, err := h.messageService.GetBatchItems(userID, c.Param("id"), c.Query("status"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrBatchNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    items,
+	})
+}
+
+// GetMessageHistory 获取消息从创建、支付到发送或退款的状态变更过程
+func (h *MessageHandler) GetMessageHistory(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	lifecycle, err := h.messageService.GetMessageLifecycle(userID, c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrMessageNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    lifecycle,
+	})
+}
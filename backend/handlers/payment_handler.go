@@ -16,15 +16,10 @@ type WechatPayRequest struct {
 	Amount  float64 `json:"amount" binding:"required"`
 }
 
-func NewPaymentHandler() (*PaymentHandler, error) {
-	paymentService, err := services.NewPaymentService()
-	if err != nil {
-		return nil, err
-	}
-
+func NewPaymentHandler(paymentService *services.PaymentService) *PaymentHandler {
 	return &PaymentHandler{
 		paymentService: paymentService,
-	}, nil
+	}
 }
 
 func (h *PaymentHandler) GetWechatPayConfig(c *gin.Context) {
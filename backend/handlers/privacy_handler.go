@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type PrivacyHandler struct {
+	privacyService *services.PrivacyService
+}
+
+type DeleteAccountRequest struct {
+	Reason         string `json:"reason"`
+	ConfirmForfeit bool   `json:"confirm_forfeit"` // 确认放弃剩余余额和套餐额度
+}
+
+func NewPrivacyHandler(privacyService *services.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{
+		privacyService: privacyService,
+	}
+}
+
+// DeleteAccount 申请注销账号，冷静期结束后生效
+func (h *PrivacyHandler) DeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req DeleteAccountRequest
+	c.ShouldBindJSON(&req) // 请求体可选
+
+	deletion, err := h.privacyService.RequestDeletion(userID, services.DeletionInput{
+		Reason:         req.Reason,
+		ConfirmForfeit: req.ConfirmForfeit,
+	})
+	if err != nil {
+		c.JSON(deletionErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "注销申请已提交，冷静期内可撤销",
+		"data":    deletion,
+	})
+}
+
+// GetDeletion 查询待处理的注销申请
+func (h *PrivacyHandler) GetDeletion(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	deletion, err := h.privacyService.GetDeletion(userID)
+	if err != nil {
+		c.JSON(deletionErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    deletion,
+	})
+}
+
+// CancelDeletion 撤销注销申请
+func (h *PrivacyHandler) CancelDeletion(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	deletion, err := h.privacyService.CancelDeletion(userID)
+	if err != nil {
+		c.JSON(deletionErrorStatus(err), gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已撤销注销申请",
+		"data":    deletion,
+	})
+}
+
+// ExportData 下载个人数据压缩包
+func (h *PrivacyHandler) ExportData(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var archive bytes.Buffer
+	if err := h.privacyService.WriteArchive(userID, &archive); err != nil {
+		c.JSON(deletionErrorStatus(err), gin.H{
+			"success": false,
+			"message": "导出个人数据失败",
+		})
+		return
+	}
+
+	fileName := fmt.Sprintf("personal-data-%s.zip", time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+func deletionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrDeletionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrDeletionPending), errors.Is(err, services.ErrUserAlreadyClosed),
+		errors.Is(err, services.ErrDeletionInFlight):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrDeletionForfeit):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type RelayHandler struct {
+	relayService *services.RelayService
+	uplinkToken  string
+}
+
+func NewRelayHandler(relayService *services.RelayService) *RelayHandler {
+	return &RelayHandler{
+		relayService: relayService,
+		uplinkToken:  config.App.SMS.UplinkToken,
+	}
+}
+
+// SMSUplinkNotify 接收阿里云上行短信推送，推送地址需带上 ?token=SMS_UPLINK_TOKEN。
+// 未配置令牌时拒绝所有推送
+func (h *RelayHandler) SMSUplinkNotify(c *gin.Context) {
+	if h.uplinkToken == "" || subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(h.uplinkToken)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code": 1,
+			"msg":  "token无效",
+		})
+		return
+	}
+
+	var reports []services.UplinkReport
+	if err := c.ShouldBindJSON(&reports); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 1,
+			"msg":  "请求参数错误",
+		})
+		return
+	}
+
+	for _, report := range reports {
+		if err := h.relayService.HandleUplink(report); err != nil {
+			log.Printf("处理上行短信失败 sequence_id=%d: %v", report.SequenceID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"msg":  "成功",
+	})
+}
+
+func (h *RelayHandler) GetReplies(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	replies, err := h.relayService.ListReplies(userID, c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrMessageNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    replies,
+	})
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type TemplateHandler struct {
+	templateService *services.TemplateService
+}
+
+type TemplateRequest struct {
+	Name         string                      `json:"name" binding:"required"`
+	TemplateType string                      `json:"template_type,omitempty"` // notification / marketing / international
+	Content      string                      `json:"content" binding:"required"`
+	Remark       string                      `json:"remark" binding:"required"` // 申请说明，服务商审核使用
+	Variables    []services.TemplateVariable `json:"variables,omitempty"`
+}
+
+func NewTemplateHandler() (*TemplateHandler, error) {
+	smsService, err := services.NewSMSService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplateHandler{
+		templateService: services.NewTemplateService(smsService),
+	}, nil
+}
+
+func (h *TemplateHandler) GetTemplates(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	templates, err := h.templateService.ListTemplates(userID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取模板列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    templates,
+	})
+}
+
+func (h *TemplateHandler) GetTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	template, err := h.templateService.GetTemplate(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    template,
+	})
+}
+
+func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	template, err := h.templateService.CreateTemplate(userID, services.TemplateInput{
+		Name:         req.Name,
+		TemplateType: req.TemplateType,
+		Content:      req.Content,
+		Remark:       req.Remark,
+		Variables:    req.Variables,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "模板已提交审核",
+		"data":    template,
+	})
+}
+
+func (h *TemplateHandler) UpdateTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	var req TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	template, err := h.templateService.UpdateTemplate(userID, c.Param("id"), services.TemplateInput{
+		Name:      req.Name,
+		Content:   req.Content,
+		Remark:    req.Remark,
+		Variables: req.Variables,
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrTemplateNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "模板已重新提交审核",
+		"data":    template,
+	})
+}
+
+func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	if err := h.templateService.DeleteTemplate(userID, c.Param("id")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrTemplateNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "模板已删除",
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+type ThreadHandler struct {
+	threadService *services.ThreadService
+}
+
+func NewThreadHandler() *ThreadHandler {
+	return &ThreadHandler{
+		threadService: services.NewThreadService(),
+	}
+}
+
+func (h *ThreadHandler) GetThreads(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	archived := c.Query("archived") == "true"
+
+	list, err := h.threadService.ListThreads(userID, archived, parseListOptions(c))
+	if err != nil {
+		status := listErrorStatus(err)
+		message := "获取会话列表失败"
+		if status == http.StatusBadRequest {
+			message = err.Error()
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+
+	resp := listResponse(list.Threads, list.ListPage)
+	resp["total_unread"] = list.TotalUnread
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *ThreadHandler) GetThreadMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	result, err := h.threadService.GetThreadMessages(userID, c.Param("id"), parseListOptions(c))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	resp := listResponse(result.Messages, result.ListPage)
+	resp["thread"] = result.Thread
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *ThreadHandler) MarkRead(c *gin.Context) {
+	h.updateThread(c, func(userID, threadID string) error {
+		return h.threadService.MarkRead(userID, threadID)
+	})
+}
+
+func (h *ThreadHandler) Archive(c *gin.Context) {
+	h.updateThread(c, func(userID, threadID string) error {
+		return h.threadService.SetArchived(userID, threadID, true)
+	})
+}
+
+func (h *ThreadHandler) Unarchive(c *gin.Context) {
+	h.updateThread(c, func(userID, threadID string) error {
+		return h.threadService.SetArchived(userID, threadID, false)
+	})
+}
+
+func (h *ThreadHandler) Mute(c *gin.Context) {
+	h.updateThread(c, func(userID, threadID string) error {
+		return h.threadService.SetMuted(userID, threadID, true)
+	})
+}
+
+func (h *ThreadHandler) Unmute(c *gin.Context) {
+	h.updateThread(c, func(userID, threadID string) error {
+		return h.threadService.SetMuted(userID, threadID, false)
+	})
+}
+
+func (h *ThreadHandler) updateThread(c *gin.Context, update func(userID, threadID string) error) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	if err := update(userID, c.Param("id")); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "操作成功",
+	})
+}
+
+func (h *ThreadHandler) respondError(c *gin.Context, err error) {
+	status := listErrorStatus(err)
+	if errors.Is(err, services.ErrThreadNotFound) || errors.Is(err, services.ErrMessageNotFound) {
+		status = http.StatusNotFound
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"message": err.Error(),
+	})
+}
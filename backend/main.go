@@ -3,49 +3,140 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"anonymous-messaging-backend/config"
 	"anonymous-messaging-backend/handlers"
 	"anonymous-messaging-backend/middleware"
+	"anonymous-messaging-backend/repository"
+	"anonymous-messaging-backend/services"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
+// 后台任务的执行间隔
+const (
+	outboxPollInterval       = 5 * time.Second  // 发送、退款等待执行事件的轮询间隔，事务提交后会立即唤醒
+	orderRecoveryInterval    = time.Minute      // 超时未支付订单的补偿间隔
+	configReloadInterval     = 30 * time.Second // 运行时配置重新加载间隔，多实例部署时后台修改在此时间内生效
+	auditChainInterval       = 5 * time.Second  // 新写入的审计记录加入哈希链的间隔
+	sessionCleanupInterval   = 24 * time.Hour   // 失效会话的清理间隔
+	suspensionExpiryInterval = 10 * time.Minute // 到期停用记录的解除间隔
+	creditExpiryInterval     = time.Hour        // 过期套餐额度的处理间隔
+	exportCleanupInterval    = time.Hour        // 过期导出文件的清理间隔
+	deletionWorkerInterval   = time.Hour        // 到期注销申请的处理间隔
+	loginAttemptInterval     = time.Hour        // 过期的管理员登录尝试记录的清理间隔
+)
+
 func main() {
 	// 加载环境变量
 	if err := godotenv.Load(); err != nil {
 		log.Println("Warning: .env file not found")
 	}
 
+	// 命令行子命令，如 config check
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// 加载并校验配置，有问题时直接退出
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.App = cfg
+	log.Printf("配置已加载: %s", cfg)
+	for _, warning := range cfg.Warnings() {
+		log.Printf("配置警告: %s", warning)
+	}
+
 	// 连接数据库
-	config.ConnectDatabase()
+	config.ConnectDatabase(cfg.Database)
 
 	// 创建Gin实例
 	r := gin.Default()
+	r.Use(middleware.RequestID())
 
 	// 配置CORS
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{"http://localhost:5173", "http://127.0.0.1:5173"}
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Idempotency-Key", "X-Request-ID"}
+	corsConfig.ExposeHeaders = []string{"X-Request-ID"}
 	corsConfig.AllowCredentials = true
 	r.Use(cors.New(corsConfig))
 
+	// 初始化服务，数据访问通过仓储和数据库连接注入，共享的服务只创建一次
+	repos := repository.NewGorm(config.DB)
+	paymentService, err := services.NewPaymentService(repos)
+	if err != nil {
+		log.Fatal("Failed to initialize payment service:", err)
+	}
+	suspensionService := services.NewSuspensionService(config.DB)
+	relayService := services.NewRelayService(config.DB, suspensionService)
+	couponService := services.NewCouponService(config.DB)
+	creditService := services.NewCreditService(repos, config.DB, paymentService)
+	billService := services.NewBillService(repos)
+
+	messageService, err := services.NewMessageService(repos, paymentService, creditService, couponService, suspensionService, relayService)
+	if err != nil {
+		log.Fatal("Failed to initialize message service:", err)
+	}
+
+	adminService := services.NewAdminService(billService, creditService, suspensionService)
+	adminService.EnsureBootstrapAdmin()
+	auditService := services.NewAuditService()
+	sessionService := services.NewSessionService()
+	exportService := services.NewExportService()
+	privacyService := services.NewPrivacyService()
+
+	// 启动后台任务
+	messageService.StartOutbox(outboxPollInterval)
+	messageService.StartOrderRecovery(orderRecoveryInterval)
+	services.RuntimeConfig().StartReload(configReloadInterval)
+	auditService.StartChaining(auditChainInterval)
+	sessionService.StartCleanup(sessionCleanupInterval)
+	suspensionService.StartExpiry(suspensionExpiryInterval)
+	creditService.StartExpiry(creditExpiryInterval)
+	exportService.StartCleanup(exportCleanupInterval)
+	privacyService.StartDeletionWorker(deletionWorkerInterval)
+	adminService.StartLoginAttemptCleanup(loginAttemptInterval)
+
 	// 初始化处理器
-	authHandler := handlers.NewAuthHandler()
-	
-	messageHandler, err := handlers.NewMessageHandler()
+	authHandler, err := handlers.NewAuthHandler(repos, sessionService, suspensionService)
+	if err != nil {
+		log.Fatal("Failed to initialize auth handler:", err)
+	}
+
+	messageHandler := handlers.NewMessageHandler(messageService)
+	paymentHandler := handlers.NewPaymentHandler(paymentService)
+	billHandler := handlers.NewBillHandler(repos)
+
+	templateHandler, err := handlers.NewTemplateHandler()
 	if err != nil {
-		log.Fatal("Failed to initialize message handler:", err)
+		log.Fatal("Failed to initialize template handler:", err)
 	}
-	
-	paymentHandler, err := handlers.NewPaymentHandler()
+
+	relayHandler := handlers.NewRelayHandler(relayService)
+	threadHandler := handlers.NewThreadHandler()
+	exportHandler := handlers.NewExportHandler(exportService)
+
+	accountHandler, err := handlers.NewAccountHandler()
 	if err != nil {
-		log.Fatal("Failed to initialize payment handler:", err)
+		log.Fatal("Failed to initialize account handler:", err)
 	}
-	
-	billHandler := handlers.NewBillHandler()
+
+	privacyHandler := handlers.NewPrivacyHandler(privacyService)
+	couponHandler := handlers.NewCouponHandler(couponService)
+	creditHandler := handlers.NewCreditHandler(creditService)
+
+	invoiceHandler, err := handlers.NewInvoiceHandler()
+	if err != nil {
+		log.Fatal("Failed to initialize invoice handler:", err)
+	}
+
+	adminHandler := handlers.NewAdminHandler(adminService, messageService, billService, suspensionService, auditService)
 
 	// 路由组
 	api := r.Group("/api")
@@ -53,7 +144,10 @@ func main() {
 		// 认证相关
 		auth := api.Group("/auth")
 		{
+			auth.POST("/code", authHandler.SendLoginCode)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/appeal", authHandler.SubmitAppeal)
 		}
 
 		// 需要认证的路由
@@ -62,19 +156,64 @@ func main() {
 		{
 			// 用户信息
 			protected.GET("/user", authHandler.GetUserInfo)
+			protected.DELETE("/user", privacyHandler.DeleteAccount)
+			protected.GET("/user/deletion", privacyHandler.GetDeletion)
+			protected.POST("/user/deletion/cancel", privacyHandler.CancelDeletion)
+			protected.GET("/user/export", privacyHandler.ExportData)
+
+			// 账号绑定
+			protected.POST("/user/phone/code", accountHandler.SendPhoneCode)
+			protected.POST("/user/phone", accountHandler.BindPhone)
+			protected.DELETE("/user/phone", accountHandler.UnbindPhone)
+			protected.POST("/user/wechat", accountHandler.BindWechat)
+			protected.DELETE("/user/wechat", accountHandler.UnbindWechat)
+
+			// 登录会话
+			protected.POST("/auth/logout", authHandler.Logout)
+			protected.GET("/auth/sessions", authHandler.GetSessions)
+			protected.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
 
 			// 消息相关
 			messages := protected.Group("/messages")
 			{
-				messages.POST("/send", messageHandler.SendMessage)
+				messages.POST("/send", middleware.IdempotencyMiddleware(), messageHandler.SendMessage)
 				messages.GET("/", messageHandler.GetMessages)
 				messages.POST("/calculate-cost", messageHandler.CalculateCost)
+				messages.GET("/countries", messageHandler.GetCountries)
+				messages.POST("/batch", middleware.IdempotencyMiddleware(), messageHandler.SendBatch)
+				messages.GET("/batches", messageHandler.GetBatches)
+				messages.GET("/batches/:id", messageHandler.GetBatch)
+				messages.GET("/batches/:id/items", messageHandler.GetBatchItems)
+				messages.GET("/:id/replies", relayHandler.GetReplies)
+				messages.GET("/:id/history", messageHandler.GetMessageHistory)
+			}
+
+			// 会话相关
+			threads := protected.Group("/threads")
+			{
+				threads.GET("/", threadHandler.GetThreads)
+				threads.GET("/:id/messages", threadHandler.GetThreadMessages)
+				threads.POST("/:id/read", threadHandler.MarkRead)
+				threads.POST("/:id/archive", threadHandler.Archive)
+				threads.POST("/:id/unarchive", threadHandler.Unarchive)
+				threads.POST("/:id/mute", threadHandler.Mute)
+				threads.POST("/:id/unmute", threadHandler.Unmute)
+			}
+
+			// 短信模板相关
+			templates := protected.Group("/templates")
+			{
+				templates.GET("/", templateHandler.GetTemplates)
+				templates.POST("/", templateHandler.CreateTemplate)
+				templates.GET("/:id", templateHandler.GetTemplate)
+				templates.PUT("/:id", templateHandler.UpdateTemplate)
+				templates.DELETE("/:id", templateHandler.DeleteTemplate)
 			}
 
 			// 支付相关
 			payment := protected.Group("/payment")
 			{
-				payment.POST("/wechat/config", paymentHandler.GetWechatPayConfig)
+				payment.POST("/wechat/config", middleware.IdempotencyMiddleware(), paymentHandler.GetWechatPayConfig)
 			}
 
 			// 账单相关
@@ -83,10 +222,84 @@ func main() {
 				bills.GET("/", billHandler.GetBills)
 				bills.GET("/summary", billHandler.GetBillSummary)
 			}
+
+			// 导出相关
+			exports := protected.Group("/exports")
+			{
+				exports.GET("/", exportHandler.GetExports)
+				exports.POST("/", exportHandler.CreateExport)
+				exports.GET("/:id", exportHandler.GetExport)
+			}
+
+			// 优惠券相关
+			protected.GET("/coupons/redemptions", couponHandler.GetRedemptions)
+
+			// 短信套餐
+			credits := protected.Group("/credits")
+			{
+				credits.GET("/", creditHandler.GetBalance)
+				credits.GET("/packages", creditHandler.GetPackages)
+				credits.POST("/purchase", middleware.IdempotencyMiddleware(), creditHandler.Purchase)
+			}
+
+			// 发票相关
+			invoices := protected.Group("/invoices")
+			{
+				invoices.GET("/", invoiceHandler.GetInvoices)
+				invoices.POST("/", middleware.IdempotencyMiddleware(), invoiceHandler.RequestInvoice)
+				invoices.GET("/orders", invoiceHandler.GetInvoiceableOrders)
+				invoices.GET("/titles", invoiceHandler.GetTitles)
+				invoices.POST("/titles", invoiceHandler.CreateTitle)
+				invoices.PUT("/titles/:id", invoiceHandler.UpdateTitle)
+				invoices.DELETE("/titles/:id", invoiceHandler.DeleteTitle)
+				invoices.GET("/:id", invoiceHandler.GetInvoice)
+			}
 		}
 
 		// 支付回调（不需要认证）
 		api.POST("/payment/wechat/notify", paymentHandler.WechatPayNotify)
+
+		// 上行短信推送（不需要认证）
+		api.POST("/sms/uplink/notify", relayHandler.SMSUplinkNotify)
+
+		// 导出文件下载（凭下载令牌访问）
+		api.GET("/exports/download/:token", exportHandler.Download)
+
+		// 运营后台，使用独立的管理员令牌，按角色校验权限
+		api.POST("/admin/login", adminHandler.Login)
+		admin := api.Group("/admin")
+		admin.Use(middleware.AdminAuthMiddleware(adminService))
+		{
+			admin.GET("/me", adminHandler.GetProfile)
+
+			admin.GET("/users", middleware.RequirePermission(services.PermUsersRead), adminHandler.GetUsers)
+			admin.GET("/users/:id", middleware.RequirePermission(services.PermUsersRead), adminHandler.GetUser)
+			admin.GET("/users/:id/bills", middleware.RequirePermission(services.PermUsersRead), adminHandler.GetUserBills)
+			admin.GET("/users/:id/messages", middleware.RequirePermission(services.PermMessagesRead), adminHandler.GetUserMessages)
+			admin.POST("/users/:id/balance", middleware.RequirePermission(services.PermUsersBalance), adminHandler.AdjustBalance)
+			admin.POST("/users/:id/suspend", middleware.RequirePermission(services.PermUsersSuspend), adminHandler.SuspendUser)
+			admin.POST("/users/:id/unsuspend", middleware.RequirePermission(services.PermUsersSuspend), adminHandler.LiftSuspension)
+
+			admin.GET("/messages/:id", middleware.RequirePermission(services.PermMessagesRead), adminHandler.GetMessage)
+			admin.GET("/messages/:id/history", middleware.RequirePermission(services.PermMessagesRead), adminHandler.GetMessageHistory)
+
+			admin.GET("/orders", middleware.RequirePermission(services.PermOrdersRead), adminHandler.GetOrders)
+			admin.GET("/orders/:id", middleware.RequirePermission(services.PermOrdersRead), adminHandler.GetOrder)
+			admin.POST("/orders/:id/refund", middleware.RequirePermission(services.PermOrdersRefund), adminHandler.RefundOrder)
+
+			admin.GET("/appeals", middleware.RequirePermission(services.PermAppealsReview), adminHandler.GetAppeals)
+			admin.POST("/appeals/:id/review", middleware.RequirePermission(services.PermAppealsReview), adminHandler.ReviewAppeal)
+
+			admin.GET("/admins", middleware.RequirePermission(services.PermAdminsManage), adminHandler.GetAdmins)
+			admin.POST("/admins", middleware.RequirePermission(services.PermAdminsManage), adminHandler.CreateAdmin)
+			admin.PUT("/admins/:id", middleware.RequirePermission(services.PermAdminsManage), adminHandler.UpdateAdmin)
+
+			admin.GET("/audit-logs", middleware.RequirePermission(services.PermAuditRead), adminHandler.GetAuditLogs)
+			admin.GET("/audit-logs/verify", middleware.RequirePermission(services.PermAuditRead), adminHandler.VerifyAuditLogs)
+
+			admin.GET("/config", middleware.RequirePermission(services.PermConfigManage), adminHandler.GetConfig)
+			admin.PUT("/config/:key", middleware.RequirePermission(services.PermConfigManage), adminHandler.UpdateConfig)
+		}
 	}
 
 	// 健康检查
@@ -98,13 +311,8 @@ func main() {
 	})
 
 	// 启动服务器
-	port := os.Getenv("SERVER_PORT")
-	if port == "" {
-		port = "8081"
-	}
-
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
+	log.Printf("Server starting on port %s", cfg.Server.Port)
+	if err := r.Run(":" + cfg.Server.Port); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"anonymous-messaging-backend/services"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuthMiddleware 校验后台访问令牌，将管理员ID和角色写入上下文
+func AdminAuthMiddleware(adminService *services.AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if authHeader == "" || tokenString == authHeader {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "缺少或无效的授权头",
+			})
+			c.Abort()
+			return
+		}
+
+		admin, err := adminService.Authenticate(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("admin_id", admin.ID)
+		c.Set("admin_role", admin.Role)
+		c.Next()
+	}
+}
+
+// RequirePermission 当前管理员的角色没有指定权限时返回403
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !services.HasPermission(c.GetString("admin_role"), permission) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "权限不足",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
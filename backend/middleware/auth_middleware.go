@@ -1,13 +1,18 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
+	"anonymous-messaging-backend/services"
 	"github.com/gin-gonic/gin"
 )
 
+// AuthMiddleware 校验访问令牌，将用户ID和会话ID写入上下文
 func AuthMiddleware() gin.HandlerFunc {
+	sessionService := services.NewSessionService()
+
 	return func(c *gin.Context) {
 		// 从请求头获取token
 		authHeader := c.GetHeader("Authorization")
@@ -31,11 +36,22 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 这里应该验证JWT token，简化处理直接使用token作为用户ID
-		// 实际项目中应该解析JWT获取用户信息
-		userID := tokenString // 简化处理
-		
-		c.Set("user_id", userID)
+		claims, err := sessionService.Authenticate(tokenString)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, services.ErrUserSuspended) {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", claims.Subject)
+		c.Set("session_id", claims.SessionID)
 		c.Next()
 	}
 }
\ No newline at end of file
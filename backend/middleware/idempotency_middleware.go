@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyKeyMaxLen = 128
+	idempotencyTTL       = 24 * time.Hour
+	// 处理中的键持有租约，处理期间定时续期。进程中断后租约过期，重试的请求可以接管
+	idempotencyLease     = 30 * time.Second
+	idempotencyRenewal   = 10 * time.Second
+	idempotencyKeepField = "idempotency_keep"
+	// 处理中 panic 且已产生副作用时保存的响应
+	idempotencyPanicBody = `{"success":false,"message":"服务器内部错误"}`
+)
+
+// idempotencyWriter 记录响应内容以便重放
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// KeepIdempotencyKey 标记请求已扣款或产生了不可重复的副作用，
+// 即使返回服务端错误也保存结果，重试时重放而不是重新执行
+func KeepIdempotencyKey(c *gin.Context) {
+	c.Set(idempotencyKeepField, true)
+}
+
+// IdempotencyMiddleware 处理 Idempotency-Key 请求头：同一用户使用相同的键和请求体重试时
+// 直接返回首次请求的结果，键被用于不同的请求时返回422。需在AuthMiddleware之后使用
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLen {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Idempotency-Key过长",
+			})
+			c.Abort()
+			return
+		}
+
+		userID := c.GetString("user_id")
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "读取请求失败",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		now := time.Now()
+		lockedUntil := now.Add(idempotencyLease)
+		record := &models.IdempotencyKey{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: requestHash,
+			Status:      "processing",
+			LockToken:   uuid.New().String(),
+			LockedUntil: &lockedUntil,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyTTL),
+		}
+
+		if err := config.DB.Create(record).Error; err != nil {
+			// 键已存在，按已有记录处理
+			var existing models.IdempotencyKey
+			if err := config.DB.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"success": false,
+					"message": "处理幂等键失败",
+				})
+				c.Abort()
+				return
+			}
+
+			if existing.ExpiresAt.Before(now) {
+				// 已过期的键可以重新使用
+				config.DB.Delete(&existing)
+				if err := config.DB.Create(record).Error; err != nil {
+					c.JSON(http.StatusConflict, gin.H{
+						"success": false,
+						"message": "请求正在处理中，请稍后重试",
+					})
+					c.Abort()
+					return
+				}
+			} else if reclaimIdempotencyKey(&existing, requestHash, record.LockToken, lockedUntil) {
+				// 首次请求的进程已中断，由本次重试接管
+				record = &existing
+			} else {
+				replayIdempotentResponse(c, &existing, requestHash)
+				return
+			}
+		}
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go renewIdempotencyLease(record, stop)
+
+		// 处理中 panic 时同样释放或保存键，再交给外层的 Recovery 处理
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				finishIdempotencyKey(record, http.StatusInternalServerError, idempotencyPanicBody, c.GetBool(idempotencyKeepField))
+				panic(recovered)
+			}
+		}()
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		finishIdempotencyKey(record, writer.Status(), writer.body.String(), c.GetBool(idempotencyKeepField))
+	}
+}
+
+// finishIdempotencyKey 保存请求结果并结束租约。服务端错误且没有产生副作用时删除键，
+// 允许客户端使用同一个键重试
+func finishIdempotencyKey(record *models.IdempotencyKey, status int, body string, keep bool) {
+	owned := config.DB.Model(&models.IdempotencyKey{}).
+		Where("id = ? AND lock_token = ? AND status = ?", record.ID, record.LockToken, "processing")
+	var result *gorm.DB
+	if status >= http.StatusInternalServerError && !keep {
+		result = owned.Delete(&models.IdempotencyKey{})
+	} else {
+		result = owned.Updates(map[string]interface{}{
+			"status":        "completed",
+			"status_code":   status,
+			"response_body": body,
+			"locked_until":  nil,
+		})
+	}
+	if result.Error != nil || result.RowsAffected == 0 {
+		log.Printf("保存幂等键结果失败，租约已被其他请求接管: key=%s err=%v", record.Key, result.Error)
+	}
+}
+
+// reclaimIdempotencyKey 处理中的键租约已过期时，由相同请求的重试接管
+func reclaimIdempotencyKey(existing *models.IdempotencyKey, requestHash, lockToken string, lockedUntil time.Time) bool {
+	if existing.Status != "processing" || existing.RequestHash != requestHash {
+		return false
+	}
+	if existing.LockedUntil != nil && existing.LockedUntil.After(time.Now()) {
+		return false
+	}
+
+	result := config.DB.Model(&models.IdempotencyKey{}).
+		Where("id = ? AND status = ? AND (locked_until IS NULL OR locked_until < ?)", existing.ID, "processing", time.Now()).
+		Updates(map[string]interface{}{"lock_token": lockToken, "locked_until": lockedUntil})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+
+	existing.LockToken = lockToken
+	existing.LockedUntil = &lockedUntil
+	return true
+}
+
+// renewIdempotencyLease 请求处理期间定时续期租约，直到 stop 关闭
+func renewIdempotencyLease(record *models.IdempotencyKey, stop <-chan struct{}) {
+	ticker := time.NewTicker(idempotencyRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			config.DB.Model(&models.IdempotencyKey{}).
+				Where("id = ? AND lock_token = ?", record.ID, record.LockToken).
+				Update("locked_until", time.Now().Add(idempotencyLease))
+		}
+	}
+}
+
+// replayIdempotentResponse 返回已有幂等键对应的结果
+func replayIdempotentResponse(c *gin.Context, existing *models.IdempotencyKey, requestHash string) {
+	defer c.Abort()
+
+	if existing.RequestHash != requestHash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success": false,
+			"message": "Idempotency-Key已被用于不同的请求",
+		})
+		return
+	}
+
+	if existing.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "请求正在处理中，请稍后重试",
+		})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(existing.StatusCode, "application/json; charset=utf-8", []byte(existing.ResponseBody))
+}
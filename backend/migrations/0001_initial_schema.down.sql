@@ -0,0 +1,35 @@
+SET FOREIGN_KEY_CHECKS = 0;
+
+DROP TABLE IF EXISTS `audit_logs`;
+DROP TABLE IF EXISTS `balance_adjustments`;
+DROP TABLE IF EXISTS `admin_users`;
+DROP TABLE IF EXISTS `suspension_appeals`;
+DROP TABLE IF EXISTS `message_complaints`;
+DROP TABLE IF EXISTS `user_suspensions`;
+DROP TABLE IF EXISTS `account_deletions`;
+DROP TABLE IF EXISTS `account_merges`;
+DROP TABLE IF EXISTS `verification_codes`;
+DROP TABLE IF EXISTS `user_sessions`;
+DROP TABLE IF EXISTS `credit_usages`;
+DROP TABLE IF EXISTS `credit_grants`;
+DROP TABLE IF EXISTS `credit_packages`;
+DROP TABLE IF EXISTS `coupon_redemptions`;
+DROP TABLE IF EXISTS `coupons`;
+DROP TABLE IF EXISTS `invoice_items`;
+DROP TABLE IF EXISTS `invoices`;
+DROP TABLE IF EXISTS `invoice_titles`;
+DROP TABLE IF EXISTS `export_jobs`;
+DROP TABLE IF EXISTS `relay_threads`;
+DROP TABLE IF EXISTS `idempotency_keys`;
+DROP TABLE IF EXISTS `message_batch_items`;
+DROP TABLE IF EXISTS `message_batches`;
+DROP TABLE IF EXISTS `sms_templates`;
+DROP TABLE IF EXISTS `refund_records`;
+DROP TABLE IF EXISTS `payment_records`;
+DROP TABLE IF EXISTS `system_configs`;
+DROP TABLE IF EXISTS `bills`;
+DROP TABLE IF EXISTS `messages`;
+DROP TABLE IF EXISTS `orders`;
+DROP TABLE IF EXISTS `users`;
+
+SET FOREIGN_KEY_CHECKS = 1;
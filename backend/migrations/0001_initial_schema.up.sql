@@ -0,0 +1,595 @@
+-- 初始表结构，与 models 中的模型定义一致。
+-- 已由 AutoMigrate 建表的数据库执行时会跳过已存在的表。
+
+CREATE TABLE IF NOT EXISTS `users` (
+    `id` varchar(36),
+    `phone` varchar(20),
+    `wechat_open_id` varchar(100),
+    `wechat_union_id` varchar(100),
+    `nickname` varchar(50),
+    `avatar_url` varchar(255),
+    `balance` decimal(10,2) DEFAULT 0,
+    `status` enum('active','suspended','deleted') DEFAULT 'active',
+    `login_type` enum('wechat','phone') DEFAULT 'phone',
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_users_phone` (`phone`),
+    UNIQUE INDEX `idx_users_wechat_open_id` (`wechat_open_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `orders` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `order_no` varchar(32) NOT NULL,
+    `amount` decimal(10,2) NOT NULL,
+    `original_amount` decimal(10,2) DEFAULT 0,
+    `discount_amount` decimal(10,2) DEFAULT 0,
+    `coupon_id` varchar(36),
+    `coupon_code` varchar(32),
+    `credits_used` bigint DEFAULT 0,
+    `status` enum('pending','paid','failed','refunded','cancelled') DEFAULT 'pending',
+    `payment_method` enum('wechat','alipay','balance','coupon','credit') DEFAULT 'wechat',
+    `payment_transaction_id` varchar(100),
+    `description` varchar(255),
+    `message_id` varchar(36),
+    `invoice_id` varchar(36),
+    `created_at` datetime(3) NULL,
+    `paid_at` datetime(3) NULL,
+    `refunded_at` datetime(3) NULL,
+    `cancelled_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_orders_coupon_id` (`coupon_id`),
+    INDEX `idx_orders_created_at` (`created_at`),
+    INDEX `idx_orders_invoice_id` (`invoice_id`),
+    UNIQUE INDEX `idx_orders_order_no` (`order_no`),
+    INDEX `idx_orders_status` (`status`),
+    INDEX `idx_orders_user_id` (`user_id`),
+    CONSTRAINT `fk_orders_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `messages` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `order_id` varchar(36),
+    `batch_id` varchar(36),
+    `thread_id` varchar(36),
+    `reply_to_id` varchar(36),
+    `direction` enum('outbound','inbound') DEFAULT 'outbound',
+    `recipient_phone` varchar(20) NOT NULL,
+    `country_code` varchar(2) DEFAULT 'CN',
+    `template_id` varchar(36),
+    `template_params` text,
+    `content` text NOT NULL,
+    `character_count` bigint NOT NULL,
+    `cost` decimal(10,2) NOT NULL,
+    `status` enum('pending','scheduled','sending','sent','failed','cancelled','received') DEFAULT 'pending',
+    `scheduled_at` datetime(3) NULL,
+    `sent_at` datetime(3) NULL,
+    `failed_reason` varchar(255),
+    `sms_provider` enum('aliyun','tencent','huawei') DEFAULT 'aliyun',
+    `sms_message_id` varchar(100),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_messages_batch_id` (`batch_id`),
+    FULLTEXT INDEX `idx_messages_content` (`content`) WITH PARSER ngram,
+    INDEX `idx_messages_order_id` (`order_id`),
+    INDEX `idx_messages_reply_to_id` (`reply_to_id`),
+    INDEX `idx_messages_scheduled_at` (`scheduled_at`),
+    INDEX `idx_messages_status` (`status`),
+    INDEX `idx_messages_template_id` (`template_id`),
+    INDEX `idx_messages_thread_id` (`thread_id`),
+    INDEX `idx_messages_user_created` (`user_id`,`created_at`),
+    INDEX `idx_messages_user_id` (`user_id`),
+    CONSTRAINT `fk_messages_order` FOREIGN KEY (`order_id`) REFERENCES `orders`(`id`),
+    CONSTRAINT `fk_messages_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `bills` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `order_id` varchar(36),
+    `type` enum('payment','refund','consumption','recharge','expiry','merge','adjustment') NOT NULL,
+    `amount` decimal(10,2) NOT NULL,
+    `balance_before` decimal(10,2) NOT NULL,
+    `balance_after` decimal(10,2) NOT NULL,
+    `credits` bigint DEFAULT 0,
+    `credits_after` bigint DEFAULT 0,
+    `merged_from` varchar(36),
+    `description` varchar(255),
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_bills_created_at` (`created_at`),
+    INDEX `idx_bills_order_id` (`order_id`),
+    INDEX `idx_bills_type` (`type`),
+    INDEX `idx_bills_user_created` (`user_id`,`created_at`),
+    INDEX `idx_bills_user_id` (`user_id`),
+    CONSTRAINT `fk_bills_order` FOREIGN KEY (`order_id`) REFERENCES `orders`(`id`),
+    CONSTRAINT `fk_bills_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `system_configs` (
+    `id` bigint AUTO_INCREMENT,
+    `config_key` varchar(100) NOT NULL,
+    `config_value` text,
+    `description` varchar(255),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_system_configs_config_key` (`config_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `payment_records` (
+    `id` varchar(36),
+    `order_id` varchar(36) NOT NULL,
+    `payment_method` enum('wechat','alipay') NOT NULL,
+    `transaction_id` varchar(100) NOT NULL,
+    `amount` decimal(10,2) NOT NULL,
+    `status` enum('pending','success','failed') DEFAULT 'pending',
+    `callback_data` text,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_payment_records_order_id` (`order_id`),
+    INDEX `idx_payment_records_status` (`status`),
+    UNIQUE INDEX `idx_payment_records_transaction_id` (`transaction_id`),
+    CONSTRAINT `fk_payment_records_order` FOREIGN KEY (`order_id`) REFERENCES `orders`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `refund_records` (
+    `id` varchar(36),
+    `order_id` varchar(36) NOT NULL,
+    `refund_amount` decimal(10,2) NOT NULL,
+    `reason` varchar(255),
+    `status` enum('pending','success','failed') DEFAULT 'pending',
+    `refund_transaction_id` varchar(100),
+    `created_at` datetime(3) NULL,
+    `processed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_refund_records_order_id` (`order_id`),
+    INDEX `idx_refund_records_status` (`status`),
+    CONSTRAINT `fk_refund_records_order` FOREIGN KEY (`order_id`) REFERENCES `orders`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `sms_templates` (
+    `id` varchar(36),
+    `user_id` varchar(36),
+    `name` varchar(100) NOT NULL,
+    `template_type` enum('notification','marketing','international') DEFAULT 'notification',
+    `template_code` varchar(50),
+    `content` text NOT NULL,
+    `variables` text,
+    `remark` varchar(255),
+    `status` enum('pending','approved','rejected','deleted') DEFAULT 'pending',
+    `reject_reason` varchar(255),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_sms_templates_status` (`status`),
+    INDEX `idx_sms_templates_template_code` (`template_code`),
+    INDEX `idx_sms_templates_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `message_batches` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `order_id` varchar(36),
+    `source` enum('json','csv','xlsx') DEFAULT 'json',
+    `content` text,
+    `template_id` varchar(36),
+    `total_rows` bigint NOT NULL,
+    `valid_rows` bigint NOT NULL,
+    `invalid_rows` bigint NOT NULL,
+    `sent_count` bigint DEFAULT 0,
+    `failed_count` bigint DEFAULT 0,
+    `total_cost` decimal(10,2) NOT NULL,
+    `status` enum('pending','processing','completed','failed') DEFAULT 'pending',
+    `scheduled_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    `completed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_message_batches_created_at` (`created_at`),
+    INDEX `idx_message_batches_order_id` (`order_id`),
+    INDEX `idx_message_batches_status` (`status`),
+    INDEX `idx_message_batches_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `message_batch_items` (
+    `id` varchar(36),
+    `batch_id` varchar(36) NOT NULL,
+    `row_no` bigint NOT NULL,
+    `phone` varchar(20),
+    `variables` text,
+    `message_id` varchar(36),
+    `cost` decimal(10,2) DEFAULT 0,
+    `status` enum('invalid','duplicate','pending','sent','failed') DEFAULT 'pending',
+    `error` varchar(255),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_message_batch_items_batch_id` (`batch_id`),
+    INDEX `idx_message_batch_items_message_id` (`message_id`),
+    INDEX `idx_message_batch_items_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `idempotency_keys` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `idempotency_key` varchar(128) NOT NULL,
+    `method` varchar(10) NOT NULL,
+    `path` varchar(255) NOT NULL,
+    `request_hash` varchar(64) NOT NULL,
+    `status` enum('processing','completed') DEFAULT 'processing',
+    `status_code` bigint,
+    `response_body` mediumtext,
+    `created_at` datetime(3) NULL,
+    `expires_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_idempotency_keys_expires_at` (`expires_at`),
+    UNIQUE INDEX `idx_user_key` (`user_id`,`idempotency_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `relay_threads` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `recipient_phone` varchar(20) NOT NULL,
+    `extend_code` varchar(8) NOT NULL,
+    `archived` boolean DEFAULT false,
+    `muted` boolean DEFAULT false,
+    `last_read_at` datetime(3) NULL,
+    `last_activity_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_relay_threads_archived` (`archived`),
+    UNIQUE INDEX `idx_relay_threads_extend_code` (`extend_code`),
+    INDEX `idx_relay_threads_last_activity_at` (`last_activity_at`),
+    UNIQUE INDEX `idx_user_recipient` (`user_id`,`recipient_phone`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `export_jobs` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `kind` enum('bills','orders','messages','statement') NOT NULL,
+    `format` enum('csv','xlsx','pdf') NOT NULL,
+    `start_date` datetime(3) NULL,
+    `end_date` datetime(3) NULL,
+    `status` enum('pending','processing','completed','failed','expired') DEFAULT 'pending',
+    `row_count` bigint DEFAULT 0,
+    `file_name` varchar(255),
+    `file_path` varchar(500),
+    `file_size` bigint DEFAULT 0,
+    `download_token` varchar(64),
+    `error` varchar(255),
+    `expires_at` datetime(3) NULL,
+    `completed_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_export_jobs_download_token` (`download_token`),
+    INDEX `idx_export_jobs_expires_at` (`expires_at`),
+    INDEX `idx_export_jobs_status` (`status`),
+    INDEX `idx_export_jobs_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `invoice_titles` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `title_type` enum('company','personal') NOT NULL,
+    `name` varchar(100) NOT NULL,
+    `tax_id` varchar(20),
+    `address` varchar(255),
+    `phone` varchar(30),
+    `bank_name` varchar(100),
+    `bank_account` varchar(50),
+    `email` varchar(100),
+    `is_default` boolean DEFAULT false,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_invoice_titles_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `invoices` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `title_id` varchar(36),
+    `invoice_type` enum('normal','special') DEFAULT 'normal',
+    `title_type` enum('company','personal') NOT NULL,
+    `title_name` varchar(100) NOT NULL,
+    `tax_id` varchar(20),
+    `email` varchar(100),
+    `period` varchar(7),
+    `amount` decimal(10,2) NOT NULL,
+    `status` enum('requested','issued','rejected','reversed') DEFAULT 'requested',
+    `provider` varchar(20),
+    `provider_request_id` varchar(100),
+    `invoice_code` varchar(20),
+    `invoice_no` varchar(30),
+    `file_url` varchar(500),
+    `reject_reason` varchar(255),
+    `issued_at` datetime(3) NULL,
+    `reversed_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_invoices_status` (`status`),
+    INDEX `idx_invoices_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `invoice_items` (
+    `id` varchar(36),
+    `invoice_id` varchar(36) NOT NULL,
+    `order_id` varchar(36) NOT NULL,
+    `order_no` varchar(32),
+    `amount` decimal(10,2) NOT NULL,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_invoice_items_invoice_id` (`invoice_id`),
+    INDEX `idx_invoice_items_order_id` (`order_id`),
+    CONSTRAINT `fk_invoices_items` FOREIGN KEY (`invoice_id`) REFERENCES `invoices`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `coupons` (
+    `id` varchar(36),
+    `code` varchar(32) NOT NULL,
+    `name` varchar(100) NOT NULL,
+    `discount_type` enum('fixed','percentage') NOT NULL,
+    `discount_value` decimal(10,2) NOT NULL,
+    `max_discount` decimal(10,2) DEFAULT 0,
+    `min_spend` decimal(10,2) DEFAULT 0,
+    `scope` enum('all','message','batch') DEFAULT 'all',
+    `first_order_only` boolean DEFAULT false,
+    `total_limit` bigint DEFAULT 0,
+    `per_user_limit` bigint DEFAULT 1,
+    `used_count` bigint DEFAULT 0,
+    `starts_at` datetime(3) NULL,
+    `expires_at` datetime(3) NULL,
+    `status` enum('active','disabled') DEFAULT 'active',
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_coupons_code` (`code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `coupon_redemptions` (
+    `id` varchar(36),
+    `coupon_id` varchar(36) NOT NULL,
+    `user_id` varchar(36) NOT NULL,
+    `order_id` varchar(36) NOT NULL,
+    `code` varchar(32) NOT NULL,
+    `discount` decimal(10,2) NOT NULL,
+    `status` enum('applied','reversed') DEFAULT 'applied',
+    `created_at` datetime(3) NULL,
+    `reversed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_coupon_redemptions_order_id` (`order_id`),
+    INDEX `idx_coupon_user` (`coupon_id`,`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `credit_packages` (
+    `id` varchar(36),
+    `name` varchar(100) NOT NULL,
+    `segments` bigint NOT NULL,
+    `price` decimal(10,2) NOT NULL,
+    `valid_days` bigint DEFAULT 365,
+    `status` enum('active','disabled') DEFAULT 'active',
+    `sort_order` bigint DEFAULT 0,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `credit_grants` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `package_id` varchar(36),
+    `order_id` varchar(36),
+    `segments` bigint NOT NULL,
+    `remaining` bigint NOT NULL,
+    `status` enum('active','exhausted','expired') DEFAULT 'active',
+    `expires_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_credit_grants_expires_at` (`expires_at`),
+    INDEX `idx_credit_grants_order_id` (`order_id`),
+    INDEX `idx_credit_grants_status` (`status`),
+    INDEX `idx_credit_grants_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `credit_usages` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `grant_id` varchar(36) NOT NULL,
+    `order_id` varchar(36) NOT NULL,
+    `segments` bigint NOT NULL,
+    `status` enum('used','refunded') DEFAULT 'used',
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_credit_usages_grant_id` (`grant_id`),
+    INDEX `idx_credit_usages_order_id` (`order_id`),
+    INDEX `idx_credit_usages_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `user_sessions` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `device_id` varchar(64),
+    `device_name` varchar(100),
+    `user_agent` varchar(255),
+    `ip_address` varchar(45),
+    `refresh_token_hash` varchar(64) NOT NULL,
+    `previous_token_hash` varchar(64),
+    `expires_at` datetime(3) NULL,
+    `last_used_at` datetime(3) NULL,
+    `revoked_at` datetime(3) NULL,
+    `revoked_reason` varchar(100),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_user_sessions_device_id` (`device_id`),
+    INDEX `idx_user_sessions_expires_at` (`expires_at`),
+    INDEX `idx_user_sessions_previous_token_hash` (`previous_token_hash`),
+    UNIQUE INDEX `idx_user_sessions_refresh_token_hash` (`refresh_token_hash`),
+    INDEX `idx_user_sessions_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `verification_codes` (
+    `id` varchar(36),
+    `phone` varchar(20) NOT NULL,
+    `purpose` varchar(20) NOT NULL,
+    `code_hash` varchar(64) NOT NULL,
+    `attempts` bigint DEFAULT 0,
+    `expires_at` datetime(3) NULL,
+    `used_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_phone_purpose` (`phone`,`purpose`),
+    INDEX `idx_verification_codes_expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `account_merges` (
+    `id` varchar(36),
+    `target_user_id` varchar(36) NOT NULL,
+    `source_user_id` varchar(36) NOT NULL,
+    `source_phone` varchar(20),
+    `source_wechat_open_id` varchar(100),
+    `balance` decimal(10,2) DEFAULT 0,
+    `reason` varchar(20),
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_account_merges_source_user_id` (`source_user_id`),
+    INDEX `idx_account_merges_target_user_id` (`target_user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `account_deletions` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `reason` varchar(255),
+    `status` enum('pending','cancelled','completed') DEFAULT 'pending',
+    `scheduled_at` datetime(3) NULL,
+    `cancelled_at` datetime(3) NULL,
+    `completed_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_account_deletions_scheduled_at` (`scheduled_at`),
+    INDEX `idx_account_deletions_status` (`status`),
+    INDEX `idx_account_deletions_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `user_suspensions` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `reason` varchar(255) NOT NULL,
+    `source` enum('manual','auto') DEFAULT 'manual',
+    `signal` varchar(50),
+    `status` enum('active','lifted','expired') DEFAULT 'active',
+    `expires_at` datetime(3) NULL,
+    `lifted_at` datetime(3) NULL,
+    `lift_note` varchar(255),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_user_suspensions_expires_at` (`expires_at`),
+    INDEX `idx_user_suspensions_status` (`status`),
+    INDEX `idx_user_suspensions_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `message_complaints` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `message_id` varchar(36),
+    `source` enum('recipient','provider','manual') DEFAULT 'recipient',
+    `reason` varchar(255),
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_complaints_user_created` (`user_id`,`created_at`),
+    INDEX `idx_message_complaints_message_id` (`message_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `suspension_appeals` (
+    `id` varchar(36),
+    `suspension_id` varchar(36) NOT NULL,
+    `user_id` varchar(36) NOT NULL,
+    `content` text NOT NULL,
+    `status` enum('pending','approved','rejected') DEFAULT 'pending',
+    `reply` varchar(255),
+    `reviewed_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_suspension_appeals_status` (`status`),
+    INDEX `idx_suspension_appeals_suspension_id` (`suspension_id`),
+    INDEX `idx_suspension_appeals_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `admin_users` (
+    `id` varchar(36),
+    `username` varchar(50) NOT NULL,
+    `password_hash` varchar(100) NOT NULL,
+    `name` varchar(50),
+    `role` enum('superadmin','support','finance','reviewer') NOT NULL,
+    `status` enum('active','disabled') DEFAULT 'active',
+    `last_login_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_admin_users_username` (`username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `balance_adjustments` (
+    `id` varchar(36),
+    `user_id` varchar(36) NOT NULL,
+    `admin_id` varchar(36) NOT NULL,
+    `bill_id` varchar(36),
+    `amount` decimal(10,2) NOT NULL,
+    `balance_before` decimal(10,2) NOT NULL,
+    `balance_after` decimal(10,2) NOT NULL,
+    `reason` varchar(255) NOT NULL,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_balance_adjustments_admin_id` (`admin_id`),
+    INDEX `idx_balance_adjustments_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `audit_logs` (
+    `id` varchar(36),
+    `sequence` bigint NOT NULL,
+    `actor_type` enum('user','admin','system') NOT NULL,
+    `actor_id` varchar(64),
+    `action` varchar(64) NOT NULL,
+    `target_type` varchar(32),
+    `target_id` varchar(36),
+    `before` text,
+    `after` text,
+    `request_id` varchar(64),
+    `ip_address` varchar(45),
+    `prev_hash` char(64),
+    `hash` char(64) NOT NULL,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_audit_actor` (`actor_type`,`actor_id`),
+    INDEX `idx_audit_logs_action` (`action`),
+    INDEX `idx_audit_logs_created_at` (`created_at`),
+    INDEX `idx_audit_logs_request_id` (`request_id`),
+    UNIQUE INDEX `idx_audit_logs_sequence` (`sequence`),
+    INDEX `idx_audit_target` (`target_type`,`target_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 未绑定的手机号和微信改为NULL，避免空字符串触发唯一索引冲突
+UPDATE `users` SET `phone` = NULL WHERE `phone` = '';
+UPDATE `users` SET `wechat_open_id` = NULL WHERE `wechat_open_id` = '';
+
+-- 默认系统配置，值为空表示未设置
+INSERT IGNORE INTO `system_configs` (`config_key`, `config_value`, `description`, `created_at`, `updated_at`) VALUES
+    ('sms_price_per_60_chars', '1.00', '每60字符短信价格（元）', NOW(3), NOW(3)),
+    ('wechat_merchant_id', '', '微信商户号', NOW(3), NOW(3)),
+    ('wechat_merchant_key', '', '微信商户密钥', NOW(3), NOW(3)),
+    ('aliyun_access_key_id', '', '阿里云AccessKeyId', NOW(3), NOW(3)),
+    ('aliyun_access_key_secret', '', '阿里云AccessKeySecret', NOW(3), NOW(3)),
+    ('aliyun_sms_sign_name', '', '阿里云短信签名', NOW(3), NOW(3)),
+    ('aliyun_sms_template_code', '', '阿里云短信模板代码', NOW(3), NOW(3));
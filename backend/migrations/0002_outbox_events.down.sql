@@ -0,0 +1,5 @@
+ALTER TABLE `refund_records` DROP INDEX `idx_refund_records_request_id`;
+
+ALTER TABLE `refund_records` DROP COLUMN `request_id`;
+
+DROP TABLE IF EXISTS `outbox_events`;
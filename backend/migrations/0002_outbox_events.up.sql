@@ -0,0 +1,27 @@
+-- 事务外的副作用（发送短信、退款、退回套餐和优惠券）改为写入 outbox_events，由后台任务执行。
+-- 退款记录增加请求标识，重试同一退款请求时不重复退款，已有记录使用自身ID。
+
+CREATE TABLE IF NOT EXISTS `outbox_events` (
+    `id` varchar(36),
+    `type` varchar(50) NOT NULL,
+    `aggregate_id` varchar(36) NOT NULL,
+    `payload` text,
+    `status` enum('pending','processing','done','failed') DEFAULT 'pending',
+    `attempts` bigint DEFAULT 0,
+    `available_at` datetime(3) NOT NULL,
+    `locked_until` datetime(3) NULL,
+    `last_error` varchar(500),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    `processed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_outbox_events_type` (`type`),
+    INDEX `idx_outbox_events_aggregate_id` (`aggregate_id`),
+    INDEX `idx_outbox_due` (`status`,`available_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE `refund_records` ADD COLUMN `request_id` varchar(36) NULL AFTER `refund_transaction_id`;
+
+UPDATE `refund_records` SET `request_id` = `id` WHERE `request_id` IS NULL;
+
+ALTER TABLE `refund_records` ADD UNIQUE INDEX `idx_refund_records_request_id` (`request_id`);
@@ -0,0 +1,26 @@
+-- 订单、消息、支付记录和退款记录的状态变更历史。
+-- 已有记录没有变更过程，写入一条迁移时的当前状态作为起点。
+
+CREATE TABLE IF NOT EXISTS `status_histories` (
+    `id` varchar(36),
+    `entity_type` enum('order','message','payment','refund') NOT NULL,
+    `entity_id` varchar(36) NOT NULL,
+    `from_status` varchar(20),
+    `to_status` varchar(20) NOT NULL,
+    `cause` varchar(255),
+    `created_at` datetime(6) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_status_history_entity` (`entity_type`,`entity_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO `status_histories` (`id`, `entity_type`, `entity_id`, `from_status`, `to_status`, `cause`, `created_at`)
+SELECT UUID(), 'order', `id`, '', `status`, '迁移时的状态', CURRENT_TIMESTAMP(6) FROM `orders`;
+
+INSERT INTO `status_histories` (`id`, `entity_type`, `entity_id`, `from_status`, `to_status`, `cause`, `created_at`)
+SELECT UUID(), 'message', `id`, '', `status`, '迁移时的状态', CURRENT_TIMESTAMP(6) FROM `messages`;
+
+INSERT INTO `status_histories` (`id`, `entity_type`, `entity_id`, `from_status`, `to_status`, `cause`, `created_at`)
+SELECT UUID(), 'payment', `id`, '', `status`, '迁移时的状态', CURRENT_TIMESTAMP(6) FROM `payment_records`;
+
+INSERT INTO `status_histories` (`id`, `entity_type`, `entity_id`, `from_status`, `to_status`, `cause`, `created_at`)
+SELECT UUID(), 'refund', `id`, '', `status`, '迁移时的状态', CURRENT_TIMESTAMP(6) FROM `refund_records`;
@@ -0,0 +1,3 @@
+ALTER TABLE `messages` DROP COLUMN `sent_parts`;
+
+ALTER TABLE `messages` DROP COLUMN `parts`;
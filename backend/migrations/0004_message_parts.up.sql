@@ -0,0 +1,8 @@
+-- 消息按拆分后的短信条数计费，记录已发出的条数，拆分发送中途失败时只退还未发出部分。
+-- 已有消息按1条处理，已发送的消息视为全部发出。
+
+ALTER TABLE `messages` ADD COLUMN `parts` bigint DEFAULT 1 AFTER `cost`;
+
+ALTER TABLE `messages` ADD COLUMN `sent_parts` bigint DEFAULT 0 AFTER `parts`;
+
+UPDATE `messages` SET `sent_parts` = `parts` WHERE `status` = 'sent';
@@ -0,0 +1,3 @@
+ALTER TABLE `idempotency_keys` DROP COLUMN `locked_until`;
+
+ALTER TABLE `idempotency_keys` DROP COLUMN `lock_token`;
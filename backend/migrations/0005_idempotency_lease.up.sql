@@ -0,0 +1,6 @@
+-- 处理中的幂等键持有可续期的租约，进程中断后租约过期，相同请求的重试可以接管。
+-- 已有的处理中记录没有租约，视为已过期。
+
+ALTER TABLE `idempotency_keys` ADD COLUMN `lock_token` varchar(36) AFTER `response_body`;
+
+ALTER TABLE `idempotency_keys` ADD COLUMN `locked_until` datetime(3) NULL AFTER `lock_token`;
@@ -0,0 +1,3 @@
+-- 事件每次领取生成新的令牌，完成或重试时校验仍持有租约，超时后被重新领取的旧执行结果不再生效。
+
+ALTER TABLE `outbox_events` ADD COLUMN `claim_token` varchar(36) AFTER `locked_until`;
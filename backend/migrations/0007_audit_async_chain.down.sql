@@ -0,0 +1,3 @@
+ALTER TABLE `audit_logs` MODIFY COLUMN `hash` char(64) NOT NULL;
+
+ALTER TABLE `audit_logs` MODIFY COLUMN `sequence` bigint NOT NULL;
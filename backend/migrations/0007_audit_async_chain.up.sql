@@ -0,0 +1,5 @@
+-- 写入审计日志时不再锁定链尾，由后台任务按写入顺序补上序号和哈希，尚未加入哈希链的记录两者为空。
+
+ALTER TABLE `audit_logs` MODIFY COLUMN `sequence` bigint NULL;
+
+ALTER TABLE `audit_logs` MODIFY COLUMN `hash` char(64) NULL;
@@ -0,0 +1,5 @@
+INSERT IGNORE INTO `system_configs` (`config_key`, `config_value`, `description`, `created_at`, `updated_at`)
+SELECT 'sms_price_per_60_chars', `config_value`, '每60字符短信价格（元）', NOW(3), NOW(3)
+FROM `system_configs` WHERE `config_key` = 'sms_price_per_message';
+
+DELETE FROM `system_configs` WHERE `config_key` = 'sms_price_per_message';
@@ -0,0 +1,8 @@
+-- 国内短信按正文拆分到默认模板后实际发送的条数计费，单价配置由 sms_price_per_60_chars 改名为 sms_price_per_message。
+-- 原配置按每60字符（约一条短信）计价，改名后的值即每条短信的单价，已设置的值原样保留。
+
+INSERT IGNORE INTO `system_configs` (`config_key`, `config_value`, `description`, `created_at`, `updated_at`)
+SELECT 'sms_price_per_message', `config_value`, '国内短信每条单价（元）', NOW(3), NOW(3)
+FROM `system_configs` WHERE `config_key` = 'sms_price_per_60_chars';
+
+DELETE FROM `system_configs` WHERE `config_key` = 'sms_price_per_60_chars';
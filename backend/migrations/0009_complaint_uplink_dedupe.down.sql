@@ -0,0 +1,7 @@
+ALTER TABLE `messages` DROP INDEX `idx_messages_thread_uplink`;
+
+ALTER TABLE `messages` DROP COLUMN `uplink_id`;
+
+ALTER TABLE `message_complaints` DROP INDEX `idx_complaints_message_thread`;
+
+ALTER TABLE `message_complaints` DROP COLUMN `thread_id`;
@@ -0,0 +1,23 @@
+-- 同一收件人对同一条消息的投诉只记一次，收件人按会话区分，投诉中不保存号码。
+-- 已有的收件人投诉按消息所属的会话补齐，重复的只保留最早一条。
+-- 上行短信按会话和服务商推送的序号去重，出站消息不参与；已重复保存的回复只保留最早一条。
+
+ALTER TABLE `message_complaints` ADD COLUMN `thread_id` varchar(36) NULL AFTER `message_id`;
+
+UPDATE `message_complaints` c JOIN `messages` m ON m.`id` = c.`message_id`
+SET c.`thread_id` = m.`thread_id`
+WHERE c.`source` = 'recipient' AND m.`thread_id` IS NOT NULL AND m.`thread_id` <> '';
+
+DELETE c1 FROM `message_complaints` c1 JOIN `message_complaints` c2
+ON c2.`message_id` = c1.`message_id` AND c2.`thread_id` = c1.`thread_id`
+AND (c2.`created_at` < c1.`created_at` OR (c2.`created_at` = c1.`created_at` AND c2.`id` < c1.`id`));
+
+ALTER TABLE `message_complaints` ADD UNIQUE INDEX `idx_complaints_message_thread` (`message_id`,`thread_id`);
+
+ALTER TABLE `messages` ADD COLUMN `uplink_id` varchar(100) AS (CASE WHEN `direction` = 'inbound' THEN `sms_message_id` END) VIRTUAL;
+
+DELETE m1 FROM `messages` m1 JOIN `messages` m2
+ON m2.`thread_id` = m1.`thread_id` AND m2.`uplink_id` = m1.`uplink_id`
+AND (m2.`created_at` < m1.`created_at` OR (m2.`created_at` = m1.`created_at` AND m2.`id` < m1.`id`));
+
+ALTER TABLE `messages` ADD UNIQUE INDEX `idx_messages_thread_uplink` (`thread_id`,`uplink_id`);
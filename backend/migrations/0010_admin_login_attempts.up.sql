@@ -0,0 +1,12 @@
+-- 管理员登录尝试记录，按用户名和IP统计近期的失败次数，超过上限时暂时拒绝登录。
+
+CREATE TABLE IF NOT EXISTS `admin_login_attempts` (
+    `id` varchar(36),
+    `username` varchar(50) NOT NULL,
+    `ip_address` varchar(45) NOT NULL,
+    `succeeded` boolean DEFAULT false,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_admin_login_username` (`username`,`created_at`),
+    INDEX `idx_admin_login_ip` (`ip_address`,`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// 引入迁移前由 AutoMigrate 建表的数据库已有 users 等表，初始迁移的 CREATE TABLE IF NOT EXISTS
+// 会跳过这些表。执行初始迁移前先按 0001 的定义补齐缺少的列、修改列类型并补建索引，
+// 已有的列和索引不重复处理，新建的数据库不执行任何语句
+
+// legacyColumn 基线版本的表缺少的列，backfill 为新增该列后为已有记录补齐数据的语句
+type legacyColumn struct {
+	table      string
+	column     string
+	definition string
+	backfill   string
+}
+
+var legacyColumns = []legacyColumn{
+	{table: "orders", column: "original_amount", definition: "decimal(10,2) DEFAULT 0 AFTER `amount`",
+		backfill: "UPDATE `orders` SET `original_amount` = `amount`"},
+	{table: "orders", column: "discount_amount", definition: "decimal(10,2) DEFAULT 0 AFTER `original_amount`"},
+	{table: "orders", column: "coupon_id", definition: "varchar(36) AFTER `discount_amount`"},
+	{table: "orders", column: "coupon_code", definition: "varchar(32) AFTER `coupon_id`"},
+	{table: "orders", column: "credits_used", definition: "bigint DEFAULT 0 AFTER `coupon_code`"},
+	{table: "orders", column: "invoice_id", definition: "varchar(36) AFTER `message_id`"},
+	{table: "messages", column: "batch_id", definition: "varchar(36) AFTER `order_id`"},
+	{table: "messages", column: "thread_id", definition: "varchar(36) AFTER `batch_id`"},
+	{table: "messages", column: "reply_to_id", definition: "varchar(36) AFTER `thread_id`"},
+	{table: "messages", column: "direction", definition: "enum('outbound','inbound') DEFAULT 'outbound' AFTER `reply_to_id`"},
+	{table: "messages", column: "country_code", definition: "varchar(2) DEFAULT 'CN' AFTER `recipient_phone`"},
+	{table: "messages", column: "template_id", definition: "varchar(36) AFTER `country_code`"},
+	{table: "messages", column: "template_params", definition: "text AFTER `template_id`"},
+	{table: "bills", column: "credits", definition: "bigint DEFAULT 0 AFTER `balance_after`"},
+	{table: "bills", column: "credits_after", definition: "bigint DEFAULT 0 AFTER `credits`"},
+	{table: "bills", column: "merged_from", definition: "varchar(36) AFTER `credits_after`"},
+}
+
+// legacyModifies 基线版本中定义不同的列：手机号改为可空，枚举增加新的取值。
+// 修改后的定义包含原有的全部取值，重复执行不影响数据
+var legacyModifies = []struct {
+	table      string
+	definition string
+}{
+	{table: "users", definition: "`phone` varchar(20) NULL"},
+	{table: "orders", definition: "`payment_method` enum('wechat','alipay','balance','coupon','credit') DEFAULT 'wechat'"},
+	{table: "messages", definition: "`status` enum('pending','scheduled','sending','sent','failed','cancelled','received') DEFAULT 'pending'"},
+	{table: "bills", definition: "`type` enum('payment','refund','consumption','recharge','expiry','merge','adjustment') NOT NULL"},
+}
+
+// legacyIndexes 基线版本的表缺少的索引
+var legacyIndexes = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{table: "orders", name: "idx_orders_coupon_id", definition: "INDEX `idx_orders_coupon_id` (`coupon_id`)"},
+	{table: "orders", name: "idx_orders_invoice_id", definition: "INDEX `idx_orders_invoice_id` (`invoice_id`)"},
+	{table: "messages", name: "idx_messages_batch_id", definition: "INDEX `idx_messages_batch_id` (`batch_id`)"},
+	{table: "messages", name: "idx_messages_thread_id", definition: "INDEX `idx_messages_thread_id` (`thread_id`)"},
+	{table: "messages", name: "idx_messages_reply_to_id", definition: "INDEX `idx_messages_reply_to_id` (`reply_to_id`)"},
+	{table: "messages", name: "idx_messages_template_id", definition: "INDEX `idx_messages_template_id` (`template_id`)"},
+	{table: "messages", name: "idx_messages_user_created", definition: "INDEX `idx_messages_user_created` (`user_id`,`created_at`)"},
+	{table: "messages", name: "idx_messages_content", definition: "FULLTEXT INDEX `idx_messages_content` (`content`) WITH PARSER ngram"},
+	{table: "bills", name: "idx_bills_user_created", definition: "INDEX `idx_bills_user_created` (`user_id`,`created_at`)"},
+}
+
+// upgrades 执行某个版本的迁移脚本前对已有数据库的处理
+var upgrades = map[int64]func(conn *gorm.DB) error{
+	1: upgradeAutoMigrated,
+}
+
+// upgradeAutoMigrated 将 AutoMigrate 创建的表升级为初始迁移的结构
+func upgradeAutoMigrated(conn *gorm.DB) error {
+	migrator := conn.Migrator()
+
+	for _, column := range legacyColumns {
+		if !migrator.HasTable(column.table) || migrator.HasColumn(column.table, column.column) {
+			continue
+		}
+		statement := fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", column.table, column.column, column.definition)
+		if err := conn.Exec(statement).Error; err != nil {
+			return fmt.Errorf("升级 %s 表失败: %v", column.table, err)
+		}
+		if column.backfill != "" {
+			if err := conn.Exec(column.backfill).Error; err != nil {
+				return fmt.Errorf("升级 %s 表失败: %v", column.table, err)
+			}
+		}
+	}
+
+	for _, modify := range legacyModifies {
+		if !migrator.HasTable(modify.table) {
+			continue
+		}
+		statement := fmt.Sprintf("ALTER TABLE `%s` MODIFY %s", modify.table, modify.definition)
+		if err := conn.Exec(statement).Error; err != nil {
+			return fmt.Errorf("升级 %s 表失败: %v", modify.table, err)
+		}
+	}
+
+	for _, index := range legacyIndexes {
+		if !migrator.HasTable(index.table) || migrator.HasIndex(index.table, index.name) {
+			continue
+		}
+		statement := fmt.Sprintf("ALTER TABLE `%s` ADD %s", index.table, index.definition)
+		if err := conn.Exec(statement).Error; err != nil {
+			return fmt.Errorf("升级 %s 表失败: %v", index.table, err)
+		}
+	}
+	return nil
+}
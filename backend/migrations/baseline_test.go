@@ -0,0 +1,211 @@
+package migrations
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// 以下为引入迁移前基线版本的模型，由 AutoMigrate 建表模拟已部署的数据库
+
+type baselineUser struct {
+	ID            string  `gorm:"primaryKey;type:varchar(36)"`
+	Phone         string  `gorm:"uniqueIndex;type:varchar(20);not null"`
+	WechatOpenID  string  `gorm:"uniqueIndex;type:varchar(100)"`
+	WechatUnionID string  `gorm:"type:varchar(100)"`
+	Nickname      string  `gorm:"type:varchar(50)"`
+	AvatarURL     string  `gorm:"type:varchar(255)"`
+	Balance       float64 `gorm:"type:decimal(10,2);default:0.00"`
+	Status        string  `gorm:"type:enum('active','suspended','deleted');default:'active'"`
+	LoginType     string  `gorm:"type:enum('wechat','phone');default:'phone'"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineOrder struct {
+	ID                   string    `gorm:"primaryKey;type:varchar(36)"`
+	UserID               string    `gorm:"type:varchar(36);not null;index"`
+	OrderNo              string    `gorm:"uniqueIndex;type:varchar(32);not null"`
+	Amount               float64   `gorm:"type:decimal(10,2);not null"`
+	Status               string    `gorm:"type:enum('pending','paid','failed','refunded','cancelled');default:'pending';index"`
+	PaymentMethod        string    `gorm:"type:enum('wechat','alipay','balance');default:'wechat'"`
+	PaymentTransactionID string    `gorm:"type:varchar(100)"`
+	Description          string    `gorm:"type:varchar(255)"`
+	MessageID            string    `gorm:"type:varchar(36)"`
+	CreatedAt            time.Time `gorm:"index"`
+	PaidAt               *time.Time
+	RefundedAt           *time.Time
+	CancelledAt          *time.Time
+	User                 baselineUser `gorm:"foreignKey:UserID"`
+}
+
+func (baselineOrder) TableName() string { return "orders" }
+
+type baselineMessage struct {
+	ID             string     `gorm:"primaryKey;type:varchar(36)"`
+	UserID         string     `gorm:"type:varchar(36);not null;index"`
+	OrderID        string     `gorm:"type:varchar(36);index"`
+	RecipientPhone string     `gorm:"type:varchar(20);not null"`
+	Content        string     `gorm:"type:text;not null"`
+	CharacterCount int        `gorm:"not null"`
+	Cost           float64    `gorm:"type:decimal(10,2);not null"`
+	Status         string     `gorm:"type:enum('pending','scheduled','sending','sent','failed','cancelled');default:'pending';index"`
+	ScheduledAt    *time.Time `gorm:"index"`
+	SentAt         *time.Time
+	FailedReason   string `gorm:"type:varchar(255)"`
+	SMSProvider    string `gorm:"type:enum('aliyun','tencent','huawei');default:'aliyun'"`
+	SMSMessageID   string `gorm:"type:varchar(100)"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	User           baselineUser  `gorm:"foreignKey:UserID"`
+	Order          baselineOrder `gorm:"foreignKey:OrderID"`
+}
+
+func (baselineMessage) TableName() string { return "messages" }
+
+type baselineBill struct {
+	ID            string        `gorm:"primaryKey;type:varchar(36)"`
+	UserID        string        `gorm:"type:varchar(36);not null;index"`
+	OrderID       string        `gorm:"type:varchar(36);index"`
+	Type          string        `gorm:"type:enum('payment','refund','consumption','recharge');not null;index"`
+	Amount        float64       `gorm:"type:decimal(10,2);not null"`
+	BalanceBefore float64       `gorm:"type:decimal(10,2);not null"`
+	BalanceAfter  float64       `gorm:"type:decimal(10,2);not null"`
+	Description   string        `gorm:"type:varchar(255)"`
+	CreatedAt     time.Time     `gorm:"index"`
+	User          baselineUser  `gorm:"foreignKey:UserID"`
+	Order         baselineOrder `gorm:"foreignKey:OrderID"`
+}
+
+func (baselineBill) TableName() string { return "bills" }
+
+type baselineSystemConfig struct {
+	ID          int    `gorm:"primaryKey;autoIncrement"`
+	ConfigKey   string `gorm:"uniqueIndex;type:varchar(100);not null"`
+	ConfigValue string `gorm:"type:text"`
+	Description string `gorm:"type:varchar(255)"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (baselineSystemConfig) TableName() string { return "system_configs" }
+
+type baselinePaymentRecord struct {
+	ID            string  `gorm:"primaryKey;type:varchar(36)"`
+	OrderID       string  `gorm:"type:varchar(36);not null;index"`
+	PaymentMethod string  `gorm:"type:enum('wechat','alipay');not null"`
+	TransactionID string  `gorm:"uniqueIndex;type:varchar(100);not null"`
+	Amount        float64 `gorm:"type:decimal(10,2);not null"`
+	Status        string  `gorm:"type:enum('pending','success','failed');default:'pending';index"`
+	CallbackData  string  `gorm:"type:text"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Order         baselineOrder `gorm:"foreignKey:OrderID"`
+}
+
+func (baselinePaymentRecord) TableName() string { return "payment_records" }
+
+type baselineRefundRecord struct {
+	ID                  string  `gorm:"primaryKey;type:varchar(36)"`
+	OrderID             string  `gorm:"type:varchar(36);not null;index"`
+	RefundAmount        float64 `gorm:"type:decimal(10,2);not null"`
+	Reason              string  `gorm:"type:varchar(255)"`
+	Status              string  `gorm:"type:enum('pending','success','failed');default:'pending';index"`
+	RefundTransactionID string  `gorm:"type:varchar(100)"`
+	CreatedAt           time.Time
+	ProcessedAt         *time.Time
+	Order               baselineOrder `gorm:"foreignKey:OrderID"`
+}
+
+func (baselineRefundRecord) TableName() string { return "refund_records" }
+
+// TestUpFromAutoMigratedBaseline 需要 MIGRATION_TEST_DSN 指向一个空的 MySQL 8 数据库，
+// 测试结束时回滚全部迁移，删除创建的表
+func TestUpFromAutoMigratedBaseline(t *testing.T) {
+	dsn := os.Getenv("MIGRATION_TEST_DSN")
+	if dsn == "" {
+		t.Skip("未设置 MIGRATION_TEST_DSN")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("连接数据库失败: %v", err)
+	}
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatalf("读取表失败: %v", err)
+	}
+	if len(tables) > 0 {
+		t.Fatalf("测试数据库不为空: %v", tables)
+	}
+
+	err = db.AutoMigrate(&baselineUser{}, &baselineOrder{}, &baselineMessage{}, &baselineBill{},
+		&baselineSystemConfig{}, &baselinePaymentRecord{}, &baselineRefundRecord{})
+	if err != nil {
+		t.Fatalf("基线建表失败: %v", err)
+	}
+	t.Cleanup(func() {
+		all, _ := Load()
+		if _, err := Down(db, len(all)); err != nil {
+			t.Errorf("回滚迁移失败: %v", err)
+		}
+		db.Migrator().DropTable("schema_migrations")
+	})
+
+	// 基线版本微信登录的用户手机号为空字符串
+	now := time.Now()
+	user := baselineUser{ID: "user-1", WechatOpenID: "openid-1", LoginType: "wechat", CreatedAt: now, UpdatedAt: now}
+	order := baselineOrder{ID: "order-1", UserID: user.ID, OrderNo: "SMS1", Amount: 1, Status: "paid", CreatedAt: now}
+	message := baselineMessage{ID: "message-1", UserID: user.ID, OrderID: order.ID, RecipientPhone: "13800138000",
+		Content: "hello", CharacterCount: 5, Cost: 1, Status: "sent", CreatedAt: now, UpdatedAt: now}
+	for _, record := range []interface{}{&user, &order, &message} {
+		if err := db.Omit("User", "Order").Create(record).Error; err != nil {
+			t.Fatalf("写入基线数据失败: %v", err)
+		}
+	}
+
+	if _, err := Up(db, 0); err != nil {
+		t.Fatalf("Up() error: %v", err)
+	}
+	if pending, err := Pending(db); err != nil || pending != 0 {
+		t.Fatalf("Pending() = %d, %v，应全部执行", pending, err)
+	}
+
+	var phone *string
+	if err := db.Raw("SELECT phone FROM users WHERE id = ?", user.ID).Row().Scan(&phone); err != nil {
+		t.Fatalf("读取用户失败: %v", err)
+	}
+	if phone != nil {
+		t.Errorf("未绑定的手机号为 %q，应为 NULL", *phone)
+	}
+
+	var upgraded struct {
+		OriginalAmount float64
+		CreditsUsed    int
+		Direction      string
+		SentParts      int
+	}
+	err = db.Raw(`SELECT o.original_amount, o.credits_used, m.direction, m.sent_parts
+		FROM messages m JOIN orders o ON o.id = m.order_id WHERE m.id = ?`, message.ID).Scan(&upgraded).Error
+	if err != nil {
+		t.Fatalf("读取升级后的消息失败: %v", err)
+	}
+	if upgraded.OriginalAmount != 1 || upgraded.CreditsUsed != 0 || upgraded.Direction != "outbound" || upgraded.SentParts != 1 {
+		t.Errorf("升级后的订单和消息 %+v 不正确", upgraded)
+	}
+
+	// 新增的枚举取值可以写入
+	statements := []string{
+		"UPDATE messages SET status = 'received', thread_id = 'thread-1' WHERE id = 'message-1'",
+		"UPDATE orders SET payment_method = 'credit', coupon_id = 'coupon-1' WHERE id = 'order-1'",
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Errorf("%s: %v", statement, err)
+		}
+	}
+}
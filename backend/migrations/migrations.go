@@ -0,0 +1,415 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 迁移文件命名为 <版本号>_<名称>.up.sql 和 <版本号>_<名称>.down.sql，
+// 语句以行尾的分号结束
+//
+//go:embed *.sql
+var files embed.FS
+
+const (
+	// 多个实例同时启动时只有一个执行迁移，其余等待锁释放
+	lockName           = "anonymous_messaging_schema_migrations"
+	lockTimeoutSeconds = 300
+)
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	ErrDirty            = errors.New("上次迁移未完成，请检查数据库后使用 migrate force <版本号> 标记状态")
+	ErrLockTimeout      = errors.New("等待迁移锁超时，可能有其他实例正在执行迁移")
+	ErrChecksumMismatch = errors.New("已执行的迁移文件被修改")
+)
+
+// Migration 一个版本的升级和回滚脚本
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus 迁移的执行状态
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	Dirty     bool       `json:"dirty"`
+	Modified  bool       `json:"modified"` // 执行后文件内容有变化
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// schemaMigration 已执行的迁移记录
+type schemaMigration struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"type:varchar(255);not null"`
+	Checksum  string    `gorm:"type:char(64);not null"`
+	Dirty     bool      `gorm:"not null;default:false"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Load 读取内嵌的迁移文件，按版本号排序
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, fmt.Errorf("读取迁移文件失败: %v", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("迁移文件名不正确: %s", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("读取迁移文件失败: %v", err)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("迁移版本 %d 重复", version)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("迁移 %d_%s 缺少 up 或 down 脚本", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up 按版本顺序执行未执行的迁移，steps<=0 时全部执行，返回本次执行的迁移
+func Up(db *gorm.DB, steps int) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var executed []Migration
+	err = withLock(db, func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkApplied(migrations, applied); err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if steps > 0 && len(executed) >= steps {
+				break
+			}
+
+			// MySQL 的 DDL 不支持事务，先标记为未完成，全部语句成功后清除标记
+			record := schemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				Checksum:  migration.Checksum,
+				Dirty:     true,
+				AppliedAt: time.Now(),
+			}
+			if err := conn.Create(&record).Error; err != nil {
+				return fmt.Errorf("记录迁移状态失败: %v", err)
+			}
+			if upgrade := upgrades[migration.Version]; upgrade != nil {
+				if err := upgrade(conn); err != nil {
+					return fmt.Errorf("执行迁移 %d_%s 失败: %v", migration.Version, migration.Name, err)
+				}
+			}
+			if err := execScript(conn, migration.Up); err != nil {
+				return fmt.Errorf("执行迁移 %d_%s 失败: %v", migration.Version, migration.Name, err)
+			}
+			if err := conn.Model(&record).Update("dirty", false).Error; err != nil {
+				return fmt.Errorf("记录迁移状态失败: %v", err)
+			}
+			executed = append(executed, migration)
+		}
+		return nil
+	})
+	return executed, err
+}
+
+// Down 按版本倒序回滚最近执行的迁移，steps<=0 时回滚一个
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = withLock(db, func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkApplied(migrations, applied); err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err := conn.Model(&schemaMigration{}).Where("version = ?", migration.Version).
+				Update("dirty", true).Error; err != nil {
+				return fmt.Errorf("记录迁移状态失败: %v", err)
+			}
+			if err := execScript(conn, migration.Down); err != nil {
+				return fmt.Errorf("回滚迁移 %d_%s 失败: %v", migration.Version, migration.Name, err)
+			}
+			if err := conn.Delete(&schemaMigration{}, migration.Version).Error; err != nil {
+				return fmt.Errorf("记录迁移状态失败: %v", err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Force 人工处理失败的迁移后修正记录：标记 version 及之前的迁移为已执行，
+// 之后的迁移为未执行，不执行任何脚本。version 为0时清空记录
+func Force(db *gorm.DB, version int64) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+
+	found := version == 0
+	for _, migration := range migrations {
+		if migration.Version == version {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("迁移版本 %d 不存在", version)
+	}
+
+	return withLock(db, func(conn *gorm.DB) error {
+		return conn.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("version > ?", version).Delete(&schemaMigration{}).Error; err != nil {
+				return err
+			}
+			for _, migration := range migrations {
+				if migration.Version > version {
+					break
+				}
+				record := schemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					Checksum:  migration.Checksum,
+					AppliedAt: time.Now(),
+				}
+				err := tx.Clauses(clause.OnConflict{
+					DoUpdates: clause.AssignmentColumns([]string{"checksum", "dirty"}),
+				}).Create(&record).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Status 列出全部迁移的执行状态
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied map[int64]schemaMigration
+	err = withLock(db, func(conn *gorm.DB) error {
+		applied, err = appliedMigrations(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.Applied = true
+			status.Dirty = record.Dirty
+			status.Modified = record.Checksum != migration.Checksum
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending 未执行的迁移数量，有未完成或被修改过的迁移时返回错误
+func Pending(db *gorm.DB) (int, error) {
+	statuses, err := Status(db)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.Dirty {
+			return 0, fmt.Errorf("%w: %d_%s", ErrDirty, status.Version, status.Name)
+		}
+		if status.Modified {
+			return 0, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, status.Version, status.Name)
+		}
+		if !status.Applied {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// withLock 在同一个数据库连接上持有 MySQL 命名锁执行迁移
+func withLock(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		// GET_LOCK 超时返回0，出错返回NULL
+		var acquired sql.NullInt64
+		if err := conn.Raw("SELECT GET_LOCK(?, ?)", lockName, lockTimeoutSeconds).Row().Scan(&acquired); err != nil {
+			return fmt.Errorf("获取迁移锁失败: %v", err)
+		}
+		if !acquired.Valid || acquired.Int64 != 1 {
+			return ErrLockTimeout
+		}
+		defer conn.Exec("SELECT RELEASE_LOCK(?)", lockName)
+
+		if err := ensureTable(conn); err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+func ensureTable(db *gorm.DB) error {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		dirty TINYINT(1) NOT NULL DEFAULT 0,
+		applied_at DATETIME(3) NOT NULL
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`).Error
+	if err != nil {
+		return fmt.Errorf("创建迁移记录表失败: %v", err)
+	}
+	return nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int64]schemaMigration, error) {
+	var records []schemaMigration
+	if err := db.Order("version").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("读取迁移记录失败: %v", err)
+	}
+
+	applied := make(map[int64]schemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// checkApplied 有未完成或被修改过的迁移时拒绝继续执行
+func checkApplied(migrations []Migration, applied map[int64]schemaMigration) error {
+	for _, migration := range migrations {
+		record, ok := applied[migration.Version]
+		if !ok {
+			continue
+		}
+		if record.Dirty {
+			return fmt.Errorf("%w: %d_%s", ErrDirty, migration.Version, migration.Name)
+		}
+		if record.Checksum != migration.Checksum {
+			return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// execScript 逐条执行脚本中的语句，忽略整行注释
+func execScript(db *gorm.DB, script string) error {
+	for _, statement := range splitStatements(script) {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
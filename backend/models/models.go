@@ -2,22 +2,21 @@ package models
 
 import (
 	"time"
-	"gorm.io/gorm"
 )
 
-// User 用户模型
+// User 用户模型，手机号和微信OpenID至少有一个，未绑定时为NULL
 type User struct {
-	ID           string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
-	Phone        string    `json:"phone" gorm:"uniqueIndex;type:varchar(20);not null"`
-	WechatOpenID string    `json:"wechat_openid" gorm:"uniqueIndex;type:varchar(100)"`
-	WechatUnionID string   `json:"wechat_unionid" gorm:"type:varchar(100)"`
-	Nickname     string    `json:"nickname" gorm:"type:varchar(50)"`
-	AvatarURL    string    `json:"avatar_url" gorm:"type:varchar(255)"`
-	Balance      float64   `json:"balance" gorm:"type:decimal(10,2);default:0.00"`
-	Status       string    `json:"status" gorm:"type:enum('active','suspended','deleted');default:'active'"`
-	LoginType    string    `json:"login_type" gorm:"type:enum('wechat','phone');default:'phone'"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Phone         *string   `json:"phone" gorm:"uniqueIndex;type:varchar(20)"`
+	WechatOpenID  *string   `json:"wechat_openid" gorm:"uniqueIndex;type:varchar(100)"`
+	WechatUnionID string    `json:"wechat_unionid" gorm:"type:varchar(100)"`
+	Nickname      string    `json:"nickname" gorm:"type:varchar(50)"`
+	AvatarURL     string    `json:"avatar_url" gorm:"type:varchar(255)"`
+	Balance       float64   `json:"balance" gorm:"type:decimal(10,2);default:0.00"`
+	Status        string    `json:"status" gorm:"type:enum('active','suspended','deleted');default:'active'"`
+	LoginType     string    `json:"login_type" gorm:"type:enum('wechat','phone');default:'phone'"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // Order 订单模型
@@ -25,12 +24,18 @@ type Order struct {
 	ID                   string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
 	UserID               string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
 	OrderNo              string     `json:"order_no" gorm:"uniqueIndex;type:varchar(32);not null"`
-	Amount               float64    `json:"amount" gorm:"type:decimal(10,2);not null"`
+	Amount               float64    `json:"amount" gorm:"type:decimal(10,2);not null"`              // 实付金额
+	OriginalAmount       float64    `json:"original_amount" gorm:"type:decimal(10,2);default:0.00"` // 优惠前金额
+	DiscountAmount       float64    `json:"discount_amount" gorm:"type:decimal(10,2);default:0.00"`
+	CouponID             string     `json:"coupon_id" gorm:"type:varchar(36);index"`
+	CouponCode           string     `json:"coupon_code" gorm:"type:varchar(32)"`
+	CreditsUsed          int        `json:"credits_used" gorm:"default:0"` // 抵扣的套餐条数
 	Status               string     `json:"status" gorm:"type:enum('pending','paid','failed','refunded','cancelled');default:'pending';index"`
-	PaymentMethod        string     `json:"payment_method" gorm:"type:enum('wechat','alipay','balance');default:'wechat'"`
+	PaymentMethod        string     `json:"payment_method" gorm:"type:enum('wechat','alipay','balance','coupon','credit');default:'wechat'"`
 	PaymentTransactionID string     `json:"payment_transaction_id" gorm:"type:varchar(100)"`
 	Description          string     `json:"description" gorm:"type:varchar(255)"`
 	MessageID            string     `json:"message_id" gorm:"type:varchar(36)"`
+	InvoiceID            string     `json:"invoice_id" gorm:"type:varchar(36);index"` // 已开票或申请中的发票
 	CreatedAt            time.Time  `json:"created_at" gorm:"index"`
 	PaidAt               *time.Time `json:"paid_at"`
 	RefundedAt           *time.Time `json:"refunded_at"`
@@ -41,35 +46,97 @@ type Order struct {
 // Message 消息模型
 type Message struct {
 	ID             string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
-	UserID         string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	UserID         string     `json:"user_id" gorm:"type:varchar(36);not null;index;index:idx_messages_user_created,priority:1"`
 	OrderID        string     `json:"order_id" gorm:"type:varchar(36);index"`
+	BatchID        string     `json:"batch_id" gorm:"type:varchar(36);index"`
+	ThreadID       string     `json:"thread_id" gorm:"type:varchar(36);index"`
+	ReplyToID      string     `json:"reply_to_id" gorm:"type:varchar(36);index"`
+	Direction      string     `json:"direction" gorm:"type:enum('outbound','inbound');default:'outbound'"`
 	RecipientPhone string     `json:"recipient_phone" gorm:"type:varchar(20);not null"`
-	Content        string     `json:"content" gorm:"type:text;not null"`
+	CountryCode    string     `json:"country_code" gorm:"type:varchar(2);default:'CN'"`
+	TemplateID     string     `json:"template_id" gorm:"type:varchar(36);index"`
+	TemplateParams string     `json:"template_params" gorm:"type:text"`
+	Content        string     `json:"content" gorm:"type:text;not null;index:idx_messages_content,class:FULLTEXT,option:WITH PARSER ngram"`
 	CharacterCount int        `json:"character_count" gorm:"not null"`
 	Cost           float64    `json:"cost" gorm:"type:decimal(10,2);not null"`
-	Status         string     `json:"status" gorm:"type:enum('pending','scheduled','sending','sent','failed','cancelled');default:'pending';index"`
+	Parts          int        `json:"parts" gorm:"default:1"`      // 拆分发送的短信条数，按条数计费
+	SentParts      int        `json:"sent_parts" gorm:"default:0"` // 已发出的条数，中途失败时只退还未发出部分的费用
+	Status         string     `json:"status" gorm:"type:enum('pending','scheduled','sending','sent','failed','cancelled','received');default:'pending';index"`
 	ScheduledAt    *time.Time `json:"scheduled_at" gorm:"index"`
 	SentAt         *time.Time `json:"sent_at"`
 	FailedReason   string     `json:"failed_reason" gorm:"type:varchar(255)"`
 	SMSProvider    string     `json:"sms_provider" gorm:"type:enum('aliyun','tencent','huawei');default:'aliyun'"`
-	SMSMessageID   string     `json:"sms_message_id" gorm:"type:varchar(100)"`
-	CreatedAt      time.Time  `json:"created_at"`
+	SMSMessageID   string     `json:"sms_message_id" gorm:"type:varchar(100)"` // 入站消息在同一会话内唯一（idx_messages_thread_uplink）
+	CreatedAt      time.Time  `json:"created_at" gorm:"index:idx_messages_user_created,priority:2"`
 	UpdatedAt      time.Time  `json:"updated_at"`
 	User           User       `json:"user" gorm:"foreignKey:UserID"`
 	Order          Order      `json:"order" gorm:"foreignKey:OrderID"`
 }
 
+// MessageBatch 批量发送任务模型
+type MessageBatch struct {
+	ID          string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID      string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	OrderID     string     `json:"order_id" gorm:"type:varchar(36);index"`
+	Source      string     `json:"source" gorm:"type:enum('json','csv','xlsx');default:'json'"`
+	Content     string     `json:"content" gorm:"type:text"` // 正文模板，可包含 ${变量}
+	TemplateID  string     `json:"template_id" gorm:"type:varchar(36)"`
+	TotalRows   int        `json:"total_rows" gorm:"not null"`
+	ValidRows   int        `json:"valid_rows" gorm:"not null"`
+	InvalidRows int        `json:"invalid_rows" gorm:"not null"`
+	SentCount   int        `json:"sent_count" gorm:"default:0"`
+	FailedCount int        `json:"failed_count" gorm:"default:0"`
+	TotalCost   float64    `json:"total_cost" gorm:"type:decimal(10,2);not null"`
+	Status      string     `json:"status" gorm:"type:enum('pending','processing','completed','failed');default:'pending';index"`
+	ScheduledAt *time.Time `json:"scheduled_at"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// MessageBatchItem 批量发送明细模型，每行对应一个收件人
+type MessageBatchItem struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	BatchID   string    `json:"batch_id" gorm:"type:varchar(36);not null;index"`
+	RowNo     int       `json:"row_no" gorm:"not null"`
+	Phone     string    `json:"phone" gorm:"type:varchar(20)"`
+	Variables string    `json:"variables" gorm:"type:text"` // JSON格式的行变量
+	MessageID string    `json:"message_id" gorm:"type:varchar(36);index"`
+	Cost      float64   `json:"cost" gorm:"type:decimal(10,2);default:0.00"`
+	Status    string    `json:"status" gorm:"type:enum('invalid','duplicate','pending','sent','failed');default:'pending';index"`
+	Error     string    `json:"error" gorm:"type:varchar(255)"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RelayThread 匿名会话，每个发送方与收件人之间一条，通过扩展码接收收件人回复
+type RelayThread struct {
+	ID             string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID         string     `json:"user_id" gorm:"type:varchar(36);not null;uniqueIndex:idx_user_recipient"`
+	RecipientPhone string     `json:"-" gorm:"type:varchar(20);not null;uniqueIndex:idx_user_recipient"`
+	ExtendCode     string     `json:"-" gorm:"type:varchar(8);not null;uniqueIndex"`
+	Archived       bool       `json:"archived" gorm:"default:false;index"`
+	Muted          bool       `json:"muted" gorm:"default:false"`
+	LastReadAt     *time.Time `json:"last_read_at"`
+	LastActivityAt time.Time  `json:"last_activity_at" gorm:"index"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
 // Bill 账单模型
 type Bill struct {
 	ID            string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
-	UserID        string    `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	UserID        string    `json:"user_id" gorm:"type:varchar(36);not null;index;index:idx_bills_user_created,priority:1"`
 	OrderID       string    `json:"order_id" gorm:"type:varchar(36);index"`
-	Type          string    `json:"type" gorm:"type:enum('payment','refund','consumption','recharge');not null;index"`
+	Type          string    `json:"type" gorm:"type:enum('payment','refund','consumption','recharge','expiry','merge','adjustment');not null;index"`
 	Amount        float64   `json:"amount" gorm:"type:decimal(10,2);not null"`
 	BalanceBefore float64   `json:"balance_before" gorm:"type:decimal(10,2);not null"`
 	BalanceAfter  float64   `json:"balance_after" gorm:"type:decimal(10,2);not null"`
+	Credits       int       `json:"credits" gorm:"default:0"`            // 套餐条数变动，增加为正、扣减为负
+	CreditsAfter  int       `json:"credits_after" gorm:"default:0"`      // 变动后的套餐剩余条数
+	MergedFrom    string    `json:"merged_from" gorm:"type:varchar(36)"` // 账户合并前所属的用户ID
 	Description   string    `json:"description" gorm:"type:varchar(255)"`
-	CreatedAt     time.Time `json:"created_at" gorm:"index"`
+	CreatedAt     time.Time `json:"created_at" gorm:"index;index:idx_bills_user_created,priority:2"`
 	User          User      `json:"user" gorm:"foreignKey:UserID"`
 	Order         Order     `json:"order" gorm:"foreignKey:OrderID"`
 }
@@ -84,6 +151,22 @@ type SystemConfig struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// SMSTemplate 短信模板模型
+type SMSTemplate struct {
+	ID           string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID       string    `json:"user_id" gorm:"type:varchar(36);index"` // 为空表示系统模板
+	Name         string    `json:"name" gorm:"type:varchar(100);not null"`
+	TemplateType string    `json:"template_type" gorm:"type:enum('notification','marketing','international');default:'notification'"`
+	TemplateCode string    `json:"template_code" gorm:"type:varchar(50);index"`
+	Content      string    `json:"content" gorm:"type:text;not null"`
+	Variables    string    `json:"variables" gorm:"type:text"` // JSON格式的变量定义
+	Remark       string    `json:"remark" gorm:"type:varchar(255)"`
+	Status       string    `json:"status" gorm:"type:enum('pending','approved','rejected','deleted');default:'pending';index"`
+	RejectReason string    `json:"reject_reason" gorm:"type:varchar(255)"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
 // PaymentRecord 支付记录模型
 type PaymentRecord struct {
 	ID            string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
@@ -106,7 +189,348 @@ type RefundRecord struct {
 	Reason              string     `json:"reason" gorm:"type:varchar(255)"`
 	Status              string     `json:"status" gorm:"type:enum('pending','success','failed');default:'pending';index"`
 	RefundTransactionID string     `json:"refund_transaction_id" gorm:"type:varchar(100)"`
+	RequestID           string     `json:"request_id" gorm:"type:varchar(36);uniqueIndex"` // 退款请求标识，重试同一请求时不重复退款
 	CreatedAt           time.Time  `json:"created_at"`
 	ProcessedAt         *time.Time `json:"processed_at"`
 	Order               Order      `json:"order" gorm:"foreignKey:OrderID"`
-}
\ No newline at end of file
+}
+
+// StatusHistory 订单、消息、支付记录和退款记录的状态变更历史，新建记录时 FromStatus 为空
+type StatusHistory struct {
+	ID         string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	EntityType string    `json:"entity_type" gorm:"type:enum('order','message','payment','refund');not null;index:idx_status_history_entity,priority:1"`
+	EntityID   string    `json:"entity_id" gorm:"type:varchar(36);not null;index:idx_status_history_entity,priority:2"`
+	FromStatus string    `json:"from_status" gorm:"type:varchar(20)"`
+	ToStatus   string    `json:"to_status" gorm:"type:varchar(20);not null"`
+	Cause      string    `json:"cause" gorm:"type:varchar(255)"`
+	CreatedAt  time.Time `json:"created_at" gorm:"type:datetime(6)"` // 同一事务中的多次变更按微秒区分先后
+}
+
+// OutboxEvent 待执行的副作用（发送短信、退款等），与业务数据在同一事务中写入，由后台任务执行
+type OutboxEvent struct {
+	ID          string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Type        string     `json:"type" gorm:"type:varchar(50);not null;index"`
+	AggregateID string     `json:"aggregate_id" gorm:"type:varchar(36);not null;index"` // 关联的订单或消息ID
+	Payload     string     `json:"payload" gorm:"type:text"`
+	Status      string     `json:"status" gorm:"type:enum('pending','processing','done','failed');default:'pending';index:idx_outbox_due,priority:1"`
+	Attempts    int        `json:"attempts" gorm:"default:0"`
+	AvailableAt time.Time  `json:"available_at" gorm:"not null;index:idx_outbox_due,priority:2"` // 最早执行时间，定时发送和重试时推后
+	LockedUntil *time.Time `json:"locked_until"`                                                 // 执行中的事件超过该时间未完成时重新执行
+	ClaimToken  string     `json:"-" gorm:"type:varchar(36)"`                                    // 每次领取生成，完成或重试时校验仍持有租约
+	LastError   string     `json:"last_error" gorm:"type:varchar(500)"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ProcessedAt *time.Time `json:"processed_at"`
+}
+
+// IdempotencyKey 幂等键记录，用于重放客户端重试的请求结果
+type IdempotencyKey struct {
+	ID           string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID       string     `json:"user_id" gorm:"type:varchar(36);not null;uniqueIndex:idx_user_key"`
+	Key          string     `json:"key" gorm:"column:idempotency_key;type:varchar(128);not null;uniqueIndex:idx_user_key"`
+	Method       string     `json:"method" gorm:"type:varchar(10);not null"`
+	Path         string     `json:"path" gorm:"type:varchar(255);not null"`
+	RequestHash  string     `json:"request_hash" gorm:"type:varchar(64);not null"`
+	Status       string     `json:"status" gorm:"type:enum('processing','completed');default:'processing'"`
+	StatusCode   int        `json:"status_code"`
+	ResponseBody string     `json:"response_body" gorm:"type:mediumtext"`
+	LockToken    string     `json:"-" gorm:"type:varchar(36)"` // 处理中的请求持有的租约标识
+	LockedUntil  *time.Time `json:"locked_until"`              // 租约到期后相同请求的重试可以接管
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"index"`
+}
+
+// ExportJob 导出任务，文件生成后通过带令牌的下载链接获取，链接过期后文件被清理
+type ExportJob struct {
+	ID            string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID        string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	Kind          string     `json:"kind" gorm:"type:enum('bills','orders','messages','statement');not null"`
+	Format        string     `json:"format" gorm:"type:enum('csv','xlsx','pdf');not null"`
+	StartDate     time.Time  `json:"start_date"`
+	EndDate       time.Time  `json:"end_date"` // 不含当天
+	Status        string     `json:"status" gorm:"type:enum('pending','processing','completed','failed','expired');default:'pending';index"`
+	RowCount      int        `json:"row_count" gorm:"default:0"`
+	FileName      string     `json:"file_name" gorm:"type:varchar(255)"`
+	FilePath      string     `json:"-" gorm:"type:varchar(500)"`
+	FileSize      int64      `json:"file_size" gorm:"default:0"`
+	DownloadToken string     `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+	DownloadURL   string     `json:"download_url,omitempty" gorm:"-"`
+	Error         string     `json:"error" gorm:"type:varchar(255)"`
+	ExpiresAt     *time.Time `json:"expires_at" gorm:"index"`
+	CompletedAt   *time.Time `json:"completed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// InvoiceTitle 发票抬头
+type InvoiceTitle struct {
+	ID          string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID      string    `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	TitleType   string    `json:"title_type" gorm:"type:enum('company','personal');not null"`
+	Name        string    `json:"name" gorm:"type:varchar(100);not null"`
+	TaxID       string    `json:"tax_id" gorm:"type:varchar(20)"` // 纳税人识别号，企业抬头必填
+	Address     string    `json:"address" gorm:"type:varchar(255)"`
+	Phone       string    `json:"phone" gorm:"type:varchar(30)"`
+	BankName    string    `json:"bank_name" gorm:"type:varchar(100)"`
+	BankAccount string    `json:"bank_account" gorm:"type:varchar(50)"`
+	Email       string    `json:"email" gorm:"type:varchar(100)"` // 电子发票接收邮箱
+	IsDefault   bool      `json:"is_default" gorm:"default:false"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Invoice 电子发票，抬头信息在申请时留存快照
+type Invoice struct {
+	ID                string        `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID            string        `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	TitleID           string        `json:"title_id" gorm:"type:varchar(36)"`
+	InvoiceType       string        `json:"invoice_type" gorm:"type:enum('normal','special');default:'normal'"` // 普通发票/增值税专用发票
+	TitleType         string        `json:"title_type" gorm:"type:enum('company','personal');not null"`
+	TitleName         string        `json:"title_name" gorm:"type:varchar(100);not null"`
+	TaxID             string        `json:"tax_id" gorm:"type:varchar(20)"`
+	Email             string        `json:"email" gorm:"type:varchar(100)"`
+	Period            string        `json:"period" gorm:"type:varchar(7)"` // 按月开票时的月份，如 2024-05
+	Amount            float64       `json:"amount" gorm:"type:decimal(10,2);not null"`
+	Status            string        `json:"status" gorm:"type:enum('requested','issued','rejected','reversed');default:'requested';index"`
+	Provider          string        `json:"provider" gorm:"type:varchar(20)"`
+	ProviderRequestID string        `json:"provider_request_id" gorm:"type:varchar(100)"`
+	InvoiceCode       string        `json:"invoice_code" gorm:"type:varchar(20)"`
+	InvoiceNo         string        `json:"invoice_no" gorm:"type:varchar(30)"`
+	FileURL           string        `json:"file_url" gorm:"type:varchar(500)"`
+	RejectReason      string        `json:"reject_reason" gorm:"type:varchar(255)"`
+	IssuedAt          *time.Time    `json:"issued_at"`
+	ReversedAt        *time.Time    `json:"reversed_at"`
+	CreatedAt         time.Time     `json:"created_at"`
+	UpdatedAt         time.Time     `json:"updated_at"`
+	Items             []InvoiceItem `json:"items,omitempty" gorm:"foreignKey:InvoiceID"`
+}
+
+// InvoiceItem 发票包含的订单及开票金额（扣除已退款部分）
+type InvoiceItem struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	InvoiceID string    `json:"invoice_id" gorm:"type:varchar(36);not null;index"`
+	OrderID   string    `json:"order_id" gorm:"type:varchar(36);not null;index"`
+	OrderNo   string    `json:"order_no" gorm:"type:varchar(32)"`
+	Amount    float64   `json:"amount" gorm:"type:decimal(10,2);not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Coupon 优惠券，按券码使用
+type Coupon struct {
+	ID             string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Code           string     `json:"code" gorm:"type:varchar(32);not null;uniqueIndex"`
+	Name           string     `json:"name" gorm:"type:varchar(100);not null"`
+	DiscountType   string     `json:"discount_type" gorm:"type:enum('fixed','percentage');not null"`
+	DiscountValue  float64    `json:"discount_value" gorm:"type:decimal(10,2);not null"`   // 固定金额（元）或折扣百分比
+	MaxDiscount    float64    `json:"max_discount" gorm:"type:decimal(10,2);default:0.00"` // 百分比折扣的最高优惠，0为不限
+	MinSpend       float64    `json:"min_spend" gorm:"type:decimal(10,2);default:0.00"`
+	Scope          string     `json:"scope" gorm:"type:enum('all','message','batch');default:'all'"`
+	FirstOrderOnly bool       `json:"first_order_only" gorm:"default:false"`
+	TotalLimit     int        `json:"total_limit" gorm:"default:0"`    // 总使用次数上限，0为不限
+	PerUserLimit   int        `json:"per_user_limit" gorm:"default:1"` // 每个用户使用次数上限，0为不限
+	UsedCount      int        `json:"used_count" gorm:"default:0"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	Status         string     `json:"status" gorm:"type:enum('active','disabled');default:'active'"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// CouponRedemption 优惠券使用记录，订单支付失败或全额退款时撤销
+type CouponRedemption struct {
+	ID         string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	CouponID   string     `json:"coupon_id" gorm:"type:varchar(36);not null;index:idx_coupon_user"`
+	UserID     string     `json:"user_id" gorm:"type:varchar(36);not null;index:idx_coupon_user"`
+	OrderID    string     `json:"order_id" gorm:"type:varchar(36);not null;uniqueIndex"`
+	Code       string     `json:"code" gorm:"type:varchar(32);not null"`
+	Discount   float64    `json:"discount" gorm:"type:decimal(10,2);not null"`
+	Status     string     `json:"status" gorm:"type:enum('applied','reversed');default:'applied'"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReversedAt *time.Time `json:"reversed_at"`
+}
+
+// CreditPackage 短信套餐，购买后获得按条（每60字符为一条）抵扣的额度
+type CreditPackage struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Name      string    `json:"name" gorm:"type:varchar(100);not null"`
+	Segments  int       `json:"segments" gorm:"not null"`
+	Price     float64   `json:"price" gorm:"type:decimal(10,2);not null"`
+	ValidDays int       `json:"valid_days" gorm:"default:365"` // 有效天数，0为永久有效
+	Status    string    `json:"status" gorm:"type:enum('active','disabled');default:'active'"`
+	SortOrder int       `json:"sort_order" gorm:"default:0"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreditGrant 用户购买套餐获得的一笔额度，按到期时间先后扣减
+type CreditGrant struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID    string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	PackageID string     `json:"package_id" gorm:"type:varchar(36)"`
+	OrderID   string     `json:"order_id" gorm:"type:varchar(36);index"`
+	Segments  int        `json:"segments" gorm:"not null"`
+	Remaining int        `json:"remaining" gorm:"not null"`
+	Status    string     `json:"status" gorm:"type:enum('active','exhausted','expired');default:'active';index"`
+	ExpiresAt *time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// CreditUsage 订单从某笔额度中扣减的条数，订单退款时退回
+type CreditUsage struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID    string    `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	GrantID   string    `json:"grant_id" gorm:"type:varchar(36);not null;index"`
+	OrderID   string    `json:"order_id" gorm:"type:varchar(36);not null;index"`
+	Segments  int       `json:"segments" gorm:"not null"`
+	Status    string    `json:"status" gorm:"type:enum('used','refunded');default:'used'"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserSession 登录会话，每个设备一个。刷新令牌只保存哈希，每次刷新都会轮换
+type UserSession struct {
+	ID                string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID            string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	DeviceID          string     `json:"device_id" gorm:"type:varchar(64);index"`
+	DeviceName        string     `json:"device_name" gorm:"type:varchar(100)"`
+	UserAgent         string     `json:"user_agent" gorm:"type:varchar(255)"`
+	IPAddress         string     `json:"ip_address" gorm:"type:varchar(45)"`
+	RefreshTokenHash  string     `json:"-" gorm:"type:varchar(64);not null;uniqueIndex"`
+	PreviousTokenHash string     `json:"-" gorm:"type:varchar(64);index"` // 上一个刷新令牌，再次使用视为泄露
+	ExpiresAt         time.Time  `json:"expires_at" gorm:"index"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	RevokedReason     string     `json:"revoked_reason" gorm:"type:varchar(100)"`
+	Current           bool       `json:"current" gorm:"-"` // 是否为当前请求使用的会话
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// VerificationCode 短信验证码，只保存哈希
+type VerificationCode struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Phone     string     `json:"phone" gorm:"type:varchar(20);not null;index:idx_phone_purpose"`
+	Purpose   string     `json:"purpose" gorm:"type:varchar(20);not null;index:idx_phone_purpose"`
+	CodeHash  string     `json:"-" gorm:"type:varchar(64);not null"`
+	Attempts  int        `json:"attempts" gorm:"default:0"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"index"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// AccountMerge 账户合并记录，来源账户的数据和余额并入目标账户
+type AccountMerge struct {
+	ID                 string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	TargetUserID       string    `json:"target_user_id" gorm:"type:varchar(36);not null;index"`
+	SourceUserID       string    `json:"source_user_id" gorm:"type:varchar(36);not null;index"`
+	SourcePhone        string    `json:"source_phone" gorm:"type:varchar(20)"`
+	SourceWechatOpenID string    `json:"source_wechat_openid" gorm:"type:varchar(100)"`
+	Balance            float64   `json:"balance" gorm:"type:decimal(10,2);default:0.00"` // 并入的余额
+	Reason             string    `json:"reason" gorm:"type:varchar(20)"`                 // bind_phone / bind_wechat
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// AccountDeletion 注销申请，冷静期结束后匿名化个人信息，财务记录依法保留
+type AccountDeletion struct {
+	ID          string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID      string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	Reason      string     `json:"reason" gorm:"type:varchar(255)"`
+	Status      string     `json:"status" gorm:"type:enum('pending','cancelled','completed');default:'pending';index"`
+	ScheduledAt time.Time  `json:"scheduled_at" gorm:"index"` // 冷静期结束时间
+	CancelledAt *time.Time `json:"cancelled_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// UserSuspension 账号停用记录，ExpiresAt为空表示永久停用
+type UserSuspension struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID    string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	Reason    string     `json:"reason" gorm:"type:varchar(255);not null"`
+	Source    string     `json:"source" gorm:"type:enum('manual','auto');default:'manual'"`
+	Signal    string     `json:"signal" gorm:"type:varchar(50)"` // 自动停用的触发信号，如 complaints
+	Status    string     `json:"status" gorm:"type:enum('active','lifted','expired');default:'active';index"`
+	ExpiresAt *time.Time `json:"expires_at" gorm:"index"`
+	LiftedAt  *time.Time `json:"lifted_at"`
+	LiftNote  string     `json:"lift_note" gorm:"type:varchar(255)"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// MessageComplaint 针对发送方的投诉，作为自动停用的依据
+type MessageComplaint struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID    string    `json:"user_id" gorm:"type:varchar(36);not null;index:idx_complaints_user_created,priority:1"` // 被投诉的发送方
+	MessageID string    `json:"message_id" gorm:"type:varchar(36);index;uniqueIndex:idx_complaints_message_thread,priority:1"`
+	ThreadID  *string   `json:"thread_id,omitempty" gorm:"type:varchar(36);uniqueIndex:idx_complaints_message_thread,priority:2"` // 投诉的收件人所在会话，同一收件人对同一消息只记一次
+	Source    string    `json:"source" gorm:"type:enum('recipient','provider','manual');default:'recipient'"`
+	Reason    string    `json:"reason" gorm:"type:varchar(255)"`
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_complaints_user_created,priority:2"`
+}
+
+// SuspensionAppeal 用户对停用的申诉
+type SuspensionAppeal struct {
+	ID           string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	SuspensionID string     `json:"suspension_id" gorm:"type:varchar(36);not null;index"`
+	UserID       string     `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	Content      string     `json:"content" gorm:"type:text;not null"`
+	Status       string     `json:"status" gorm:"type:enum('pending','approved','rejected');default:'pending';index"`
+	Reply        string     `json:"reply" gorm:"type:varchar(255)"`
+	ReviewedAt   *time.Time `json:"reviewed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// AdminUser 运营后台管理员，Role决定可执行的操作
+type AdminUser struct {
+	ID           string     `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Username     string     `json:"username" gorm:"uniqueIndex;type:varchar(50);not null"`
+	PasswordHash string     `json:"-" gorm:"type:varchar(100);not null"`
+	Name         string     `json:"name" gorm:"type:varchar(50)"`
+	Role         string     `json:"role" gorm:"type:enum('superadmin','support','finance','reviewer');not null"`
+	Status       string     `json:"status" gorm:"type:enum('active','disabled');default:'active'"`
+	LastLoginAt  *time.Time `json:"last_login_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// AdminLoginAttempt 管理员登录尝试，按用户名和IP统计近期的失败次数以限制暴力破解
+type AdminLoginAttempt struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Username  string    `json:"username" gorm:"type:varchar(50);not null;index:idx_admin_login_username,priority:1"`
+	IPAddress string    `json:"ip_address" gorm:"type:varchar(45);not null;index:idx_admin_login_ip,priority:1"`
+	Succeeded bool      `json:"succeeded" gorm:"default:false"`
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_admin_login_username,priority:2;index:idx_admin_login_ip,priority:2"`
+}
+
+// BalanceAdjustment 管理员人工调整余额的记录，同时写入adjustment类型账单
+type BalanceAdjustment struct {
+	ID            string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	UserID        string    `json:"user_id" gorm:"type:varchar(36);not null;index"`
+	AdminID       string    `json:"admin_id" gorm:"type:varchar(36);not null;index"`
+	BillID        string    `json:"bill_id" gorm:"type:varchar(36)"`
+	Amount        float64   `json:"amount" gorm:"type:decimal(10,2);not null"` // 增加为正、扣减为负
+	BalanceBefore float64   `json:"balance_before" gorm:"type:decimal(10,2);not null"`
+	BalanceAfter  float64   `json:"balance_after" gorm:"type:decimal(10,2);not null"`
+	Reason        string    `json:"reason" gorm:"type:varchar(255);not null"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AuditLog 只追加的审计日志，Hash由上一条的Hash和本条内容计算，用于发现篡改
+type AuditLog struct {
+	ID         string    `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	Sequence   *int64    `json:"sequence" gorm:"uniqueIndex"` // 加入哈希链后的序号，写入后由后台任务补上
+	ActorType  string    `json:"actor_type" gorm:"type:enum('user','admin','system');not null;index:idx_audit_actor,priority:1"`
+	ActorID    string    `json:"actor_id" gorm:"type:varchar(64);index:idx_audit_actor,priority:2"` // 系统任务为任务名
+	Action     string    `json:"action" gorm:"type:varchar(64);not null;index"`
+	TargetType string    `json:"target_type" gorm:"type:varchar(32);index:idx_audit_target,priority:1"`
+	TargetID   string    `json:"target_id" gorm:"type:varchar(36);index:idx_audit_target,priority:2"`
+	Before     string    `json:"before" gorm:"type:text"` // JSON快照
+	After      string    `json:"after" gorm:"type:text"`
+	RequestID  string    `json:"request_id" gorm:"type:varchar(64);index"`
+	IPAddress  string    `json:"ip_address" gorm:"type:varchar(45)"`
+	PrevHash   string    `json:"prev_hash" gorm:"type:char(64)"`
+	Hash       string    `json:"hash" gorm:"type:char(64)"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrInvalidTransition 状态机不允许的状态变更，或变更到目标状态的条件不满足
+var ErrInvalidTransition = errors.New("不允许的状态变更")
+
+// 状态历史中的记录类型
+const (
+	EntityOrder   = "order"
+	EntityMessage = "message"
+	EntityPayment = "payment"
+	EntityRefund  = "refund"
+)
+
+// StateMachine 一类记录的状态机：新建时允许的状态、各状态允许变更到的状态，
+// 以及变更到某一状态前记录需要满足的条件
+type StateMachine[T any] struct {
+	Entity      string
+	Initial     []string
+	Transitions map[string][]string
+	Guards      map[string]func(record *T) error
+}
+
+// Check 校验 from → to 的变更，from 为空表示新建记录
+func (m *StateMachine[T]) Check(record *T, from, to string) error {
+	allowed := m.Initial
+	if from != "" {
+		allowed = m.Transitions[from]
+	}
+	if !slices.Contains(allowed, to) {
+		return fmt.Errorf("%w: %s %s → %s", ErrInvalidTransition, m.Entity, from, to)
+	}
+	if guard := m.Guards[to]; guard != nil {
+		if err := guard(record); err != nil {
+			return fmt.Errorf("%w: %s %s → %s: %v", ErrInvalidTransition, m.Entity, from, to, err)
+		}
+	}
+	return nil
+}
+
+// OrderStates 订单状态机。部分退款的订单仍为 paid，全额退款后为 refunded
+var OrderStates = &StateMachine[Order]{
+	Entity:  EntityOrder,
+	Initial: []string{"pending"},
+	Transitions: map[string][]string{
+		"pending": {"paid", "failed", "cancelled"},
+		"paid":    {"refunded"},
+	},
+	Guards: map[string]func(*Order) error{
+		"paid": func(o *Order) error {
+			if o.PaidAt == nil {
+				return errors.New("缺少支付时间")
+			}
+			return nil
+		},
+		"refunded": func(o *Order) error {
+			if o.RefundedAt == nil {
+				return errors.New("缺少退款时间")
+			}
+			return nil
+		},
+	},
+}
+
+// MessageStates 消息状态机。发出的消息从 pending 开始，收到的回复直接为 received；
+// scheduled 仅存在于定时发送改为事件执行之前的数据
+var MessageStates = &StateMachine[Message]{
+	Entity:  EntityMessage,
+	Initial: []string{"pending", "received"},
+	Transitions: map[string][]string{
+		"pending":   {"sending", "cancelled"},
+		"scheduled": {"sending", "cancelled"},
+		"sending":   {"sent", "failed"},
+	},
+	Guards: map[string]func(*Message) error{
+		"sent": func(m *Message) error {
+			if m.SentAt == nil {
+				return errors.New("缺少发送时间")
+			}
+			return nil
+		},
+		"failed": func(m *Message) error {
+			if m.FailedReason == "" {
+				return errors.New("缺少失败原因")
+			}
+			return nil
+		},
+	},
+}
+
+// PaymentStates 支付记录状态机。同步扣款的记录新建时即为最终结果
+var PaymentStates = &StateMachine[PaymentRecord]{
+	Entity:  EntityPayment,
+	Initial: []string{"pending", "success", "failed"},
+	Transitions: map[string][]string{
+		"pending": {"success", "failed"},
+	},
+	Guards: map[string]func(*PaymentRecord) error{
+		"success": func(p *PaymentRecord) error {
+			if p.TransactionID == "" {
+				return errors.New("缺少支付交易号")
+			}
+			return nil
+		},
+	},
+}
+
+// RefundStates 退款记录状态机
+var RefundStates = &StateMachine[RefundRecord]{
+	Entity:  EntityRefund,
+	Initial: []string{"pending", "success", "failed"},
+	Transitions: map[string][]string{
+		"pending": {"success", "failed"},
+	},
+	Guards: map[string]func(*RefundRecord) error{
+		"success": func(r *RefundRecord) error {
+			if r.ProcessedAt == nil {
+				return errors.New("缺少退款完成时间")
+			}
+			return nil
+		},
+	},
+}
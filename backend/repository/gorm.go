@@ -0,0 +1,622 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 全文索引使用 ngram 分词，短于分词长度的关键词改用 LIKE 匹配
+const ngramTokenSize = 2
+
+// periodFormats 各汇总周期对应的 MySQL 日期格式，周按 ISO 周计算
+var periodFormats = map[string]string{
+	"day":   "%Y-%m-%d",
+	"week":  "%x-W%v",
+	"month": "%Y-%m",
+}
+
+// NewGorm 基于数据库连接创建仓储
+func NewGorm(db *gorm.DB) Repositories {
+	return Repositories{
+		Users:    &gormUsers{db: db},
+		Orders:   &gormOrders{db: db},
+		Messages: &gormMessages{db: db},
+		Bills:    &gormBills{db: db},
+		Payments: &gormPayments{db: db},
+		Refunds:  &gormRefunds{db: db},
+		Outbox:   &gormOutbox{db: db},
+		History:  &gormHistory{db: db},
+		Audit:    NewGormAudit(db),
+		Batches:  &gormBatches{db: db},
+		transaction: func(fn func(tx Repositories, db *gorm.DB) error) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				return fn(NewGorm(tx), tx)
+			})
+		},
+	}
+}
+
+// first 查询单条记录，不存在时返回 ErrNotFound
+func first(query *gorm.DB, dest interface{}) error {
+	err := query.First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// create 按状态机校验初始状态，在同一事务中写入记录和状态历史
+func create[T any](db *gorm.DB, machine *models.StateMachine[T], key func(*T) (string, string), records ...*T) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	histories := make([]*models.StatusHistory, 0, len(records))
+	for _, record := range records {
+		id, status := key(record)
+		if err := machine.Check(record, "", status); err != nil {
+			return err
+		}
+		histories = append(histories, newHistory(machine.Entity, id, "", status, createdCause))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(records, 100).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(histories, 100).Error
+	})
+}
+
+// transition 锁定记录，校验当前状态和状态机后按主键更新全部字段，并写入状态历史。
+// 当前状态不是 from 之一时返回 ErrConflict
+func transition[T any](db *gorm.DB, machine *models.StateMachine[T], key func(*T) (string, string), record *T, cause string, from []string) error {
+	id, to := key(record)
+	return db.Transaction(func(tx *gorm.DB) error {
+		var statuses []string
+		err := tx.Model(new(T)).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			Pluck("status", &statuses).Error
+		if err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			return ErrNotFound
+		}
+		current := statuses[0]
+		if !slices.Contains(from, current) {
+			return ErrConflict
+		}
+		if err := machine.Check(record, current, to); err != nil {
+			return err
+		}
+
+		err = tx.Model(record).
+			Where("id = ? AND status = ?", id, current).
+			Select("*").Omit(clause.Associations).
+			Updates(record).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(newHistory(machine.Entity, id, current, to, cause)).Error
+	})
+}
+
+// paginate 叠加时间范围并统计总数，再按游标倒序取 Limit 条
+func paginate(query *gorm.DB, page Page, dest interface{}) (int64, error) {
+	if page.Start != nil {
+		query = query.Where("created_at >= ?", *page.Start)
+	}
+	if page.End != nil {
+		query = query.Where("created_at < ?", *page.End)
+	}
+
+	var total int64
+	if page.IncludeTotal {
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if page.AfterTime != nil {
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", *page.AfterTime, *page.AfterTime, page.AfterID)
+	}
+	err := query.Order("created_at DESC, id DESC").Limit(page.Limit).Find(dest).Error
+	return total, err
+}
+
+// periodQuery 按汇总范围筛选并返回周期表达式
+func periodQuery(query *gorm.DB, r PeriodRange) (*gorm.DB, string, error) {
+	format, ok := periodFormats[r.GroupBy]
+	if !ok {
+		return nil, "", fmt.Errorf("不支持的汇总周期: %s", r.GroupBy)
+	}
+
+	query = query.Where("user_id = ?", r.UserID)
+	if r.Start != nil {
+		query = query.Where("created_at >= ?", *r.Start)
+	}
+	if r.End != nil {
+		query = query.Where("created_at < ?", *r.End)
+	}
+	return query, fmt.Sprintf("DATE_FORMAT(created_at, '%s')", format), nil
+}
+
+// escapeLike 转义 LIKE 通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r *gormUsers) FindByID(id string) (*models.User, error) {
+	var user models.User
+	if err := first(r.db.Where("id = ?", id), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) FindByPhone(phone string) (*models.User, error) {
+	var user models.User
+	if err := first(r.db.Where("phone = ?", phone), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) FindByWechatOpenID(openID string) (*models.User, error) {
+	var user models.User
+	if err := first(r.db.Where("wechat_open_id = ?", openID), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) Create(user *models.User) error {
+	return r.db.Create(user).Error
+}
+
+type gormOrders struct {
+	db *gorm.DB
+}
+
+func (r *gormOrders) FindByID(id string) (*models.Order, error) {
+	var order models.Order
+	if err := first(r.db.Where("id = ?", id), &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (r *gormOrders) FindForUpdate(id string) (*models.Order, error) {
+	var order models.Order
+	if err := first(r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id), &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (r *gormOrders) Create(order *models.Order) error {
+	return create(r.db, models.OrderStates, orderKey, order)
+}
+
+func (r *gormOrders) Transition(order *models.Order, cause string, from ...string) error {
+	return transition(r.db, models.OrderStates, orderKey, order, cause, from)
+}
+
+func (r *gormOrders) ListStale(status string, before time.Time, limit int) ([]models.Order, error) {
+	var orders []models.Order
+	err := r.db.Where("status = ? AND created_at < ?", status, before).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&orders).Error
+	return orders, err
+}
+
+type gormMessages struct {
+	db *gorm.DB
+}
+
+func (r *gormMessages) FindByID(id string) (*models.Message, error) {
+	var message models.Message
+	if err := first(r.db.Where("id = ?", id), &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (r *gormMessages) Create(message *models.Message) error {
+	return create(r.db, models.MessageStates, messageKey, message)
+}
+
+func (r *gormMessages) CreateAll(messages []*models.Message) error {
+	return create(r.db, models.MessageStates, messageKey, messages...)
+}
+
+func (r *gormMessages) Transition(message *models.Message, cause string, from ...string) error {
+	message.UpdatedAt = time.Now()
+	return transition(r.db, models.MessageStates, messageKey, message, cause, from)
+}
+
+func (r *gormMessages) ListByOrder(orderID string) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.Where("order_id = ?", orderID).Order("created_at ASC, id ASC").Find(&messages).Error
+	return messages, err
+}
+
+func (r *gormMessages) List(q MessageQuery) ([]models.Message, int64, error) {
+	query := r.db.Model(&models.Message{}).Where("user_id = ?", q.UserID)
+	if q.Status != "" {
+		query = query.Where("status = ?", q.Status)
+	}
+	if q.Direction != "" {
+		query = query.Where("direction = ?", q.Direction)
+	}
+	if q.Recipient != "" {
+		query = query.Where("recipient_phone = ?", q.Recipient)
+	} else if q.RecipientLike != "" {
+		query = query.Where("recipient_phone LIKE ?", "%"+escapeLike(q.RecipientLike)+"%")
+	}
+	if q.Keyword != "" {
+		if len([]rune(q.Keyword)) < ngramTokenSize {
+			query = query.Where("content LIKE ?", "%"+escapeLike(q.Keyword)+"%")
+		} else {
+			// 整体作为短语匹配，避免关键词中的布尔运算符生效
+			phrase := `"` + strings.ReplaceAll(q.Keyword, `"`, " ") + `"`
+			query = query.Where("MATCH(content) AGAINST(? IN BOOLEAN MODE)", phrase)
+		}
+	}
+
+	var messages []models.Message
+	total, err := paginate(query, q.Page, &messages)
+	return messages, total, err
+}
+
+func (r *gormMessages) CountByOrder(orderID, excludeStatus string) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Message{}).
+		Where("order_id = ? AND status <> ?", orderID, excludeStatus).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *gormMessages) CountByPeriod(pr PeriodRange) ([]MessagePeriodCount, error) {
+	query, periodExpr, err := periodQuery(r.db.Model(&models.Message{}).Where("direction = ?", "outbound"), pr)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []MessagePeriodCount
+	err = query.Select(periodExpr + " AS period, status, COUNT(*) AS count").
+		Group("period, status").
+		Scan(&rows).Error
+	return rows, err
+}
+
+type gormBills struct {
+	db *gorm.DB
+}
+
+func (r *gormBills) Create(bill *models.Bill) error {
+	return r.db.Create(bill).Error
+}
+
+func (r *gormBills) List(q BillQuery) ([]models.Bill, int64, error) {
+	query := r.db.Model(&models.Bill{}).Where("user_id = ?", q.UserID)
+	if q.Type != "" {
+		query = query.Where("type = ?", q.Type)
+	}
+
+	var bills []models.Bill
+	total, err := paginate(query, q.Page, &bills)
+	return bills, total, err
+}
+
+func (r *gormBills) SumByPeriod(pr PeriodRange) ([]BillPeriodTotal, error) {
+	query, periodExpr, err := periodQuery(r.db.Model(&models.Bill{}), pr)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []BillPeriodTotal
+	err = query.Select(periodExpr + " AS period, type, COALESCE(SUM(amount), 0) AS amount").
+		Group("period, type").
+		Scan(&rows).Error
+	return rows, err
+}
+
+func (r *gormBills) Ledger(userID string) ([]models.Bill, error) {
+	var bills []models.Bill
+	err := r.db.Select("id", "balance_before", "balance_after", "merged_from", "created_at").
+		Where("user_id = ?", userID).
+		Order("created_at ASC, id ASC").
+		Find(&bills).Error
+	return bills, err
+}
+
+type gormPayments struct {
+	db *gorm.DB
+}
+
+func (r *gormPayments) Create(record *models.PaymentRecord) error {
+	return create(r.db, models.PaymentStates, paymentKey, record)
+}
+
+func (r *gormPayments) Transition(record *models.PaymentRecord, cause string, from ...string) error {
+	record.UpdatedAt = time.Now()
+	return transition(r.db, models.PaymentStates, paymentKey, record, cause, from)
+}
+
+func (r *gormPayments) ListByOrder(orderID string) ([]models.PaymentRecord, error) {
+	var records []models.PaymentRecord
+	err := r.db.Where("order_id = ?", orderID).Order("created_at ASC").Find(&records).Error
+	return records, err
+}
+
+type gormRefunds struct {
+	db *gorm.DB
+}
+
+func (r *gormRefunds) Create(record *models.RefundRecord) error {
+	return create(r.db, models.RefundStates, refundKey, record)
+}
+
+func (r *gormRefunds) Transition(record *models.RefundRecord, cause string, from ...string) error {
+	return transition(r.db, models.RefundStates, refundKey, record, cause, from)
+}
+
+func (r *gormRefunds) FindByRequestID(requestID string) (*models.RefundRecord, error) {
+	var record models.RefundRecord
+	if err := first(r.db.Where("request_id = ?", requestID), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *gormRefunds) ListByOrder(orderID string) ([]models.RefundRecord, error) {
+	var records []models.RefundRecord
+	err := r.db.Where("order_id = ?", orderID).Order("created_at ASC").Find(&records).Error
+	return records, err
+}
+
+func (r *gormRefunds) SumSucceeded(orderID string) (float64, error) {
+	return r.sum(orderID, "success")
+}
+
+func (r *gormRefunds) SumReserved(orderID string) (float64, error) {
+	return r.sum(orderID, "success", "pending")
+}
+
+func (r *gormRefunds) sum(orderID string, statuses ...string) (float64, error) {
+	var refunded float64
+	err := r.db.Model(&models.RefundRecord{}).
+		Where("order_id = ? AND status IN ?", orderID, statuses).
+		Select("COALESCE(SUM(refund_amount), 0)").
+		Scan(&refunded).Error
+	return refunded, err
+}
+
+type gormHistory struct {
+	db *gorm.DB
+}
+
+func (r *gormHistory) ListByEntity(entityType, entityID string) ([]models.StatusHistory, error) {
+	var histories []models.StatusHistory
+	err := r.db.Where("entity_type = ? AND entity_id = ?", entityType, entityID).
+		Order("created_at ASC, id ASC").
+		Find(&histories).Error
+	return histories, err
+}
+
+type gormBatches struct {
+	db *gorm.DB
+}
+
+func (r *gormBatches) Create(batch *models.MessageBatch, items []models.MessageBatchItem) error {
+	if err := r.db.Create(batch).Error; err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return r.db.CreateInBatches(items, 100).Error
+}
+
+func (r *gormBatches) FindByID(id string) (*models.MessageBatch, error) {
+	var batch models.MessageBatch
+	if err := first(r.db.Where("id = ?", id), &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func (r *gormBatches) ListByUser(userID string) ([]models.MessageBatch, error) {
+	var batches []models.MessageBatch
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&batches).Error
+	return batches, err
+}
+
+func (r *gormBatches) ListItems(batchID, status string) ([]models.MessageBatchItem, error) {
+	query := r.db.Where("batch_id = ?", batchID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var items []models.MessageBatchItem
+	err := query.Order("row_no ASC").Find(&items).Error
+	return items, err
+}
+
+func (r *gormBatches) RecordProgress(message *models.Message) error {
+	now := time.Now()
+	err := r.db.Model(&models.MessageBatchItem{}).
+		Where("message_id = ?", message.ID).
+		Updates(map[string]interface{}{
+			"status":     message.Status,
+			"error":      message.FailedReason,
+			"updated_at": now,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	counter := "sent_count"
+	if message.Status != "sent" {
+		counter = "failed_count"
+	}
+	err = r.db.Model(&models.MessageBatch{}).
+		Where("id = ?", message.BatchID).
+		Updates(map[string]interface{}{
+			counter:      gorm.Expr(counter + " + 1"),
+			"status":     "processing",
+			"updated_at": now,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	return r.db.Model(&models.MessageBatch{}).
+		Where("id = ? AND sent_count + failed_count >= valid_rows", message.BatchID).
+		Updates(map[string]interface{}{
+			"status":       "completed",
+			"completed_at": &now,
+		}).Error
+}
+
+func (r *gormBatches) FailForOrder(orderID string) error {
+	var batch models.MessageBatch
+	err := r.db.Where("order_id = ?", orderID).Limit(1).Find(&batch).Error
+	if err != nil || batch.ID == "" {
+		return err
+	}
+
+	now := time.Now()
+	err = r.db.Model(&models.MessageBatchItem{}).
+		Where("batch_id = ? AND status = ?", batch.ID, "pending").
+		Updates(map[string]interface{}{
+			"status":     "failed",
+			"error":      "支付失败",
+			"updated_at": now,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return r.db.Model(&batch).Updates(map[string]interface{}{
+		"status":     "failed",
+		"updated_at": now,
+	}).Error
+}
+
+type gormOutbox struct {
+	db *gorm.DB
+}
+
+func (r *gormOutbox) Enqueue(event *models.OutboxEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *gormOutbox) ClaimDue(now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// 多个实例同时领取时跳过已被其他实例锁定的事件
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND available_at <= ?) OR (status = ? AND locked_until < ?)", "pending", now, "processing", now).
+			Order("available_at ASC, id ASC").
+			Limit(limit).
+			Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		ids := make([]string, len(events))
+		for i := range events {
+			ids[i] = events[i].ID
+		}
+		lockedUntil := now.Add(lease)
+		token := uuid.New().String()
+		err = tx.Model(&models.OutboxEvent{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":       "processing",
+			"locked_until": lockedUntil,
+			"claim_token":  token,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"updated_at":   now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		for i := range events {
+			events[i].Status = "processing"
+			events[i].LockedUntil = &lockedUntil
+			events[i].ClaimToken = token
+			events[i].Attempts++
+		}
+		return nil
+	})
+	return events, err
+}
+
+// finish 结束本次领取，只更新仍持有租约的事件
+func (r *gormOutbox) finish(event *models.OutboxEvent, updates map[string]interface{}) error {
+	updates["locked_until"] = nil
+	updates["claim_token"] = ""
+	updates["updated_at"] = time.Now()
+
+	result := r.db.Model(&models.OutboxEvent{}).
+		Where("id = ? AND status = ? AND claim_token = ?", event.ID, "processing", event.ClaimToken).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (r *gormOutbox) Complete(event *models.OutboxEvent) error {
+	now := time.Now()
+	return r.finish(event, map[string]interface{}{
+		"status":       "done",
+		"last_error":   "",
+		"processed_at": &now,
+	})
+}
+
+func (r *gormOutbox) Retry(event *models.OutboxEvent, lastError string, availableAt time.Time) error {
+	return r.finish(event, map[string]interface{}{
+		"status":       "pending",
+		"available_at": availableAt,
+		"last_error":   lastError,
+	})
+}
+
+func (r *gormOutbox) Fail(event *models.OutboxEvent, lastError string) error {
+	return r.finish(event, map[string]interface{}{
+		"status":     "failed",
+		"last_error": lastError,
+	})
+}
+
+type gormAudit struct {
+	db *gorm.DB
+}
+
+// NewGormAudit 基于数据库连接写入审计记录，供尚未使用仓储的服务在自己的事务中调用
+func NewGormAudit(db *gorm.DB) AuditRepository {
+	return &gormAudit{db: db}
+}
+
+func (r *gormAudit) Append(record *models.AuditLog) error {
+	return r.db.Create(record).Error
+}
@@ -0,0 +1,837 @@
+package repository
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrDuplicate 内存实现中主键或唯一字段重复
+var ErrDuplicate = errors.New("记录已存在")
+
+// memoryStore 内存仓储的数据，按表保存记录副本
+type memoryStore struct {
+	mu       sync.Mutex
+	users    map[string]models.User
+	orders   map[string]models.Order
+	messages map[string]models.Message
+	bills    map[string]models.Bill
+	payments map[string]models.PaymentRecord
+	refunds  map[string]models.RefundRecord
+	outbox   map[string]models.OutboxEvent
+	batches  map[string]models.MessageBatch
+	items    map[string]models.MessageBatchItem
+	history  []models.StatusHistory
+	audits   []models.AuditLog
+}
+
+// NewMemory 创建内存仓储，用于单元测试。事务在出错时恢复到开始前的数据，
+// 但不隔离并发的读写，也不提供数据库连接
+func NewMemory() Repositories {
+	s := &memoryStore{
+		users:    make(map[string]models.User),
+		orders:   make(map[string]models.Order),
+		messages: make(map[string]models.Message),
+		bills:    make(map[string]models.Bill),
+		payments: make(map[string]models.PaymentRecord),
+		refunds:  make(map[string]models.RefundRecord),
+		outbox:   make(map[string]models.OutboxEvent),
+		batches:  make(map[string]models.MessageBatch),
+		items:    make(map[string]models.MessageBatchItem),
+	}
+
+	repos := Repositories{
+		Users:    &memoryUsers{s},
+		Orders:   &memoryOrders{s},
+		Messages: &memoryMessages{s},
+		Bills:    &memoryBills{s},
+		Payments: &memoryPayments{s},
+		Refunds:  &memoryRefunds{s},
+		Outbox:   &memoryOutbox{s},
+		History:  &memoryHistory{s},
+		Audit:    &memoryAudit{s},
+		Batches:  &memoryBatches{s},
+	}
+	repos.transaction = func(fn func(tx Repositories, db *gorm.DB) error) error {
+		restore := s.snapshot()
+		if err := fn(repos, nil); err != nil {
+			restore()
+			return err
+		}
+		return nil
+	}
+	return repos
+}
+
+// snapshot 复制当前数据，返回恢复函数
+func (s *memoryStore) snapshot() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, orders, messages := maps.Clone(s.users), maps.Clone(s.orders), maps.Clone(s.messages)
+	bills, payments, refunds := maps.Clone(s.bills), maps.Clone(s.payments), maps.Clone(s.refunds)
+	outbox, batches, items := maps.Clone(s.outbox), maps.Clone(s.batches), maps.Clone(s.items)
+	history, audits := slices.Clone(s.history), slices.Clone(s.audits)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.users, s.orders, s.messages = users, orders, messages
+		s.bills, s.payments, s.refunds = bills, payments, refunds
+		s.outbox, s.batches, s.items = outbox, batches, items
+		s.history, s.audits = history, audits
+	}
+}
+
+// checkCreate 按状态机校验新建记录的初始状态，返回对应的状态历史
+func checkCreate[T any](machine *models.StateMachine[T], key func(*T) (string, string), record *T) (*models.StatusHistory, error) {
+	id, status := key(record)
+	if err := machine.Check(record, "", status); err != nil {
+		return nil, err
+	}
+	return newHistory(machine.Entity, id, "", status, createdCause), nil
+}
+
+// transitionIn 校验当前状态和状态机后替换 rows 中的记录并追加状态历史，调用方需持有锁
+func transitionIn[T any](s *memoryStore, rows map[string]T, machine *models.StateMachine[T], key func(*T) (string, string), record *T, cause string, from []string) error {
+	id, to := key(record)
+	existing, ok := rows[id]
+	if !ok {
+		return ErrNotFound
+	}
+	_, current := key(&existing)
+	if !slices.Contains(from, current) {
+		return ErrConflict
+	}
+	if err := machine.Check(record, current, to); err != nil {
+		return err
+	}
+	rows[id] = *record
+	s.history = append(s.history, *newHistory(machine.Entity, id, current, to, cause))
+	return nil
+}
+
+// touch 与 GORM 一致，创建时补齐创建时间，保存时更新修改时间
+func touch(createdAt, updatedAt *time.Time) {
+	now := time.Now()
+	if createdAt != nil && createdAt.IsZero() {
+		*createdAt = now
+	}
+	if updatedAt != nil {
+		*updatedAt = now
+	}
+}
+
+// inPage 记录是否在分页的时间范围内
+func inPage(page Page, createdAt time.Time) bool {
+	if page.Start != nil && createdAt.Before(*page.Start) {
+		return false
+	}
+	return page.End == nil || createdAt.Before(*page.End)
+}
+
+// pageRows 按 (created_at, id) 倒序排列并截取游标之后的 Limit 条
+func pageRows[T any](rows []T, page Page, key func(*T) (time.Time, string)) ([]T, int64) {
+	total := int64(len(rows))
+	sort.Slice(rows, func(i, j int) bool {
+		ti, idi := key(&rows[i])
+		tj, idj := key(&rows[j])
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return idi > idj
+	})
+
+	result := make([]T, 0, len(rows))
+	for i := range rows {
+		createdAt, id := key(&rows[i])
+		if page.AfterTime != nil && !createdAt.Before(*page.AfterTime) &&
+			!(createdAt.Equal(*page.AfterTime) && id < page.AfterID) {
+			continue
+		}
+		if page.Limit > 0 && len(result) == page.Limit {
+			break
+		}
+		result = append(result, rows[i])
+	}
+	if !page.IncludeTotal {
+		total = 0
+	}
+	return result, total
+}
+
+// inRange 记录是否在汇总范围内
+func inRange(r PeriodRange, userID string, createdAt time.Time) bool {
+	if userID != r.UserID {
+		return false
+	}
+	if r.Start != nil && createdAt.Before(*r.Start) {
+		return false
+	}
+	return r.End == nil || createdAt.Before(*r.End)
+}
+
+type memoryUsers struct {
+	s *memoryStore
+}
+
+func (r *memoryUsers) find(match func(*models.User) bool) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, user := range r.s.users {
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUsers) FindByID(id string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID == id })
+}
+
+func (r *memoryUsers) FindByPhone(phone string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Phone != nil && *u.Phone == phone })
+}
+
+func (r *memoryUsers) FindByWechatOpenID(openID string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.WechatOpenID != nil && *u.WechatOpenID == openID })
+}
+
+func (r *memoryUsers) Create(user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.users {
+		if existing.ID == user.ID ||
+			(user.Phone != nil && existing.Phone != nil && *existing.Phone == *user.Phone) ||
+			(user.WechatOpenID != nil && existing.WechatOpenID != nil && *existing.WechatOpenID == *user.WechatOpenID) {
+			return ErrDuplicate
+		}
+	}
+	touch(&user.CreatedAt, &user.UpdatedAt)
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+type memoryOrders struct {
+	s *memoryStore
+}
+
+func (r *memoryOrders) FindByID(id string) (*models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, ok := r.s.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &order, nil
+}
+
+func (r *memoryOrders) FindForUpdate(id string) (*models.Order, error) {
+	return r.FindByID(id)
+}
+
+func (r *memoryOrders) Create(order *models.Order) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.orders[order.ID]; ok {
+		return ErrDuplicate
+	}
+	history, err := checkCreate(models.OrderStates, orderKey, order)
+	if err != nil {
+		return err
+	}
+	touch(&order.CreatedAt, nil)
+	r.s.orders[order.ID] = *order
+	r.s.history = append(r.s.history, *history)
+	return nil
+}
+
+func (r *memoryOrders) Transition(order *models.Order, cause string, from ...string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return transitionIn(r.s, r.s.orders, models.OrderStates, orderKey, order, cause, from)
+}
+
+func (r *memoryOrders) ListStale(status string, before time.Time, limit int) ([]models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var orders []models.Order
+	for _, order := range r.s.orders {
+		if order.Status == status && order.CreatedAt.Before(before) {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+type memoryMessages struct {
+	s *memoryStore
+}
+
+func (r *memoryMessages) FindByID(id string) (*models.Message, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	message, ok := r.s.messages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &message, nil
+}
+
+func (r *memoryMessages) Create(message *models.Message) error {
+	return r.CreateAll([]*models.Message{message})
+}
+
+func (r *memoryMessages) CreateAll(messages []*models.Message) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	histories := make([]models.StatusHistory, 0, len(messages))
+	for _, message := range messages {
+		if _, ok := r.s.messages[message.ID]; ok {
+			return ErrDuplicate
+		}
+		history, err := checkCreate(models.MessageStates, messageKey, message)
+		if err != nil {
+			return err
+		}
+		histories = append(histories, *history)
+	}
+	for _, message := range messages {
+		touch(&message.CreatedAt, &message.UpdatedAt)
+		r.s.messages[message.ID] = *message
+	}
+	r.s.history = append(r.s.history, histories...)
+	return nil
+}
+
+func (r *memoryMessages) Transition(message *models.Message, cause string, from ...string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	touch(nil, &message.UpdatedAt)
+	return transitionIn(r.s, r.s.messages, models.MessageStates, messageKey, message, cause, from)
+}
+
+func (r *memoryMessages) ListByOrder(orderID string) ([]models.Message, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var messages []models.Message
+	for _, m := range r.s.messages {
+		if m.OrderID == orderID {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	return messages, nil
+}
+
+func (r *memoryMessages) List(q MessageQuery) ([]models.Message, int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var rows []models.Message
+	for _, m := range r.s.messages {
+		if m.UserID != q.UserID || !inPage(q.Page, m.CreatedAt) ||
+			(q.Status != "" && m.Status != q.Status) ||
+			(q.Direction != "" && m.Direction != q.Direction) ||
+			(q.Recipient != "" && m.RecipientPhone != q.Recipient) ||
+			(q.Recipient == "" && q.RecipientLike != "" && !strings.Contains(m.RecipientPhone, q.RecipientLike)) ||
+			(q.Keyword != "" && !strings.Contains(m.Content, q.Keyword)) {
+			continue
+		}
+		rows = append(rows, m)
+	}
+
+	messages, total := pageRows(rows, q.Page, func(m *models.Message) (time.Time, string) {
+		return m.CreatedAt, m.ID
+	})
+	return messages, total, nil
+}
+
+func (r *memoryMessages) CountByOrder(orderID, excludeStatus string) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var count int64
+	for _, m := range r.s.messages {
+		if m.OrderID == orderID && m.Status != excludeStatus {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryMessages) CountByPeriod(pr PeriodRange) ([]MessagePeriodCount, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	counts := make(map[[2]string]int64)
+	for _, m := range r.s.messages {
+		if m.Direction == "outbound" && inRange(pr, m.UserID, m.CreatedAt) {
+			counts[[2]string{PeriodKey(m.CreatedAt, pr.GroupBy), m.Status}]++
+		}
+	}
+
+	rows := make([]MessagePeriodCount, 0, len(counts))
+	for key, count := range counts {
+		rows = append(rows, MessagePeriodCount{Period: key[0], Status: key[1], Count: count})
+	}
+	return rows, nil
+}
+
+type memoryBills struct {
+	s *memoryStore
+}
+
+func (r *memoryBills) Create(bill *models.Bill) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.bills[bill.ID]; ok {
+		return ErrDuplicate
+	}
+	touch(&bill.CreatedAt, nil)
+	r.s.bills[bill.ID] = *bill
+	return nil
+}
+
+func (r *memoryBills) List(q BillQuery) ([]models.Bill, int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var rows []models.Bill
+	for _, b := range r.s.bills {
+		if b.UserID == q.UserID && inPage(q.Page, b.CreatedAt) && (q.Type == "" || b.Type == q.Type) {
+			rows = append(rows, b)
+		}
+	}
+
+	bills, total := pageRows(rows, q.Page, func(b *models.Bill) (time.Time, string) {
+		return b.CreatedAt, b.ID
+	})
+	return bills, total, nil
+}
+
+func (r *memoryBills) SumByPeriod(pr PeriodRange) ([]BillPeriodTotal, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	sums := make(map[[2]string]float64)
+	for _, b := range r.s.bills {
+		if inRange(pr, b.UserID, b.CreatedAt) {
+			sums[[2]string{PeriodKey(b.CreatedAt, pr.GroupBy), b.Type}] += b.Amount
+		}
+	}
+
+	rows := make([]BillPeriodTotal, 0, len(sums))
+	for key, amount := range sums {
+		rows = append(rows, BillPeriodTotal{Period: key[0], Type: key[1], Amount: amount})
+	}
+	return rows, nil
+}
+
+func (r *memoryBills) Ledger(userID string) ([]models.Bill, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var bills []models.Bill
+	for _, b := range r.s.bills {
+		if b.UserID == userID {
+			bills = append(bills, b)
+		}
+	}
+	sort.Slice(bills, func(i, j int) bool {
+		if !bills[i].CreatedAt.Equal(bills[j].CreatedAt) {
+			return bills[i].CreatedAt.Before(bills[j].CreatedAt)
+		}
+		return bills[i].ID < bills[j].ID
+	})
+	return bills, nil
+}
+
+type memoryPayments struct {
+	s *memoryStore
+}
+
+func (r *memoryPayments) Create(record *models.PaymentRecord) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.payments {
+		if existing.ID == record.ID || existing.TransactionID == record.TransactionID {
+			return ErrDuplicate
+		}
+	}
+	history, err := checkCreate(models.PaymentStates, paymentKey, record)
+	if err != nil {
+		return err
+	}
+	touch(&record.CreatedAt, &record.UpdatedAt)
+	r.s.payments[record.ID] = *record
+	r.s.history = append(r.s.history, *history)
+	return nil
+}
+
+func (r *memoryPayments) Transition(record *models.PaymentRecord, cause string, from ...string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	touch(nil, &record.UpdatedAt)
+	return transitionIn(r.s, r.s.payments, models.PaymentStates, paymentKey, record, cause, from)
+}
+
+func (r *memoryPayments) ListByOrder(orderID string) ([]models.PaymentRecord, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var records []models.PaymentRecord
+	for _, record := range r.s.payments {
+		if record.OrderID == orderID {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+type memoryRefunds struct {
+	s *memoryStore
+}
+
+func (r *memoryRefunds) Create(record *models.RefundRecord) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.refunds {
+		if existing.ID == record.ID || (record.RequestID != "" && existing.RequestID == record.RequestID) {
+			return ErrDuplicate
+		}
+	}
+	history, err := checkCreate(models.RefundStates, refundKey, record)
+	if err != nil {
+		return err
+	}
+	touch(&record.CreatedAt, nil)
+	r.s.refunds[record.ID] = *record
+	r.s.history = append(r.s.history, *history)
+	return nil
+}
+
+func (r *memoryRefunds) Transition(record *models.RefundRecord, cause string, from ...string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return transitionIn(r.s, r.s.refunds, models.RefundStates, refundKey, record, cause, from)
+}
+
+func (r *memoryRefunds) FindByRequestID(requestID string) (*models.RefundRecord, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, record := range r.s.refunds {
+		if record.RequestID == requestID {
+			return &record, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryRefunds) ListByOrder(orderID string) ([]models.RefundRecord, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var records []models.RefundRecord
+	for _, record := range r.s.refunds {
+		if record.OrderID == orderID {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+func (r *memoryRefunds) SumSucceeded(orderID string) (float64, error) {
+	return r.sum(orderID, "success")
+}
+
+func (r *memoryRefunds) SumReserved(orderID string) (float64, error) {
+	return r.sum(orderID, "success", "pending")
+}
+
+func (r *memoryRefunds) sum(orderID string, statuses ...string) (float64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var refunded float64
+	for _, record := range r.s.refunds {
+		if record.OrderID == orderID && slices.Contains(statuses, record.Status) {
+			refunded += record.RefundAmount
+		}
+	}
+	return refunded, nil
+}
+
+type memoryHistory struct {
+	s *memoryStore
+}
+
+func (r *memoryHistory) ListByEntity(entityType, entityID string) ([]models.StatusHistory, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var histories []models.StatusHistory
+	for _, history := range r.s.history {
+		if history.EntityType == entityType && history.EntityID == entityID {
+			histories = append(histories, history)
+		}
+	}
+	return histories, nil
+}
+
+type memoryAudit struct {
+	s *memoryStore
+}
+
+func (r *memoryAudit) Append(record *models.AuditLog) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.audits = append(r.s.audits, *record)
+	return nil
+}
+
+type memoryOutbox struct {
+	s *memoryStore
+}
+
+func (r *memoryOutbox) Enqueue(event *models.OutboxEvent) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.outbox[event.ID]; ok {
+		return ErrDuplicate
+	}
+	touch(&event.CreatedAt, &event.UpdatedAt)
+	r.s.outbox[event.ID] = *event
+	return nil
+}
+
+func (r *memoryOutbox) ClaimDue(now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var events []models.OutboxEvent
+	for _, event := range r.s.outbox {
+		due := event.Status == "pending" && !event.AvailableAt.After(now)
+		expired := event.Status == "processing" && event.LockedUntil != nil && event.LockedUntil.Before(now)
+		if due || expired {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].AvailableAt.Equal(events[j].AvailableAt) {
+			return events[i].AvailableAt.Before(events[j].AvailableAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	lockedUntil := now.Add(lease)
+	token := uuid.New().String()
+	for i := range events {
+		events[i].Status = "processing"
+		events[i].LockedUntil = &lockedUntil
+		events[i].ClaimToken = token
+		events[i].Attempts++
+		events[i].UpdatedAt = now
+		r.s.outbox[events[i].ID] = events[i]
+	}
+	return events, nil
+}
+
+// finish 结束本次领取，只修改仍持有租约的事件
+func (r *memoryOutbox) finish(claimed *models.OutboxEvent, fn func(event *models.OutboxEvent)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	event, ok := r.s.outbox[claimed.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if event.Status != "processing" || event.ClaimToken != claimed.ClaimToken {
+		return ErrLeaseLost
+	}
+	fn(&event)
+	event.LockedUntil = nil
+	event.ClaimToken = ""
+	event.UpdatedAt = time.Now()
+	r.s.outbox[event.ID] = event
+	return nil
+}
+
+func (r *memoryOutbox) Complete(event *models.OutboxEvent) error {
+	return r.finish(event, func(event *models.OutboxEvent) {
+		now := time.Now()
+		event.Status = "done"
+		event.LastError = ""
+		event.ProcessedAt = &now
+	})
+}
+
+func (r *memoryOutbox) Retry(event *models.OutboxEvent, lastError string, availableAt time.Time) error {
+	return r.finish(event, func(event *models.OutboxEvent) {
+		event.Status = "pending"
+		event.LastError = lastError
+		event.AvailableAt = availableAt
+	})
+}
+
+func (r *memoryOutbox) Fail(event *models.OutboxEvent, lastError string) error {
+	return r.finish(event, func(event *models.OutboxEvent) {
+		event.Status = "failed"
+		event.LastError = lastError
+	})
+}
+
+type memoryBatches struct {
+	s *memoryStore
+}
+
+func (r *memoryBatches) Create(batch *models.MessageBatch, items []models.MessageBatchItem) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.batches[batch.ID]; ok {
+		return ErrDuplicate
+	}
+	for i := range items {
+		if _, ok := r.s.items[items[i].ID]; ok {
+			return ErrDuplicate
+		}
+	}
+	touch(&batch.CreatedAt, &batch.UpdatedAt)
+	r.s.batches[batch.ID] = *batch
+	for i := range items {
+		touch(&items[i].CreatedAt, &items[i].UpdatedAt)
+		r.s.items[items[i].ID] = items[i]
+	}
+	return nil
+}
+
+func (r *memoryBatches) FindByID(id string) (*models.MessageBatch, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	batch, ok := r.s.batches[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &batch, nil
+}
+
+func (r *memoryBatches) ListByUser(userID string) ([]models.MessageBatch, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var batches []models.MessageBatch
+	for _, batch := range r.s.batches {
+		if batch.UserID == userID {
+			batches = append(batches, batch)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].CreatedAt.After(batches[j].CreatedAt) })
+	return batches, nil
+}
+
+func (r *memoryBatches) ListItems(batchID, status string) ([]models.MessageBatchItem, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var items []models.MessageBatchItem
+	for _, item := range r.s.items {
+		if item.BatchID == batchID && (status == "" || item.Status == status) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].RowNo < items[j].RowNo })
+	return items, nil
+}
+
+func (r *memoryBatches) RecordProgress(message *models.Message) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := time.Now()
+	for id, item := range r.s.items {
+		if item.MessageID == message.ID {
+			item.Status, item.Error, item.UpdatedAt = message.Status, message.FailedReason, now
+			r.s.items[id] = item
+		}
+	}
+
+	batch, ok := r.s.batches[message.BatchID]
+	if !ok {
+		return nil
+	}
+	if message.Status == "sent" {
+		batch.SentCount++
+	} else {
+		batch.FailedCount++
+	}
+	batch.Status, batch.UpdatedAt = "processing", now
+	if batch.SentCount+batch.FailedCount >= batch.ValidRows {
+		batch.Status, batch.CompletedAt = "completed", &now
+	}
+	r.s.batches[batch.ID] = batch
+	return nil
+}
+
+func (r *memoryBatches) FailForOrder(orderID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := time.Now()
+	for _, batch := range r.s.batches {
+		if batch.OrderID != orderID {
+			continue
+		}
+		for id, item := range r.s.items {
+			if item.BatchID == batch.ID && item.Status == "pending" {
+				item.Status, item.Error, item.UpdatedAt = "failed", "支付失败", now
+				r.s.items[id] = item
+			}
+		}
+		batch.Status, batch.UpdatedAt = "failed", now
+		r.s.batches[batch.ID] = batch
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNotFound 按条件查询的记录不存在
+	ErrNotFound = errors.New("记录不存在")
+	// ErrConflict 状态变更时记录已不是预期的状态，通常是被并发的流程处理过
+	ErrConflict = errors.New("记录状态已变更")
+	// ErrLeaseLost 事件执行超过租约时长，已被重新领取，本次执行结果不再生效
+	ErrLeaseLost = errors.New("事件租约已失效")
+)
+
+// Page 按 (created_at, id) 倒序的游标分页条件
+type Page struct {
+	Start        *time.Time // 创建时间下限（含）
+	End          *time.Time // 创建时间上限（不含）
+	AfterTime    *time.Time // 上一页最后一条的位置，为空时从第一条开始
+	AfterID      string
+	Limit        int
+	IncludeTotal bool // 统计不含游标条件的总数
+}
+
+// MessageQuery 消息列表查询条件
+type MessageQuery struct {
+	Page
+	UserID        string
+	Status        string
+	Direction     string
+	Recipient     string // 完整号码精确匹配
+	RecipientLike string // 部分号码模糊匹配
+	Keyword       string // 正文关键词
+}
+
+// BillQuery 账单列表查询条件
+type BillQuery struct {
+	Page
+	UserID string
+	Type   string
+}
+
+// PeriodRange 按周期汇总的范围，Start/End 为空表示不限
+type PeriodRange struct {
+	UserID  string
+	Start   *time.Time
+	End     *time.Time
+	GroupBy string // day / week / month
+}
+
+// BillPeriodTotal 单个周期内某类账单的金额合计
+type BillPeriodTotal struct {
+	Period string
+	Type   string
+	Amount float64
+}
+
+// MessagePeriodCount 单个周期内某状态发出短信的数量
+type MessagePeriodCount struct {
+	Period string
+	Status string
+	Count  int64
+}
+
+type UserRepository interface {
+	FindByID(id string) (*models.User, error)
+	FindByPhone(phone string) (*models.User, error)
+	FindByWechatOpenID(openID string) (*models.User, error)
+	Create(user *models.User) error
+}
+
+// 订单、消息、支付记录和退款记录的状态只能通过 Create 和 Transition 变更：
+// 两者都按 models 中的状态机校验，不允许时返回 models.ErrInvalidTransition，
+// 并在同一事务中写入状态历史，cause 为变更原因
+
+type OrderRepository interface {
+	FindByID(id string) (*models.Order, error)
+	// FindForUpdate 在事务中查询并锁定订单，同一订单的退款等操作依次执行。
+	// 内存实现不加锁
+	FindForUpdate(id string) (*models.Order, error)
+	Create(order *models.Order) error
+	// Transition 仅当订单状态仍为 from 之一时保存，否则返回 ErrConflict
+	Transition(order *models.Order, cause string, from ...string) error
+	// ListStale 创建时间早于 before 且仍处于 status 的订单
+	ListStale(status string, before time.Time, limit int) ([]models.Order, error)
+}
+
+type MessageRepository interface {
+	FindByID(id string) (*models.Message, error)
+	Create(message *models.Message) error
+	CreateAll(messages []*models.Message) error
+	// Transition 仅当消息状态仍为 from 之一时保存，否则返回 ErrConflict
+	Transition(message *models.Message, cause string, from ...string) error
+	ListByOrder(orderID string) ([]models.Message, error)
+	List(query MessageQuery) ([]models.Message, int64, error)
+	// CountByOrder 统计订单下状态不是 excludeStatus 的消息
+	CountByOrder(orderID, excludeStatus string) (int64, error)
+	// CountByPeriod 按周期和状态统计用户发出的短信
+	CountByPeriod(r PeriodRange) ([]MessagePeriodCount, error)
+}
+
+type BillRepository interface {
+	Create(bill *models.Bill) error
+	List(query BillQuery) ([]models.Bill, int64, error)
+	// SumByPeriod 按周期和类型汇总账单金额
+	SumByPeriod(r PeriodRange) ([]BillPeriodTotal, error)
+	// Ledger 按时间顺序返回用户的全部账单，用于余额对账
+	Ledger(userID string) ([]models.Bill, error)
+}
+
+type PaymentRepository interface {
+	Create(record *models.PaymentRecord) error
+	// Transition 仅当支付记录状态仍为 from 之一时保存，否则返回 ErrConflict
+	Transition(record *models.PaymentRecord, cause string, from ...string) error
+	ListByOrder(orderID string) ([]models.PaymentRecord, error)
+}
+
+type RefundRepository interface {
+	Create(record *models.RefundRecord) error
+	// Transition 仅当退款记录状态仍为 from 之一时保存，否则返回 ErrConflict
+	Transition(record *models.RefundRecord, cause string, from ...string) error
+	FindByRequestID(requestID string) (*models.RefundRecord, error)
+	ListByOrder(orderID string) ([]models.RefundRecord, error)
+	// SumSucceeded 订单已成功退款的金额
+	SumSucceeded(orderID string) (float64, error)
+	// SumReserved 订单已成功和处理中的退款金额，新的退款不能超过订单实付减去该金额
+	SumReserved(orderID string) (float64, error)
+}
+
+type OutboxRepository interface {
+	Enqueue(event *models.OutboxEvent) error
+	// ClaimDue 领取到期的事件并锁定 lease 时长，执行中超时的事件会被重新领取。
+	// 每次领取生成新的 ClaimToken，Complete、Retry 和 Fail 需传入领取到的事件，
+	// 租约已被重新领取时不做修改并返回 ErrLeaseLost
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]models.OutboxEvent, error)
+	Complete(event *models.OutboxEvent) error
+	// Retry 执行失败，availableAt 后重试
+	Retry(event *models.OutboxEvent, lastError string, availableAt time.Time) error
+	// Fail 多次失败后放弃，保留记录供人工处理
+	Fail(event *models.OutboxEvent, lastError string) error
+}
+
+// BatchRepository 批量发送任务和逐行明细
+type BatchRepository interface {
+	// Create 写入批量任务和全部明细
+	Create(batch *models.MessageBatch, items []models.MessageBatchItem) error
+	FindByID(id string) (*models.MessageBatch, error)
+	ListByUser(userID string) ([]models.MessageBatch, error)
+	// ListItems 按行号返回明细，status 为空时返回全部
+	ListItems(batchID, status string) ([]models.MessageBatchItem, error)
+	// RecordProgress 记录批量消息的发送结果，全部有结果后任务完成
+	RecordProgress(message *models.Message) error
+	// FailForOrder 订单支付失败时，批量任务和待发送的明细标记为失败，订单不属于批量任务时不做处理
+	FailForOrder(orderID string) error
+}
+
+type AuditRepository interface {
+	// Append 写入尚未加入哈希链的审计记录，序号和哈希由后台任务补上
+	Append(record *models.AuditLog) error
+}
+
+type HistoryRepository interface {
+	// ListByEntity 按时间顺序返回记录的状态历史，entityType 为 models.EntityOrder 等
+	ListByEntity(entityType, entityID string) ([]models.StatusHistory, error)
+}
+
+// Repositories 通过构造函数注入到服务和处理器的数据访问接口
+type Repositories struct {
+	Users    UserRepository
+	Orders   OrderRepository
+	Messages MessageRepository
+	Bills    BillRepository
+	Payments PaymentRepository
+	Refunds  RefundRepository
+	Outbox   OutboxRepository
+	History  HistoryRepository
+	Audit    AuditRepository
+	Batches  BatchRepository
+
+	transaction func(fn func(tx Repositories, db *gorm.DB) error) error
+}
+
+// Transaction 在同一事务中执行，fn 返回错误时回滚。db 为事务连接，
+// 供优惠券、套餐等尚未使用仓储的服务加入同一事务；内存实现中 db 为 nil
+func (r Repositories) Transaction(fn func(tx Repositories, db *gorm.DB) error) error {
+	return r.transaction(fn)
+}
+
+// 新建记录时状态历史中的原因
+const createdCause = "新建"
+
+// newHistory 创建一条状态历史，原因超长时截断
+func newHistory(entityType, entityID, from, to, cause string) *models.StatusHistory {
+	if runes := []rune(cause); len(runes) > 255 {
+		cause = string(runes[:255])
+	}
+	return &models.StatusHistory{
+		ID:         uuid.New().String(),
+		EntityType: entityType,
+		EntityID:   entityID,
+		FromStatus: from,
+		ToStatus:   to,
+		Cause:      cause,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// 状态机管理的记录的主键和状态
+func orderKey(o *models.Order) (string, string)           { return o.ID, o.Status }
+func messageKey(m *models.Message) (string, string)       { return m.ID, m.Status }
+func paymentKey(p *models.PaymentRecord) (string, string) { return p.ID, p.Status }
+func refundKey(r *models.RefundRecord) (string, string)   { return r.ID, r.Status }
+
+// ValidPeriod 是否为支持的汇总周期
+func ValidPeriod(groupBy string) bool {
+	_, ok := periodFormats[groupBy]
+	return ok
+}
+
+// PeriodKey 生成与数据库汇总一致的周期标识，周按 ISO 周计算
+func PeriodKey(t time.Time, groupBy string) string {
+	switch groupBy {
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const verifyPurposeBindPhone = "bind_phone"
+
+var (
+	ErrUserNotFound        = errors.New("用户不存在")
+	ErrPhoneAlreadyBound   = errors.New("已绑定其他手机号，请先解绑")
+	ErrWechatAlreadyBound  = errors.New("已绑定其他微信，请先解绑")
+	ErrIdentityNotBound    = errors.New("尚未绑定")
+	ErrLastIdentity        = errors.New("至少需要保留一种登录方式")
+	ErrAccountConflict     = errors.New("该号码或微信所属账号已绑定其他登录方式，无法合并")
+	ErrAccountNotMergeable = errors.New("该号码或微信所属账号状态异常，无法合并")
+)
+
+// mergedUserTables 账户合并时整体迁移到目标账户的数据
+var mergedUserTables = []interface{}{
+	&models.Order{},
+	&models.Message{},
+	&models.Bill{},
+	&models.MessageBatch{},
+	&models.SMSTemplate{},
+	&models.ExportJob{},
+	&models.Invoice{},
+	&models.CouponRedemption{},
+	&models.CreditGrant{},
+	&models.CreditUsage{},
+}
+
+// WechatIdentity 微信身份信息
+type WechatIdentity struct {
+	OpenID    string
+	UnionID   string
+	Nickname  string
+	AvatarURL string
+}
+
+type AccountService struct {
+	verificationService *VerificationService
+	wechatAuthService   *WechatAuthService
+}
+
+func NewAccountService() (*AccountService, error) {
+	verificationService, err := NewVerificationService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountService{
+		verificationService: verificationService,
+		wechatAuthService:   NewWechatAuthService(),
+	}, nil
+}
+
+// SendBindPhoneCode 向待绑定的手机号发送验证码
+func (a *AccountService) SendBindPhoneCode(userID, phone string) error {
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return ErrUserNotFound
+	}
+	if user.Phone != nil {
+		return ErrPhoneAlreadyBound
+	}
+
+	_, err := a.verificationService.SendCode(phone, verifyPurposeBindPhone)
+	return err
+}
+
+// BindPhone 校验验证码后绑定手机号，该号码已注册过账号时将其合并到当前账号
+func (a *AccountService) BindPhone(actor Actor, userID, phone, code string) (*models.User, *models.AccountMerge, error) {
+	phone, err := a.verificationService.VerifyCode(phone, verifyPurposeBindPhone, code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var user models.User
+	var merge *models.AccountMerge
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, &user, "id = ?", userID); err != nil {
+			return ErrUserNotFound
+		}
+		if user.Phone != nil {
+			if *user.Phone == phone {
+				return nil
+			}
+			return ErrPhoneAlreadyBound
+		}
+
+		var source models.User
+		err := lockUser(tx, &source, "phone = ?", phone)
+		if err == nil {
+			if merge, err = mergeUsers(tx, actor, &user, &source, verifyPurposeBindPhone); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		user.Phone = &phone
+		user.UpdatedAt = time.Now()
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, nil, accountError("绑定手机号失败", err)
+	}
+
+	return &user, merge, nil
+}
+
+// BindWechat 用微信登录凭证换取 openid 后绑定微信，该微信已注册过账号时将其合并到当前账号。
+// profile 只提供昵称和头像
+func (a *AccountService) BindWechat(actor Actor, userID, code string, profile WechatIdentity) (*models.User, *models.AccountMerge, error) {
+	identity, err := a.wechatAuthService.Exchange(code)
+	if err != nil {
+		return nil, nil, err
+	}
+	identity.Nickname = profile.Nickname
+	identity.AvatarURL = profile.AvatarURL
+
+	var user models.User
+	var merge *models.AccountMerge
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, &user, "id = ?", userID); err != nil {
+			return ErrUserNotFound
+		}
+		if user.WechatOpenID != nil {
+			if *user.WechatOpenID == identity.OpenID {
+				return nil
+			}
+			return ErrWechatAlreadyBound
+		}
+
+		var source models.User
+		err := lockUser(tx, &source, "wechat_open_id = ?", identity.OpenID)
+		if err == nil {
+			if merge, err = mergeUsers(tx, actor, &user, &source, "bind_wechat"); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		openID := identity.OpenID
+		user.WechatOpenID = &openID
+		if identity.UnionID != "" {
+			user.WechatUnionID = identity.UnionID
+		}
+		if user.Nickname == "" {
+			user.Nickname = identity.Nickname
+		}
+		if user.AvatarURL == "" {
+			user.AvatarURL = identity.AvatarURL
+		}
+		user.UpdatedAt = time.Now()
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, nil, accountError("绑定微信失败", err)
+	}
+
+	return &user, merge, nil
+}
+
+// UnbindPhone 解绑手机号，需已绑定微信
+func (a *AccountService) UnbindPhone(userID string) (*models.User, error) {
+	return a.unbind(userID, func(user *models.User) (map[string]interface{}, error) {
+		if user.Phone == nil {
+			return nil, ErrIdentityNotBound
+		}
+		if user.WechatOpenID == nil {
+			return nil, ErrLastIdentity
+		}
+		return map[string]interface{}{"phone": nil, "login_type": "wechat"}, nil
+	})
+}
+
+// UnbindWechat 解绑微信，需已绑定手机号
+func (a *AccountService) UnbindWechat(userID string) (*models.User, error) {
+	return a.unbind(userID, func(user *models.User) (map[string]interface{}, error) {
+		if user.WechatOpenID == nil {
+			return nil, ErrIdentityNotBound
+		}
+		if user.Phone == nil {
+			return nil, ErrLastIdentity
+		}
+		return map[string]interface{}{"wechat_open_id": nil, "wechat_union_id": "", "login_type": "phone"}, nil
+	})
+}
+
+func (a *AccountService) unbind(userID string, updates func(user *models.User) (map[string]interface{}, error)) (*models.User, error) {
+	var user models.User
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, &user, "id = ?", userID); err != nil {
+			return ErrUserNotFound
+		}
+		values, err := updates(&user)
+		if err != nil {
+			return err
+		}
+		values["updated_at"] = time.Now()
+		if err := tx.Model(&user).Updates(values).Error; err != nil {
+			return err
+		}
+		return tx.First(&user, "id = ?", userID).Error
+	})
+	if err != nil {
+		return nil, accountError("解绑失败", err)
+	}
+
+	return &user, nil
+}
+
+// mergeUsers 在事务中将来源账户的消息、订单、账单等数据和余额并入目标账户，
+// 来源账户释放登录方式并标记为已删除。调用方负责保存目标账户
+func mergeUsers(tx *gorm.DB, actor Actor, target, source *models.User, reason string) (*models.AccountMerge, error) {
+	if source.Status != "active" {
+		return nil, ErrAccountNotMergeable
+	}
+	if (target.Phone != nil && source.Phone != nil) || (target.WechatOpenID != nil && source.WechatOpenID != nil) {
+		return nil, ErrAccountConflict
+	}
+
+	// 来源账户的账单单独成链，对账时按原账户分别校验
+	if err := tx.Model(&models.Bill{}).Where("user_id = ? AND merged_from = ''", source.ID).
+		Update("merged_from", source.ID).Error; err != nil {
+		return nil, err
+	}
+	for _, model := range mergedUserTables {
+		if err := tx.Model(model).Where("user_id = ?", source.ID).Update("user_id", target.ID).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := mergeInvoiceTitles(tx, target.ID, source.ID); err != nil {
+		return nil, err
+	}
+	if err := mergeThreads(tx, target.ID, source.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", source.ID).Delete(&models.IdempotencyKey{}).Error; err != nil {
+		return nil, err
+	}
+	if err := revokeSessions(tx.Where("user_id = ?", source.ID), "账户已合并"); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	merge := &models.AccountMerge{
+		ID:                 uuid.New().String(),
+		TargetUserID:       target.ID,
+		SourceUserID:       source.ID,
+		SourcePhone:        stringValue(source.Phone),
+		SourceWechatOpenID: stringValue(source.WechatOpenID),
+		Balance:            source.Balance,
+		Reason:             reason,
+		CreatedAt:          now,
+	}
+	balanceBefore := target.Balance
+
+	if source.Balance != 0 {
+		bill := &models.Bill{
+			ID:            uuid.New().String(),
+			UserID:        target.ID,
+			Type:          "merge",
+			Amount:        source.Balance,
+			BalanceBefore: target.Balance,
+			BalanceAfter:  roundAmount(target.Balance + source.Balance),
+			Description:   "合并账户余额",
+			CreatedAt:     now,
+		}
+		// 合并账单没有对应订单，order_id 留空为 NULL
+		if err := tx.Omit("OrderID").Create(bill).Error; err != nil {
+			return nil, err
+		}
+		target.Balance = bill.BalanceAfter
+	}
+
+	// 先释放来源账户的手机号和微信，再由目标账户接管
+	err := tx.Model(source).Updates(map[string]interface{}{
+		"phone":          nil,
+		"wechat_open_id": nil,
+		"balance":        0,
+		"status":         "deleted",
+		"updated_at":     now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Phone == nil {
+		target.Phone = source.Phone
+	}
+	if target.WechatOpenID == nil {
+		target.WechatOpenID = source.WechatOpenID
+		if target.WechatUnionID == "" {
+			target.WechatUnionID = source.WechatUnionID
+		}
+	}
+	if target.Nickname == "" {
+		target.Nickname = source.Nickname
+	}
+	if target.AvatarURL == "" {
+		target.AvatarURL = source.AvatarURL
+	}
+
+	if err := tx.Create(merge).Error; err != nil {
+		return nil, err
+	}
+	err = RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+		Actor:      actor,
+		Action:     "account.merge",
+		TargetType: "user",
+		TargetID:   target.ID,
+		Before:     map[string]interface{}{"balance": balanceBefore, "source_user_id": source.ID, "source_balance": source.Balance},
+		After:      map[string]interface{}{"balance": target.Balance, "merge_id": merge.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merge, nil
+}
+
+// mergeInvoiceTitles 迁移发票抬头，目标账户已有默认抬头时保留目标账户的默认
+func mergeInvoiceTitles(tx *gorm.DB, targetID, sourceID string) error {
+	var defaults int64
+	if err := tx.Model(&models.InvoiceTitle{}).Where("user_id = ? AND is_default = ?", targetID, true).Count(&defaults).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"user_id": targetID}
+	if defaults > 0 {
+		updates["is_default"] = false
+	}
+	return tx.Model(&models.InvoiceTitle{}).Where("user_id = ?", sourceID).Updates(updates).Error
+}
+
+// mergeThreads 迁移匿名会话，与目标账户同一收件人的会话合并为一个，
+// 合并后来源会话的扩展码失效
+func mergeThreads(tx *gorm.DB, targetID, sourceID string) error {
+	var threads []models.RelayThread
+	if err := tx.Where("user_id = ?", sourceID).Find(&threads).Error; err != nil {
+		return err
+	}
+
+	for _, thread := range threads {
+		var existing models.RelayThread
+		err := tx.Where("user_id = ? AND recipient_phone = ?", targetID, thread.RecipientPhone).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Model(&thread).Update("user_id", targetID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Message{}).Where("thread_id = ?", thread.ID).Update("thread_id", existing.ID).Error; err != nil {
+			return err
+		}
+		if thread.LastActivityAt.After(existing.LastActivityAt) {
+			if err := tx.Model(&existing).Update("last_activity_at", thread.LastActivityAt).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&thread).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lockUser(tx *gorm.DB, user *models.User, query string, args ...interface{}) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, args...).First(user).Error
+}
+
+// accountError 业务错误原样返回，数据库错误附加说明
+func accountError(action string, err error) error {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrPhoneAlreadyBound), errors.Is(err, ErrWechatAlreadyBound),
+		errors.Is(err, ErrIdentityNotBound), errors.Is(err, ErrLastIdentity),
+		errors.Is(err, ErrAccountConflict), errors.Is(err, ErrAccountNotMergeable):
+		return err
+	}
+	return fmt.Errorf("%s: %v", action, err)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOrderNotFound      = errors.New("订单不存在")
+	ErrOrderNotRefundable = errors.New("订单未支付、已退款或为套餐订单，不能退款")
+	ErrRefundFailed       = errors.New("退款失败，请稍后重试")
+)
+
+// UserFilter 后台用户搜索条件
+type UserFilter struct {
+	ListOptions
+	Keyword string // 用户ID、手机号、微信OpenID精确匹配，昵称模糊匹配
+	Status  string
+}
+
+// UserList 用户列表分页结果
+type UserList struct {
+	ListPage
+	Users []models.User
+}
+
+// UserDetail 后台查看的用户详情
+type UserDetail struct {
+	User        models.User                `json:"user"`
+	Credits     *CreditBalance             `json:"credits"`
+	Balance     *BalanceReconciliation     `json:"balance"`
+	Suspension  *models.UserSuspension     `json:"suspension,omitempty"`
+	Adjustments []models.BalanceAdjustment `json:"adjustments"` // 最近的人工调账记录
+}
+
+// OrderFilter 后台订单列表筛选条件
+type OrderFilter struct {
+	ListOptions
+	UserID  string
+	OrderNo string
+	Status  string
+}
+
+// OrderList 订单列表分页结果
+type OrderList struct {
+	ListPage
+	Orders []models.Order
+}
+
+// OrderDetail 订单及其支付、退款记录
+type OrderDetail struct {
+	Order    models.Order           `json:"order"`
+	Payments []models.PaymentRecord `json:"payments"`
+	Refunds  []models.RefundRecord  `json:"refunds"`
+	Messages []models.Message       `json:"messages"`
+}
+
+// AppealFilter 后台申诉列表筛选条件
+type AppealFilter struct {
+	ListOptions
+	Status string
+}
+
+// AppealList 申诉列表分页结果
+type AppealList struct {
+	ListPage
+	Appeals []models.SuspensionAppeal
+}
+
+// SearchUsers 按关键词和状态搜索用户，按注册时间倒序
+func (s *AdminService) SearchUsers(filter UserFilter) (*UserList, error) {
+	query := config.DB.Model(&models.User{})
+	if keyword := strings.TrimSpace(filter.Keyword); keyword != "" {
+		phone := keyword
+		if parsed, err := ParsePhoneNumber(keyword); err == nil {
+			phone = parsed.String()
+		}
+		query = query.Where("id = ? OR phone = ? OR wechat_open_id = ? OR nickname LIKE ?",
+			keyword, phone, keyword, "%"+escapeLike(keyword)+"%")
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	query, err := applyDateRange(query, filter.StartDate, filter.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &UserList{}
+	if filter.IncludeTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("统计用户数量失败: %v", err)
+		}
+		list.Total = &total
+	}
+
+	query, limit, err := applyListOptions(query, filter.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("获取用户列表失败: %v", err)
+	}
+
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[limit-1]
+		list.HasMore = true
+		list.NextCursor = encodeListCursor(last.CreatedAt, last.ID)
+	}
+	list.Users = users
+
+	return list, nil
+}
+
+// GetUserDetail 获取用户、余额对账结果、套餐余量和生效中的停用
+func (s *AdminService) GetUserDetail(userID string) (*UserDetail, error) {
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	balance, err := s.billService.reconcileBalance(userID)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := s.creditService.GetBalance(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &UserDetail{User: user, Credits: credits, Balance: balance}
+	if suspension, err := s.suspensionService.ActiveSuspension(userID); err == nil {
+		detail.Suspension = suspension
+	}
+	config.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(20).Find(&detail.Adjustments)
+
+	return detail, nil
+}
+
+// ListOrders 按创建时间倒序分页获取订单
+func (s *AdminService) ListOrders(filter OrderFilter) (*OrderList, error) {
+	query := config.DB.Model(&models.Order{})
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.OrderNo != "" {
+		query = query.Where("order_no = ?", filter.OrderNo)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	query, err := applyDateRange(query, filter.StartDate, filter.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &OrderList{}
+	if filter.IncludeTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("统计订单数量失败: %v", err)
+		}
+		list.Total = &total
+	}
+
+	query, limit, err := applyListOptions(query, filter.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []models.Order
+	if err := query.Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("获取订单列表失败: %v", err)
+	}
+
+	if len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		list.HasMore = true
+		list.NextCursor = encodeListCursor(last.CreatedAt, last.ID)
+	}
+	list.Orders = orders
+
+	return list, nil
+}
+
+// GetOrderDetail 获取订单及其支付、退款记录和关联的消息
+func (s *AdminService) GetOrderDetail(orderID string) (*OrderDetail, error) {
+	detail := &OrderDetail{}
+	if err := config.DB.First(&detail.Order, "id = ?", orderID).Error; err != nil {
+		return nil, ErrOrderNotFound
+	}
+
+	config.DB.Where("order_id = ?", orderID).Order("created_at ASC").Find(&detail.Payments)
+	config.DB.Where("order_id = ?", orderID).Order("created_at ASC").Find(&detail.Refunds)
+	config.DB.Where("order_id = ?", orderID).Order("created_at ASC").Find(&detail.Messages)
+
+	return detail, nil
+}
+
+// GetMessage 获取任意用户的消息
+func (s *AdminService) GetMessage(messageID string) (*models.Message, error) {
+	var message models.Message
+	if err := config.DB.First(&message, "id = ?", messageID).Error; err != nil {
+		return nil, ErrMessageNotFound
+	}
+	return &message, nil
+}
+
+// ListAppeals 按提交时间倒序分页获取停用申诉
+func (s *AdminService) ListAppeals(filter AppealFilter) (*AppealList, error) {
+	query := config.DB.Model(&models.SuspensionAppeal{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	query, err := applyDateRange(query, filter.StartDate, filter.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &AppealList{}
+	if filter.IncludeTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("统计申诉数量失败: %v", err)
+		}
+		list.Total = &total
+	}
+
+	query, limit, err := applyListOptions(query, filter.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var appeals []models.SuspensionAppeal
+	if err := query.Find(&appeals).Error; err != nil {
+		return nil, fmt.Errorf("获取申诉列表失败: %v", err)
+	}
+
+	if len(appeals) > limit {
+		appeals = appeals[:limit]
+		last := appeals[limit-1]
+		list.HasMore = true
+		list.NextCursor = encodeListCursor(last.CreatedAt, last.ID)
+	}
+	list.Appeals = appeals
+
+	return list, nil
+}
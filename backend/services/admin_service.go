@@ -0,0 +1,512 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	adminTokenAudience   = "admin"
+	minAdminPasswordLen  = 8
+	maxBalanceAdjustment = 100000
+
+	adminLoginWindow           = 15 * time.Minute // 统计登录失败次数的时间窗口
+	adminLoginMaxFailures      = 5                // 窗口内每个用户名最多失败次数，登录成功后重新计算
+	adminLoginMaxIPFailures    = 20               // 窗口内每个IP最多失败次数
+	adminLoginAttemptRetention = 24 * time.Hour   // 登录尝试记录的保留时长
+)
+
+// 后台权限
+const (
+	PermUsersRead     = "users.read"
+	PermUsersBalance  = "users.balance"
+	PermUsersSuspend  = "users.suspend"
+	PermMessagesRead  = "messages.read"
+	PermOrdersRead    = "orders.read"
+	PermOrdersRefund  = "orders.refund"
+	PermAppealsReview = "appeals.review"
+	PermAdminsManage  = "admins.manage"
+	PermAuditRead     = "audit.read"
+	PermConfigManage  = "config.manage"
+)
+
+// adminRolePermissions 各角色的权限，superadmin拥有全部权限
+var adminRolePermissions = map[string][]string{
+	"superadmin": nil,
+	"support":    {PermUsersRead, PermMessagesRead, PermOrdersRead},
+	"finance":    {PermUsersRead, PermOrdersRead, PermUsersBalance, PermOrdersRefund, PermAuditRead},
+	"reviewer":   {PermUsersRead, PermMessagesRead, PermUsersSuspend, PermAppealsReview},
+}
+
+var (
+	ErrAdminInvalidLogin     = errors.New("用户名或密码错误")
+	ErrAdminDisabled         = errors.New("管理员账号已停用")
+	ErrAdminNotFound         = errors.New("管理员不存在")
+	ErrAdminUsernameTaken    = errors.New("用户名已存在")
+	ErrAdminInvalidRole      = errors.New("无效的管理员角色")
+	ErrAdminPasswordTooShort = fmt.Errorf("密码至少%d位", minAdminPasswordLen)
+	ErrAdminSelfUpdate       = errors.New("不能修改自己的角色或状态")
+	ErrAdjustmentReason      = errors.New("调整余额必须填写原因")
+	ErrAdjustmentAmount      = fmt.Errorf("调整金额不能为0且绝对值不超过%d", maxBalanceAdjustment)
+	ErrInsufficientBalance   = errors.New("余额不足，无法扣减")
+	ErrAdminLoginThrottled   = errors.New("登录失败次数过多，请稍后再试")
+)
+
+var (
+	adminSecretOnce sync.Once
+	adminSecret     []byte
+)
+
+// AdminClaims 后台访问令牌声明，Subject为管理员ID
+type AdminClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// AdminLoginResult 管理员登录结果
+type AdminLoginResult struct {
+	Token     string           `json:"token"`
+	ExpiresIn int64            `json:"expires_in"`
+	Admin     models.AdminUser `json:"admin"`
+}
+
+// AdminInput 创建或修改管理员，修改时空字段保持不变
+type AdminInput struct {
+	Username string
+	Password string
+	Name     string
+	Role     string
+	Status   string
+}
+
+type AdminService struct {
+	secret            []byte
+	tokenTTL          time.Duration
+	billService       *BillService
+	creditService     *CreditService
+	suspensionService *SuspensionService
+}
+
+// NewAdminService 用户详情中的余额对账、套餐余量和停用状态由注入的服务查询
+func NewAdminService(billService *BillService, creditService *CreditService, suspensionService *SuspensionService) *AdminService {
+	return &AdminService{
+		secret:            loadAdminSecret(),
+		tokenTTL:          config.App.Admin.TokenTTL,
+		billService:       billService,
+		creditService:     creditService,
+		suspensionService: suspensionService,
+	}
+}
+
+// loadAdminSecret 后台令牌使用独立的签名密钥，用户令牌不能访问后台接口
+func loadAdminSecret() []byte {
+	adminSecretOnce.Do(func() {
+		if secret := config.App.Admin.JWTSecret; secret != "" {
+			adminSecret = []byte(secret)
+			return
+		}
+
+		log.Println("未配置ADMIN_JWT_SECRET，使用随机密钥")
+		adminSecret = make([]byte, 32)
+		if _, err := rand.Read(adminSecret); err != nil {
+			log.Fatal("生成后台JWT密钥失败:", err)
+		}
+	})
+	return adminSecret
+}
+
+// HasPermission 角色是否拥有指定权限
+func HasPermission(role, permission string) bool {
+	if role == "superadmin" {
+		return true
+	}
+	for _, p := range adminRolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureBootstrapAdmin 还没有管理员时按 ADMIN_BOOTSTRAP_USERNAME/ADMIN_BOOTSTRAP_PASSWORD 创建超级管理员
+func (s *AdminService) EnsureBootstrapAdmin() {
+	username := config.App.Admin.BootstrapUsername
+	password := config.App.Admin.BootstrapPassword
+	if username == "" || password == "" {
+		return
+	}
+
+	var count int64
+	if err := config.DB.Model(&models.AdminUser{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	input := AdminInput{Username: username, Password: password, Name: username, Role: "superadmin"}
+	if _, err := s.CreateAdmin(SystemActor("admin_bootstrap"), input); err != nil {
+		log.Printf("创建初始管理员失败: %v", err)
+		return
+	}
+	log.Printf("已创建初始管理员: %s", username)
+}
+
+// Login 校验用户名密码并签发后台访问令牌。同一用户名或IP近期失败次数过多时
+// 返回 ErrAdminLoginThrottled，不再校验密码
+func (s *AdminService) Login(username, password, ipAddress string) (*AdminLoginResult, error) {
+	username = strings.TrimSpace(username)
+	if err := s.checkLoginThrottle(username, ipAddress); err != nil {
+		return nil, err
+	}
+
+	var admin models.AdminUser
+	err := config.DB.Where("username = ?", username).First(&admin).Error
+	if err == nil && bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte(password)) != nil {
+		err = ErrAdminInvalidLogin
+	}
+	s.recordLoginAttempt(username, ipAddress, err == nil)
+	if err != nil {
+		return nil, ErrAdminInvalidLogin
+	}
+	if admin.Status != "active" {
+		return nil, ErrAdminDisabled
+	}
+
+	now := time.Now()
+	claims := AdminClaims{
+		Role: admin.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   admin.ID,
+			Audience:  jwt.ClaimStrings{adminTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.tokenTTL)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return nil, fmt.Errorf("签发令牌失败: %v", err)
+	}
+
+	admin.LastLoginAt = &now
+	config.DB.Model(&admin).Update("last_login_at", now)
+
+	return &AdminLoginResult{
+		Token:     token,
+		ExpiresIn: int64(s.tokenTTL.Seconds()),
+		Admin:     admin,
+	}, nil
+}
+
+// checkLoginThrottle 统计窗口内的登录失败次数，用户名只统计最近一次成功登录之后的失败
+func (s *AdminService) checkLoginThrottle(username, ipAddress string) error {
+	since := time.Now().Add(-adminLoginWindow)
+
+	var lastSuccess models.AdminLoginAttempt
+	err := config.DB.Where("username = ? AND succeeded = ? AND created_at >= ?", username, true, since).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&lastSuccess).Error
+	if err != nil {
+		return fmt.Errorf("查询登录记录失败: %v", err)
+	}
+	if lastSuccess.ID != "" {
+		since = lastSuccess.CreatedAt
+	}
+
+	var failures int64
+	err = config.DB.Model(&models.AdminLoginAttempt{}).
+		Where("username = ? AND succeeded = ? AND created_at >= ?", username, false, since).
+		Count(&failures).Error
+	if err != nil {
+		return fmt.Errorf("查询登录记录失败: %v", err)
+	}
+	if failures >= adminLoginMaxFailures {
+		return ErrAdminLoginThrottled
+	}
+
+	err = config.DB.Model(&models.AdminLoginAttempt{}).
+		Where("ip_address = ? AND succeeded = ? AND created_at >= ?", ipAddress, false, time.Now().Add(-adminLoginWindow)).
+		Count(&failures).Error
+	if err != nil {
+		return fmt.Errorf("查询登录记录失败: %v", err)
+	}
+	if failures >= adminLoginMaxIPFailures {
+		return ErrAdminLoginThrottled
+	}
+	return nil
+}
+
+// recordLoginAttempt 记录登录结果，写入失败只记录日志
+func (s *AdminService) recordLoginAttempt(username, ipAddress string, succeeded bool) {
+	attempt := &models.AdminLoginAttempt{
+		ID:        uuid.New().String(),
+		Username:  username,
+		IPAddress: ipAddress,
+		Succeeded: succeeded,
+		CreatedAt: time.Now(),
+	}
+	if err := config.DB.Create(attempt).Error; err != nil {
+		log.Printf("记录管理员登录失败: %v", err)
+	}
+}
+
+// CleanupLoginAttempts 删除超过保留时长的登录尝试记录
+func (s *AdminService) CleanupLoginAttempts() {
+	cutoff := time.Now().Add(-adminLoginAttemptRetention)
+	if err := config.DB.Where("created_at < ?", cutoff).Delete(&models.AdminLoginAttempt{}).Error; err != nil {
+		log.Printf("清理管理员登录记录失败: %v", err)
+	}
+}
+
+// StartLoginAttemptCleanup 定期清理过期的登录尝试记录
+func (s *AdminService) StartLoginAttemptCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.CleanupLoginAttempts()
+		}
+	}()
+}
+
+// Authenticate 校验后台访问令牌，并重新读取管理员，停用或调整角色后立即生效
+func (s *AdminService) Authenticate(tokenString string) (*models.AdminUser, error) {
+	claims := &AdminClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(adminTokenAudience))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrInvalidToken
+	}
+
+	var admin models.AdminUser
+	if err := config.DB.First(&admin, "id = ?", claims.Subject).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+	if admin.Status != "active" {
+		return nil, ErrAdminDisabled
+	}
+	return &admin, nil
+}
+
+// ListAdmins 获取全部管理员
+func (s *AdminService) ListAdmins() ([]models.AdminUser, error) {
+	var admins []models.AdminUser
+	if err := config.DB.Order("created_at ASC").Find(&admins).Error; err != nil {
+		return nil, fmt.Errorf("获取管理员失败: %v", err)
+	}
+	return admins, nil
+}
+
+// CreateAdmin 创建管理员
+func (s *AdminService) CreateAdmin(actor Actor, input AdminInput) (*models.AdminUser, error) {
+	username := strings.TrimSpace(input.Username)
+	if _, ok := adminRolePermissions[input.Role]; !ok {
+		return nil, ErrAdminInvalidRole
+	}
+	hash, err := hashAdminPassword(input.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	config.DB.Model(&models.AdminUser{}).Where("username = ?", username).Count(&count)
+	if count > 0 {
+		return nil, ErrAdminUsernameTaken
+	}
+
+	now := time.Now()
+	admin := &models.AdminUser{
+		ID:           uuid.New().String(),
+		Username:     username,
+		PasswordHash: hash,
+		Name:         truncateString(input.Name, 50),
+		Role:         input.Role,
+		Status:       "active",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(admin).Error; err != nil {
+			return err
+		}
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "admin.create",
+			TargetType: "admin",
+			TargetID:   admin.ID,
+			After:      admin,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建管理员失败: %v", err)
+	}
+	return admin, nil
+}
+
+// UpdateAdmin 修改管理员的名称、角色、状态或密码，不能修改自己的角色和状态
+func (s *AdminService) UpdateAdmin(actor Actor, adminID string, input AdminInput) (*models.AdminUser, error) {
+	var admin models.AdminUser
+	if err := config.DB.First(&admin, "id = ?", adminID).Error; err != nil {
+		return nil, ErrAdminNotFound
+	}
+
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if input.Name != "" {
+		updates["name"] = truncateString(input.Name, 50)
+	}
+	if input.Role != "" && input.Role != admin.Role {
+		if _, ok := adminRolePermissions[input.Role]; !ok {
+			return nil, ErrAdminInvalidRole
+		}
+		updates["role"] = input.Role
+	}
+	if input.Status != "" && input.Status != admin.Status {
+		if input.Status != "active" && input.Status != "disabled" {
+			return nil, fmt.Errorf("无效的状态: %s", input.Status)
+		}
+		updates["status"] = input.Status
+	}
+	if actor.ID == adminID && (updates["role"] != nil || updates["status"] != nil) {
+		return nil, ErrAdminSelfUpdate
+	}
+	if input.Password != "" {
+		hash, err := hashAdminPassword(input.Password)
+		if err != nil {
+			return nil, err
+		}
+		updates["password_hash"] = hash
+	}
+
+	before := admin
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&admin).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&admin, "id = ?", adminID).Error; err != nil {
+			return err
+		}
+
+		// 密码只记录是否修改
+		_, passwordChanged := updates["password_hash"]
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "admin.update",
+			TargetType: "admin",
+			TargetID:   adminID,
+			Before:     before,
+			After:      map[string]interface{}{"admin": admin, "password_changed": passwordChanged},
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("修改管理员失败: %v", err)
+	}
+	return &admin, nil
+}
+
+// AdjustBalance 人工调整用户余额，amount为正增加、为负扣减，同时记账
+func (s *AdminService) AdjustBalance(actor Actor, userID string, amount float64, reason string) (*models.BalanceAdjustment, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrAdjustmentReason
+	}
+	amount = roundAmount(amount)
+	if amount == 0 || math.Abs(amount) > maxBalanceAdjustment {
+		return nil, ErrAdjustmentAmount
+	}
+
+	var adjustment *models.BalanceAdjustment
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := lockUser(tx, &user, "id = ?", userID); err != nil {
+			return ErrUserNotFound
+		}
+		if user.Status == "deleted" {
+			return ErrUserInactive
+		}
+
+		balanceAfter := roundAmount(user.Balance + amount)
+		if balanceAfter < 0 {
+			return ErrInsufficientBalance
+		}
+
+		now := time.Now()
+		bill := &models.Bill{
+			ID:            uuid.New().String(),
+			UserID:        userID,
+			Type:          "adjustment",
+			Amount:        amount,
+			BalanceBefore: user.Balance,
+			BalanceAfter:  balanceAfter,
+			Description:   truncateString("人工调账 - "+reason, 255),
+			CreatedAt:     now,
+		}
+		// 调账账单没有对应订单，order_id 留空为 NULL
+		if err := tx.Omit("OrderID").Create(bill).Error; err != nil {
+			return err
+		}
+
+		adjustment = &models.BalanceAdjustment{
+			ID:            uuid.New().String(),
+			UserID:        userID,
+			AdminID:       actor.ID,
+			BillID:        bill.ID,
+			Amount:        amount,
+			BalanceBefore: user.Balance,
+			BalanceAfter:  balanceAfter,
+			Reason:        truncateString(reason, 255),
+			CreatedAt:     now,
+		}
+		if err := tx.Create(adjustment).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{"balance": balanceAfter, "updated_at": now}).Error; err != nil {
+			return err
+		}
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "balance.adjust",
+			TargetType: "user",
+			TargetID:   userID,
+			Before:     map[string]interface{}{"balance": adjustment.BalanceBefore},
+			After:      adjustment,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrUserInactive) || errors.Is(err, ErrInsufficientBalance) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("调整余额失败: %v", err)
+	}
+
+	log.Printf("余额已调整: admin=%s user=%s amount=%.2f reason=%s", actor.ID, userID, amount, reason)
+	return adjustment, nil
+}
+
+func hashAdminPassword(password string) (string, error) {
+	if len(password) < minAdminPasswordLen {
+		return "", ErrAdminPasswordTooShort
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("生成密码哈希失败: %v", err)
+	}
+	return string(hash), nil
+}
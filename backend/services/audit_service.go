@@ -0,0 +1,348 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	auditVerifyBatchSize = 1000 // 校验哈希链时每次读取的条数
+	auditChainBatchSize  = 500  // 每次加入哈希链的条数
+	auditChainLock       = "audit_chain"
+)
+
+// errAuditChainBusy 其他实例正在追加哈希链
+var errAuditChainBusy = errors.New("审计日志哈希链正在由其他任务追加")
+
+// Actor 审计日志中的操作者
+type Actor struct {
+	Type      string // user/admin/system
+	ID        string // 用户ID、管理员ID或系统任务名
+	RequestID string
+	IPAddress string
+}
+
+// SystemActor 后台任务或回调触发的操作
+func SystemActor(job string) Actor {
+	return Actor{Type: "system", ID: job}
+}
+
+// AuditEntry 一条待写入的审计记录，Before/After 为变更前后的快照
+type AuditEntry struct {
+	Actor      Actor
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+}
+
+// AuditFilter 审计日志查询条件
+type AuditFilter struct {
+	ListOptions
+	ActorType  string
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	RequestID  string
+}
+
+// AuditList 审计日志分页结果
+type AuditList struct {
+	ListPage
+	Logs []models.AuditLog
+}
+
+// AuditVerification 哈希链校验结果，BrokenAt 为第一条校验失败的序号，
+// Pending 为已写入但尚未加入哈希链的记录数
+type AuditVerification struct {
+	Checked  int64  `json:"checked"`
+	Pending  int64  `json:"pending"`
+	Valid    bool   `json:"valid"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type AuditService struct{}
+
+func NewAuditService() *AuditService {
+	return &AuditService{}
+}
+
+// RecordAudit 在业务事务中写入审计记录，写入失败时调用方应回滚业务变更。
+// 写入时不锁定链尾，序号和哈希由 StartChaining 启动的后台任务按写入顺序补上
+func RecordAudit(audit repository.AuditRepository, entry AuditEntry) error {
+	before, err := auditSnapshot(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := auditSnapshot(entry.After)
+	if err != nil {
+		return err
+	}
+
+	// 时间精确到秒，避免数据库时间精度导致重算哈希不一致
+	record := &models.AuditLog{
+		ID:         uuid.New().String(),
+		ActorType:  entry.Actor.Type,
+		ActorID:    truncateString(entry.Actor.ID, 64),
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     before,
+		After:      after,
+		RequestID:  truncateString(entry.Actor.RequestID, 64),
+		IPAddress:  entry.Actor.IPAddress,
+		CreatedAt:  time.Now().Truncate(time.Second),
+	}
+	if err := audit.Append(record); err != nil {
+		return fmt.Errorf("写入审计日志失败: %v", err)
+	}
+	return nil
+}
+
+// StartChaining 启动后台任务，按间隔将新写入的审计记录加入哈希链
+func (s *AuditService) StartChaining(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			// 一批处理满时继续，直到没有待加入的记录
+			for {
+				count, err := s.chainPending()
+				if err != nil {
+					if !errors.Is(err, errAuditChainBusy) {
+						log.Printf("审计日志加入哈希链失败: %v", err)
+					}
+					break
+				}
+				if count < auditChainBatchSize {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// chainPending 按写入顺序为尚未加入哈希链的记录补上序号和哈希，返回处理的条数。
+// 多实例部署时通过 MySQL 命名锁保证同一时间只有一个实例追加，锁在事务提交后释放
+func (s *AuditService) chainPending() (int, error) {
+	var count int
+	err := config.DB.Connection(func(conn *gorm.DB) error {
+		// GET_LOCK 已被占用时立即返回0，出错返回NULL
+		var acquired sql.NullInt64
+		if err := conn.Raw("SELECT GET_LOCK(?, 0)", auditChainLock).Row().Scan(&acquired); err != nil {
+			return fmt.Errorf("获取哈希链锁失败: %v", err)
+		}
+		if !acquired.Valid || acquired.Int64 != 1 {
+			return errAuditChainBusy
+		}
+		defer conn.Exec("SELECT RELEASE_LOCK(?)", auditChainLock)
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			var last models.AuditLog
+			err := tx.Select("sequence", "hash").
+				Where("sequence IS NOT NULL").
+				Order("sequence DESC").
+				Limit(1).
+				Find(&last).Error
+			if err != nil {
+				return fmt.Errorf("读取审计日志失败: %v", err)
+			}
+
+			var pending []models.AuditLog
+			err = tx.Where("sequence IS NULL").
+				Order("created_at ASC, id ASC").
+				Limit(auditChainBatchSize).
+				Find(&pending).Error
+			if err != nil {
+				return fmt.Errorf("读取待加入哈希链的审计日志失败: %v", err)
+			}
+
+			var sequence int64
+			if last.Sequence != nil {
+				sequence = *last.Sequence
+			}
+			prevHash := last.Hash
+			for i := range pending {
+				record := &pending[i]
+				sequence++
+				current := sequence
+				record.Sequence = &current
+				record.PrevHash = prevHash
+				record.Hash = auditHash(record)
+
+				result := tx.Model(&models.AuditLog{}).
+					Where("id = ? AND sequence IS NULL", record.ID).
+					Updates(map[string]interface{}{
+						"sequence":  current,
+						"prev_hash": record.PrevHash,
+						"hash":      record.Hash,
+					})
+				if result.Error != nil {
+					return fmt.Errorf("更新审计日志哈希失败: %v", result.Error)
+				}
+				if result.RowsAffected == 0 {
+					return errAuditChainBusy
+				}
+				prevHash = record.Hash
+			}
+			count = len(pending)
+			return nil
+		})
+	})
+	return count, err
+}
+
+// ListLogs 按时间倒序分页查询审计日志
+func (s *AuditService) ListLogs(filter AuditFilter) (*AuditList, error) {
+	query := config.DB.Model(&models.AuditLog{})
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.ActorID != "" {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.RequestID != "" {
+		query = query.Where("request_id = ?", filter.RequestID)
+	}
+
+	query, err := applyDateRange(query, filter.StartDate, filter.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &AuditList{}
+	if filter.IncludeTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("统计审计日志数量失败: %v", err)
+		}
+		list.Total = &total
+	}
+
+	query, limit, err := applyListOptions(query, filter.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []models.AuditLog
+	if err := query.Find(&logs).Error; err != nil {
+		return nil, fmt.Errorf("获取审计日志失败: %v", err)
+	}
+
+	if len(logs) > limit {
+		logs = logs[:limit]
+		last := logs[limit-1]
+		list.HasMore = true
+		list.NextCursor = encodeListCursor(last.CreatedAt, last.ID)
+	}
+	list.Logs = logs
+
+	return list, nil
+}
+
+// VerifyChain 按序号重算已加入哈希链的记录的哈希，检查序号连续、前后哈希相接且内容未被修改
+func (s *AuditService) VerifyChain() (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+	if err := config.DB.Model(&models.AuditLog{}).Where("sequence IS NULL").Count(&result.Pending).Error; err != nil {
+		return nil, fmt.Errorf("统计审计日志数量失败: %v", err)
+	}
+
+	var prevHash string
+	var prevSequence int64
+
+	for {
+		var logs []models.AuditLog
+		err := config.DB.Where("sequence > ?", prevSequence).
+			Order("sequence ASC").
+			Limit(auditVerifyBatchSize).
+			Find(&logs).Error
+		if err != nil {
+			return nil, fmt.Errorf("读取审计日志失败: %v", err)
+		}
+
+		for i := range logs {
+			record := &logs[i]
+			sequence := *record.Sequence
+			var reason string
+			switch {
+			case sequence != prevSequence+1:
+				reason = fmt.Sprintf("序号不连续，缺少 %d", prevSequence+1)
+			case record.PrevHash != prevHash:
+				reason = "与上一条记录的哈希不相接"
+			case record.Hash != auditHash(record):
+				reason = "记录内容与哈希不一致"
+			}
+			if reason != "" {
+				result.Valid = false
+				result.BrokenAt = record.Sequence
+				result.Reason = reason
+				return result, nil
+			}
+
+			result.Checked++
+			prevHash = record.Hash
+			prevSequence = sequence
+		}
+
+		if len(logs) < auditVerifyBatchSize {
+			return result, nil
+		}
+	}
+}
+
+// auditHash 对上一条哈希和本条记录的各字段计算 SHA-256，记录需已分配序号
+func auditHash(record *models.AuditLog) string {
+	fields := []string{
+		record.PrevHash,
+		strconv.FormatInt(*record.Sequence, 10),
+		record.ActorType,
+		record.ActorID,
+		record.Action,
+		record.TargetType,
+		record.TargetID,
+		record.Before,
+		record.After,
+		record.RequestID,
+		record.IPAddress,
+		strconv.FormatInt(record.CreatedAt.Unix(), 10),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+func auditSnapshot(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", errors.New("审计快照序列化失败")
+	}
+	return string(data), nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// MaxBatchRows 单个批量任务最多的收件人行数
+const MaxBatchRows = 1000
+
+// 识别为手机号列的表头
+var phoneColumnNames = map[string]bool{
+	"phone":  true,
+	"mobile": true,
+	"手机号":    true,
+	"手机号码":   true,
+}
+
+// BatchRecipient 批量发送的收件人及其个性化变量
+type BatchRecipient struct {
+	Phone     string            `json:"phone"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// ParseRecipientFile 解析上传的CSV或XLSX文件，返回收件人列表和文件类型。
+// 首行为表头，手机号列之外的每一列都作为同名变量
+func ParseRecipientFile(filename string, r io.Reader) ([]BatchRecipient, string, error) {
+	var rows [][]string
+	var source string
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		source = "csv"
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, "", fmt.Errorf("解析CSV文件失败: %v", err)
+		}
+		rows = records
+	case ".xlsx":
+		source = "xlsx"
+		file, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, "", fmt.Errorf("解析Excel文件失败: %v", err)
+		}
+		defer file.Close()
+
+		records, err := file.GetRows(file.GetSheetName(0))
+		if err != nil {
+			return nil, "", fmt.Errorf("读取Excel工作表失败: %v", err)
+		}
+		rows = records
+	default:
+		return nil, "", fmt.Errorf("仅支持CSV或XLSX文件")
+	}
+
+	recipients, err := parseRecipientRows(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	return recipients, source, nil
+}
+
+// parseRecipientRows 按表头将表格行转换为收件人，跳过空行
+func parseRecipientRows(rows [][]string) ([]BatchRecipient, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("文件内容为空")
+	}
+
+	header := make([]string, len(rows[0]))
+	phoneColumn := -1
+	for i, name := range rows[0] {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		header[i] = name
+		if phoneColumnNames[strings.ToLower(name)] && phoneColumn < 0 {
+			phoneColumn = i
+		}
+	}
+	if phoneColumn < 0 {
+		return nil, fmt.Errorf("未找到手机号列，表头需包含 phone 或 手机号")
+	}
+
+	var recipients []BatchRecipient
+	for _, row := range rows[1:] {
+		if isBlankRow(row) {
+			continue
+		}
+
+		recipient := BatchRecipient{Variables: make(map[string]string)}
+		for i, value := range row {
+			if i >= len(header) || header[i] == "" {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if i == phoneColumn {
+				recipient.Phone = value
+			} else {
+				recipient.Variables[header[i]] = value
+			}
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("文件中没有收件人")
+	}
+	if len(recipients) > MaxBatchRows {
+		return nil, fmt.Errorf("单次最多发送%d个收件人", MaxBatchRows)
+	}
+
+	return recipients, nil
+}
+
+func isBlankRow(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
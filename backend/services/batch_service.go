@@ -0,0 +1,272 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrBatchNotFound       = errors.New("批量任务不存在")
+	ErrNoValidRecipients   = errors.New("没有可发送的收件人")
+	ErrBatchRecipientLimit = fmt.Errorf("单次最多发送%d个收件人", MaxBatchRows)
+)
+
+// SendBatchInput 批量发送参数。Content中的 ${变量} 或模板变量由每行的Variables填充
+type SendBatchInput struct {
+	Content     string
+	TemplateID  string
+	Recipients  []BatchRecipient
+	Source      string // json / csv / xlsx
+	CouponCode  string
+	ScheduledAt *time.Time
+	Actor       Actor
+}
+
+// BatchResult 批量任务及每行的校验、发送结果
+type BatchResult struct {
+	Batch *models.MessageBatch      `json:"batch"`
+	Items []models.MessageBatchItem `json:"items"`
+}
+
+// SendBatch 校验并去重收件人，整批合并为一个订单支付后拆分为单条消息发送
+func (m *MessageService) SendBatch(userID string, input SendBatchInput) (*BatchResult, error) {
+	if err := m.suspensionService.CheckUser(userID); err != nil {
+		return nil, err
+	}
+	if len(input.Recipients) == 0 {
+		return nil, ErrNoValidRecipients
+	}
+	if len(input.Recipients) > MaxBatchRows {
+		return nil, ErrBatchRecipientLimit
+	}
+	if input.Content == "" && input.TemplateID == "" {
+		return nil, fmt.Errorf("请填写短信内容或选择模板")
+	}
+	if input.Source == "" {
+		input.Source = "json"
+	}
+
+	var template *models.SMSTemplate
+	if input.TemplateID != "" {
+		var err error
+		template, err = m.templateService.GetTemplate(userID, input.TemplateID)
+		if err != nil {
+			return nil, err
+		}
+		if template.Status != "approved" {
+			return nil, ErrTemplateNotApproved
+		}
+	}
+
+	now := time.Now()
+	batch := &models.MessageBatch{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Source:      input.Source,
+		Content:     input.Content,
+		TemplateID:  input.TemplateID,
+		TotalRows:   len(input.Recipients),
+		Status:      "pending",
+		ScheduledAt: input.ScheduledAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	// 1. 逐行校验、去重并计算费用
+	items := make([]models.MessageBatchItem, 0, len(input.Recipients))
+	contents := make(map[int]*batchRowContent)
+	seen := make(map[string]int)
+	for i, recipient := range input.Recipients {
+		item := models.MessageBatchItem{
+			ID:        uuid.New().String(),
+			BatchID:   batch.ID,
+			RowNo:     i + 1,
+			Phone:     recipient.Phone,
+			Status:    "pending",
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if len(recipient.Variables) > 0 {
+			variables, _ := json.Marshal(recipient.Variables)
+			item.Variables = string(variables)
+		}
+
+		content, cost, err := m.prepareBatchRow(template, input.Content, recipient)
+		if err != nil {
+			item.Status = "invalid"
+			item.Error = err.Error()
+		} else if row, ok := seen[content.phone.E164]; ok {
+			item.Status = "duplicate"
+			item.Error = fmt.Sprintf("与第%d行手机号重复", row)
+		} else {
+			seen[content.phone.E164] = item.RowNo
+			item.Phone = content.phone.String()
+			item.Cost = cost
+			contents[i] = content
+			batch.ValidRows++
+			batch.TotalCost += cost
+		}
+		items = append(items, item)
+	}
+	batch.InvalidRows = batch.TotalRows - batch.ValidRows
+
+	if batch.ValidRows == 0 {
+		batch.Status = "failed"
+		if err := m.repos.Batches.Create(batch, items); err != nil {
+			return nil, fmt.Errorf("保存批量任务失败: %v", err)
+		}
+		return &BatchResult{Batch: batch, Items: items}, ErrNoValidRecipients
+	}
+
+	// 2. 拆分为单条消息，订单、批量任务、明细和消息在同一事务中写入
+	if input.ScheduledAt == nil {
+		batch.Status = "processing"
+	}
+
+	var messages []*models.Message
+	for i := range items {
+		item := &items[i]
+		if item.Status != "pending" {
+			continue
+		}
+
+		var params string
+		if template != nil {
+			params = item.Variables
+		}
+		message := &models.Message{
+			ID:             uuid.New().String(),
+			UserID:         userID,
+			BatchID:        batch.ID,
+			Direction:      "outbound",
+			RecipientPhone: item.Phone,
+			TemplateID:     input.TemplateID,
+			TemplateParams: params,
+			Content:        contents[i].text,
+			CharacterCount: len([]rune(contents[i].text)),
+			Cost:           item.Cost,
+			Parts:          contents[i].parts,
+			Status:         "pending",
+			ScheduledAt:    input.ScheduledAt,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		if phone, err := ParsePhoneNumber(item.Phone); err == nil {
+			message.CountryCode = phone.Country.Code
+			if thread, err := m.relayService.EnsureThread(userID, phone); err == nil {
+				message.ThreadID = thread.ID
+			}
+		}
+		item.MessageID = message.ID
+		messages = append(messages, message)
+	}
+
+	order, err := m.placeOrder(userID, OrderInput{
+		Amount:      batch.TotalCost,
+		Description: fmt.Sprintf("批量发送短信 - %d条", batch.ValidRows),
+		CouponCode:  input.CouponCode,
+		Scope:       "batch",
+		Actor:       input.Actor,
+	}, messages, func(tx repository.Repositories, order *models.Order) error {
+		batch.OrderID = order.ID
+		return tx.Batches.Create(batch, items)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. 整批支付，成功后每条消息作为事件依次发送，定时任务到时间后发送
+	if err := m.chargeOrder(input.Actor, order); err != nil {
+		return nil, err
+	}
+
+	return &BatchResult{Batch: batch, Items: items}, nil
+}
+
+// GetBatch 获取批量任务及发送进度
+func (m *MessageService) GetBatch(userID, batchID string) (*models.MessageBatch, error) {
+	batch, err := m.repos.Batches.FindByID(batchID)
+	if err != nil || batch.UserID != userID {
+		return nil, ErrBatchNotFound
+	}
+	return batch, nil
+}
+
+// ListBatches 获取用户的批量任务列表
+func (m *MessageService) ListBatches(userID string) ([]models.MessageBatch, error) {
+	batches, err := m.repos.Batches.ListByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("获取批量任务失败: %v", err)
+	}
+	return batches, nil
+}
+
+// GetBatchItems 获取批量任务的逐行结果，status为空时返回全部
+func (m *MessageService) GetBatchItems(userID, batchID, status string) ([]models.MessageBatchItem, error) {
+	if _, err := m.GetBatch(userID, batchID); err != nil {
+		return nil, err
+	}
+
+	items, err := m.repos.Batches.ListItems(batchID, status)
+	if err != nil {
+		return nil, fmt.Errorf("获取批量明细失败: %v", err)
+	}
+	return items, nil
+}
+
+type batchRowContent struct {
+	phone *PhoneNumber
+	text  string
+	parts int
+}
+
+// prepareBatchRow 校验单行收件人并生成个性化正文和费用
+func (m *MessageService) prepareBatchRow(template *models.SMSTemplate, content string, recipient BatchRecipient) (*batchRowContent, float64, error) {
+	phone, err := ParsePhoneNumber(recipient.Phone)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var text string
+	if template != nil {
+		text, err = renderTemplateContent(template, recipient.Variables)
+	} else {
+		text, err = renderBatchContent(content, recipient.Variables)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cost, parts, err := m.messageCost(recipient.Phone, text, template != nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &batchRowContent{phone: phone, text: text, parts: parts}, cost, nil
+}
+
+// renderBatchContent 用行变量替换正文中的 ${变量}，缺少变量时返回错误
+func renderBatchContent(content string, variables map[string]string) (string, error) {
+	var missing string
+	text := templateVariablePattern.ReplaceAllStringFunc(content, func(match string) string {
+		name := templateVariablePattern.FindStringSubmatch(match)[1]
+		value, ok := variables[name]
+		if !ok || value == "" {
+			if missing == "" {
+				missing = name
+			}
+			return match
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("缺少变量: %s", missing)
+	}
+	return text, nil
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+)
+
+// BillFilter 账单列表筛选条件
+type BillFilter struct {
+	ListOptions
+	Type string
+}
+
+// BillList 账单列表分页结果
+type BillList struct {
+	ListPage
+	Bills []models.Bill
+}
+
+type BillService struct {
+	users    repository.UserRepository
+	bills    repository.BillRepository
+	messages repository.MessageRepository
+}
+
+func NewBillService(repos repository.Repositories) *BillService {
+	return &BillService{
+		users:    repos.Users,
+		bills:    repos.Bills,
+		messages: repos.Messages,
+	}
+}
+
+// ListBills 按创建时间倒序分页获取用户的账单
+func (b *BillService) ListBills(userID string, filter BillFilter) (*BillList, error) {
+	page, limit, err := listPage(filter.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	bills, total, err := b.bills.List(repository.BillQuery{
+		Page:   page,
+		UserID: userID,
+		Type:   filter.Type,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("获取账单失败: %v", err)
+	}
+
+	list := &BillList{}
+	if filter.IncludeTotal {
+		list.Total = &total
+	}
+	if len(bills) > limit {
+		bills = bills[:limit]
+		last := bills[limit-1]
+		list.HasMore = true
+		list.NextCursor = encodeListCursor(last.CreatedAt, last.ID)
+	}
+	list.Bills = bills
+
+	return list, nil
+}
+
+const maxSummaryDays = 366
+
+var (
+	ErrInvalidGroupBy      = errors.New("group_by 只能为 day、week 或 month")
+	ErrSummaryRangeTooLong = fmt.Errorf("按天统计最多%d天", maxSummaryDays)
+)
+
+// SummaryOptions 账单汇总参数，未指定日期范围时统计全部数据
+type SummaryOptions struct {
+	StartDate string
+	EndDate   string
+	GroupBy   string // day / week / month
+}
+
+// BillTotals 按账单类型汇总的金额
+type BillTotals struct {
+	Payment     float64 `json:"payment"`
+	Refund      float64 `json:"refund"`
+	Consumption float64 `json:"consumption"`
+	Recharge    float64 `json:"recharge"`
+}
+
+// NetSpend 净支出：支付与消费之和扣除退款，充值不计入
+func (t BillTotals) NetSpend() float64 {
+	return roundAmount(t.Payment + t.Consumption - t.Refund)
+}
+
+func (t *BillTotals) add(billType string, amount float64) {
+	switch billType {
+	case "payment":
+		t.Payment += amount
+	case "refund":
+		t.Refund += amount
+	case "consumption":
+		t.Consumption += amount
+	case "recharge":
+		t.Recharge += amount
+	}
+}
+
+// MessageStats 发出短信的数量与成功率，成功率按已有结果（成功或失败）的消息计算
+type MessageStats struct {
+	Total       int64   `json:"total"`
+	Sent        int64   `json:"sent"`
+	Failed      int64   `json:"failed"`
+	SuccessRate float64 `json:"success_rate"`
+}
+
+func (s *MessageStats) add(status string, count int64) {
+	s.Total += count
+	switch status {
+	case "sent":
+		s.Sent += count
+	case "failed":
+		s.Failed += count
+	}
+	if finished := s.Sent + s.Failed; finished > 0 {
+		s.SuccessRate = math.Round(float64(s.Sent)/float64(finished)*10000) / 10000
+	}
+}
+
+// PeriodSummary 单个统计周期的汇总
+type PeriodSummary struct {
+	Period   string       `json:"period"`
+	Totals   BillTotals   `json:"totals"`
+	NetSpend float64      `json:"net_spend"`
+	Messages MessageStats `json:"messages"`
+}
+
+// BalanceReconciliation 用户余额与账单流水的对账结果
+type BalanceReconciliation struct {
+	CurrentBalance float64    `json:"current_balance"` // 用户表中的余额
+	LedgerBalance  float64    `json:"ledger_balance"`  // 最后一笔账单的变动后余额
+	Difference     float64    `json:"difference"`
+	ChainBreaks    int64      `json:"chain_breaks"` // 变动前余额与上一笔变动后余额不一致的账单数
+	Reconciled     bool       `json:"reconciled"`
+	LastBillAt     *time.Time `json:"last_bill_at,omitempty"`
+}
+
+// BillSummary 账单汇总报表
+type BillSummary struct {
+	StartDate string                `json:"start_date,omitempty"`
+	EndDate   string                `json:"end_date,omitempty"`
+	GroupBy   string                `json:"group_by"`
+	Totals    BillTotals            `json:"totals"`
+	NetSpend  float64               `json:"net_spend"`
+	Messages  MessageStats          `json:"messages"`
+	Periods   []PeriodSummary       `json:"periods"`
+	Balance   BalanceReconciliation `json:"balance"`
+}
+
+// Summarize 统计指定范围内各类型金额、净支出和短信发送情况，按周期分组，并核对当前余额
+func (b *BillService) Summarize(userID string, opts SummaryOptions) (*BillSummary, error) {
+	if opts.GroupBy == "" {
+		opts.GroupBy = "day"
+		if opts.StartDate == "" && opts.EndDate == "" {
+			opts.GroupBy = "month"
+		}
+	}
+	if !repository.ValidPeriod(opts.GroupBy) {
+		return nil, ErrInvalidGroupBy
+	}
+
+	// 起止日期都指定时补齐没有数据的周期
+	var start, end time.Time
+	if opts.StartDate != "" && opts.EndDate != "" {
+		var err error
+		if start, err = time.ParseInLocation(listDateLayout, opts.StartDate, time.Local); err != nil {
+			return nil, ErrInvalidDate
+		}
+		if end, err = time.ParseInLocation(listDateLayout, opts.EndDate, time.Local); err != nil {
+			return nil, ErrInvalidDate
+		}
+		end = end.AddDate(0, 0, 1)
+		if !end.After(start) {
+			return nil, ErrInvalidDateRange
+		}
+		if opts.GroupBy == "day" && end.Sub(start) > maxSummaryDays*24*time.Hour {
+			return nil, ErrSummaryRangeTooLong
+		}
+	}
+
+	summary := &BillSummary{
+		StartDate: opts.StartDate,
+		EndDate:   opts.EndDate,
+		GroupBy:   opts.GroupBy,
+	}
+	periods := make(map[string]*PeriodSummary)
+	period := func(key string) *PeriodSummary {
+		if p, ok := periods[key]; ok {
+			return p
+		}
+		p := &PeriodSummary{Period: key}
+		periods[key] = p
+		return p
+	}
+	if !start.IsZero() {
+		for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+			period(repository.PeriodKey(day, opts.GroupBy))
+		}
+	}
+
+	periodRange, err := summaryRange(userID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 各周期、各类型的账单金额
+	billRows, err := b.bills.SumByPeriod(periodRange)
+	if err != nil {
+		return nil, fmt.Errorf("统计账单失败: %v", err)
+	}
+	for _, row := range billRows {
+		summary.Totals.add(row.Type, row.Amount)
+		period(row.Period).Totals.add(row.Type, row.Amount)
+	}
+
+	// 2. 各周期发出短信的状态分布
+	messageRows, err := b.messages.CountByPeriod(periodRange)
+	if err != nil {
+		return nil, fmt.Errorf("统计消息失败: %v", err)
+	}
+	for _, row := range messageRows {
+		summary.Messages.add(row.Status, row.Count)
+		period(row.Period).Messages.add(row.Status, row.Count)
+	}
+
+	summary.Totals = roundTotals(summary.Totals)
+	summary.NetSpend = summary.Totals.NetSpend()
+	summary.Periods = make([]PeriodSummary, 0, len(periods))
+	for _, p := range periods {
+		p.Totals = roundTotals(p.Totals)
+		p.NetSpend = p.Totals.NetSpend()
+		summary.Periods = append(summary.Periods, *p)
+	}
+	sort.Slice(summary.Periods, func(i, j int) bool {
+		return summary.Periods[i].Period < summary.Periods[j].Period
+	})
+
+	// 3. 余额对账
+	balance, err := b.reconcileBalance(userID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Balance = *balance
+
+	return summary, nil
+}
+
+// reconcileBalance 按时间顺序检查账单余额是否首尾相接，并与用户当前余额比对。
+// 账户合并迁入的账单按原账户分别成链
+func (b *BillService) reconcileBalance(userID string) (*BalanceReconciliation, error) {
+	user, err := b.users.FindByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户余额失败: %v", err)
+	}
+
+	result := &BalanceReconciliation{CurrentBalance: user.Balance}
+
+	bills, err := b.bills.Ledger(userID)
+	if err != nil {
+		return nil, fmt.Errorf("读取账单失败: %v", err)
+	}
+
+	chains := make(map[string]float64) // 各账户最后一笔账单的变动后余额
+	for i := range bills {
+		bill := &bills[i]
+		if last, ok := chains[bill.MergedFrom]; ok && math.Abs(bill.BalanceBefore-last) >= 0.005 {
+			result.ChainBreaks++
+		}
+		chains[bill.MergedFrom] = bill.BalanceAfter
+		result.LastBillAt = &bill.CreatedAt
+	}
+
+	result.LedgerBalance = chains[""]
+	result.Difference = roundAmount(result.CurrentBalance - result.LedgerBalance)
+	result.Reconciled = math.Abs(result.Difference) < 0.005 && result.ChainBreaks == 0
+	return result, nil
+}
+
+// summaryRange 汇总的时间范围，起止日期可只指定其一，结束日期包含当天
+func summaryRange(userID string, opts SummaryOptions) (repository.PeriodRange, error) {
+	r := repository.PeriodRange{UserID: userID, GroupBy: opts.GroupBy}
+	if opts.StartDate != "" {
+		start, err := time.ParseInLocation(listDateLayout, opts.StartDate, time.Local)
+		if err != nil {
+			return r, ErrInvalidDate
+		}
+		r.Start = &start
+	}
+	if opts.EndDate != "" {
+		end, err := time.ParseInLocation(listDateLayout, opts.EndDate, time.Local)
+		if err != nil {
+			return r, ErrInvalidDate
+		}
+		end = end.AddDate(0, 0, 1)
+		r.End = &end
+	}
+	return r, nil
+}
+
+func roundTotals(t BillTotals) BillTotals {
+	return BillTotals{
+		Payment:     roundAmount(t.Payment),
+		Refund:      roundAmount(t.Refund),
+		Consumption: roundAmount(t.Consumption),
+		Recharge:    roundAmount(t.Recharge),
+	}
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
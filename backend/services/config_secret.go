@@ -0,0 +1,73 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"anonymous-messaging-backend/config"
+)
+
+// 加密存储的配置值前缀，不带前缀的视为明文（早期写入的数据）
+const encryptedConfigPrefix = "enc:v1:"
+
+var ErrConfigEncryptionKey = errors.New("未配置 CONFIG_ENCRYPTION_KEY，不能保存密钥类配置")
+
+// configCipher 由 CONFIG_ENCRYPTION_KEY 派生 AES-256-GCM 密钥
+func configCipher() (cipher.AEAD, error) {
+	secret := config.App.Runtime.EncryptionKey
+	if secret == "" {
+		return nil, ErrConfigEncryptionKey
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("初始化配置加密失败: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptConfigValue 加密配置值，配置键作为附加数据，密文不能挪用到其他配置项
+func encryptConfigValue(key, value string) (string, error) {
+	aead, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(key))
+	return encryptedConfigPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptConfigValue(key, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedConfigPrefix) {
+		return stored, nil
+	}
+
+	aead, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedConfigPrefix))
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("密文格式不正确")
+	}
+
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(key))
+	if err != nil {
+		return "", errors.New("解密失败，请检查 CONFIG_ENCRYPTION_KEY")
+	}
+	return string(plain), nil
+}
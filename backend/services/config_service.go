@@ -0,0 +1,553 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"gorm.io/gorm"
+)
+
+// 配置分组，同组配置变更时通知订阅者
+const (
+	ConfigGroupSMS     = "sms"
+	ConfigGroupPayment = "payment"
+)
+
+// 配置值来源，优先级从低到高
+const (
+	ConfigSourceDefault  = "default"
+	ConfigSourceFile     = "file"
+	ConfigSourceEnv      = "env"
+	ConfigSourceDatabase = "database"
+)
+
+const maskedConfigValue = "******"
+
+var (
+	ErrConfigUnknownKey   = errors.New("不支持的配置项")
+	ErrConfigInvalidValue = errors.New("配置值格式不正确")
+)
+
+// configDefinition 已知配置项。Env为对应的环境变量，Secret的值加密存储且不对外展示，
+// Prefix表示Key是一组配置的前缀（如按国家覆盖的单价）
+type configDefinition struct {
+	Key         string
+	Env         string
+	Type        string // string/float/int/bool
+	Default     string
+	Secret      bool
+	Group       string
+	Prefix      bool
+	Description string
+}
+
+// renamedConfigKeys 改名的配置项，修改配置或读取配置文件时原键名按新键名处理
+var renamedConfigKeys = map[string]string{
+	"sms_price_per_60_chars": "sms_price_per_message",
+}
+
+var configDefinitions = []configDefinition{
+	{Key: "sms_price_per_message", Type: "float", Description: "国内短信每条单价（元），正文按默认模板拆分后实际发送的条数计费"},
+	{Key: "sms_price_", Type: "float", Prefix: true, Description: "按国家/地区代码覆盖短信单价"},
+	{Key: "sms_enabled_", Type: "bool", Prefix: true, Description: "按国家/地区代码开关短信发送"},
+	{Key: "sms_international_enabled", Type: "bool", Default: "true", Description: "国际短信总开关"},
+	{Key: "abuse_complaint_keywords", Type: "string", Default: "举报,投诉,骚扰", Description: "投诉关键词，逗号分隔"},
+	{Key: "abuse_complaint_threshold", Type: "int", Default: "5", Description: "触发自动停用的投诉收件人数，0为关闭"},
+	{Key: "abuse_complaint_window_days", Type: "int", Default: "7", Description: "统计投诉的天数"},
+	{Key: "abuse_suspension_days", Type: "int", Default: "7", Description: "自动停用的天数"},
+	{Key: "aliyun_access_key_id", Env: "ALIYUN_ACCESS_KEY_ID", Type: "string", Group: ConfigGroupSMS, Description: "阿里云AccessKeyId"},
+	{Key: "aliyun_access_key_secret", Env: "ALIYUN_ACCESS_KEY_SECRET", Type: "string", Secret: true, Group: ConfigGroupSMS, Description: "阿里云AccessKeySecret"},
+	{Key: "aliyun_sms_region", Env: "ALIYUN_SMS_REGION", Type: "string", Default: "cn-hangzhou", Group: ConfigGroupSMS, Description: "阿里云短信地域"},
+	{Key: "aliyun_sms_sign_name", Env: "ALIYUN_SMS_SIGN_NAME", Type: "string", Group: ConfigGroupSMS, Description: "阿里云短信签名"},
+	{Key: "aliyun_sms_template_code", Env: "ALIYUN_SMS_TEMPLATE_CODE", Type: "string", Group: ConfigGroupSMS, Description: "阿里云短信模板代码"},
+	{Key: "aliyun_sms_verify_template_code", Env: "ALIYUN_SMS_VERIFY_TEMPLATE_CODE", Type: "string", Group: ConfigGroupSMS, Description: "验证码短信模板代码"},
+	{Key: "aliyun_sms_intl_sender_id", Env: "ALIYUN_SMS_INTL_SENDER_ID", Type: "string", Group: ConfigGroupSMS, Description: "国际短信发送方ID"},
+	{Key: "aliyun_sms_content_params", Env: "ALIYUN_SMS_CONTENT_PARAMS", Type: "string", Default: "content", Group: ConfigGroupSMS, Description: "默认模板中承载正文的变量名，逗号分隔"},
+	{Key: "wechat_app_id", Env: "WECHAT_APP_ID", Type: "string", Group: ConfigGroupPayment, Description: "微信AppID"},
+	{Key: "wechat_app_secret", Env: "WECHAT_APP_SECRET", Type: "string", Secret: true, Group: ConfigGroupPayment, Description: "微信AppSecret，用于登录时换取openid"},
+	{Key: "wechat_merchant_id", Env: "WECHAT_MERCHANT_ID", Type: "string", Group: ConfigGroupPayment, Description: "微信商户号"},
+	{Key: "wechat_merchant_key", Env: "WECHAT_MERCHANT_KEY", Type: "string", Secret: true, Group: ConfigGroupPayment, Description: "微信商户密钥"},
+	{Key: "wechat_cert_path", Env: "WECHAT_CERT_PATH", Type: "string", Group: ConfigGroupPayment, Description: "微信支付证书路径"},
+	{Key: "wechat_key_path", Env: "WECHAT_KEY_PATH", Type: "string", Group: ConfigGroupPayment, Description: "微信支付私钥路径"},
+}
+
+// SMSConfig 短信服务配置
+type SMSConfig struct {
+	AccessKeyID        string
+	AccessKeySecret    string
+	Region             string
+	SignName           string
+	TemplateCode       string
+	VerifyTemplateCode string
+	IntlSenderID       string
+	ContentParams      []string
+}
+
+// PaymentConfig 微信支付配置，AppID 和 AppSecret 同时用于微信登录
+type PaymentConfig struct {
+	AppID       string
+	AppSecret   string
+	MerchantID  string
+	MerchantKey string
+	CertPath    string
+	KeyPath     string
+}
+
+// ConfigItem 后台展示的配置项，密钥类配置只返回是否已设置
+type ConfigItem struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Source      string `json:"source"`
+	Type        string `json:"type"`
+	Secret      bool   `json:"secret"`
+	Group       string `json:"group,omitempty"`
+	Description string `json:"description"`
+}
+
+type configValue struct {
+	Value  string
+	Source string
+}
+
+// ConfigService 运行时配置，按 默认值 < 配置文件 < 环境变量 < 数据库 的顺序合并，
+// 数据库中的值可在后台修改，定时重新加载后通知订阅者
+type ConfigService struct {
+	mu          sync.RWMutex
+	loaded      bool
+	fileModTime time.Time
+	fileValues  map[string]string
+	values      map[string]configValue
+	listeners   map[string][]func()
+}
+
+var runtimeConfig = &ConfigService{
+	fileValues: map[string]string{},
+	values:     map[string]configValue{},
+	listeners:  map[string][]func(){},
+}
+
+// RuntimeConfig 进程内共享的运行时配置
+func RuntimeConfig() *ConfigService {
+	return runtimeConfig
+}
+
+// lookupConfigDefinition 按完整键名或前缀查找配置定义
+func lookupConfigDefinition(key string) (configDefinition, bool) {
+	for _, def := range configDefinitions {
+		if def.Prefix {
+			if strings.HasPrefix(key, def.Key) && len(key) > len(def.Key) {
+				return def, true
+			}
+		} else if def.Key == key {
+			return def, true
+		}
+	}
+	return configDefinition{}, false
+}
+
+// String 读取配置，未设置时返回默认值
+func (c *ConfigService) String(key, defaultValue string) string {
+	c.ensureLoaded()
+
+	c.mu.RLock()
+	value := c.values[key].Value
+	c.mu.RUnlock()
+
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// SMS 当前的短信服务配置
+func (c *ConfigService) SMS() SMSConfig {
+	return SMSConfig{
+		AccessKeyID:        c.String("aliyun_access_key_id", ""),
+		AccessKeySecret:    c.String("aliyun_access_key_secret", ""),
+		Region:             c.String("aliyun_sms_region", ""),
+		SignName:           c.String("aliyun_sms_sign_name", ""),
+		TemplateCode:       c.String("aliyun_sms_template_code", ""),
+		VerifyTemplateCode: c.String("aliyun_sms_verify_template_code", ""),
+		IntlSenderID:       c.String("aliyun_sms_intl_sender_id", ""),
+		ContentParams:      splitContentParams(c.String("aliyun_sms_content_params", "content")),
+	}
+}
+
+// splitContentParams 拆分逗号分隔的正文变量名，去掉各项首尾空白并忽略空项，全部为空时使用 content
+func splitContentParams(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return []string{"content"}
+	}
+	return items
+}
+
+// Payment 当前的微信支付配置
+func (c *ConfigService) Payment() PaymentConfig {
+	return PaymentConfig{
+		AppID:       c.String("wechat_app_id", ""),
+		AppSecret:   c.String("wechat_app_secret", ""),
+		MerchantID:  c.String("wechat_merchant_id", ""),
+		MerchantKey: c.String("wechat_merchant_key", ""),
+		CertPath:    c.String("wechat_cert_path", ""),
+		KeyPath:     c.String("wechat_key_path", ""),
+	}
+}
+
+// OnChange 订阅某个分组的配置变更，回调在重新加载后执行
+func (c *ConfigService) OnChange(group string, fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners[group] = append(c.listeners[group], fn)
+}
+
+func (c *ConfigService) ensureLoaded() {
+	c.mu.RLock()
+	loaded := c.loaded
+	c.mu.RUnlock()
+
+	if !loaded {
+		if err := c.Reload(); err != nil {
+			log.Printf("加载运行时配置失败: %v", err)
+		}
+	}
+}
+
+// Reload 重新读取配置文件和数据库，有变更的分组会通知订阅者。
+// 读取失败时保留上一次的值
+func (c *ConfigService) Reload() error {
+	fileValues, modTime, fileErr := c.readConfigFile()
+	dbValues, dbErr := readDatabaseConfig()
+
+	c.mu.Lock()
+	if fileErr == nil {
+		c.fileValues = fileValues
+		c.fileModTime = modTime
+	}
+	if dbErr != nil {
+		dbValues = c.databaseValues()
+	}
+
+	values := mergeConfigValues(c.fileValues, dbValues)
+	changed := map[string]bool{}
+	if c.loaded {
+		for key, value := range values {
+			if c.values[key] != value {
+				changed[key] = true
+			}
+		}
+		for key := range c.values {
+			if _, ok := values[key]; !ok {
+				changed[key] = true
+			}
+		}
+	}
+	c.values = values
+	c.loaded = true
+
+	var callbacks []func()
+	notified := map[string]bool{}
+	for key := range changed {
+		def, ok := lookupConfigDefinition(key)
+		if !ok || def.Group == "" || notified[def.Group] {
+			continue
+		}
+		notified[def.Group] = true
+		callbacks = append(callbacks, c.listeners[def.Group]...)
+	}
+	c.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+
+	if fileErr != nil {
+		return fileErr
+	}
+	return dbErr
+}
+
+// StartReload 定时重新加载配置，使多实例部署时后台的修改在各实例生效
+func (c *ConfigService) StartReload(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := c.Reload(); err != nil {
+				log.Printf("重新加载运行时配置失败: %v", err)
+			}
+		}
+	}()
+}
+
+// ListItems 列出已知配置项和数据库中的配置及其当前值和来源
+func (c *ConfigService) ListItems() []ConfigItem {
+	c.ensureLoaded()
+
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	items := make([]ConfigItem, 0, len(keys))
+	for _, key := range keys {
+		def, ok := lookupConfigDefinition(key)
+		if !ok {
+			def = configDefinition{Key: key, Type: "string"}
+		}
+		items = append(items, newConfigItem(key, def, c.values[key]))
+	}
+	c.mu.RUnlock()
+
+	return items
+}
+
+// Update 修改数据库中的配置并立即生效，value为空时清除数据库中的值，
+// 回退到环境变量、配置文件或默认值
+func (c *ConfigService) Update(actor Actor, key, value string) (*ConfigItem, error) {
+	if renamed, ok := renamedConfigKeys[key]; ok {
+		key = renamed
+	}
+	def, ok := lookupConfigDefinition(key)
+	if !ok {
+		return nil, ErrConfigUnknownKey
+	}
+
+	value = strings.TrimSpace(value)
+	if err := validateConfigValue(def.Type, value); err != nil {
+		return nil, err
+	}
+
+	stored := value
+	if def.Secret && value != "" {
+		encrypted, err := encryptConfigValue(key, value)
+		if err != nil {
+			return nil, err
+		}
+		stored = encrypted
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var item models.SystemConfig
+		err := tx.Where("config_key = ?", key).Limit(1).Find(&item).Error
+		if err != nil {
+			return fmt.Errorf("查询配置失败: %v", err)
+		}
+		before := item.ConfigValue
+
+		if item.ID == 0 {
+			item = models.SystemConfig{
+				ConfigKey:   key,
+				ConfigValue: stored,
+				Description: def.Description,
+			}
+			if err := tx.Create(&item).Error; err != nil {
+				return fmt.Errorf("保存配置失败: %v", err)
+			}
+		} else {
+			if err := tx.Model(&item).Update("config_value", stored).Error; err != nil {
+				return fmt.Errorf("保存配置失败: %v", err)
+			}
+		}
+
+		after := value
+		if def.Secret {
+			before = maskConfigValue(before)
+			after = maskConfigValue(after)
+		}
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "config.update",
+			TargetType: "config",
+			TargetID:   key,
+			Before:     map[string]string{"value": before},
+			After:      map[string]string{"value": after},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Reload(); err != nil {
+		log.Printf("重新加载运行时配置失败: %v", err)
+	}
+
+	c.mu.RLock()
+	item := newConfigItem(key, def, c.values[key])
+	c.mu.RUnlock()
+	return &item, nil
+}
+
+// readConfigFile 读取 CONFIG_FILE 指定的JSON配置文件，文件未修改时沿用上次的内容
+func (c *ConfigService) readConfigFile() (map[string]string, time.Time, error) {
+	path := config.App.Runtime.ConfigFile
+	if path == "" {
+		return map[string]string{}, time.Time{}, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	c.mu.RLock()
+	unchanged := c.loaded && info.ModTime().Equal(c.fileModTime)
+	current := c.fileValues
+	c.mu.RUnlock()
+	if unchanged {
+		return current, info.ModTime(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, time.Time{}, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if renamed, ok := renamedConfigKeys[key]; ok {
+			log.Printf("配置文件中的 %s 已改名为 %s，请更新配置文件", key, renamed)
+			if _, exists := raw[renamed]; exists {
+				continue
+			}
+			key = renamed
+		}
+		if value != nil {
+			values[key] = fmt.Sprint(value)
+		}
+	}
+	return values, info.ModTime(), nil
+}
+
+// databaseValues 当前生效值中来自数据库的部分，数据库读取失败时沿用
+func (c *ConfigService) databaseValues() map[string]string {
+	values := map[string]string{}
+	for key, value := range c.values {
+		if value.Source == ConfigSourceDatabase {
+			values[key] = value.Value
+		}
+	}
+	return values
+}
+
+// readDatabaseConfig 读取数据库中非空的配置，密钥类配置解密后返回
+func readDatabaseConfig() (map[string]string, error) {
+	values := map[string]string{}
+	if config.DB == nil {
+		return values, nil
+	}
+
+	var items []models.SystemConfig
+	if err := config.DB.Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("读取系统配置失败: %v", err)
+	}
+
+	for _, item := range items {
+		if item.ConfigValue == "" {
+			continue
+		}
+		value, err := decryptConfigValue(item.ConfigKey, item.ConfigValue)
+		if err != nil {
+			log.Printf("解密配置失败，已忽略: key=%s err=%v", item.ConfigKey, err)
+			continue
+		}
+		values[item.ConfigKey] = value
+	}
+	return values, nil
+}
+
+// mergeConfigValues 按优先级合并各来源的配置
+func mergeConfigValues(fileValues, dbValues map[string]string) map[string]configValue {
+	values := map[string]configValue{}
+	for _, def := range configDefinitions {
+		if def.Prefix {
+			continue
+		}
+		values[def.Key] = configValue{Value: def.Default, Source: ConfigSourceDefault}
+		if value, ok := fileValues[def.Key]; ok {
+			values[def.Key] = configValue{Value: value, Source: ConfigSourceFile}
+		}
+		if def.Env != "" {
+			if value := os.Getenv(def.Env); value != "" {
+				values[def.Key] = configValue{Value: value, Source: ConfigSourceEnv}
+			}
+		}
+	}
+
+	for key, value := range fileValues {
+		if _, ok := values[key]; !ok {
+			values[key] = configValue{Value: value, Source: ConfigSourceFile}
+		}
+	}
+	for key, value := range dbValues {
+		values[key] = configValue{Value: value, Source: ConfigSourceDatabase}
+	}
+	return values
+}
+
+func newConfigItem(key string, def configDefinition, value configValue) ConfigItem {
+	item := ConfigItem{
+		Key:         key,
+		Value:       value.Value,
+		Source:      value.Source,
+		Type:        def.Type,
+		Secret:      def.Secret,
+		Group:       def.Group,
+		Description: def.Description,
+	}
+	if def.Secret {
+		item.Value = maskConfigValue(item.Value)
+	}
+	return item
+}
+
+func validateConfigValue(valueType, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	var err error
+	switch valueType {
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return ErrConfigInvalidValue
+	}
+	return nil
+}
+
+func maskConfigValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return maskedConfigValue
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DomesticCountryCode 国内短信的国家/地区代码
+const DomesticCountryCode = "CN"
+
+// CountryInfo 国家/地区短信配置
+type CountryInfo struct {
+	Code      string  `json:"code"`
+	DialCode  string  `json:"dial_code"`
+	Name      string  `json:"name"`
+	UnitPrice float64 `json:"unit_price"` // 每60字符价格（元）
+	Enabled   bool    `json:"enabled"`
+}
+
+// PhoneNumber 规范化后的手机号码
+type PhoneNumber struct {
+	E164     string       // 含国家码、不含+号的完整号码，如 8613800138000
+	National string       // 不含国家码的号码
+	Country  *CountryInfo // 号码所属国家/地区
+}
+
+// 默认国家/地区配置，可通过 system_config 中的
+// sms_price_<code> 和 sms_enabled_<code> 覆盖
+var defaultCountries = []CountryInfo{
+	{Code: "CN", DialCode: "86", Name: "中国大陆", UnitPrice: 1.00, Enabled: true},
+	{Code: "HK", DialCode: "852", Name: "中国香港", UnitPrice: 2.00, Enabled: true},
+	{Code: "MO", DialCode: "853", Name: "中国澳门", UnitPrice: 2.00, Enabled: true},
+	{Code: "TW", DialCode: "886", Name: "中国台湾", UnitPrice: 2.00, Enabled: true},
+	{Code: "SG", DialCode: "65", Name: "新加坡", UnitPrice: 3.00, Enabled: true},
+	{Code: "MY", DialCode: "60", Name: "马来西亚", UnitPrice: 3.00, Enabled: true},
+	{Code: "TH", DialCode: "66", Name: "泰国", UnitPrice: 3.00, Enabled: true},
+	{Code: "JP", DialCode: "81", Name: "日本", UnitPrice: 4.00, Enabled: true},
+	{Code: "KR", DialCode: "82", Name: "韩国", UnitPrice: 4.00, Enabled: true},
+	{Code: "US", DialCode: "1", Name: "美国/加拿大", UnitPrice: 4.00, Enabled: true},
+	{Code: "GB", DialCode: "44", Name: "英国", UnitPrice: 4.00, Enabled: true},
+	{Code: "DE", DialCode: "49", Name: "德国", UnitPrice: 4.00, Enabled: true},
+	{Code: "FR", DialCode: "33", Name: "法国", UnitPrice: 4.00, Enabled: true},
+	{Code: "AU", DialCode: "61", Name: "澳大利亚", UnitPrice: 4.00, Enabled: true},
+}
+
+// ParsePhoneNumber 规范化手机号码并识别所属国家/地区
+// 支持 +852xxxx、00852xxxx 以及不带国家码的11位大陆手机号
+func ParsePhoneNumber(raw string) (*PhoneNumber, error) {
+	phone := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+
+	var e164 string
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		e164 = phone[1:]
+	case strings.HasPrefix(phone, "00"):
+		e164 = phone[2:]
+	case len(phone) == 11 && strings.HasPrefix(phone, "1"):
+		e164 = "86" + phone
+	case len(phone) == 13 && strings.HasPrefix(phone, "86"):
+		e164 = phone
+	default:
+		return nil, fmt.Errorf("无法识别的手机号码: %s", raw)
+	}
+
+	if len(e164) < 8 || len(e164) > 15 {
+		return nil, fmt.Errorf("手机号码长度不正确: %s", raw)
+	}
+	if _, err := strconv.ParseUint(e164, 10, 64); err != nil {
+		return nil, fmt.Errorf("手机号码格式不正确: %s", raw)
+	}
+
+	country := detectCountry(e164)
+	if country == nil {
+		return nil, fmt.Errorf("暂不支持该国家/地区的号码: %s", raw)
+	}
+
+	national := strings.TrimPrefix(e164, country.DialCode)
+	if country.Code == DomesticCountryCode && (len(national) != 11 || national[0] != '1') {
+		return nil, fmt.Errorf("手机号码格式不正确: %s", raw)
+	}
+
+	return &PhoneNumber{
+		E164:     e164,
+		National: national,
+		Country:  country,
+	}, nil
+}
+
+// IsDomestic 是否为国内号码
+func (p *PhoneNumber) IsDomestic() bool {
+	return p.Country.Code == DomesticCountryCode
+}
+
+// String 国内号码返回11位手机号，其他返回带+号的国际格式
+func (p *PhoneNumber) String() string {
+	if p.IsDomestic() {
+		return p.National
+	}
+	return "+" + p.E164
+}
+
+// detectCountry 识别号码所属国家/地区，并叠加系统配置
+func detectCountry(e164 string) *CountryInfo {
+	matched := matchCountry(e164)
+	if matched == nil {
+		return nil
+	}
+
+	country := GetCountry(matched.Code)
+	return &country
+}
+
+// matchCountry 按最长国家码前缀匹配默认国家/地区表
+func matchCountry(e164 string) *CountryInfo {
+	var matched *CountryInfo
+	for i := range defaultCountries {
+		c := &defaultCountries[i]
+		if strings.HasPrefix(e164, c.DialCode) && (matched == nil || len(c.DialCode) > len(matched.DialCode)) {
+			matched = c
+		}
+	}
+	return matched
+}
+
+// GetCountry 获取国家/地区配置，叠加 system_config 中的价格与开关
+func GetCountry(code string) CountryInfo {
+	var country CountryInfo
+	for _, c := range defaultCountries {
+		if c.Code == code {
+			country = c
+			break
+		}
+	}
+
+	key := strings.ToLower(code)
+	if code == DomesticCountryCode {
+		country.UnitPrice = getSystemConfigFloat("sms_price_per_message", country.UnitPrice)
+	} else {
+		country.UnitPrice = getSystemConfigFloat("sms_price_"+key, country.UnitPrice)
+		if !getSystemConfigBool("sms_international_enabled", true) {
+			country.Enabled = false
+		}
+	}
+	country.Enabled = country.Enabled && getSystemConfigBool("sms_enabled_"+key, true)
+
+	return country
+}
+
+// ListCountries 获取所有支持的国家/地区配置
+func ListCountries() []CountryInfo {
+	countries := make([]CountryInfo, 0, len(defaultCountries))
+	for _, c := range defaultCountries {
+		countries = append(countries, GetCountry(c.Code))
+	}
+	return countries
+}
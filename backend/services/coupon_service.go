@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCouponNotFound   = errors.New("优惠券不存在")
+	ErrCouponNotStarted = errors.New("优惠券尚未生效")
+	ErrCouponExpired    = errors.New("优惠券已过期")
+	ErrCouponUsedUp     = errors.New("优惠券已被领完")
+	ErrCouponUserLimit  = errors.New("已达到该优惠券的使用次数上限")
+	ErrCouponMinSpend   = errors.New("未达到优惠券的最低消费金额")
+	ErrCouponScope      = errors.New("该优惠券不适用于当前订单")
+	ErrCouponFirstOrder = errors.New("该优惠券仅限首单使用")
+)
+
+// IsCouponError 是否为优惠券校验失败
+func IsCouponError(err error) bool {
+	for _, target := range []error{
+		ErrCouponNotFound, ErrCouponNotStarted, ErrCouponExpired, ErrCouponUsedUp,
+		ErrCouponUserLimit, ErrCouponMinSpend, ErrCouponScope, ErrCouponFirstOrder,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// CouponQuote 优惠试算结果
+type CouponQuote struct {
+	Code           string  `json:"code"`
+	Name           string  `json:"name"`
+	OriginalAmount float64 `json:"original_amount"`
+	Discount       float64 `json:"discount"`
+	Amount         float64 `json:"amount"` // 实付金额
+}
+
+type CouponService struct {
+	db *gorm.DB
+}
+
+func NewCouponService(db *gorm.DB) *CouponService {
+	return &CouponService{db: db}
+}
+
+// Quote 试算优惠券对指定金额的优惠，不占用使用次数
+func (s *CouponService) Quote(userID, code string, amount float64, scope string) (*CouponQuote, error) {
+	var coupon models.Coupon
+	err := s.db.Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询优惠券失败: %v", err)
+	}
+
+	discount, err := s.check(s.db, &coupon, userID, amount, scope)
+	if err != nil {
+		return nil, err
+	}
+	return newCouponQuote(&coupon, amount, discount), nil
+}
+
+// Redeem 在事务中校验并占用优惠券，返回实际优惠。优惠券行加锁以保证使用次数不超限
+func (s *CouponService) Redeem(tx *gorm.DB, userID, code, orderID string, amount float64, scope string) (*models.Coupon, float64, error) {
+	var coupon models.Coupon
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("code = ?", normalizeCouponCode(code)).
+		First(&coupon).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, 0, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询优惠券失败: %v", err)
+	}
+
+	discount, err := s.check(tx, &coupon, userID, amount, scope)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = tx.Model(&coupon).Updates(map[string]interface{}{
+		"used_count": gorm.Expr("used_count + 1"),
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("使用优惠券失败: %v", err)
+	}
+
+	redemption := &models.CouponRedemption{
+		ID:        uuid.New().String(),
+		CouponID:  coupon.ID,
+		UserID:    userID,
+		OrderID:   orderID,
+		Code:      coupon.Code,
+		Discount:  discount,
+		Status:    "applied",
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(redemption).Error; err != nil {
+		return nil, 0, fmt.Errorf("使用优惠券失败: %v", err)
+	}
+
+	return &coupon, discount, nil
+}
+
+// Reverse 撤销订单的优惠券使用，归还使用次数。订单支付失败或全额退款时调用
+func (s *CouponService) Reverse(orderID string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var redemption models.CouponRedemption
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ? AND status = ?", orderID, "applied").
+			First(&redemption).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		err = tx.Model(&redemption).Updates(map[string]interface{}{
+			"status":      "reversed",
+			"reversed_at": &now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Coupon{}).
+			Where("id = ? AND used_count > 0", redemption.CouponID).
+			Updates(map[string]interface{}{
+				"used_count": gorm.Expr("used_count - 1"),
+				"updated_at": now,
+			}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("撤销优惠券使用失败: %v", err)
+	}
+	return nil
+}
+
+// ListRedemptions 获取用户的优惠券使用记录
+func (s *CouponService) ListRedemptions(userID string) ([]models.CouponRedemption, error) {
+	var redemptions []models.CouponRedemption
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&redemptions).Error; err != nil {
+		return nil, fmt.Errorf("获取优惠券记录失败: %v", err)
+	}
+	return redemptions, nil
+}
+
+// check 校验优惠券是否可用于该用户和金额，返回优惠金额
+func (s *CouponService) check(db *gorm.DB, coupon *models.Coupon, userID string, amount float64, scope string) (float64, error) {
+	now := time.Now()
+	if coupon.Status != "active" {
+		return 0, ErrCouponNotFound
+	}
+	if coupon.StartsAt != nil && now.Before(*coupon.StartsAt) {
+		return 0, ErrCouponNotStarted
+	}
+	if coupon.ExpiresAt != nil && !now.Before(*coupon.ExpiresAt) {
+		return 0, ErrCouponExpired
+	}
+	if coupon.Scope != "all" && coupon.Scope != scope {
+		return 0, ErrCouponScope
+	}
+	if amount < coupon.MinSpend {
+		return 0, ErrCouponMinSpend
+	}
+	if coupon.TotalLimit > 0 && coupon.UsedCount >= coupon.TotalLimit {
+		return 0, ErrCouponUsedUp
+	}
+
+	if coupon.PerUserLimit > 0 {
+		var used int64
+		err := db.Model(&models.CouponRedemption{}).
+			Where("coupon_id = ? AND user_id = ? AND status = ?", coupon.ID, userID, "applied").
+			Count(&used).Error
+		if err != nil {
+			return 0, fmt.Errorf("查询优惠券使用次数失败: %v", err)
+		}
+		if used >= int64(coupon.PerUserLimit) {
+			return 0, ErrCouponUserLimit
+		}
+	}
+
+	// 待支付的订单也计入，避免首单支付完成前再下一单使用首单优惠
+	if coupon.FirstOrderOnly {
+		var orders int64
+		err := db.Model(&models.Order{}).
+			Where("user_id = ? AND status IN ?", userID, []string{"pending", "paid", "refunded"}).
+			Count(&orders).Error
+		if err != nil {
+			return 0, fmt.Errorf("查询历史订单失败: %v", err)
+		}
+		if orders > 0 {
+			return 0, ErrCouponFirstOrder
+		}
+	}
+
+	return couponDiscount(coupon, amount), nil
+}
+
+// couponDiscount 计算优惠金额，不超过订单金额
+func couponDiscount(coupon *models.Coupon, amount float64) float64 {
+	var discount float64
+	switch coupon.DiscountType {
+	case "fixed":
+		discount = coupon.DiscountValue
+	case "percentage":
+		discount = amount * coupon.DiscountValue / 100
+		if coupon.MaxDiscount > 0 {
+			discount = math.Min(discount, coupon.MaxDiscount)
+		}
+	}
+	return roundAmount(math.Max(0, math.Min(discount, amount)))
+}
+
+func newCouponQuote(coupon *models.Coupon, amount, discount float64) *CouponQuote {
+	return &CouponQuote{
+		Code:           coupon.Code,
+		Name:           coupon.Name,
+		OriginalAmount: roundAmount(amount),
+		Discount:       discount,
+		Amount:         roundAmount(amount - discount),
+	}
+}
+
+// QuoteCoupon 试算优惠券对订单金额的优惠
+func (m *MessageService) QuoteCoupon(userID, code string, amount float64, scope string) (*CouponQuote, error) {
+	return m.couponService.Quote(userID, code, amount, scope)
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
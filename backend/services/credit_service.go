@@ -0,0 +1,382 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrCreditPackageNotFound = errors.New("套餐不存在")
+
+// CreditBalance 用户的套餐余量
+type CreditBalance struct {
+	Remaining     int                  `json:"remaining"`
+	NextExpiresAt *time.Time           `json:"next_expires_at,omitempty"` // 最早到期的一笔额度的到期时间
+	Grants        []models.CreditGrant `json:"grants"`
+}
+
+type CreditService struct {
+	repos          repository.Repositories
+	db             *gorm.DB
+	paymentService *PaymentService
+}
+
+// NewCreditService 套餐和额度记录尚未使用仓储，通过 db 访问
+func NewCreditService(repos repository.Repositories, db *gorm.DB, paymentService *PaymentService) *CreditService {
+	return &CreditService{
+		repos:          repos,
+		db:             db,
+		paymentService: paymentService,
+	}
+}
+
+// ListPackages 获取可购买的套餐
+func (s *CreditService) ListPackages() ([]models.CreditPackage, error) {
+	var packages []models.CreditPackage
+	if err := s.db.Where("status = ?", "active").Order("sort_order ASC, price ASC").Find(&packages).Error; err != nil {
+		return nil, fmt.Errorf("获取套餐失败: %v", err)
+	}
+	return packages, nil
+}
+
+// GetBalance 获取用户未过期的套餐余量
+func (s *CreditService) GetBalance(userID string) (*CreditBalance, error) {
+	var grants []models.CreditGrant
+	err := activeGrants(s.db, userID).Find(&grants).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取套餐余量失败: %v", err)
+	}
+
+	balance := &CreditBalance{Grants: grants}
+	for _, grant := range grants {
+		balance.Remaining += grant.Remaining
+		if grant.ExpiresAt != nil && (balance.NextExpiresAt == nil || grant.ExpiresAt.Before(*balance.NextExpiresAt)) {
+			balance.NextExpiresAt = grant.ExpiresAt
+		}
+	}
+	return balance, nil
+}
+
+// PurchasePackage 购买套餐：创建订单并支付，成功后发放额度并记账
+func (s *CreditService) PurchasePackage(actor Actor, userID, packageID string) (*models.CreditGrant, error) {
+	var pkg models.CreditPackage
+	if err := s.db.Where("id = ? AND status = ?", packageID, "active").First(&pkg).Error; err != nil {
+		return nil, ErrCreditPackageNotFound
+	}
+
+	order := &models.Order{
+		ID:             uuid.New().String(),
+		UserID:         userID,
+		OrderNo:        generateOrderNo(),
+		Amount:         pkg.Price,
+		OriginalAmount: pkg.Price,
+		Status:         "pending",
+		PaymentMethod:  "wechat",
+		Description:    fmt.Sprintf("购买短信套餐 - %s", pkg.Name),
+		CreatedAt:      time.Now(),
+	}
+	if err := s.repos.Orders.Create(order); err != nil {
+		return nil, fmt.Errorf("创建订单失败: %v", err)
+	}
+
+	paymentResult, err := s.paymentService.ProcessPayment(order.ID, order.Amount)
+	if err != nil || !paymentResult.Success {
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = paymentResult.Error
+		}
+		order.Status = "failed"
+		err := s.repos.Transaction(func(repos repository.Repositories, tx *gorm.DB) error {
+			if err := repos.Orders.Transition(order, "支付失败: "+reason, "pending"); err != nil {
+				return err
+			}
+			return RecordAudit(repos.Audit, orderStatusEntry(actor, "order.payment_failed", order, "pending"))
+		})
+		if err != nil {
+			log.Printf("更新订单状态失败: order=%s err=%v", order.ID, err)
+		}
+		return nil, fmt.Errorf("支付失败: %v", reason)
+	}
+
+	now := time.Now()
+	order.Status = "paid"
+	order.PaidAt = &now
+	order.PaymentTransactionID = paymentResult.TransactionID
+
+	grant := &models.CreditGrant{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		PackageID: pkg.ID,
+		OrderID:   order.ID,
+		Segments:  pkg.Segments,
+		Remaining: pkg.Segments,
+		Status:    "active",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if pkg.ValidDays > 0 {
+		expiresAt := now.AddDate(0, 0, pkg.ValidDays)
+		grant.ExpiresAt = &expiresAt
+	}
+
+	err = s.repos.Transaction(func(repos repository.Repositories, tx *gorm.DB) error {
+		if err := repos.Orders.Transition(order, "支付成功", "pending"); err != nil {
+			return err
+		}
+		if err := tx.Create(grant).Error; err != nil {
+			return err
+		}
+		if err := recordCreditBill(tx, userID, order.ID, "payment", order.Amount, pkg.Segments, order.Description); err != nil {
+			return err
+		}
+		return RecordAudit(repos.Audit, AuditEntry{
+			Actor:      actor,
+			Action:     "credit.purchase",
+			TargetType: "order",
+			TargetID:   order.ID,
+			Before:     map[string]interface{}{"status": "pending"},
+			After:      map[string]interface{}{"order": orderSnapshot(order), "grant_id": grant.ID, "segments": grant.Segments},
+		})
+	})
+	if err != nil {
+		// 已扣款但发放失败，退回款项
+		log.Printf("发放套餐额度失败: order=%s err=%v", order.ID, err)
+		refund, refundErr := s.paymentService.RefundPayment(uuid.New().String(), order.ID, order.Amount, "套餐发放失败", nil, func(tx repository.Repositories, record *models.RefundRecord) error {
+			return markRefunded(tx, actor, order, now)
+		})
+		if refundErr == nil && refund.Status == "success" {
+			return nil, fmt.Errorf("发放套餐额度失败: %v", err)
+		}
+		return nil, fmt.Errorf("%w: 发放套餐额度失败: %v", ErrOrderCharged, err)
+	}
+
+	return grant, nil
+}
+
+// markRefunded 发放失败并退款后，在保存退款记录的事务中将订单依次记录为已支付和已退款
+func markRefunded(tx repository.Repositories, actor Actor, order *models.Order, paidAt time.Time) error {
+	refundedAt := time.Now()
+	order.Status = "paid"
+	order.PaidAt = &paidAt
+	if err := tx.Orders.Transition(order, "支付成功", "pending"); err != nil {
+		return err
+	}
+	order.Status = "refunded"
+	order.RefundedAt = &refundedAt
+	if err := tx.Orders.Transition(order, "套餐发放失败，已退款", "paid"); err != nil {
+		return err
+	}
+	return RecordAudit(tx.Audit, orderStatusEntry(actor, "order.refunded", order, "paid"))
+}
+
+// Consume 在事务中按到期时间先后扣减至多 segments 条额度，返回实际扣减的条数
+func (s *CreditService) Consume(tx *gorm.DB, userID, orderID string, segments int, description string) (int, error) {
+	if segments <= 0 {
+		return 0, nil
+	}
+
+	var grants []models.CreditGrant
+	err := activeGrants(tx, userID).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Find(&grants).Error
+	if err != nil {
+		return 0, fmt.Errorf("获取套餐余量失败: %v", err)
+	}
+
+	used := 0
+	for _, grant := range grants {
+		if used >= segments {
+			break
+		}
+		n := grant.Remaining
+		if n > segments-used {
+			n = segments - used
+		}
+
+		updates := map[string]interface{}{
+			"remaining":  gorm.Expr("remaining - ?", n),
+			"updated_at": time.Now(),
+		}
+		if n == grant.Remaining {
+			updates["status"] = "exhausted"
+		}
+		if err := tx.Model(&models.CreditGrant{}).Where("id = ?", grant.ID).Updates(updates).Error; err != nil {
+			return 0, fmt.Errorf("扣减套餐额度失败: %v", err)
+		}
+
+		usage := &models.CreditUsage{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			GrantID:   grant.ID,
+			OrderID:   orderID,
+			Segments:  n,
+			Status:    "used",
+			CreatedAt: time.Now(),
+		}
+		if err := tx.Create(usage).Error; err != nil {
+			return 0, fmt.Errorf("扣减套餐额度失败: %v", err)
+		}
+		used += n
+	}
+
+	if used > 0 {
+		if err := recordCreditBill(tx, userID, orderID, "consumption", 0, -used, description); err != nil {
+			return 0, err
+		}
+	}
+	return used, nil
+}
+
+// Refund 退回订单扣减的额度，已过期的额度不再退回。已退回的额度不会重复退回
+func (s *CreditService) Refund(actor Actor, orderID, reason string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var usages []models.CreditUsage
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ? AND status = ?", orderID, "used").
+			Find(&usages).Error
+		if err != nil || len(usages) == 0 {
+			return err
+		}
+
+		total := 0
+		for _, usage := range usages {
+			if err := tx.Model(&usage).Update("status", "refunded").Error; err != nil {
+				return err
+			}
+
+			// 已过期的额度不再退回
+			result := tx.Model(&models.CreditGrant{}).
+				Where("id = ? AND status IN ? AND (expires_at IS NULL OR expires_at > ?)", usage.GrantID, []string{"active", "exhausted"}, time.Now()).
+				Updates(map[string]interface{}{
+					"remaining":  gorm.Expr("remaining + ?", usage.Segments),
+					"status":     "active",
+					"updated_at": time.Now(),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				total += usage.Segments
+			}
+		}
+		if total == 0 {
+			return nil
+		}
+
+		if err := recordCreditBill(tx, usages[0].UserID, orderID, "refund", 0, total, "退回套餐额度 - "+reason); err != nil {
+			return err
+		}
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "credit.refund",
+			TargetType: "order",
+			TargetID:   orderID,
+			After:      map[string]interface{}{"credits": total, "reason": reason},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("退回套餐额度失败: %v", err)
+	}
+	return nil
+}
+
+// ExpireCredits 将到期的额度置为过期并记账
+func (s *CreditService) ExpireCredits() {
+	var grants []models.CreditGrant
+	s.db.Where("status = ? AND expires_at <= ?", "active", time.Now()).Find(&grants)
+	for _, grant := range grants {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.CreditGrant{}).
+				Where("id = ? AND status = ?", grant.ID, "active").
+				Updates(map[string]interface{}{
+					"status":     "expired",
+					"updated_at": time.Now(),
+				})
+			if result.Error != nil || result.RowsAffected == 0 || grant.Remaining == 0 {
+				return result.Error
+			}
+			if err := recordCreditBill(tx, grant.UserID, grant.OrderID, "expiry", 0, -grant.Remaining, "套餐额度过期"); err != nil {
+				return err
+			}
+			return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+				Actor:      SystemActor("credit_expiry"),
+				Action:     "credit.expire",
+				TargetType: "credit_grant",
+				TargetID:   grant.ID,
+				Before:     map[string]interface{}{"status": "active", "remaining": grant.Remaining},
+				After:      map[string]interface{}{"status": "expired"},
+			})
+		})
+		if err != nil {
+			log.Printf("套餐额度过期处理失败: grant=%s err=%v", grant.ID, err)
+		}
+	}
+}
+
+// StartExpiry 定期处理过期额度
+func (s *CreditService) StartExpiry(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.ExpireCredits()
+		}
+	}()
+}
+
+// HasGrants 订单是否发放过套餐额度
+func (s *CreditService) HasGrants(orderID string) bool {
+	var grants int64
+	s.db.Model(&models.CreditGrant{}).Where("order_id = ?", orderID).Count(&grants)
+	return grants > 0
+}
+
+// activeGrants 未过期且有剩余的额度，先到期的在前，永久额度最后使用
+func activeGrants(db *gorm.DB, userID string) *gorm.DB {
+	return db.Where("user_id = ? AND status = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, "active", time.Now()).
+		Order("expires_at IS NULL, expires_at ASC, created_at ASC")
+}
+
+// recordCreditBill 记录套餐相关的账单，金额余额不变，附带套餐条数变动
+func recordCreditBill(tx *gorm.DB, userID, orderID, billType string, amount float64, credits int, description string) error {
+	var user models.User
+	if err := tx.Select("id", "balance").First(&user, "id = ?", userID).Error; err != nil {
+		return fmt.Errorf("获取用户失败: %v", err)
+	}
+
+	var remaining int64
+	err := tx.Model(&models.CreditGrant{}).
+		Select("COALESCE(SUM(remaining), 0)").
+		Where("user_id = ? AND status = ? AND (expires_at IS NULL OR expires_at > ?)", userID, "active", time.Now()).
+		Scan(&remaining).Error
+	if err != nil {
+		return fmt.Errorf("获取套餐余量失败: %v", err)
+	}
+
+	bill := &models.Bill{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		OrderID:       orderID,
+		Type:          billType,
+		Amount:        amount,
+		BalanceBefore: user.Balance,
+		BalanceAfter:  user.Balance,
+		Credits:       credits,
+		CreditsAfter:  int(remaining),
+		Description:   truncateString(description, 255),
+		CreatedAt:     time.Now(),
+	}
+	if err := tx.Create(bill).Error; err != nil {
+		return fmt.Errorf("记录账单失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+)
+
+const (
+	maxExportRangeDays    = 366
+	exportDownloadURLPath = "/api/exports/download/"
+)
+
+var (
+	ErrExportNotFound = errors.New("导出任务不存在")
+	ErrExportNotReady = errors.New("文件尚未生成")
+	ErrExportExpired  = errors.New("下载链接已过期")
+)
+
+// exportFormats 各导出类型支持的文件格式
+var exportFormats = map[string][]string{
+	"bills":     {"csv", "xlsx"},
+	"orders":    {"csv", "xlsx"},
+	"messages":  {"csv", "xlsx"},
+	"statement": {"pdf"},
+}
+
+// ExportInput 导出参数。月度对账单使用Month（2006-01），其他类型使用日期范围
+type ExportInput struct {
+	Kind      string
+	Format    string
+	StartDate string
+	EndDate   string
+	Month     string
+}
+
+type ExportService struct {
+	dir     string
+	linkTTL time.Duration
+	pdfFont string
+}
+
+func NewExportService() *ExportService {
+	return &ExportService{
+		dir:     config.App.Export.Dir,
+		linkTTL: config.App.Export.LinkTTL,
+		pdfFont: config.App.Export.PDFFont,
+	}
+}
+
+// CreateExport 创建导出任务并在后台生成文件
+func (e *ExportService) CreateExport(userID string, input ExportInput) (*models.ExportJob, error) {
+	formats, ok := exportFormats[input.Kind]
+	if !ok {
+		return nil, fmt.Errorf("不支持的导出类型: %s", input.Kind)
+	}
+	if input.Format == "" {
+		input.Format = formats[0]
+	}
+	if !containsString(formats, input.Format) {
+		return nil, fmt.Errorf("%s 不支持导出为 %s", input.Kind, input.Format)
+	}
+
+	start, end, err := exportRange(input)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateDownloadToken()
+	if err != nil {
+		return nil, fmt.Errorf("创建导出任务失败: %v", err)
+	}
+
+	job := &models.ExportJob{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		Kind:          input.Kind,
+		Format:        input.Format,
+		StartDate:     start,
+		EndDate:       end,
+		Status:        "pending",
+		DownloadToken: token,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if err := config.DB.Create(job).Error; err != nil {
+		return nil, fmt.Errorf("创建导出任务失败: %v", err)
+	}
+
+	go e.runExport(job)
+
+	return job, nil
+}
+
+// GetExport 获取导出任务状态，已完成的任务附带下载链接
+func (e *ExportService) GetExport(userID, exportID string) (*models.ExportJob, error) {
+	var job models.ExportJob
+	if err := config.DB.Where("id = ? AND user_id = ?", exportID, userID).First(&job).Error; err != nil {
+		return nil, ErrExportNotFound
+	}
+	withDownloadURL(&job)
+	return &job, nil
+}
+
+// ListExports 获取用户最近的导出任务
+func (e *ExportService) ListExports(userID string) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	if err := config.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(50).Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("获取导出任务失败: %v", err)
+	}
+	for i := range jobs {
+		withDownloadURL(&jobs[i])
+	}
+	return jobs, nil
+}
+
+// OpenDownload 按下载令牌获取已生成的文件
+func (e *ExportService) OpenDownload(token string) (*models.ExportJob, error) {
+	var job models.ExportJob
+	if token == "" || config.DB.Where("download_token = ?", token).First(&job).Error != nil {
+		return nil, ErrExportNotFound
+	}
+
+	switch job.Status {
+	case "completed":
+	case "expired":
+		return nil, ErrExportExpired
+	default:
+		return nil, ErrExportNotReady
+	}
+	if job.ExpiresAt != nil && job.ExpiresAt.Before(time.Now()) {
+		return nil, ErrExportExpired
+	}
+	return &job, nil
+}
+
+// CleanupExpired 删除下载链接已过期的文件
+func (e *ExportService) CleanupExpired() {
+	var jobs []models.ExportJob
+	config.DB.Where("status = ? AND expires_at < ?", "completed", time.Now()).Find(&jobs)
+	for _, job := range jobs {
+		if job.FilePath != "" {
+			if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("删除导出文件失败: %v", err)
+				continue
+			}
+		}
+		config.DB.Model(&job).Updates(map[string]interface{}{
+			"status":     "expired",
+			"file_path":  "",
+			"updated_at": time.Now(),
+		})
+	}
+}
+
+// StartCleanup 定期清理过期的导出文件
+func (e *ExportService) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.CleanupExpired()
+		}
+	}()
+}
+
+// runExport 生成导出文件并更新任务状态
+func (e *ExportService) runExport(job *models.ExportJob) {
+	config.DB.Model(job).Updates(map[string]interface{}{
+		"status":     "processing",
+		"updated_at": time.Now(),
+	})
+
+	rowCount, err := e.writeExport(job)
+	if err != nil {
+		log.Printf("导出任务 %s 失败: %v", job.ID, err)
+		if job.FilePath != "" {
+			os.Remove(job.FilePath)
+		}
+		config.DB.Model(job).Updates(map[string]interface{}{
+			"status":     "failed",
+			"file_path":  "",
+			"error":      truncateString(err.Error(), 255),
+			"updated_at": time.Now(),
+		})
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(job.FilePath); err == nil {
+		size = info.Size()
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(e.linkTTL)
+	config.DB.Model(job).Updates(map[string]interface{}{
+		"status":       "completed",
+		"row_count":    rowCount,
+		"file_name":    job.FileName,
+		"file_path":    job.FilePath,
+		"file_size":    size,
+		"expires_at":   &expiresAt,
+		"completed_at": &now,
+		"updated_at":   now,
+	})
+}
+
+func (e *ExportService) writeExport(job *models.ExportJob) (int, error) {
+	dir := filepath.Join(e.dir, job.UserID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return 0, fmt.Errorf("创建导出目录失败: %v", err)
+	}
+
+	job.FileName = exportFileName(job)
+	job.FilePath = filepath.Join(dir, job.ID+"."+job.Format)
+
+	file, err := os.OpenFile(job.FilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("创建导出文件失败: %v", err)
+	}
+	defer file.Close()
+
+	if job.Kind == "statement" {
+		statement, err := buildStatement(job.UserID, job.StartDate, job.EndDate)
+		if err != nil {
+			return 0, err
+		}
+		if err := writeStatementPDF(file, statement, e.pdfFont); err != nil {
+			return 0, err
+		}
+		return len(statement.Bills), nil
+	}
+
+	table, err := loadExportTable(job.Kind, job.UserID, job.StartDate, job.EndDate)
+	if err != nil {
+		return 0, err
+	}
+
+	switch job.Format {
+	case "csv":
+		err = writeTableCSV(file, table)
+	case "xlsx":
+		err = writeTableXLSX(file, table)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(table.Rows), nil
+}
+
+// exportRange 解析导出的时间范围，返回 [start, end)
+func exportRange(input ExportInput) (time.Time, time.Time, error) {
+	if input.Kind == "statement" {
+		month, err := time.ParseInLocation("2006-01", input.Month, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("月份格式不正确，应为YYYY-MM")
+		}
+		if !month.Before(time.Now()) {
+			return time.Time{}, time.Time{}, fmt.Errorf("只能导出已开始月份的对账单")
+		}
+		return month, month.AddDate(0, 1, 0), nil
+	}
+
+	if input.StartDate == "" || input.EndDate == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("请指定起止日期")
+	}
+	start, err := time.ParseInLocation(listDateLayout, input.StartDate, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidDate
+	}
+	end, err := time.ParseInLocation(listDateLayout, input.EndDate, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidDate
+	}
+	end = end.AddDate(0, 0, 1)
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, ErrInvalidDateRange
+	}
+	if end.Sub(start) > maxExportRangeDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("单次最多导出%d天的数据", maxExportRangeDays)
+	}
+	return start, end, nil
+}
+
+func exportFileName(job *models.ExportJob) string {
+	if job.Kind == "statement" {
+		return fmt.Sprintf("statement_%s.pdf", job.StartDate.Format("2006-01"))
+	}
+	last := job.EndDate.AddDate(0, 0, -1)
+	return fmt.Sprintf("%s_%s_%s.%s", job.Kind, job.StartDate.Format("20060102"), last.Format("20060102"), job.Format)
+}
+
+func withDownloadURL(job *models.ExportJob) {
+	if job.Status == "completed" && (job.ExpiresAt == nil || job.ExpiresAt.After(time.Now())) {
+		job.DownloadURL = exportDownloadURLPath + job.DownloadToken
+	}
+}
+
+func generateDownloadToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateString(s string, maxRunes int) string {
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+	return string(runes[:maxRunes])
+}
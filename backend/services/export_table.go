@@ -0,0 +1,251 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	exportBatchSize  = 500
+	exportTimeLayout = "2006-01-02 15:04:05"
+)
+
+var billTypeLabels = map[string]string{
+	"payment":     "支付",
+	"refund":      "退款",
+	"consumption": "消费",
+	"recharge":    "充值",
+	"expiry":      "套餐过期",
+	"merge":       "账户合并",
+	"adjustment":  "人工调账",
+}
+
+var orderStatusLabels = map[string]string{
+	"pending":   "待支付",
+	"paid":      "已支付",
+	"failed":    "支付失败",
+	"refunded":  "已退款",
+	"cancelled": "已取消",
+}
+
+var messageStatusLabels = map[string]string{
+	"pending":   "待发送",
+	"scheduled": "定时",
+	"sending":   "发送中",
+	"sent":      "已发送",
+	"failed":    "发送失败",
+	"cancelled": "已取消",
+	"received":  "已接收",
+}
+
+// exportTable 导出的表格数据，单元格为 string、int 或 float64（金额）
+type exportTable struct {
+	Sheet   string
+	Headers []string
+	Rows    [][]interface{}
+}
+
+// loadExportTable 按类型分批读取 [start, end) 范围内的数据
+func loadExportTable(kind, userID string, start, end time.Time) (*exportTable, error) {
+	query := config.DB.Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, start, end).
+		Order("created_at ASC, id ASC")
+
+	switch kind {
+	case "bills":
+		table := &exportTable{
+			Sheet:   "账单",
+			Headers: []string{"时间", "类型", "金额", "变动前余额", "变动后余额", "订单ID", "说明"},
+		}
+		var batch []models.Bill
+		err := query.FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, n int) error {
+			for _, bill := range batch {
+				table.Rows = append(table.Rows, []interface{}{
+					bill.CreatedAt.Format(exportTimeLayout), labelOf(billTypeLabels, bill.Type), bill.Amount,
+					bill.BalanceBefore, bill.BalanceAfter, bill.OrderID, bill.Description,
+				})
+			}
+			return nil
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("读取账单失败: %v", err)
+		}
+		return table, nil
+
+	case "orders":
+		table := &exportTable{
+			Sheet:   "订单",
+			Headers: []string{"订单号", "创建时间", "金额", "状态", "支付方式", "交易号", "说明", "支付时间", "退款时间"},
+		}
+		var batch []models.Order
+		err := query.FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, n int) error {
+			for _, order := range batch {
+				table.Rows = append(table.Rows, []interface{}{
+					order.OrderNo, order.CreatedAt.Format(exportTimeLayout), order.Amount,
+					labelOf(orderStatusLabels, order.Status), order.PaymentMethod, order.PaymentTransactionID,
+					order.Description, formatOptionalTime(order.PaidAt), formatOptionalTime(order.RefundedAt),
+				})
+			}
+			return nil
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("读取订单失败: %v", err)
+		}
+		return table, nil
+
+	case "messages":
+		table := &exportTable{
+			Sheet:   "消息",
+			Headers: []string{"时间", "方向", "收件人", "国家/地区", "内容", "字数", "费用", "状态", "发送时间", "失败原因"},
+		}
+		var batch []models.Message
+		err := query.FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, n int) error {
+			for _, message := range batch {
+				direction := "发出"
+				if message.Direction == "inbound" {
+					direction = "回复"
+				}
+				table.Rows = append(table.Rows, []interface{}{
+					message.CreatedAt.Format(exportTimeLayout), direction, message.RecipientPhone,
+					message.CountryCode, message.Content, message.CharacterCount, message.Cost,
+					labelOf(messageStatusLabels, message.Status), formatOptionalTime(message.SentAt),
+					message.FailedReason,
+				})
+			}
+			return nil
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("读取消息失败: %v", err)
+		}
+		return table, nil
+	}
+
+	return nil, fmt.Errorf("不支持的导出类型: %s", kind)
+}
+
+// writeTableCSV 写入带BOM的UTF-8 CSV，便于Excel直接打开
+func writeTableCSV(w io.Writer, table *exportTable) error {
+	if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return fmt.Errorf("写入CSV失败: %v", err)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(table.Headers); err != nil {
+		return fmt.Errorf("写入CSV失败: %v", err)
+	}
+	record := make([]string, len(table.Headers))
+	for _, row := range table.Rows {
+		for i, cell := range row {
+			record[i] = formatCSVCell(cell)
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("写入CSV失败: %v", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("写入CSV失败: %v", err)
+	}
+	return nil
+}
+
+// writeTableXLSX 写入单工作表的XLSX文件，金额列保留两位小数
+func writeTableXLSX(w io.Writer, table *exportTable) error {
+	file := excelize.NewFile()
+	defer file.Close()
+
+	sheet := table.Sheet
+	if err := file.SetSheetName("Sheet1", sheet); err != nil {
+		return fmt.Errorf("生成XLSX失败: %v", err)
+	}
+
+	amountStyle, err := file.NewStyle(&excelize.Style{NumFmt: 2})
+	if err != nil {
+		return fmt.Errorf("生成XLSX失败: %v", err)
+	}
+	headerStyle, err := file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return fmt.Errorf("生成XLSX失败: %v", err)
+	}
+
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return fmt.Errorf("生成XLSX失败: %v", err)
+	}
+
+	header := make([]interface{}, len(table.Headers))
+	for i, h := range table.Headers {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: h}
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return fmt.Errorf("生成XLSX失败: %v", err)
+	}
+
+	for r, row := range table.Rows {
+		cells := make([]interface{}, len(row))
+		for i, cell := range row {
+			if amount, ok := cell.(float64); ok {
+				cells[i] = excelize.Cell{StyleID: amountStyle, Value: amount}
+			} else {
+				cells[i] = cell
+			}
+		}
+		axis, _ := excelize.CoordinatesToCellName(1, r+2)
+		if err := stream.SetRow(axis, cells); err != nil {
+			return fmt.Errorf("生成XLSX失败: %v", err)
+		}
+	}
+
+	if err := stream.Flush(); err != nil {
+		return fmt.Errorf("生成XLSX失败: %v", err)
+	}
+	if _, err := file.WriteTo(w); err != nil {
+		return fmt.Errorf("写入XLSX失败: %v", err)
+	}
+	return nil
+}
+
+// formatCSVCell 格式化单元格，并防止以公式字符开头的文本在表格软件中被执行
+func formatCSVCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case int:
+		return strconv.Itoa(v)
+	case string:
+		if v == "" {
+			return v
+		}
+		switch v[0] {
+		case '=', '@', '\t', '\r':
+			return "'" + v
+		case '+', '-':
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return "'" + v
+			}
+		}
+		return v
+	}
+	return fmt.Sprint(cell)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(exportTimeLayout)
+}
+
+func labelOf(labels map[string]string, value string) string {
+	if label, ok := labels[value]; ok {
+		return label
+	}
+	return value
+}
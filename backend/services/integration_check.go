@@ -0,0 +1,30 @@
+package services
+
+import (
+	"anonymous-messaging-backend/config"
+)
+
+// IntegrationCheck 外部服务的模式和配置检查结果
+type IntegrationCheck struct {
+	Name  string
+	Mode  string
+	Error error
+}
+
+// CheckIntegrations 按当前配置尝试创建各外部服务的客户端，不替换正在使用的客户端
+func CheckIntegrations() []IntegrationCheck {
+	sms := IntegrationCheck{Name: "sms", Mode: SMSMode()}
+	if sms.Mode == config.ModeLive {
+		_, sms.Error = newSMSClient(RuntimeConfig().SMS())
+	}
+
+	payment := IntegrationCheck{Name: "payment", Mode: PaymentMode()}
+	if payment.Mode == config.ModeLive {
+		_, payment.Error = newWechatPayClient(RuntimeConfig().Payment())
+	}
+
+	invoice := IntegrationCheck{Name: "invoice", Mode: config.App.Invoice.Provider}
+	_, invoice.Error = NewInvoiceProvider()
+
+	return []IntegrationCheck{sms, payment, invoice}
+}
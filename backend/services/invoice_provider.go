@@ -0,0 +1,142 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"anonymous-messaging-backend/config"
+)
+
+// InvoiceIssueRequest 提交给开票服务商的开票请求
+type InvoiceIssueRequest struct {
+	InvoiceID   string // 作为服务商侧的业务流水号，重复提交时服务商应返回同一结果
+	InvoiceType string // normal / special
+	TitleType   string // company / personal
+	TitleName   string
+	TaxID       string
+	Address     string
+	Phone       string
+	BankName    string
+	BankAccount string
+	Email       string
+	ItemName    string // 开票项目名称
+	Amount      float64
+}
+
+// InvoiceReverseRequest 红冲（作废）已开具发票的请求
+type InvoiceReverseRequest struct {
+	InvoiceID   string
+	InvoiceCode string
+	InvoiceNo   string
+	Amount      float64
+	Reason      string
+}
+
+// InvoiceResult 服务商返回的开票结果，Status 为 pending 时需稍后查询
+type InvoiceResult struct {
+	Status      string // pending / issued / rejected / reversed
+	RequestID   string
+	InvoiceCode string
+	InvoiceNo   string
+	FileURL     string
+	Reason      string
+}
+
+// InvoiceProvider 电子发票服务商
+type InvoiceProvider interface {
+	Name() string
+	Issue(req InvoiceIssueRequest) (*InvoiceResult, error)
+	Query(requestID string) (*InvoiceResult, error)
+	Reverse(req InvoiceReverseRequest) (*InvoiceResult, error)
+}
+
+var (
+	invoiceProvidersMu sync.RWMutex
+	invoiceProviders   = map[string]func() (InvoiceProvider, error){
+		"fake": func() (InvoiceProvider, error) { return defaultFakeInvoiceProvider, nil },
+	}
+)
+
+// RegisterInvoiceProvider 注册开票服务商，通过 INVOICE_PROVIDER 选择
+func RegisterInvoiceProvider(name string, factory func() (InvoiceProvider, error)) {
+	invoiceProvidersMu.Lock()
+	defer invoiceProvidersMu.Unlock()
+	invoiceProviders[name] = factory
+}
+
+// NewInvoiceProvider 按 INVOICE_PROVIDER 创建开票服务商，未配置时使用本地模拟开票
+func NewInvoiceProvider() (InvoiceProvider, error) {
+	name := config.App.Invoice.Provider
+
+	invoiceProvidersMu.RLock()
+	factory, ok := invoiceProviders[name]
+	invoiceProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("不支持的开票服务商: %s", name)
+	}
+	return factory()
+}
+
+// 模拟开票记录保存在内存中，所有服务共用同一实例
+var defaultFakeInvoiceProvider = newFakeInvoiceProvider()
+
+// fakeInvoiceProvider 本地模拟开票，提交后立即开具
+type fakeInvoiceProvider struct {
+	mu      sync.Mutex
+	issued  map[string]*InvoiceResult
+	counter int
+}
+
+func newFakeInvoiceProvider() *fakeInvoiceProvider {
+	return &fakeInvoiceProvider{
+		issued: make(map[string]*InvoiceResult),
+	}
+}
+
+func (f *fakeInvoiceProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeInvoiceProvider) Issue(req InvoiceIssueRequest) (*InvoiceResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if result, ok := f.issued[req.InvoiceID]; ok {
+		return result, nil
+	}
+
+	f.counter++
+	result := &InvoiceResult{
+		Status:      "issued",
+		RequestID:   req.InvoiceID,
+		InvoiceCode: "000000000000",
+		InvoiceNo:   fmt.Sprintf("%s%06d", time.Now().Format("060102"), f.counter%1000000),
+	}
+	f.issued[req.InvoiceID] = result
+	return result, nil
+}
+
+func (f *fakeInvoiceProvider) Query(requestID string) (*InvoiceResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if result, ok := f.issued[requestID]; ok {
+		return result, nil
+	}
+	return nil, fmt.Errorf("开票请求不存在: %s", requestID)
+}
+
+func (f *fakeInvoiceProvider) Reverse(req InvoiceReverseRequest) (*InvoiceResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := &InvoiceResult{
+		Status:      "reversed",
+		RequestID:   req.InvoiceID,
+		InvoiceCode: req.InvoiceCode,
+		InvoiceNo:   req.InvoiceNo,
+	}
+	f.issued[req.InvoiceID] = result
+	return result, nil
+}
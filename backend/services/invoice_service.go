@@ -0,0 +1,551 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 开票项目名称
+const invoiceItemName = "*信息技术服务*短信服务费"
+
+var (
+	ErrInvoiceNotFound      = errors.New("发票不存在")
+	ErrInvoiceTitleNotFound = errors.New("发票抬头不存在")
+	ErrNoInvoiceableOrders  = errors.New("没有可开票的订单")
+	ErrOrderAlreadyInvoiced = errors.New("部分订单已开票或不可开票")
+)
+
+// 统一社会信用代码18位，旧版纳税人识别号15或20位
+var taxIDPattern = regexp.MustCompile(`^([0-9A-Z]{15}|[0-9A-Z]{18}|[0-9A-Z]{20})$`)
+
+// InvoiceTitleInput 创建或修改发票抬头的参数
+type InvoiceTitleInput struct {
+	TitleType   string
+	Name        string
+	TaxID       string
+	Address     string
+	Phone       string
+	BankName    string
+	BankAccount string
+	Email       string
+	IsDefault   bool
+}
+
+// InvoiceRequestInput 申请开票参数，OrderIDs 与 Month 二选一
+type InvoiceRequestInput struct {
+	TitleID     string
+	InvoiceType string
+	OrderIDs    []string
+	Month       string // 2006-01，开具该月所有未开票的已支付订单
+	Email       string // 为空时使用抬头中的邮箱
+}
+
+// InvoiceableOrder 可开票订单及可开票金额
+type InvoiceableOrder struct {
+	models.Order
+	InvoiceableAmount float64 `json:"invoiceable_amount"`
+}
+
+type InvoiceService struct {
+	provider InvoiceProvider
+}
+
+func NewInvoiceService() (*InvoiceService, error) {
+	provider, err := NewInvoiceProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	return &InvoiceService{
+		provider: provider,
+	}, nil
+}
+
+// ListTitles 获取用户的发票抬头，默认抬头在前
+func (i *InvoiceService) ListTitles(userID string) ([]models.InvoiceTitle, error) {
+	var titles []models.InvoiceTitle
+	if err := config.DB.Where("user_id = ?", userID).Order("is_default DESC, created_at DESC").Find(&titles).Error; err != nil {
+		return nil, fmt.Errorf("获取发票抬头失败: %v", err)
+	}
+	return titles, nil
+}
+
+// SaveTitle 创建（titleID为空）或修改发票抬头
+func (i *InvoiceService) SaveTitle(userID, titleID string, input InvoiceTitleInput) (*models.InvoiceTitle, error) {
+	if err := validateInvoiceTitle(&input); err != nil {
+		return nil, err
+	}
+
+	title := &models.InvoiceTitle{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+	if titleID != "" {
+		var err error
+		if title, err = i.getTitle(userID, titleID); err != nil {
+			return nil, err
+		}
+	}
+
+	title.TitleType = input.TitleType
+	title.Name = input.Name
+	title.TaxID = input.TaxID
+	title.Address = input.Address
+	title.Phone = input.Phone
+	title.BankName = input.BankName
+	title.BankAccount = input.BankAccount
+	title.Email = input.Email
+	title.IsDefault = input.IsDefault
+	title.UpdatedAt = time.Now()
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if title.IsDefault {
+			err := tx.Model(&models.InvoiceTitle{}).
+				Where("user_id = ? AND id <> ?", userID, title.ID).
+				Update("is_default", false).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Save(title).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存发票抬头失败: %v", err)
+	}
+
+	return title, nil
+}
+
+// DeleteTitle 删除发票抬头，已申请的发票保留抬头快照不受影响
+func (i *InvoiceService) DeleteTitle(userID, titleID string) error {
+	title, err := i.getTitle(userID, titleID)
+	if err != nil {
+		return err
+	}
+	if err := config.DB.Delete(title).Error; err != nil {
+		return fmt.Errorf("删除发票抬头失败: %v", err)
+	}
+	return nil
+}
+
+// ListInvoiceableOrders 获取已支付且未开票的订单，可开票金额扣除已退款部分
+func (i *InvoiceService) ListInvoiceableOrders(userID string) ([]InvoiceableOrder, error) {
+	var orders []models.Order
+	err := config.DB.Where("user_id = ? AND status = ? AND (invoice_id = '' OR invoice_id IS NULL)", userID, "paid").
+		Order("paid_at DESC").
+		Find(&orders).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取可开票订单失败: %v", err)
+	}
+
+	amounts, err := invoiceableAmounts(orders)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]InvoiceableOrder, 0, len(orders))
+	for _, order := range orders {
+		if amounts[order.ID] <= 0 {
+			continue
+		}
+		result = append(result, InvoiceableOrder{Order: order, InvoiceableAmount: amounts[order.ID]})
+	}
+	return result, nil
+}
+
+// RequestInvoice 锁定订单并创建开票申请，随后在后台提交服务商开具
+func (i *InvoiceService) RequestInvoice(userID string, input InvoiceRequestInput) (*models.Invoice, error) {
+	title, err := i.getTitle(userID, input.TitleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.InvoiceType == "" {
+		input.InvoiceType = "normal"
+	}
+	switch input.InvoiceType {
+	case "normal":
+	case "special":
+		if title.TitleType != "company" || title.Address == "" || title.Phone == "" || title.BankName == "" || title.BankAccount == "" {
+			return nil, fmt.Errorf("开具增值税专用发票需填写企业抬头的地址、电话、开户行及账号")
+		}
+	default:
+		return nil, fmt.Errorf("不支持的发票类型: %s", input.InvoiceType)
+	}
+
+	email := input.Email
+	if email == "" {
+		email = title.Email
+	}
+	if email == "" {
+		return nil, fmt.Errorf("请填写接收电子发票的邮箱")
+	}
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil, fmt.Errorf("邮箱格式不正确")
+	}
+
+	// 1. 确定开票订单
+	query := config.DB.Where("user_id = ? AND status = ? AND (invoice_id = '' OR invoice_id IS NULL)", userID, "paid")
+	switch {
+	case input.Month != "":
+		month, err := time.ParseInLocation("2006-01", input.Month, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("月份格式不正确，应为YYYY-MM")
+		}
+		query = query.Where("paid_at >= ? AND paid_at < ?", month, month.AddDate(0, 1, 0))
+	case len(input.OrderIDs) > 0:
+		query = query.Where("id IN ?", input.OrderIDs)
+	default:
+		return nil, fmt.Errorf("请选择开票订单或月份")
+	}
+
+	var orders []models.Order
+	if err := query.Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("获取订单失败: %v", err)
+	}
+	if input.Month == "" && len(orders) != len(uniqueStrings(input.OrderIDs)) {
+		return nil, ErrOrderAlreadyInvoiced
+	}
+
+	amounts, err := invoiceableAmounts(orders)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invoice := &models.Invoice{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		TitleID:     title.ID,
+		InvoiceType: input.InvoiceType,
+		TitleType:   title.TitleType,
+		TitleName:   title.Name,
+		TaxID:       title.TaxID,
+		Email:       email,
+		Period:      input.Month,
+		Status:      "requested",
+		Provider:    i.provider.Name(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	var orderIDs []string
+	for _, order := range orders {
+		amount := amounts[order.ID]
+		if amount <= 0 {
+			continue
+		}
+		invoice.Items = append(invoice.Items, models.InvoiceItem{
+			ID:        uuid.New().String(),
+			InvoiceID: invoice.ID,
+			OrderID:   order.ID,
+			OrderNo:   order.OrderNo,
+			Amount:    amount,
+			CreatedAt: now,
+		})
+		invoice.Amount += amount
+		orderIDs = append(orderIDs, order.ID)
+	}
+	invoice.Amount = roundAmount(invoice.Amount)
+	if len(orderIDs) == 0 || invoice.Amount <= 0 {
+		return nil, ErrNoInvoiceableOrders
+	}
+
+	// 2. 条件更新锁定订单，防止同一订单被并发重复开票
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Order{}).
+			Where("id IN ? AND status = ? AND (invoice_id = '' OR invoice_id IS NULL)", orderIDs, "paid").
+			Update("invoice_id", invoice.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(orderIDs)) {
+			return ErrOrderAlreadyInvoiced
+		}
+		return tx.Create(invoice).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrOrderAlreadyInvoiced) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("创建开票申请失败: %v", err)
+	}
+
+	// 3. 提交服务商开具
+	go i.submit(invoice, title)
+
+	return invoice, nil
+}
+
+// GetInvoice 获取发票详情，开具中的发票会向服务商同步状态
+func (i *InvoiceService) GetInvoice(userID, invoiceID string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := config.DB.Preload("Items").Where("id = ? AND user_id = ?", invoiceID, userID).First(&invoice).Error; err != nil {
+		return nil, ErrInvoiceNotFound
+	}
+	i.syncInvoice(&invoice)
+	return &invoice, nil
+}
+
+// ListInvoices 获取用户的发票列表，status为空时返回全部
+func (i *InvoiceService) ListInvoices(userID, status string) ([]models.Invoice, error) {
+	query := config.DB.Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var invoices []models.Invoice
+	if err := query.Order("created_at DESC").Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("获取发票列表失败: %v", err)
+	}
+	for idx := range invoices {
+		i.syncInvoice(&invoices[idx])
+	}
+	return invoices, nil
+}
+
+// ReverseForOrder 订单退款后红冲其所在的发票。未开具的申请直接驳回，
+// 发票中其他订单释放后可重新申请开票。返回错误时由调用方重试
+func (i *InvoiceService) ReverseForOrder(orderID, reason string) error {
+	var order models.Order
+	err := config.DB.Select("id", "invoice_id").First(&order, "id = ?", orderID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("获取订单失败: %v", err)
+	}
+	if order.InvoiceID == "" {
+		return nil
+	}
+
+	var invoice models.Invoice
+	err = config.DB.First(&invoice, "id = ?", order.InvoiceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("获取发票失败: %v", err)
+	}
+
+	switch invoice.Status {
+	case "requested":
+		return i.reject(&invoice, "订单已退款: "+reason)
+	case "issued":
+		result, err := i.provider.Reverse(InvoiceReverseRequest{
+			InvoiceID:   invoice.ID,
+			InvoiceCode: invoice.InvoiceCode,
+			InvoiceNo:   invoice.InvoiceNo,
+			Amount:      invoice.Amount,
+			Reason:      reason,
+		})
+		if err != nil {
+			return fmt.Errorf("发票 %s 红冲失败: %v", invoice.ID, err)
+		}
+		if result.Status != "reversed" {
+			return fmt.Errorf("发票 %s 红冲未完成: %s", invoice.ID, result.Status)
+		}
+
+		now := time.Now()
+		return config.DB.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&invoice).Updates(map[string]interface{}{
+				"status":      "reversed",
+				"reversed_at": &now,
+				"updated_at":  now,
+			}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Model(&models.Order{}).Where("invoice_id = ?", invoice.ID).Update("invoice_id", "").Error
+		})
+	}
+	return nil
+}
+
+// submit 将开票申请提交给服务商
+func (i *InvoiceService) submit(invoice *models.Invoice, title *models.InvoiceTitle) {
+	result, err := i.provider.Issue(issueRequest(invoice, title))
+	if err != nil {
+		// 提交失败保持申请状态，查询时重新提交
+		log.Printf("发票 %s 提交失败: %v", invoice.ID, err)
+		return
+	}
+	i.applyResult(invoice, result)
+}
+
+// syncInvoice 向服务商同步申请中的发票状态
+func (i *InvoiceService) syncInvoice(invoice *models.Invoice) {
+	if invoice.Status != "requested" || invoice.Provider != i.provider.Name() {
+		return
+	}
+
+	var result *InvoiceResult
+	var err error
+	if invoice.ProviderRequestID == "" {
+		var title models.InvoiceTitle
+		config.DB.First(&title, "id = ?", invoice.TitleID)
+		result, err = i.provider.Issue(issueRequest(invoice, &title))
+	} else {
+		result, err = i.provider.Query(invoice.ProviderRequestID)
+	}
+	if err != nil {
+		log.Printf("同步发票 %s 状态失败: %v", invoice.ID, err)
+		return
+	}
+	i.applyResult(invoice, result)
+}
+
+// applyResult 保存服务商返回的开票结果
+func (i *InvoiceService) applyResult(invoice *models.Invoice, result *InvoiceResult) {
+	switch result.Status {
+	case "issued":
+		now := time.Now()
+		invoice.Status = "issued"
+		invoice.ProviderRequestID = result.RequestID
+		invoice.InvoiceCode = result.InvoiceCode
+		invoice.InvoiceNo = result.InvoiceNo
+		invoice.FileURL = result.FileURL
+		invoice.IssuedAt = &now
+		invoice.UpdatedAt = now
+		config.DB.Model(invoice).Updates(map[string]interface{}{
+			"status":              invoice.Status,
+			"provider_request_id": invoice.ProviderRequestID,
+			"invoice_code":        invoice.InvoiceCode,
+			"invoice_no":          invoice.InvoiceNo,
+			"file_url":            invoice.FileURL,
+			"issued_at":           invoice.IssuedAt,
+			"updated_at":          invoice.UpdatedAt,
+		})
+	case "rejected":
+		i.reject(invoice, result.Reason)
+	default:
+		if result.RequestID != "" && result.RequestID != invoice.ProviderRequestID {
+			invoice.ProviderRequestID = result.RequestID
+			config.DB.Model(invoice).Update("provider_request_id", result.RequestID)
+		}
+	}
+}
+
+// reject 驳回开票申请并释放订单
+func (i *InvoiceService) reject(invoice *models.Invoice, reason string) error {
+	invoice.Status = "rejected"
+	invoice.RejectReason = truncateString(reason, 255)
+	invoice.UpdatedAt = time.Now()
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(invoice).Updates(map[string]interface{}{
+			"status":        invoice.Status,
+			"reject_reason": invoice.RejectReason,
+			"updated_at":    invoice.UpdatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Order{}).Where("invoice_id = ?", invoice.ID).Update("invoice_id", "").Error
+	})
+}
+
+// issueRequest 按发票快照生成开票请求，地址、开户行等取自抬头
+func issueRequest(invoice *models.Invoice, title *models.InvoiceTitle) InvoiceIssueRequest {
+	return InvoiceIssueRequest{
+		InvoiceID:   invoice.ID,
+		InvoiceType: invoice.InvoiceType,
+		TitleType:   invoice.TitleType,
+		TitleName:   invoice.TitleName,
+		TaxID:       invoice.TaxID,
+		Address:     title.Address,
+		Phone:       title.Phone,
+		BankName:    title.BankName,
+		BankAccount: title.BankAccount,
+		Email:       invoice.Email,
+		ItemName:    invoiceItemName,
+		Amount:      invoice.Amount,
+	}
+}
+
+func (i *InvoiceService) getTitle(userID, titleID string) (*models.InvoiceTitle, error) {
+	var title models.InvoiceTitle
+	if err := config.DB.Where("id = ? AND user_id = ?", titleID, userID).First(&title).Error; err != nil {
+		return nil, ErrInvoiceTitleNotFound
+	}
+	return &title, nil
+}
+
+// invoiceableAmounts 计算订单扣除成功退款后的可开票金额
+func invoiceableAmounts(orders []models.Order) (map[string]float64, error) {
+	amounts := make(map[string]float64, len(orders))
+	if len(orders) == 0 {
+		return amounts, nil
+	}
+
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+		amounts[order.ID] = order.Amount
+	}
+
+	var refunds []struct {
+		OrderID string
+		Amount  float64
+	}
+	err := config.DB.Model(&models.RefundRecord{}).
+		Select("order_id, COALESCE(SUM(refund_amount), 0) AS amount").
+		Where("order_id IN ? AND status = ?", ids, "success").
+		Group("order_id").
+		Scan(&refunds).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取退款记录失败: %v", err)
+	}
+	for _, refund := range refunds {
+		amounts[refund.OrderID] = roundAmount(amounts[refund.OrderID] - refund.Amount)
+	}
+	return amounts, nil
+}
+
+func validateInvoiceTitle(input *InvoiceTitleInput) error {
+	input.Name = strings.TrimSpace(input.Name)
+	input.TaxID = strings.ToUpper(strings.TrimSpace(input.TaxID))
+	input.Email = strings.TrimSpace(input.Email)
+
+	if input.Name == "" {
+		return fmt.Errorf("请填写发票抬头名称")
+	}
+	switch input.TitleType {
+	case "company":
+		if !taxIDPattern.MatchString(input.TaxID) {
+			return fmt.Errorf("纳税人识别号格式不正确")
+		}
+	case "personal":
+		input.TaxID = ""
+	default:
+		return fmt.Errorf("不支持的抬头类型: %s", input.TitleType)
+	}
+	if input.Email != "" {
+		if _, err := mail.ParseAddress(input.Email); err != nil {
+			return fmt.Errorf("邮箱格式不正确")
+		}
+	}
+	return nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
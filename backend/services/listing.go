@@ -0,0 +1,155 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+	listDateLayout   = "2006-01-02"
+)
+
+var (
+	ErrInvalidCursor    = errors.New("无效的分页游标")
+	ErrInvalidDate      = errors.New("日期格式不正确，应为YYYY-MM-DD")
+	ErrInvalidDateRange = errors.New("结束日期不能早于起始日期")
+)
+
+// ListOptions 列表查询的通用分页与时间范围参数
+type ListOptions struct {
+	Cursor       string
+	Limit        int
+	StartDate    string // 起始日期（含），格式 2006-01-02
+	EndDate      string // 结束日期（含），格式 2006-01-02
+	IncludeTotal bool
+}
+
+// ListPage 游标分页结果，NextCursor 为空表示没有更多数据
+type ListPage struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// listCursor 按 (created_at, id) 倒序翻页的位置
+type listCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func encodeListCursor(createdAt time.Time, id string) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeListCursor(cursor string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &listCursor{CreatedAt: createdAt, ID: parts[1]}, nil
+}
+
+// applyListOptions 在查询上叠加时间范围和游标条件，返回规范化后的条数。
+// 统计总数需在调用前完成，游标条件不影响总数
+func applyListOptions(query *gorm.DB, opts ListOptions) (*gorm.DB, int, error) {
+	return applyCursor(query, opts, "created_at")
+}
+
+// applyCursor 按 (column, id) 倒序翻页，column 为游标中时间对应的字段
+func applyCursor(query *gorm.DB, opts ListOptions, column string) (*gorm.DB, int, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	if opts.Cursor != "" {
+		cursor, err := decodeListCursor(opts.Cursor)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where(column+" < ? OR ("+column+" = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+
+	return query.Order(column + " DESC, id DESC").Limit(limit + 1), limit, nil
+}
+
+// listPage 将分页参数转换为仓储的分页条件，多取一条用于判断是否还有更多数据
+func listPage(opts ListOptions) (repository.Page, int, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	page := repository.Page{Limit: limit + 1, IncludeTotal: opts.IncludeTotal}
+	if opts.StartDate != "" {
+		start, err := time.ParseInLocation(listDateLayout, opts.StartDate, time.Local)
+		if err != nil {
+			return page, 0, ErrInvalidDate
+		}
+		page.Start = &start
+	}
+	if opts.EndDate != "" {
+		end, err := time.ParseInLocation(listDateLayout, opts.EndDate, time.Local)
+		if err != nil {
+			return page, 0, ErrInvalidDate
+		}
+		end = end.AddDate(0, 0, 1)
+		page.End = &end
+	}
+	if opts.Cursor != "" {
+		cursor, err := decodeListCursor(opts.Cursor)
+		if err != nil {
+			return page, 0, err
+		}
+		page.AfterTime = &cursor.CreatedAt
+		page.AfterID = cursor.ID
+	}
+	return page, limit, nil
+}
+
+// applyDateRange 按创建日期筛选，结束日期包含当天
+func applyDateRange(query *gorm.DB, startDate, endDate string) (*gorm.DB, error) {
+	if startDate != "" {
+		start, err := time.ParseInLocation(listDateLayout, startDate, time.Local)
+		if err != nil {
+			return nil, ErrInvalidDate
+		}
+		query = query.Where("created_at >= ?", start)
+	}
+	if endDate != "" {
+		end, err := time.ParseInLocation(listDateLayout, endDate, time.Local)
+		if err != nil {
+			return nil, ErrInvalidDate
+		}
+		query = query.Where("created_at < ?", end.AddDate(0, 0, 1))
+	}
+	return query, nil
+}
+
+// escapeLike 转义 LIKE 通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
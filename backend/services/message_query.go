@@ -0,0 +1,110 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+)
+
+// MessageFilter 消息列表筛选条件
+type MessageFilter struct {
+	ListOptions
+	Status    string
+	Direction string
+	Recipient string // 完整号码精确匹配，部分号码模糊匹配
+	Query     string // 正文全文搜索
+}
+
+// MessageList 消息列表分页结果
+type MessageList struct {
+	ListPage
+	Messages []models.Message
+}
+
+// ListMessages 按创建时间倒序分页获取用户的消息
+func (m *MessageService) ListMessages(userID string, filter MessageFilter) (*MessageList, error) {
+	page, limit, err := listPage(filter.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	query := repository.MessageQuery{
+		Page:      page,
+		UserID:    userID,
+		Status:    filter.Status,
+		Direction: filter.Direction,
+		Keyword:   strings.TrimSpace(filter.Query),
+	}
+	if recipient := strings.TrimSpace(filter.Recipient); recipient != "" {
+		if phone, err := ParsePhoneNumber(recipient); err == nil {
+			query.Recipient = phone.String()
+		} else {
+			query.RecipientLike = recipient
+		}
+	}
+
+	messages, total, err := m.repos.Messages.List(query)
+	if err != nil {
+		return nil, fmt.Errorf("获取消息列表失败: %v", err)
+	}
+
+	list := &MessageList{}
+	if filter.IncludeTotal {
+		list.Total = &total
+	}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[limit-1]
+		list.HasMore = true
+		list.NextCursor = encodeListCursor(last.CreatedAt, last.ID)
+	}
+	list.Messages = messages
+
+	return list, nil
+}
+
+// MessageLifecycle 消息及所属订单的状态变更历史
+type MessageLifecycle struct {
+	Message      *models.Message        `json:"message"`
+	History      []models.StatusHistory `json:"history"`
+	OrderHistory []models.StatusHistory `json:"order_history"` // 收到的回复没有订单，为空
+}
+
+// GetMessageLifecycle 获取用户消息的状态历史，包括所属订单的支付和退款
+func (m *MessageService) GetMessageLifecycle(userID, messageID string) (*MessageLifecycle, error) {
+	message, err := m.repos.Messages.FindByID(messageID)
+	if err != nil || message.UserID != userID {
+		return nil, ErrMessageNotFound
+	}
+	return m.messageLifecycle(message)
+}
+
+// MessageLifecycle 获取任意用户消息的状态历史，供运营后台使用
+func (m *MessageService) MessageLifecycle(messageID string) (*MessageLifecycle, error) {
+	message, err := m.repos.Messages.FindByID(messageID)
+	if err != nil {
+		return nil, ErrMessageNotFound
+	}
+	return m.messageLifecycle(message)
+}
+
+func (m *MessageService) messageLifecycle(message *models.Message) (*MessageLifecycle, error) {
+	lifecycle := &MessageLifecycle{Message: message}
+
+	history, err := m.repos.History.ListByEntity(models.EntityMessage, message.ID)
+	if err != nil {
+		return nil, fmt.Errorf("获取状态历史失败: %v", err)
+	}
+	lifecycle.History = history
+
+	if message.OrderID != "" {
+		history, err = m.repos.History.ListByEntity(models.EntityOrder, message.OrderID)
+		if err != nil {
+			return nil, fmt.Errorf("获取状态历史失败: %v", err)
+		}
+		lifecycle.OrderHistory = history
+	}
+	return lifecycle, nil
+}
@@ -1,175 +1,337 @@
 package services
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"math"
 	"time"
 
-	"anonymous-messaging-backend/config"
 	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type MessageService struct {
-	smsService     *SMSService
-	paymentService *PaymentService
+	repos             repository.Repositories
+	smsService        *SMSService
+	paymentService    *PaymentService
+	templateService   templateStore
+	relayService      threadStore
+	invoiceService    invoiceReverser
+	couponService     couponLedger
+	creditService     creditLedger
+	suspensionService accountChecker
+	outbox            *OutboxService
 }
 
-func NewMessageService() (*MessageService, error) {
+// 以下为 MessageService 依赖的其他服务。这些服务尚未使用仓储，通过注入的数据库连接访问，
+// 单元测试中配合内存仓储替换为不访问数据库的实现
+
+// accountChecker 检查账号是否可以发送
+type accountChecker interface {
+	CheckUser(userID string) error
+}
+
+// templateStore 用户的短信模板
+type templateStore interface {
+	GetTemplate(userID, templateID string) (*models.SMSTemplate, error)
+	RenderTemplate(userID, templateID string, params map[string]string) (*models.SMSTemplate, string, error)
+	templateByID(templateID string) (*models.SMSTemplate, error)
+}
+
+// threadStore 收件人对应的匿名会话
+type threadStore interface {
+	EnsureThread(userID string, recipient *PhoneNumber) (*models.RelayThread, error)
+	GetExtendCode(threadID string) string
+	TouchThread(threadID string)
+}
+
+// creditLedger 套餐额度，Consume 通过 tx 加入下单事务，内存仓储中 tx 为 nil
+type creditLedger interface {
+	Consume(tx *gorm.DB, userID, orderID string, segments int, description string) (int, error)
+	Refund(actor Actor, orderID, reason string) error
+	HasGrants(orderID string) bool
+}
+
+// couponLedger 优惠券，Redeem 通过 tx 加入下单事务，内存仓储中 tx 为 nil
+type couponLedger interface {
+	Quote(userID, code string, amount float64, scope string) (*CouponQuote, error)
+	Redeem(tx *gorm.DB, userID, code, orderID string, amount float64, scope string) (*models.Coupon, float64, error)
+	Reverse(orderID string) error
+}
+
+// invoiceReverser 退款后红冲发票
+type invoiceReverser interface {
+	ReverseForOrder(orderID, reason string) error
+}
+
+// SendMessageInput 发送消息参数，指定TemplateID时按模板和参数生成正文
+type SendMessageInput struct {
+	Phone          string
+	Content        string
+	TemplateID     string
+	TemplateParams map[string]string
+	CouponCode     string
+	ScheduledAt    *time.Time
+	Actor          Actor // 审计日志中的操作者
+}
+
+// NewMessageService 支付、套餐、优惠券、停用和会话服务由调用方创建后注入，与其他处理器共用
+func NewMessageService(repos repository.Repositories, paymentService *PaymentService, creditService *CreditService, couponService *CouponService, suspensionService *SuspensionService, relayService *RelayService) (*MessageService, error) {
 	smsService, err := NewSMSService()
 	if err != nil {
 		return nil, err
 	}
 
-	paymentService, err := NewPaymentService()
+	invoiceService, err := NewInvoiceService()
 	if err != nil {
 		return nil, err
 	}
 
-	return &MessageService{
-		smsService:     smsService,
-		paymentService: paymentService,
-	}, nil
+	m := &MessageService{
+		repos:             repos,
+		smsService:        smsService,
+		paymentService:    paymentService,
+		templateService:   NewTemplateService(smsService),
+		relayService:      relayService,
+		invoiceService:    invoiceService,
+		couponService:     couponService,
+		creditService:     creditService,
+		suspensionService: suspensionService,
+		outbox:            NewOutboxService(repos),
+	}
+	m.registerEvents()
+	return m, nil
+}
+
+// registerEvents 注册由消息服务执行的事件
+func (m *MessageService) registerEvents() {
+	m.outbox.Handle(EventSMSDispatch, m.handleDispatch)
+	m.outbox.Handle(EventMessageRefund, m.handleMessageRefund)
+	m.outbox.Handle(EventOrderRelease, m.handleOrderRelease)
+	m.outbox.Handle(EventInvoiceReverse, m.handleInvoiceReverse)
+}
+
+// StartOutbox 启动发送短信、退款等事件的后台执行
+func (m *MessageService) StartOutbox(interval time.Duration) {
+	m.outbox.StartDispatch(interval)
 }
 
-func (m *MessageService) CalculateCost(content string) float64 {
-	charCount := len([]rune(content))
-	units := int(math.Ceil(float64(charCount) / 60.0))
-	return float64(units) * 1.0 // 每60字符1元
+// CalculateCost 按收件人所属国家/地区单价和短信条数计算费用，phone为空时按国内价格计算
+func (m *MessageService) CalculateCost(phone, content string) (float64, error) {
+	cost, _, err := m.messageCost(phone, content, false)
+	return cost, err
 }
 
-func (m *MessageService) CreateOrder(userID string, amount float64, description string) (*models.Order, error) {
-	order := &models.Order{
-		ID:            uuid.New().String(),
-		UserID:        userID,
-		OrderNo:       generateOrderNo(),
-		Amount:        amount,
-		Status:        "pending",
-		PaymentMethod: "wechat",
-		Description:   description,
-		CreatedAt:     time.Now(),
+// messageCost 计算费用和实际发送的短信条数。国内短信按正文拆分到默认模板的条数计费，
+// 超过拆分上限时返回 ErrContentTooLong；使用自定义模板时为1条。国际短信由服务商拆分，按每60字符计费
+func (m *MessageService) messageCost(phone, content string, templated bool) (float64, int, error) {
+	country := GetCountry(DomesticCountryCode)
+	if phone != "" {
+		parsed, err := ParsePhoneNumber(phone)
+		if err != nil {
+			return 0, 0, err
+		}
+		country = *parsed.Country
 	}
 
-	if err := config.DB.Create(order).Error; err != nil {
-		return nil, fmt.Errorf("创建订单失败: %v", err)
+	if !country.Enabled {
+		return 0, 0, fmt.Errorf("暂未开通%s短信服务", country.Name)
 	}
 
-	return order, nil
+	parts := 1
+	switch {
+	case country.Code != DomesticCountryCode:
+		parts = segmentCount(content)
+	case !templated:
+		var err error
+		if parts, err = m.smsService.ContentParts(content); err != nil {
+			return 0, 0, err
+		}
+	}
+	return float64(parts) * country.UnitPrice, parts, nil
 }
 
-func (m *MessageService) SendMessage(userID, phone, content string, scheduledAt *time.Time) (*models.Message, error) {
-	cost := m.CalculateCost(content)
-	
-	// 1. 创建订单
-	order, err := m.CreateOrder(userID, cost, fmt.Sprintf("发送短信 - %d字符", len([]rune(content))))
+// segmentCount 国际短信计费条数，每60字符为一条
+func segmentCount(content string) int {
+	return int(math.Ceil(float64(len([]rune(content))) / 60.0))
+}
+
+// OrderInput 创建订单参数
+type OrderInput struct {
+	Amount      float64 // 原价
+	Description string
+	CouponCode  string
+	Scope       string // 订单类型 message / batch，用于校验优惠券适用范围
+	Segments    int    // 可用套餐额度抵扣的短信条数，0为不使用套餐
+	Actor       Actor
+}
+
+func (m *MessageService) SendMessage(userID string, input SendMessageInput) (*models.Message, error) {
+	// 停用的账号不能发送
+	if err := m.suspensionService.CheckUser(userID); err != nil {
+		return nil, err
+	}
+
+	recipient, err := ParsePhoneNumber(input.Phone)
 	if err != nil {
 		return nil, err
 	}
 
-	// 2. 处理支付
-	paymentResult, err := m.paymentService.ProcessPayment(order.ID, cost)
-	if err != nil || !paymentResult.Success {
-		// 支付失败，更新订单状态
-		order.Status = "failed"
-		config.DB.Save(order)
-		return nil, fmt.Errorf("支付失败: %v", paymentResult.Error)
+	content := input.Content
+	var templateParams string
+	if input.TemplateID != "" {
+		_, content, err = m.templateService.RenderTemplate(userID, input.TemplateID, input.TemplateParams)
+		if err != nil {
+			return nil, err
+		}
+
+		params, err := json.Marshal(input.TemplateParams)
+		if err != nil {
+			return nil, fmt.Errorf("模板参数格式错误: %v", err)
+		}
+		templateParams = string(params)
 	}
 
-	// 3. 支付成功，更新订单状态
-	now := time.Now()
-	order.Status = "paid"
-	order.PaidAt = &now
-	order.PaymentTransactionID = paymentResult.TransactionID
-	config.DB.Save(order)
+	// 下单前按实际发送的条数计费，正文过长时直接拒绝
+	cost, parts, err := m.messageCost(input.Phone, content, input.TemplateID != "")
+	if err != nil {
+		return nil, err
+	}
+
+	// 每个收件人对应一个匿名会话，用于接收回复
+	thread, err := m.relayService.EnsureThread(userID, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 订单和消息在同一事务中创建，国内短信优先使用套餐额度，剩余金额可使用优惠券
+	orderInput := OrderInput{
+		Amount:      cost,
+		Description: fmt.Sprintf("发送短信 - %d字符", len([]rune(content))),
+		CouponCode:  input.CouponCode,
+		Scope:       "message",
+		Actor:       input.Actor,
+	}
+	if recipient.IsDomestic() {
+		orderInput.Segments = parts
+	}
 
-	// 4. 创建消息记录
 	message := &models.Message{
 		ID:             uuid.New().String(),
 		UserID:         userID,
-		OrderID:        order.ID,
-		RecipientPhone: phone,
+		ThreadID:       thread.ID,
+		Direction:      "outbound",
+		RecipientPhone: recipient.String(),
+		CountryCode:    recipient.Country.Code,
+		TemplateID:     input.TemplateID,
+		TemplateParams: templateParams,
 		Content:        content,
 		CharacterCount: len([]rune(content)),
 		Cost:           cost,
+		Parts:          parts,
 		Status:         "pending",
-		ScheduledAt:    scheduledAt,
+		ScheduledAt:    input.ScheduledAt,
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 	}
-
-	if err := config.DB.Create(message).Error; err != nil {
-		return nil, fmt.Errorf("创建消息记录失败: %v", err)
+	order, err := m.placeOrder(userID, orderInput, []*models.Message{message}, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	// 5. 如果不是定时发送，立即发送短信
-	if scheduledAt == nil {
-		go m.sendSMSAsync(message)
+	// 2. 处理支付，成功后在同一事务中提交发送任务，定时消息到时间后发送
+	if err := m.chargeOrder(input.Actor, order); err != nil {
+		return nil, err
 	}
 
 	return message, nil
 }
 
-func (m *MessageService) sendSMSAsync(message *models.Message) {
-	// 更新消息状态为发送中
-	message.Status = "sending"
-	config.DB.Save(message)
+// applyTemplate 使用消息关联的模板代码和参数发送
+func (m *MessageService) applyTemplate(request *SMSRequest, message *models.Message) error {
+	template, err := m.templateService.templateByID(message.TemplateID)
+	if err != nil {
+		return err
+	}
+	if template.Status != "approved" {
+		return ErrTemplateNotApproved
+	}
 
-	// 发送短信
-	smsRequest := SMSRequest{
-		PhoneNumber: message.RecipientPhone,
-		Content:     message.Content,
+	params := make(map[string]string)
+	if message.TemplateParams != "" {
+		if err := json.Unmarshal([]byte(message.TemplateParams), &params); err != nil {
+			return fmt.Errorf("模板参数格式错误: %v", err)
+		}
 	}
 
-	smsResponse, err := m.smsService.SendSMS(smsRequest)
-	if err != nil || !smsResponse.Success {
-		// 发送失败
-		message.Status = "failed"
-		message.FailedReason = smsResponse.Error
-		config.DB.Save(message)
+	request.TemplateCode = template.TemplateCode
+	request.TemplateParams = params
+	return nil
+}
 
-		// 退款
-		m.refundOrder(message.OrderID, message.Cost, "短信发送失败")
-		return
+// RefundOrder 后台发起的整单退款，退还订单剩余未退的实付金额
+func (m *MessageService) RefundOrder(actor Actor, orderID, reason string) (*models.Order, error) {
+	order, err := m.repos.Orders.FindByID(orderID)
+	if err != nil {
+		return nil, ErrOrderNotFound
+	}
+	if order.Status != "paid" || order.Amount <= 0 {
+		return nil, ErrOrderNotRefundable
 	}
 
-	// 发送成功
-	now := time.Now()
-	message.Status = "sent"
-	message.SentAt = &now
-	message.SMSMessageID = smsResponse.MessageID
-	config.DB.Save(message)
-}
+	// 套餐订单的额度已发放，不能直接退款
+	if m.creditService.HasGrants(orderID) {
+		return nil, ErrOrderNotRefundable
+	}
 
-func (m *MessageService) refundOrder(orderID string, amount float64, reason string) {
-	// 处理退款
-	refundResult, err := m.paymentService.RefundPayment(orderID, amount, reason)
-	if err != nil || !refundResult.Success {
-		return
+	listAmount := order.OriginalAmount
+	if listAmount <= 0 {
+		listAmount = order.Amount
+	}
+	if err := m.refundOrder(actor, uuid.New().String(), orderID, listAmount, reason); err != nil {
+		log.Printf("订单退款失败: order=%s err=%v", orderID, err)
+		return nil, ErrRefundFailed
 	}
 
-	// 更新订单状态
-	var order models.Order
-	if err := config.DB.First(&order, "id = ?", orderID).Error; err != nil {
-		return
+	order, err = m.repos.Orders.FindByID(orderID)
+	if err != nil {
+		return nil, fmt.Errorf("获取订单失败: %v", err)
 	}
+	if order.Status != "refunded" {
+		return nil, ErrRefundFailed
+	}
+	return order, nil
+}
 
-	now := time.Now()
-	order.Status = "refunded"
-	order.RefundedAt = &now
-	config.DB.Save(&order)
+// orderSnapshot 审计日志中记录的订单字段
+func orderSnapshot(order *models.Order) map[string]interface{} {
+	return map[string]interface{}{
+		"order_no":        order.OrderNo,
+		"user_id":         order.UserID,
+		"status":          order.Status,
+		"amount":          order.Amount,
+		"original_amount": order.OriginalAmount,
+		"discount_amount": order.DiscountAmount,
+		"coupon_code":     order.CouponCode,
+		"credits_used":    order.CreditsUsed,
+		"payment_method":  order.PaymentMethod,
+		"transaction_id":  order.PaymentTransactionID,
+	}
+}
 
-	// 创建退款记录
-	refundRecord := &models.RefundRecord{
-		ID:                  uuid.New().String(),
-		OrderID:             orderID,
-		RefundAmount:        amount,
-		Reason:              reason,
-		Status:              "success",
-		RefundTransactionID: refundResult.TransactionID,
-		CreatedAt:           time.Now(),
-		ProcessedAt:         &now,
+// orderStatusEntry 订单状态变更的审计日志
+func orderStatusEntry(actor Actor, action string, order *models.Order, previousStatus string) AuditEntry {
+	return AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: "order",
+		TargetID:   order.ID,
+		Before:     map[string]interface{}{"status": previousStatus},
+		After:      orderSnapshot(order),
 	}
-	config.DB.Create(refundRecord)
 }
 
 func generateOrderNo() string {
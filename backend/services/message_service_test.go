@@ -0,0 +1,338 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 以下为内存仓储测试中替换访问数据库的服务的实现
+
+type stubAccounts struct{}
+
+func (stubAccounts) CheckUser(userID string) error { return nil }
+
+type stubTemplates struct{}
+
+func (stubTemplates) GetTemplate(userID, templateID string) (*models.SMSTemplate, error) {
+	return nil, ErrTemplateNotFound
+}
+
+func (stubTemplates) RenderTemplate(userID, templateID string, params map[string]string) (*models.SMSTemplate, string, error) {
+	return nil, "", ErrTemplateNotFound
+}
+
+func (stubTemplates) templateByID(templateID string) (*models.SMSTemplate, error) {
+	return nil, ErrTemplateNotFound
+}
+
+type stubThreads struct{}
+
+func (stubThreads) EnsureThread(userID string, recipient *PhoneNumber) (*models.RelayThread, error) {
+	return &models.RelayThread{ID: uuid.New().String(), UserID: userID}, nil
+}
+
+func (stubThreads) GetExtendCode(threadID string) string { return "" }
+
+func (stubThreads) TouchThread(threadID string) {}
+
+// stubCredits 套餐余量为 remaining 条，记录退回额度的订单
+type stubCredits struct {
+	remaining int
+	refunded  []string
+}
+
+func (s *stubCredits) Consume(tx *gorm.DB, userID, orderID string, segments int, description string) (int, error) {
+	used := min(segments, s.remaining)
+	s.remaining -= used
+	return used, nil
+}
+
+func (s *stubCredits) Refund(actor Actor, orderID, reason string) error {
+	s.refunded = append(s.refunded, orderID)
+	return nil
+}
+
+func (s *stubCredits) HasGrants(orderID string) bool { return false }
+
+// stubCoupons 记录退回优惠券的订单
+type stubCoupons struct {
+	reversed []string
+}
+
+func (s *stubCoupons) Quote(userID, code string, amount float64, scope string) (*CouponQuote, error) {
+	return nil, ErrCouponNotFound
+}
+
+func (s *stubCoupons) Redeem(tx *gorm.DB, userID, code, orderID string, amount float64, scope string) (*models.Coupon, float64, error) {
+	return nil, 0, ErrCouponNotFound
+}
+
+func (s *stubCoupons) Reverse(orderID string) error {
+	s.reversed = append(s.reversed, orderID)
+	return nil
+}
+
+// stubInvoices 记录红冲发票的订单
+type stubInvoices struct {
+	reversed []string
+}
+
+func (s *stubInvoices) ReverseForOrder(orderID, reason string) error {
+	s.reversed = append(s.reversed, orderID)
+	return nil
+}
+
+type testMessageService struct {
+	*MessageService
+	repos    repository.Repositories
+	credits  *stubCredits
+	coupons  *stubCoupons
+	invoices *stubInvoices
+}
+
+// newTestMessageService 基于内存仓储创建消息服务，短信和支付使用模拟模式
+func newTestMessageService(t *testing.T, credits int) *testMessageService {
+	t.Helper()
+	if config.App == nil {
+		config.App = &config.AppConfig{}
+	}
+
+	repos := repository.NewMemory()
+	smsService, err := NewSMSService()
+	if err != nil {
+		t.Fatalf("创建短信服务失败: %v", err)
+	}
+	paymentService, err := NewPaymentService(repos)
+	if err != nil {
+		t.Fatalf("创建支付服务失败: %v", err)
+	}
+
+	s := &testMessageService{
+		repos:    repos,
+		credits:  &stubCredits{remaining: credits},
+		coupons:  &stubCoupons{},
+		invoices: &stubInvoices{},
+	}
+	s.MessageService = &MessageService{
+		repos:             repos,
+		smsService:        smsService,
+		paymentService:    paymentService,
+		templateService:   stubTemplates{},
+		relayService:      stubThreads{},
+		invoiceService:    s.invoices,
+		couponService:     s.coupons,
+		creditService:     s.credits,
+		suspensionService: stubAccounts{},
+		outbox:            NewOutboxService(repos),
+	}
+	s.registerEvents()
+	return s
+}
+
+func TestSendMessageWithCredits(t *testing.T) {
+	s := newTestMessageService(t, 5)
+
+	message, err := s.SendMessage("user-1", SendMessageInput{
+		Phone:   "13800138000",
+		Content: "hello",
+		Actor:   SystemActor("test"),
+	})
+	if err != nil {
+		t.Fatalf("SendMessage() error: %v", err)
+	}
+	if message.Status != "pending" || message.Parts != 1 {
+		t.Fatalf("消息状态 %s、条数 %d，应为 pending、1", message.Status, message.Parts)
+	}
+	if s.credits.remaining != 4 {
+		t.Errorf("剩余额度 %d，应为 4", s.credits.remaining)
+	}
+
+	order, err := s.repos.Orders.FindByID(message.OrderID)
+	if err != nil {
+		t.Fatalf("获取订单失败: %v", err)
+	}
+	if order.Status != "paid" || order.PaymentMethod != "credit" || order.Amount != 0 || order.CreditsUsed != 1 {
+		t.Fatalf("订单 %+v 应为套餐全额抵扣并已支付", order)
+	}
+
+	// 支付成功时写入的 sms.dispatch 事件执行后消息已发出
+	if n := s.outbox.ProcessDue(); n != 1 {
+		t.Fatalf("执行了 %d 个事件，应为 1", n)
+	}
+	sent, err := s.repos.Messages.FindByID(message.ID)
+	if err != nil {
+		t.Fatalf("获取消息失败: %v", err)
+	}
+	if sent.Status != "sent" || sent.SentParts != 1 {
+		t.Errorf("消息状态 %s、已发送 %d 条，应为 sent、1", sent.Status, sent.SentParts)
+	}
+
+	histories, err := s.repos.History.ListByEntity(models.EntityMessage, message.ID)
+	if err != nil {
+		t.Fatalf("获取状态历史失败: %v", err)
+	}
+	var statuses []string
+	for _, history := range histories {
+		statuses = append(statuses, history.ToStatus)
+	}
+	if len(statuses) != 3 || statuses[0] != "pending" || statuses[1] != "sending" || statuses[2] != "sent" {
+		t.Errorf("状态历史 %v，应为 [pending sending sent]", statuses)
+	}
+}
+
+func TestSendMessageCouponErrorSavesNothing(t *testing.T) {
+	s := newTestMessageService(t, 0)
+
+	_, err := s.SendMessage("user-1", SendMessageInput{
+		Phone:      "13800138000",
+		Content:    "hello",
+		CouponCode: "NOPE",
+		Actor:      SystemActor("test"),
+	})
+	if !errors.Is(err, ErrCouponNotFound) {
+		t.Fatalf("err = %v, want %v", err, ErrCouponNotFound)
+	}
+
+	messages, _, err := s.repos.Messages.List(repository.MessageQuery{UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("获取消息失败: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("下单失败后仍保存了 %d 条消息", len(messages))
+	}
+}
+
+// paidOrder 在内存仓储中创建一笔已支付、使用了优惠券的订单
+func paidOrder(t *testing.T, repos repository.Repositories, amount float64) *models.Order {
+	t.Helper()
+	order := &models.Order{
+		ID:             uuid.New().String(),
+		UserID:         "user-1",
+		OrderNo:        generateOrderNo(),
+		Amount:         amount,
+		OriginalAmount: amount + 0.5,
+		DiscountAmount: 0.5,
+		CouponID:       "coupon-1",
+		Status:         "pending",
+		CreatedAt:      time.Now(),
+	}
+	if err := repos.Orders.Create(order); err != nil {
+		t.Fatalf("创建订单失败: %v", err)
+	}
+	now := time.Now()
+	order.Status = "paid"
+	order.PaidAt = &now
+	if err := repos.Orders.Transition(order, "支付成功", "pending"); err != nil {
+		t.Fatalf("更新订单状态失败: %v", err)
+	}
+	return order
+}
+
+func TestRefundOrder(t *testing.T) {
+	s := newTestMessageService(t, 0)
+	order := paidOrder(t, s.repos, 1.5)
+
+	refunded, err := s.RefundOrder(SystemActor("test"), order.ID, "测试退款")
+	if err != nil {
+		t.Fatalf("RefundOrder() error: %v", err)
+	}
+	if refunded.Status != "refunded" || refunded.RefundedAt == nil {
+		t.Fatalf("订单状态 %s，应为 refunded", refunded.Status)
+	}
+
+	total, err := s.repos.Refunds.SumSucceeded(order.ID)
+	if err != nil {
+		t.Fatalf("统计退款金额失败: %v", err)
+	}
+	if total != 1.5 {
+		t.Errorf("退款金额 %.2f，应为实付的 1.50", total)
+	}
+
+	// 退款事务中写入的红冲发票事件和全额退款后的额度、优惠券退回事件
+	if n := s.outbox.ProcessDue(); n != 2 {
+		t.Fatalf("执行了 %d 个事件，应为 2", n)
+	}
+	if len(s.invoices.reversed) != 1 || s.invoices.reversed[0] != order.ID {
+		t.Errorf("红冲发票的订单 %v，应为 [%s]", s.invoices.reversed, order.ID)
+	}
+	if len(s.coupons.reversed) != 1 || len(s.credits.refunded) != 1 {
+		t.Errorf("退回优惠券 %v、额度 %v，应各退回一次", s.coupons.reversed, s.credits.refunded)
+	}
+
+	if _, err := s.RefundOrder(SystemActor("test"), order.ID, "重复退款"); !errors.Is(err, ErrOrderNotRefundable) {
+		t.Errorf("重复退款 err = %v, want %v", err, ErrOrderNotRefundable)
+	}
+}
+
+func TestRefundOrderNotOverRefunded(t *testing.T) {
+	s := newTestMessageService(t, 0)
+	order := paidOrder(t, s.repos, 1.5)
+
+	// 两次部分退款的原价合计超过订单原价，第二次只退剩余的实付金额
+	for _, requestID := range []string{"refund-1", "refund-2", "refund-3"} {
+		if err := s.refundOrder(SystemActor("test"), requestID, order.ID, 1.5, "部分退款"); err != nil {
+			t.Fatalf("refundOrder(%s) error: %v", requestID, err)
+		}
+	}
+
+	total, err := s.repos.Refunds.SumSucceeded(order.ID)
+	if err != nil {
+		t.Fatalf("统计退款金额失败: %v", err)
+	}
+	if total != 1.5 {
+		t.Errorf("退款金额 %.2f，不应超过实付的 1.50", total)
+	}
+	if _, err := s.repos.Refunds.FindByRequestID("refund-3"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("无可退金额时仍保存了退款记录: err = %v", err)
+	}
+}
+
+func TestSendBatchRecordsProgress(t *testing.T) {
+	s := newTestMessageService(t, 10)
+
+	result, err := s.SendBatch("user-1", SendBatchInput{
+		Content: "hello ${name}",
+		Recipients: []BatchRecipient{
+			{Phone: "13800138000", Variables: map[string]string{"name": "a"}},
+			{Phone: "13800138001", Variables: map[string]string{"name": "b"}},
+			{Phone: "13800138000", Variables: map[string]string{"name": "c"}},
+		},
+		Actor: SystemActor("test"),
+	})
+	if err != nil {
+		t.Fatalf("SendBatch() error: %v", err)
+	}
+	if result.Batch.ValidRows != 2 || result.Batch.InvalidRows != 1 {
+		t.Fatalf("有效 %d 行、无效 %d 行，应为 2、1", result.Batch.ValidRows, result.Batch.InvalidRows)
+	}
+
+	// 每条消息一个 sms.dispatch 事件，全部发出后任务完成
+	if n := s.outbox.ProcessDue(); n != 2 {
+		t.Fatalf("执行了 %d 个事件，应为 2", n)
+	}
+	batch, err := s.GetBatch("user-1", result.Batch.ID)
+	if err != nil {
+		t.Fatalf("GetBatch() error: %v", err)
+	}
+	if batch.Status != "completed" || batch.SentCount != 2 || batch.FailedCount != 0 || batch.CompletedAt == nil {
+		t.Errorf("批量任务 %+v 应已完成并发出 2 条", batch)
+	}
+
+	sent, err := s.GetBatchItems("user-1", batch.ID, "sent")
+	if err != nil {
+		t.Fatalf("GetBatchItems() error: %v", err)
+	}
+	if len(sent) != 2 || sent[0].RowNo != 1 || sent[1].RowNo != 2 {
+		t.Errorf("已发送的明细 %+v，应为第 1、2 行", sent)
+	}
+	if _, err := s.GetBatch("user-2", batch.ID); !errors.Is(err, ErrBatchNotFound) {
+		t.Errorf("其他用户查询 err = %v, want %v", err, ErrBatchNotFound)
+	}
+}
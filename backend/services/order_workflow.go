@@ -0,0 +1,578 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 订单、支付、消息的状态流转：
+//
+//	下单     订单 pending、消息 pending，扣减套餐额度和优惠券，同一事务提交
+//	支付成功 订单 paid，同一事务写入 sms.dispatch 事件，定时消息到时间后才执行
+//	支付失败 订单 failed、消息 cancelled，写入 order.release 事件退回额度和优惠券
+//	发送     消息 pending → sending → sent / failed，失败时写入 message.refund 事件
+//	退款     按请求标识幂等退款，同一事务写入审计日志和 invoice.reverse 事件，
+//	         全额退款后订单 refunded，写入 order.release 事件
+//
+// 短信发送、退款等外部调用都由事件异步执行，失败时按退避时间重试。进程在
+// 任一步骤中断后，事件和超时未支付的订单会被后台任务重新处理
+
+// ErrOrderCharged 已扣款（或已扣减套餐额度、优惠券）后处理失败，订单由后台任务补偿，
+// 客户端不应重新下单
+var ErrOrderCharged = errors.New("订单已扣款，处理结果以订单状态为准")
+
+// 超过该时间仍未支付的订单由后台任务补偿：已扣款的继续完成，否则按支付失败处理
+const orderPaymentTimeout = 10 * time.Minute
+
+// dispatchPayload sms.dispatch 事件内容
+type dispatchPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// messageRefundPayload message.refund 事件内容
+type messageRefundPayload struct {
+	MessageID string `json:"message_id"`
+	Reason    string `json:"reason"`
+}
+
+// orderReleasePayload order.release 事件内容
+type orderReleasePayload struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
+
+// invoiceReversePayload invoice.reverse 事件内容
+type invoiceReversePayload struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
+
+// placeOrder 在同一事务中创建订单和待发送的消息，先扣减套餐额度，剩余金额再使用优惠券。
+// persist 用于在同一事务中写入批量任务等关联记录
+func (m *MessageService) placeOrder(userID string, input OrderInput, messages []*models.Message, persist func(tx repository.Repositories, order *models.Order) error) (*models.Order, error) {
+	order := &models.Order{
+		ID:             uuid.New().String(),
+		UserID:         userID,
+		OrderNo:        generateOrderNo(),
+		Amount:         input.Amount,
+		OriginalAmount: input.Amount,
+		Status:         "pending",
+		PaymentMethod:  "wechat",
+		Description:    input.Description,
+		CreatedAt:      time.Now(),
+	}
+
+	err := m.repos.Transaction(func(tx repository.Repositories, db *gorm.DB) error {
+		if input.Segments > 0 {
+			used, err := m.creditService.Consume(db, userID, order.ID, input.Segments, input.Description)
+			if err != nil {
+				return err
+			}
+			order.CreditsUsed = used
+			order.Amount = roundAmount(input.Amount * float64(input.Segments-used) / float64(input.Segments))
+		}
+
+		// 套餐已全额抵扣时不再使用优惠券
+		if input.CouponCode != "" && order.Amount > 0 {
+			coupon, discount, err := m.couponService.Redeem(db, userID, input.CouponCode, order.ID, order.Amount, input.Scope)
+			if err != nil {
+				return err
+			}
+			order.CouponID = coupon.ID
+			order.CouponCode = coupon.Code
+			order.DiscountAmount = discount
+			order.Amount = roundAmount(order.Amount - discount)
+		}
+		if err := tx.Orders.Create(order); err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			message.OrderID = order.ID
+		}
+		if err := tx.Messages.CreateAll(messages); err != nil {
+			return err
+		}
+		if persist != nil {
+			if err := persist(tx, order); err != nil {
+				return err
+			}
+		}
+
+		return RecordAudit(tx.Audit, AuditEntry{
+			Actor:      input.Actor,
+			Action:     "order.create",
+			TargetType: "order",
+			TargetID:   order.ID,
+			After:      orderSnapshot(order),
+		})
+	})
+	if err != nil {
+		if IsCouponError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("创建订单失败: %v", err)
+	}
+
+	return order, nil
+}
+
+// chargeOrder 支付订单，抵扣后金额为0时无需支付
+func (m *MessageService) chargeOrder(actor Actor, order *models.Order) error {
+	if order.Amount <= 0 {
+		method := "coupon"
+		if order.CreditsUsed > 0 {
+			method = "credit"
+		}
+		if err := m.settlePayment(actor, order, method, ""); err != nil {
+			return fmt.Errorf("%w: %v", ErrOrderCharged, err)
+		}
+		return nil
+	}
+
+	paymentResult, err := m.paymentService.ProcessPayment(order.ID, order.Amount)
+	if err != nil || !paymentResult.Success {
+		reason := "支付失败"
+		if err != nil {
+			reason = err.Error()
+		} else if paymentResult.Error != "" {
+			reason = paymentResult.Error
+		}
+		if failErr := m.failPayment(actor, order, reason); failErr != nil {
+			log.Printf("更新支付失败状态失败: order=%s err=%v", order.ID, failErr)
+		}
+		return fmt.Errorf("支付失败: %v", reason)
+	}
+
+	if err := m.settlePayment(actor, order, "wechat", paymentResult.TransactionID); err != nil {
+		return fmt.Errorf("%w: %v", ErrOrderCharged, err)
+	}
+	return nil
+}
+
+// settlePayment 订单标记为已支付，并在同一事务中提交待发送消息的发送事件
+func (m *MessageService) settlePayment(actor Actor, order *models.Order, method, transactionID string) error {
+	now := time.Now()
+	paid := *order
+	paid.Status = "paid"
+	paid.PaidAt = &now
+	paid.PaymentMethod = method
+	paid.PaymentTransactionID = transactionID
+
+	err := m.repos.Transaction(func(tx repository.Repositories, db *gorm.DB) error {
+		if err := tx.Orders.Transition(&paid, "支付成功", "pending"); err != nil {
+			return err
+		}
+
+		messages, err := tx.Messages.ListByOrder(order.ID)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if message.Status != "pending" {
+				continue
+			}
+			availableAt := now
+			if message.ScheduledAt != nil && message.ScheduledAt.After(now) {
+				availableAt = *message.ScheduledAt
+			}
+			if err := enqueueEvent(tx, EventSMSDispatch, message.ID, dispatchPayload{MessageID: message.ID}, availableAt); err != nil {
+				return err
+			}
+		}
+
+		return RecordAudit(tx.Audit, orderStatusEntry(actor, "order.pay", &paid, "pending"))
+	})
+	if err != nil {
+		return fmt.Errorf("更新订单支付状态失败: %v", err)
+	}
+
+	*order = paid
+	wakeOutbox()
+	return nil
+}
+
+// failPayment 订单标记为支付失败，取消待发送的消息，退回额度和优惠券
+func (m *MessageService) failPayment(actor Actor, order *models.Order, reason string) error {
+	failed := *order
+	failed.Status = "failed"
+
+	err := m.repos.Transaction(func(tx repository.Repositories, db *gorm.DB) error {
+		if err := tx.Orders.Transition(&failed, "支付失败: "+reason, "pending"); err != nil {
+			return err
+		}
+
+		messages, err := tx.Messages.ListByOrder(order.ID)
+		if err != nil {
+			return err
+		}
+		for i := range messages {
+			message := &messages[i]
+			message.Status = "cancelled"
+			message.FailedReason = truncateString("支付失败: "+reason, 255)
+			if err := tx.Messages.Transition(message, "订单支付失败", "pending"); err != nil && !errors.Is(err, repository.ErrConflict) {
+				return err
+			}
+		}
+		if len(messages) > 0 && messages[0].BatchID != "" {
+			if err := tx.Batches.FailForOrder(order.ID); err != nil {
+				return err
+			}
+		}
+
+		if order.CreditsUsed > 0 || order.CouponID != "" {
+			payload := orderReleasePayload{OrderID: order.ID, Reason: "支付失败"}
+			if err := enqueueEvent(tx, EventOrderRelease, order.ID, payload, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		return RecordAudit(tx.Audit, orderStatusEntry(actor, "order.payment_failed", &failed, "pending"))
+	})
+	if err != nil {
+		return err
+	}
+
+	*order = failed
+	wakeOutbox()
+	return nil
+}
+
+// StartOrderRecovery 启动后台任务，补偿进程中断后停留在待支付的订单
+func (m *MessageService) StartOrderRecovery(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			m.recoverStaleOrders()
+		}
+	}()
+}
+
+// recoverStaleOrders 已扣款的订单继续完成支付，未扣款的按支付超时处理
+func (m *MessageService) recoverStaleOrders() {
+	orders, err := m.repos.Orders.ListStale("pending", time.Now().Add(-orderPaymentTimeout), 100)
+	if err != nil {
+		log.Printf("查询超时订单失败: %v", err)
+		return
+	}
+
+	actor := SystemActor("order_recovery")
+	for i := range orders {
+		order := &orders[i]
+
+		// 套餐购买等不含消息的订单由各自的流程处理
+		count, err := m.repos.Messages.CountByOrder(order.ID, "")
+		if err != nil || count == 0 {
+			continue
+		}
+
+		if order.Amount <= 0 {
+			err = m.chargeOrder(actor, order)
+		} else if record := m.paymentService.succeededPayment(order.ID); record != nil {
+			err = m.settlePayment(actor, order, record.PaymentMethod, record.TransactionID)
+		} else {
+			err = m.failPayment(actor, order, "支付超时")
+		}
+		if err != nil && !errors.Is(err, repository.ErrConflict) {
+			log.Printf("补偿超时订单失败: order=%s err=%v", order.ID, err)
+		}
+	}
+}
+
+// handleDispatch 执行 sms.dispatch 事件，发送单条已支付的消息
+func (m *MessageService) handleDispatch(event *models.OutboxEvent) error {
+	var payload dispatchPayload
+	if err := decodeEvent(event, &payload); err != nil {
+		return err
+	}
+
+	message, err := m.repos.Messages.FindByID(payload.MessageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch message.Status {
+	case "pending":
+	case "sending":
+		// 上次发送中断，无法确认是否已发出，按失败退款而不是重复发送
+		return m.finishDelivery(message, nil, "发送结果未知", "短信发送失败")
+	default:
+		return nil
+	}
+
+	order, err := m.repos.Orders.FindByID(message.OrderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != "paid" {
+		message.Status = "cancelled"
+		message.FailedReason = "订单未支付"
+		if err := m.repos.Messages.Transition(message, "订单未支付", "pending"); err != nil && !errors.Is(err, repository.ErrConflict) {
+			return err
+		}
+		return nil
+	}
+
+	message.Status = "sending"
+	if err := m.repos.Messages.Transition(message, "开始发送", "pending"); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil
+		}
+		return err
+	}
+
+	return m.deliverMessage(message)
+}
+
+// deliverMessage 调用短信服务发送消息并记录结果。记录失败时返回错误，
+// 事件重试时消息仍为发送中，按发送结果未知处理
+func (m *MessageService) deliverMessage(message *models.Message) error {
+	smsRequest := SMSRequest{
+		PhoneNumber: message.RecipientPhone,
+		Content:     message.Content,
+	}
+	if message.ThreadID != "" {
+		smsRequest.ExtendCode = m.relayService.GetExtendCode(message.ThreadID)
+		m.relayService.TouchThread(message.ThreadID)
+	}
+	if message.TemplateID != "" {
+		if err := m.applyTemplate(&smsRequest, message); err != nil {
+			return m.finishDelivery(message, nil, err.Error(), "短信模板不可用")
+		}
+	}
+
+	smsResponse, err := m.smsService.SendSMS(smsRequest)
+	if err == nil && smsResponse.Success {
+		message.SentParts = message.Parts
+		return m.finishDelivery(message, smsResponse, "", "")
+	}
+
+	// 拆分发送中途失败时，已发出的条数照常计费
+	var failedReason string
+	if smsResponse != nil {
+		failedReason = smsResponse.Error
+		message.SentParts = smsResponse.SentParts
+	}
+	if failedReason == "" && err != nil {
+		failedReason = err.Error()
+	}
+	return m.finishDelivery(message, nil, failedReason, "短信发送失败")
+}
+
+// finishDelivery 在同一事务中更新消息状态和批量进度，发送失败时写入退款事件
+func (m *MessageService) finishDelivery(message *models.Message, response *SMSResponse, failedReason, refundReason string) error {
+	now := time.Now()
+	cause := "短信发送成功"
+	if response != nil {
+		message.Status = "sent"
+		message.SentAt = &now
+		message.SMSMessageID = response.MessageID
+	} else {
+		if failedReason == "" {
+			failedReason = refundReason
+		}
+		if message.SentParts > 0 {
+			failedReason = fmt.Sprintf("已发送%d/%d条: %s", message.SentParts, message.Parts, failedReason)
+		}
+		message.Status = "failed"
+		message.FailedReason = truncateString(failedReason, 255)
+		cause = refundReason + ": " + failedReason
+	}
+
+	err := m.repos.Transaction(func(tx repository.Repositories, db *gorm.DB) error {
+		if err := tx.Messages.Transition(message, cause, "sending"); err != nil {
+			return err
+		}
+		if message.BatchID != "" {
+			if err := tx.Batches.RecordProgress(message); err != nil {
+				return err
+			}
+		}
+		if message.Status != "failed" {
+			return nil
+		}
+		payload := messageRefundPayload{MessageID: message.ID, Reason: refundReason}
+		return enqueueEvent(tx, EventMessageRefund, message.ID, payload, now)
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if message.Status == "failed" {
+		wakeOutbox()
+	}
+	return nil
+}
+
+// handleMessageRefund 执行 message.refund 事件，退还发送失败的消息费用
+func (m *MessageService) handleMessageRefund(event *models.OutboxEvent) error {
+	var payload messageRefundPayload
+	if err := decodeEvent(event, &payload); err != nil {
+		return err
+	}
+
+	message, err := m.repos.Messages.FindByID(payload.MessageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// 只退还未发出部分的费用
+	listAmount := message.Cost
+	if message.SentParts > 0 && message.Parts > 0 {
+		listAmount = roundAmount(message.Cost * float64(message.Parts-message.SentParts) / float64(message.Parts))
+	}
+
+	// 事件标识作为退款请求标识，重试时不会重复退款
+	return m.refundOrder(SystemActor("sms_delivery"), event.ID, message.OrderID, listAmount, payload.Reason)
+}
+
+// handleOrderRelease 执行 order.release 事件，退回订单扣减的套餐额度和优惠券
+func (m *MessageService) handleOrderRelease(event *models.OutboxEvent) error {
+	var payload orderReleasePayload
+	if err := decodeEvent(event, &payload); err != nil {
+		return err
+	}
+
+	if err := m.creditService.Refund(SystemActor("order_release"), payload.OrderID, payload.Reason); err != nil {
+		return err
+	}
+	return m.couponService.Reverse(payload.OrderID)
+}
+
+// handleInvoiceReverse 执行 invoice.reverse 事件，红冲退款订单所在的发票
+func (m *MessageService) handleInvoiceReverse(event *models.OutboxEvent) error {
+	var payload invoiceReversePayload
+	if err := decodeEvent(event, &payload); err != nil {
+		return err
+	}
+	return m.invoiceService.ReverseForOrder(payload.OrderID, payload.Reason)
+}
+
+// refundOrder 按原价金额退款，使用了套餐或优惠券的订单按实付比例折算。
+// 同一 requestID 只退款一次，退款记录、审计日志和红冲发票的事件在同一事务中保存；
+// 全额退款后订单标记为已退款，并写入事件退回套餐额度和优惠券
+func (m *MessageService) refundOrder(actor Actor, requestID, orderID string, listAmount float64, reason string) error {
+	order, err := m.repos.Orders.FindByID(orderID)
+	if err != nil {
+		return err
+	}
+
+	// 批量订单按消息部分退款。锁定订单后按实付减去已成功和处理中的退款计算可退金额，
+	// 同一订单并发的退款不会超过实付
+	limit := func(tx repository.Repositories) (float64, error) {
+		locked, err := tx.Orders.FindForUpdate(orderID)
+		if err != nil {
+			return 0, err
+		}
+		if locked.Status != "paid" {
+			return 0, nil
+		}
+		reserved, err := tx.Refunds.SumReserved(orderID)
+		if err != nil {
+			return 0, err
+		}
+		return roundAmount(locked.Amount - reserved), nil
+	}
+
+	amount := listAmount
+	if order.OriginalAmount > 0 && order.Amount < order.OriginalAmount {
+		amount = roundAmount(listAmount * order.Amount / order.OriginalAmount)
+	}
+	if amount > 0 {
+		refundRecord, err := m.paymentService.RefundPayment(requestID, orderID, amount, reason, limit, func(tx repository.Repositories, record *models.RefundRecord) error {
+			refunded, err := tx.Refunds.SumSucceeded(orderID)
+			if err != nil {
+				return err
+			}
+			// 订单可能在退款前后申请开票，由事件执行时再判断是否需要红冲
+			payload := invoiceReversePayload{OrderID: orderID, Reason: reason}
+			if err := enqueueEvent(tx, EventInvoiceReverse, orderID, payload, time.Now()); err != nil {
+				return err
+			}
+			return RecordAudit(tx.Audit, AuditEntry{
+				Actor:      actor,
+				Action:     "order.refund",
+				TargetType: "order",
+				TargetID:   orderID,
+				Before:     map[string]interface{}{"refunded_amount": roundAmount(refunded - record.RefundAmount)},
+				After:      map[string]interface{}{"refunded_amount": refunded, "refund": record},
+			})
+		})
+		if err != nil && !errors.Is(err, ErrNoRefundableAmount) {
+			return err
+		}
+		if err == nil {
+			if refundRecord.Status != "success" {
+				return fmt.Errorf("退款未成功: %s", refundRecord.Status)
+			}
+			wakeOutbox()
+		}
+	}
+
+	refunded, err := m.repos.Refunds.SumSucceeded(orderID)
+	if err != nil {
+		return err
+	}
+
+	// 实付为0的订单在所有消息都失败且没有发出任何一条时视为全额退款
+	fullyRefunded := refunded >= order.Amount-0.001
+	if order.Amount <= 0 {
+		messages, err := m.repos.Messages.ListByOrder(orderID)
+		if err != nil {
+			return err
+		}
+		fullyRefunded = true
+		for _, message := range messages {
+			if message.Status != "failed" || message.SentParts > 0 {
+				fullyRefunded = false
+				break
+			}
+		}
+	}
+	if !fullyRefunded || order.Status != "paid" {
+		return nil
+	}
+
+	now := time.Now()
+	previousStatus := order.Status
+	order.Status = "refunded"
+	order.RefundedAt = &now
+	err = m.repos.Transaction(func(tx repository.Repositories, db *gorm.DB) error {
+		if err := tx.Orders.Transition(order, "全额退款: "+reason, previousStatus); err != nil {
+			return err
+		}
+		if order.CreditsUsed > 0 || order.CouponID != "" {
+			payload := orderReleasePayload{OrderID: orderID, Reason: reason}
+			if err := enqueueEvent(tx, EventOrderRelease, orderID, payload, now); err != nil {
+				return err
+			}
+		}
+		return RecordAudit(tx.Audit, orderStatusEntry(actor, "order.refunded", order, previousStatus))
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	wakeOutbox()
+	return nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/google/uuid"
+)
+
+// 事件类型
+const (
+	EventSMSDispatch    = "sms.dispatch"    // 发送已支付的消息
+	EventMessageRefund  = "message.refund"  // 退还发送失败的消息费用
+	EventOrderRelease   = "order.release"   // 支付失败后退回套餐额度和优惠券
+	EventInvoiceReverse = "invoice.reverse" // 已开票的订单退款后红冲发票
+)
+
+const (
+	outboxBatchSize   = 20
+	outboxLease       = 5 * time.Minute // 执行中的事件超过该时间未完成视为中断，重新执行
+	outboxMaxAttempts = 8
+	outboxRetryBase   = 30 * time.Second
+	outboxRetryMax    = time.Hour
+)
+
+// outboxWake 事务提交后通知后台任务立即执行，不必等到下一次轮询
+var outboxWake = make(chan struct{}, 1)
+
+// OutboxHandler 执行单个事件，返回错误时按退避时间重试，因此需要可以重复执行
+type OutboxHandler func(event *models.OutboxEvent) error
+
+type OutboxService struct {
+	outbox   repository.OutboxRepository
+	handlers map[string]OutboxHandler
+}
+
+func NewOutboxService(repos repository.Repositories) *OutboxService {
+	return &OutboxService{
+		outbox:   repos.Outbox,
+		handlers: make(map[string]OutboxHandler),
+	}
+}
+
+// Handle 注册事件类型的处理函数
+func (o *OutboxService) Handle(eventType string, handler OutboxHandler) {
+	o.handlers[eventType] = handler
+}
+
+// ProcessDue 执行到期的事件，返回执行的数量
+func (o *OutboxService) ProcessDue() int {
+	events, err := o.outbox.ClaimDue(time.Now(), outboxLease, outboxBatchSize)
+	if err != nil {
+		log.Printf("领取待执行事件失败: %v", err)
+		return 0
+	}
+
+	for i := range events {
+		o.process(&events[i])
+	}
+	return len(events)
+}
+
+func (o *OutboxService) process(event *models.OutboxEvent) {
+	handler, ok := o.handlers[event.Type]
+	if !ok {
+		o.finish(event, o.outbox.Fail(event, "未知的事件类型"))
+		return
+	}
+
+	err := handler(event)
+	if err == nil {
+		o.finish(event, o.outbox.Complete(event))
+		return
+	}
+
+	message := truncateString(err.Error(), 500)
+	if event.Attempts >= outboxMaxAttempts {
+		log.Printf("事件多次执行失败，需要人工处理: event=%s type=%s err=%v", event.ID, event.Type, err)
+		o.finish(event, o.outbox.Fail(event, message))
+		return
+	}
+	o.finish(event, o.outbox.Retry(event, message, time.Now().Add(outboxRetryDelay(event.Attempts))))
+}
+
+// finish 记录更新事件状态的结果。租约已失效时事件已被重新领取，以新的执行结果为准
+func (o *OutboxService) finish(event *models.OutboxEvent, err error) {
+	if errors.Is(err, repository.ErrLeaseLost) {
+		log.Printf("事件执行超过租约时长，已被重新领取: event=%s type=%s", event.ID, event.Type)
+	} else if err != nil {
+		log.Printf("更新事件状态失败: event=%s err=%v", event.ID, err)
+	}
+}
+
+// outboxRetryDelay 第 attempts 次失败后的重试间隔，按指数增长
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(float64(outboxRetryBase) * math.Pow(2, float64(attempts-1)))
+	if delay > outboxRetryMax {
+		return outboxRetryMax
+	}
+	return delay
+}
+
+// StartDispatch 启动后台任务，按间隔轮询，有新事件提交时立即执行
+func (o *OutboxService) StartDispatch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			// 一批执行满时继续领取，直到没有到期的事件
+			for o.ProcessDue() == outboxBatchSize {
+			}
+			select {
+			case <-ticker.C:
+			case <-outboxWake:
+			}
+		}
+	}()
+}
+
+// wakeOutbox 通知后台任务有新的事件
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueEvent 在事务中写入事件，availableAt 之前不会执行
+func enqueueEvent(tx repository.Repositories, eventType, aggregateID string, payload interface{}, availableAt time.Time) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("事件内容格式错误: %v", err)
+	}
+	return tx.Outbox.Enqueue(&models.OutboxEvent{
+		ID:          uuid.New().String(),
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     string(data),
+		Status:      "pending",
+		AvailableAt: availableAt,
+	})
+}
+
+// decodeEvent 解析事件内容
+func decodeEvent(event *models.OutboxEvent, payload interface{}) error {
+	if err := json.Unmarshal([]byte(event.Payload), payload); err != nil {
+		return fmt.Errorf("事件内容格式错误: %v", err)
+	}
+	return nil
+}
@@ -3,25 +3,43 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"math/big"
-	"os"
+	"sync"
 	"time"
 
+	"anonymous-messaging-backend/config"
 	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
 	"github.com/google/uuid"
 	"github.com/wechatpay-apiv3/wechatpay-go/core"
 	"github.com/wechatpay-apiv3/wechatpay-go/core/option"
 	"github.com/wechatpay-apiv3/wechatpay-go/services/payments/jsapi"
 	"github.com/wechatpay-apiv3/wechatpay-go/utils"
+	"gorm.io/gorm"
 )
 
 type PaymentService struct {
+	repos    repository.Repositories
+	payments repository.PaymentRepository
+	refunds  repository.RefundRepository
+}
+
+// wechatPayClient 按当前配置创建的微信支付客户端，配置变更后整体替换
+type wechatPayClient struct {
 	client     *core.Client
 	appID      string
 	merchantID string
 }
 
+var (
+	wechatPayClientMu      sync.RWMutex
+	currentWechatPayClient *wechatPayClient
+)
+
 type WechatPayConfig struct {
 	AppID     string `json:"appId"`
 	TimeStamp string `json:"timeStamp"`
@@ -37,22 +55,59 @@ type PaymentResult struct {
 	Error         string `json:"error,omitempty"`
 }
 
-func NewPaymentService() (*PaymentService, error) {
-	appID := os.Getenv("WECHAT_APP_ID")
-	merchantID := os.Getenv("WECHAT_MERCHANT_ID")
-	merchantKey := os.Getenv("WECHAT_MERCHANT_KEY")
-	certPath := os.Getenv("WECHAT_CERT_PATH")
-	keyPath := os.Getenv("WECHAT_KEY_PATH")
-
-	if appID == "" || merchantID == "" {
-		return &PaymentService{
-			appID:      appID,
-			merchantID: merchantID,
-		}, nil
+// NewPaymentService 首次调用时按支付模式创建客户端：mock 模式使用模拟支付，
+// live 模式缺少商户配置时返回错误。支付配置变更后按新的模式重建客户端
+func NewPaymentService(repos repository.Repositories) (*PaymentService, error) {
+	wechatPayClientMu.Lock()
+	defer wechatPayClientMu.Unlock()
+
+	if currentWechatPayClient == nil {
+		client, err := buildWechatPayClient()
+		if err != nil {
+			return nil, err
+		}
+		currentWechatPayClient = client
+		RuntimeConfig().OnChange(ConfigGroupPayment, reloadWechatPayClient)
+	}
+
+	return &PaymentService{
+		repos:    repos,
+		payments: repos.Payments,
+		refunds:  repos.Refunds,
+	}, nil
+}
+
+// buildWechatPayClient 按当前生效的支付模式创建客户端，mock 模式的客户端使用模拟支付
+func buildWechatPayClient() (*wechatPayClient, error) {
+	if PaymentMode() == config.ModeMock {
+		log.Println("微信支付使用模拟模式")
+		return &wechatPayClient{}, nil
+	}
+	return newWechatPayClient(RuntimeConfig().Payment())
+}
+
+// PaymentMode 当前生效的支付模式，未指定 PAYMENT_MODE 时按是否配置了商户号选择
+func PaymentMode() string {
+	cfg := RuntimeConfig().Payment()
+	return config.ResolveMode(config.App.Payment.Mode, cfg.AppID != "" && cfg.MerchantID != "")
+}
+
+func newWechatPayClient(cfg PaymentConfig) (*wechatPayClient, error) {
+	if cfg.AppID == "" || cfg.MerchantID == "" || cfg.MerchantKey == "" {
+		return nil, fmt.Errorf("微信支付配置不完整")
+	}
+
+	certificate, err := utils.LoadCertificateWithPath(cfg.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载微信支付证书失败: %v", err)
+	}
+	privateKey, err := utils.LoadPrivateKeyWithPath(cfg.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载微信支付私钥失败: %v", err)
 	}
 
 	opts := []core.ClientOption{
-		option.WithWechatPayAutoAuthCipher(merchantID, merchantKey, certPath, keyPath),
+		option.WithWechatPayAutoAuthCipher(cfg.MerchantID, utils.GetCertificateSerialNumber(*certificate), privateKey, cfg.MerchantKey),
 	}
 
 	client, err := core.NewClient(context.Background(), opts...)
@@ -60,16 +115,38 @@ func NewPaymentService() (*PaymentService, error) {
 		return nil, fmt.Errorf("创建微信支付客户端失败: %v", err)
 	}
 
-	return &PaymentService{
+	return &wechatPayClient{
 		client:     client,
-		appID:      appID,
-		merchantID: merchantID,
+		appID:      cfg.AppID,
+		merchantID: cfg.MerchantID,
 	}, nil
 }
 
+// reloadWechatPayClient 支付配置变更后按新的模式重建客户端，新配置不可用时继续使用原客户端
+func reloadWechatPayClient() {
+	client, err := buildWechatPayClient()
+	if err != nil {
+		log.Printf("微信支付配置已变更，重建客户端失败: %v", err)
+		return
+	}
+
+	wechatPayClientMu.Lock()
+	currentWechatPayClient = client
+	wechatPayClientMu.Unlock()
+	log.Println("微信支付配置已变更，客户端已重建")
+}
+
+func (p *PaymentService) current() *wechatPayClient {
+	wechatPayClientMu.RLock()
+	defer wechatPayClientMu.RUnlock()
+	return currentWechatPayClient
+}
+
 func (p *PaymentService) GetWechatPayConfig(orderID string, amount float64) (*WechatPayConfig, error) {
+	c := p.current()
+
 	// 如果没有配置微信支付信息，返回模拟配置
-	if p.client == nil {
+	if c.client == nil {
 		return &WechatPayConfig{
 			AppID:     "mock_app_id",
 			TimeStamp: fmt.Sprintf("%d", time.Now().Unix()),
@@ -80,11 +157,11 @@ func (p *PaymentService) GetWechatPayConfig(orderID string, amount float64) (*We
 		}, nil
 	}
 
-	svc := jsapi.JsapiApiService{Client: p.client}
+	svc := jsapi.JsapiApiService{Client: c.client}
 	
 	req := jsapi.PrepayRequest{
-		Appid:       core.String(p.appID),
-		Mchid:       core.String(p.merchantID),
+		Appid:       core.String(c.appID),
+		Mchid:       core.String(c.merchantID),
 		Description: core.String("飞鸟飞信短信服务"),
 		OutTradeNo:  core.String(orderID),
 		NotifyUrl:   core.String("http://127.0.0.1:8081/api/payment/wechat/notify"),
@@ -109,33 +186,130 @@ func (p *PaymentService) GetWechatPayConfig(orderID string, amount float64) (*We
 	}, nil
 }
 
+// ProcessPayment 扣款，成功时保存支付记录
 func (p *PaymentService) ProcessPayment(orderID string, amount float64) (*PaymentResult, error) {
 	// 模拟支付处理
 	time.Sleep(2 * time.Second)
-	
+
 	// 95% 成功率
 	n, _ := rand.Int(rand.Reader, big.NewInt(100))
-	if n.Int64() < 95 {
-		return &PaymentResult{
-			Success:       true,
-			TransactionID: fmt.Sprintf("wx_%d_%s", time.Now().Unix(), uuid.New().String()[:8]),
-		}, nil
-	} else {
+	if n.Int64() >= 95 {
 		return &PaymentResult{
 			Success: false,
 			Error:   "支付失败",
 		}, nil
 	}
+
+	result := &PaymentResult{
+		Success:       true,
+		TransactionID: fmt.Sprintf("wx_%d_%s", time.Now().Unix(), uuid.New().String()[:8]),
+	}
+	record := &models.PaymentRecord{
+		ID:            uuid.New().String(),
+		OrderID:       orderID,
+		PaymentMethod: "wechat",
+		TransactionID: result.TransactionID,
+		Amount:        amount,
+		Status:        "success",
+	}
+	// 已经扣款，记录失败不影响支付结果
+	if err := p.payments.Create(record); err != nil {
+		log.Printf("保存支付记录失败: order=%s transaction=%s err=%v", orderID, result.TransactionID, err)
+	}
+	return result, nil
+}
+
+// succeededPayment 订单已成功扣款的支付记录，没有时返回 nil
+func (p *PaymentService) succeededPayment(orderID string) *models.PaymentRecord {
+	records, err := p.payments.ListByOrder(orderID)
+	if err != nil {
+		return nil
+	}
+	for i := range records {
+		if records[i].Status == "success" {
+			return &records[i]
+		}
+	}
+	return nil
 }
 
-func (p *PaymentService) RefundPayment(orderID string, amount float64, reason string) (*PaymentResult, error) {
+// ErrNoRefundableAmount 订单已退款或处理中的退款已达到实付金额
+var ErrNoRefundableAmount = errors.New("订单已无可退金额")
+
+// RefundPayment 退款并保存退款记录。requestID 同时作为商户退款单号，
+// 重试同一请求时不会重复退款。
+//
+// 调用服务商前先在事务中写入处理中的退款记录占用金额：limit 在该事务中返回本次最多可退的金额
+// （通常锁定订单后按实付减去已占用的金额计算），amount 超过时按 limit 退款，为0时返回
+// ErrNoRefundableAmount。服务商退款成功后，persist 在标记退款成功的事务中写入关联的变更。
+// 同一请求的记录仍在处理中时按原金额继续退款
+func (p *PaymentService) RefundPayment(requestID, orderID string, amount float64, reason string, limit func(tx repository.Repositories) (float64, error), persist func(tx repository.Repositories, record *models.RefundRecord) error) (*models.RefundRecord, error) {
+	record, err := p.refunds.FindByRequestID(requestID)
+	switch {
+	case err == nil && record.Status != "pending":
+		return record, nil
+	case errors.Is(err, repository.ErrNotFound):
+		if record, err = p.reserveRefund(requestID, orderID, amount, reason, limit); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("获取退款记录失败: %v", err)
+	}
+
 	// 模拟退款处理
 	time.Sleep(1 * time.Second)
-	
-	return &PaymentResult{
-		Success:       true,
-		TransactionID: fmt.Sprintf("refund_%d_%s", time.Now().Unix(), uuid.New().String()[:8]),
-	}, nil
+
+	now := time.Now()
+	record.Status = "success"
+	record.RefundTransactionID = fmt.Sprintf("refund_%d_%s", time.Now().Unix(), uuid.New().String()[:8])
+	record.ProcessedAt = &now
+	err = p.repos.Transaction(func(tx repository.Repositories, db *gorm.DB) error {
+		if err := tx.Refunds.Transition(record, "退款成功", "pending"); err != nil {
+			return err
+		}
+		if persist != nil {
+			return persist(tx, record)
+		}
+		return nil
+	})
+	if err != nil {
+		// 记录保持处理中并继续占用金额，同一请求重试时完成
+		return nil, fmt.Errorf("保存退款结果失败: %v", err)
+	}
+	return record, nil
+}
+
+// reserveRefund 在事务中计算可退金额并写入处理中的退款记录
+func (p *PaymentService) reserveRefund(requestID, orderID string, amount float64, reason string, limit func(tx repository.Repositories) (float64, error)) (*models.RefundRecord, error) {
+	record := &models.RefundRecord{
+		ID:        uuid.New().String(),
+		RequestID: requestID,
+		OrderID:   orderID,
+		Reason:    reason,
+		Status:    "pending",
+		CreatedAt: time.Now(),
+	}
+	err := p.repos.Transaction(func(tx repository.Repositories, db *gorm.DB) error {
+		record.RefundAmount = amount
+		if limit != nil {
+			available, err := limit(tx)
+			if err != nil {
+				return err
+			}
+			record.RefundAmount = math.Min(amount, available)
+		}
+		if record.RefundAmount <= 0 {
+			return ErrNoRefundableAmount
+		}
+		return tx.Refunds.Create(record)
+	})
+	if errors.Is(err, ErrNoRefundableAmount) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("保存退款记录失败: %v", err)
+	}
+	return record, nil
 }
 
 func generateNonceStr() string {
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDeletionPending   = errors.New("已提交注销申请")
+	ErrDeletionNotFound  = errors.New("没有待处理的注销申请")
+	ErrDeletionForfeit   = errors.New("账户仍有余额或套餐额度，确认放弃后才能注销")
+	ErrDeletionInFlight  = errors.New("还有未完成的短信发送任务，请完成后再注销")
+	ErrUserAlreadyClosed = errors.New("账号已注销")
+)
+
+// DeletionInput 注销申请参数
+type DeletionInput struct {
+	Reason         string
+	ConfirmForfeit bool // 确认放弃剩余余额和套餐额度
+}
+
+type PrivacyService struct {
+	coolingOff time.Duration
+}
+
+func NewPrivacyService() *PrivacyService {
+	return &PrivacyService{
+		coolingOff: config.App.Account.DeletionCoolingOff,
+	}
+}
+
+// RequestDeletion 提交注销申请，冷静期内可以撤销
+func (p *PrivacyService) RequestDeletion(userID string, input DeletionInput) (*models.AccountDeletion, error) {
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.Status == "deleted" {
+		return nil, ErrUserAlreadyClosed
+	}
+
+	if _, err := p.GetDeletion(userID); err == nil {
+		return nil, ErrDeletionPending
+	}
+
+	if err := checkInFlight(config.DB, userID); err != nil {
+		return nil, err
+	}
+
+	var credits int64
+	activeGrants(config.DB, userID).Select("COALESCE(SUM(remaining), 0)").Scan(&credits)
+	if (user.Balance > 0 || credits > 0) && !input.ConfirmForfeit {
+		return nil, ErrDeletionForfeit
+	}
+
+	now := time.Now()
+	deletion := &models.AccountDeletion{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Reason:      truncateString(input.Reason, 255),
+		Status:      "pending",
+		ScheduledAt: now.Add(p.coolingOff),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := config.DB.Create(deletion).Error; err != nil {
+		return nil, fmt.Errorf("提交注销申请失败: %v", err)
+	}
+
+	return deletion, nil
+}
+
+// GetDeletion 获取用户待处理的注销申请
+func (p *PrivacyService) GetDeletion(userID string) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	if err := config.DB.Where("user_id = ? AND status = ?", userID, "pending").First(&deletion).Error; err != nil {
+		return nil, ErrDeletionNotFound
+	}
+	return &deletion, nil
+}
+
+// CancelDeletion 冷静期内撤销注销申请
+func (p *PrivacyService) CancelDeletion(userID string) (*models.AccountDeletion, error) {
+	deletion, err := p.GetDeletion(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := config.DB.Model(deletion).
+		Where("status = ?", "pending").
+		Updates(map[string]interface{}{
+			"status":       "cancelled",
+			"cancelled_at": now,
+			"updated_at":   now,
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("撤销注销申请失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrDeletionNotFound
+	}
+
+	deletion.Status = "cancelled"
+	deletion.CancelledAt = &now
+	return deletion, nil
+}
+
+// ProcessDueDeletions 处理冷静期已结束的注销申请
+func (p *PrivacyService) ProcessDueDeletions() {
+	var deletions []models.AccountDeletion
+	if err := config.DB.Where("status = ? AND scheduled_at <= ?", "pending", time.Now()).Find(&deletions).Error; err != nil {
+		log.Printf("查询注销申请失败: %v", err)
+		return
+	}
+
+	for _, deletion := range deletions {
+		if err := p.completeDeletion(&deletion); err != nil {
+			log.Printf("注销账号失败: user=%s err=%v", deletion.UserID, err)
+		}
+	}
+}
+
+// StartDeletionWorker 定期处理到期的注销申请
+func (p *PrivacyService) StartDeletionWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.ProcessDueDeletions()
+		}
+	}()
+}
+
+// completeDeletion 匿名化用户的个人信息和消息内容，删除会话、模板、发票抬头和导出文件。
+// 订单、账单、支付退款记录、发票和优惠券/套餐记录依法保留
+func (p *PrivacyService) completeDeletion(deletion *models.AccountDeletion) error {
+	userID := deletion.UserID
+	var exportFiles []string
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := lockUser(tx, &user, "id = ?", userID); err != nil {
+			return err
+		}
+
+		// 冷静期内发起的发送任务完成后再处理
+		if err := checkInFlight(tx, userID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		err := tx.Model(&models.Message{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"recipient_phone": "",
+			"content":         "",
+			"template_params": "",
+			"updated_at":      now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		batchIDs := tx.Model(&models.MessageBatch{}).Select("id").Where("user_id = ?", userID)
+		err = tx.Model(&models.MessageBatchItem{}).Where("batch_id IN (?)", batchIDs).Updates(map[string]interface{}{
+			"phone":      "",
+			"variables":  "",
+			"updated_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.MessageBatch{}).Where("user_id = ?", userID).Update("content", "").Error; err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.AccountMerge{}).Where("target_user_id = ? OR source_user_id = ?", userID, userID).
+			Updates(map[string]interface{}{
+				"source_phone":          "",
+				"source_wechat_open_id": "",
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		var exports []models.ExportJob
+		if err := tx.Where("user_id = ?", userID).Find(&exports).Error; err != nil {
+			return err
+		}
+		for _, job := range exports {
+			if job.FilePath != "" {
+				exportFiles = append(exportFiles, job.FilePath)
+			}
+		}
+
+		if user.Phone != nil {
+			if err := tx.Where("phone = ?", *user.Phone).Delete(&models.VerificationCode{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, model := range []interface{}{
+			&models.RelayThread{},
+			&models.SMSTemplate{},
+			&models.InvoiceTitle{},
+			&models.ExportJob{},
+			&models.IdempotencyKey{},
+			&models.UserSession{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&user).Updates(map[string]interface{}{
+			"phone":           nil,
+			"wechat_open_id":  nil,
+			"wechat_union_id": "",
+			"nickname":        "",
+			"avatar_url":      "",
+			"status":          "deleted",
+			"updated_at":      now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(deletion).Updates(map[string]interface{}{
+			"status":       "completed",
+			"completed_at": now,
+			"updated_at":   now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range exportFiles {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除导出文件失败: %s err=%v", path, err)
+		}
+	}
+	return nil
+}
+
+// WriteArchive 将用户的全部个人数据以JSON文件打包为ZIP写入w
+func (p *PrivacyService) WriteArchive(userID string, w io.Writer) error {
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return ErrUserNotFound
+	}
+
+	orderIDs := config.DB.Model(&models.Order{}).Select("id").Where("user_id = ?", userID)
+	batchIDs := config.DB.Model(&models.MessageBatch{}).Select("id").Where("user_id = ?", userID)
+
+	var (
+		orders        []models.Order
+		payments      []models.PaymentRecord
+		refunds       []models.RefundRecord
+		bills         []models.Bill
+		messages      []models.Message
+		threads       []models.RelayThread
+		batches       []models.MessageBatch
+		batchItems    []models.MessageBatchItem
+		templates     []models.SMSTemplate
+		invoiceTitles []models.InvoiceTitle
+		invoices      []models.Invoice
+		redemptions   []models.CouponRedemption
+		creditGrants  []models.CreditGrant
+		creditUsages  []models.CreditUsage
+		sessions      []models.UserSession
+		merges        []models.AccountMerge
+		deletions     []models.AccountDeletion
+	)
+	sections := []struct {
+		name  string
+		query *gorm.DB
+		dest  interface{}
+	}{
+		{"orders.json", config.DB.Where("user_id = ?", userID), &orders},
+		{"payment_records.json", config.DB.Where("order_id IN (?)", orderIDs), &payments},
+		{"refund_records.json", config.DB.Where("order_id IN (?)", orderIDs), &refunds},
+		{"bills.json", config.DB.Where("user_id = ?", userID), &bills},
+		{"messages.json", config.DB.Where("user_id = ?", userID), &messages},
+		{"threads.json", config.DB.Where("user_id = ?", userID), &threads},
+		{"message_batches.json", config.DB.Where("user_id = ?", userID), &batches},
+		{"message_batch_items.json", config.DB.Where("batch_id IN (?)", batchIDs), &batchItems},
+		{"sms_templates.json", config.DB.Where("user_id = ?", userID), &templates},
+		{"invoice_titles.json", config.DB.Where("user_id = ?", userID), &invoiceTitles},
+		{"invoices.json", config.DB.Preload("Items").Where("user_id = ?", userID), &invoices},
+		{"coupon_redemptions.json", config.DB.Where("user_id = ?", userID), &redemptions},
+		{"credit_grants.json", config.DB.Where("user_id = ?", userID), &creditGrants},
+		{"credit_usages.json", config.DB.Where("user_id = ?", userID), &creditUsages},
+		{"sessions.json", config.DB.Where("user_id = ?", userID), &sessions},
+		{"account_merges.json", config.DB.Where("target_user_id = ? OR source_user_id = ?", userID, userID), &merges},
+		{"account_deletions.json", config.DB.Where("user_id = ?", userID), &deletions},
+	}
+
+	archive := zip.NewWriter(w)
+	if err := writeArchiveFile(archive, "user.json", user); err != nil {
+		return err
+	}
+	for _, section := range sections {
+		if err := section.query.Order("created_at ASC").Find(section.dest).Error; err != nil {
+			return fmt.Errorf("读取%s失败: %v", section.name, err)
+		}
+		if err := writeArchiveFile(archive, section.name, section.dest); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func writeArchiveFile(archive *zip.Writer, name string, data interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("写入%s失败: %v", name, err)
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("写入%s失败: %v", name, err)
+	}
+	return nil
+}
+
+// checkInFlight 是否还有待发送的消息或批量任务
+func checkInFlight(db *gorm.DB, userID string) error {
+	var messages, batches int64
+	db.Model(&models.Message{}).
+		Where("user_id = ? AND status IN ?", userID, []string{"pending", "scheduled", "sending"}).
+		Count(&messages)
+	db.Model(&models.MessageBatch{}).
+		Where("user_id = ? AND status IN ?", userID, []string{"pending", "processing"}).
+		Count(&batches)
+	if messages > 0 || batches > 0 {
+		return ErrDeletionInFlight
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// 扩展码位数，追加在短信签名对应的号码之后，收件人回复时据此找到会话
+	extendCodeLength  = 6
+	extendCodeRetries = 5
+)
+
+var ErrMessageNotFound = errors.New("消息不存在")
+
+// UplinkReport 阿里云上行短信推送内容
+type UplinkReport struct {
+	PhoneNumber string `json:"phone_number"`
+	Content     string `json:"content"`
+	SignName    string `json:"sign_name"`
+	DestCode    string `json:"dest_code"` // 发送时使用的扩展码
+	SendTime    string `json:"send_time"`
+	SequenceID  int64  `json:"sequence_id"`
+}
+
+type RelayService struct {
+	db                *gorm.DB
+	suspensionService *SuspensionService
+}
+
+func NewRelayService(db *gorm.DB, suspensionService *SuspensionService) *RelayService {
+	return &RelayService{
+		db:                db,
+		suspensionService: suspensionService,
+	}
+}
+
+// EnsureThread 获取或创建发送方与收件人之间的匿名会话
+func (r *RelayService) EnsureThread(userID string, recipient *PhoneNumber) (*models.RelayThread, error) {
+	var thread models.RelayThread
+	err := r.db.Where("user_id = ? AND recipient_phone = ?", userID, recipient.E164).First(&thread).Error
+	if err == nil {
+		return &thread, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("查询会话失败: %v", err)
+	}
+
+	// 扩展码随机生成，冲突时重试
+	for i := 0; i < extendCodeRetries; i++ {
+		thread = models.RelayThread{
+			ID:             uuid.New().String(),
+			UserID:         userID,
+			RecipientPhone: recipient.E164,
+			ExtendCode:     generateExtendCode(),
+			LastActivityAt: time.Now(),
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		if err = r.db.Create(&thread).Error; err == nil {
+			return &thread, nil
+		}
+
+		// 并发创建了同一会话
+		var existing models.RelayThread
+		if r.db.Where("user_id = ? AND recipient_phone = ?", userID, recipient.E164).First(&existing).Error == nil {
+			return &existing, nil
+		}
+	}
+
+	return nil, fmt.Errorf("创建会话失败: %v", err)
+}
+
+// TouchThread 更新会话最近活动时间，已归档的会话有新消息时自动取消归档
+func (r *RelayService) TouchThread(threadID string) {
+	r.db.Model(&models.RelayThread{}).Where("id = ?", threadID).
+		Updates(map[string]interface{}{
+			"archived":         false,
+			"last_activity_at": time.Now(),
+			"updated_at":       time.Now(),
+		})
+}
+
+// GetExtendCode 获取会话的扩展码
+func (r *RelayService) GetExtendCode(threadID string) string {
+	var thread models.RelayThread
+	if err := r.db.Select("extend_code").First(&thread, "id = ?", threadID).Error; err != nil {
+		return ""
+	}
+	return thread.ExtendCode
+}
+
+// HandleUplink 将收件人的回复存为入站消息，关联到会话中最近一条发出的消息。
+// 号码与会话不匹配或重复推送的回复会被忽略，包含投诉关键词的回复记为投诉
+func (r *RelayService) HandleUplink(report UplinkReport) error {
+	phone, err := ParsePhoneNumber(report.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	var thread models.RelayThread
+	err = r.db.Where("extend_code = ? AND recipient_phone = ?", report.DestCode, phone.E164).First(&thread).Error
+	if err != nil {
+		log.Printf("未找到上行短信对应的会话: dest_code=%s", report.DestCode)
+		return nil
+	}
+
+	providerID := fmt.Sprintf("up_%d", report.SequenceID)
+	receivedAt := time.Now()
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", report.SendTime, time.Local); err == nil {
+		receivedAt = t
+	}
+
+	var original models.Message
+	r.db.Where("thread_id = ? AND direction = ?", thread.ID, "outbound").
+		Order("created_at DESC").
+		First(&original)
+
+	// 入站消息不保存对方号码，只通过会话关联；没有对应订单，order_id 留空为 NULL
+	message := &models.Message{
+		ID:             uuid.New().String(),
+		UserID:         thread.UserID,
+		ThreadID:       thread.ID,
+		ReplyToID:      original.ID,
+		Direction:      "inbound",
+		CountryCode:    phone.Country.Code,
+		Content:        strings.TrimSpace(report.Content),
+		CharacterCount: len([]rune(report.Content)),
+		Status:         "received",
+		SentAt:         &receivedAt,
+		SMSMessageID:   providerID,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	// 同一会话的回复按服务商序号唯一，重复推送的回复已处理过
+	if err := r.db.Omit("OrderID").Create(message).Error; err != nil {
+		if isDuplicateKey(err) {
+			return nil
+		}
+		return fmt.Errorf("保存回复失败: %v", err)
+	}
+
+	r.TouchThread(thread.ID)
+
+	// 收件人回复投诉关键词时记录投诉，达到阈值后自动停用发送方
+	if IsComplaint(message.Content) {
+		if err := r.suspensionService.RecordComplaint(thread.UserID, original.ID, thread.ID, "recipient", message.Content); err != nil {
+			log.Printf("记录投诉失败: thread=%s err=%v", thread.ID, err)
+		}
+	}
+	return nil
+}
+
+// ListReplies 获取某条发出消息收到的回复
+func (r *RelayService) ListReplies(userID, messageID string) ([]models.Message, error) {
+	var original models.Message
+	if err := r.db.Where("id = ? AND user_id = ?", messageID, userID).First(&original).Error; err != nil {
+		return nil, ErrMessageNotFound
+	}
+
+	var replies []models.Message
+	err := r.db.Where("user_id = ? AND reply_to_id = ? AND direction = ?", userID, messageID, "inbound").
+		Order("created_at ASC").
+		Find(&replies).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取回复失败: %v", err)
+	}
+	return replies, nil
+}
+
+func generateExtendCode() string {
+	code := make([]byte, extendCodeLength)
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, big.NewInt(10))
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code)
+}
+
+// isDuplicateKey 写入违反唯一索引时 MySQL 返回的错误
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
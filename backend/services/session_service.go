@@ -0,0 +1,348 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const sessionRetention = 30 * 24 * time.Hour // 失效会话保留时间
+
+var (
+	ErrInvalidToken       = errors.New("无效的令牌")
+	ErrTokenExpired       = errors.New("令牌已过期")
+	ErrSessionRevoked     = errors.New("会话已失效，请重新登录")
+	ErrRefreshTokenReused = errors.New("刷新令牌已被使用，会话已注销")
+	ErrSessionNotFound    = errors.New("会话不存在")
+	ErrUserInactive       = errors.New("账号已停用")
+)
+
+var (
+	jwtSecretOnce sync.Once
+	jwtSecret     []byte
+)
+
+// DeviceInfo 登录设备信息，DeviceID由客户端生成并保持不变
+type DeviceInfo struct {
+	DeviceID   string
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+}
+
+// TokenPair 登录或刷新后返回的令牌
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // 访问令牌有效期（秒）
+	SessionID    string `json:"session_id"`
+}
+
+// AccessClaims 访问令牌声明，Subject为用户ID
+type AccessClaims struct {
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+type SessionService struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewSessionService() *SessionService {
+	return &SessionService{
+		secret:     loadJWTSecret(),
+		accessTTL:  config.App.Auth.AccessTokenTTL,
+		refreshTTL: config.App.Auth.RefreshTokenTTL,
+	}
+}
+
+// loadJWTSecret 读取签名密钥，未配置时生成随机密钥，服务重启后需重新登录
+func loadJWTSecret() []byte {
+	jwtSecretOnce.Do(func() {
+		if secret := config.App.Auth.JWTSecret; secret != "" {
+			jwtSecret = []byte(secret)
+			return
+		}
+
+		log.Println("未配置JWT_SECRET，使用随机密钥")
+		jwtSecret = make([]byte, 32)
+		if _, err := rand.Read(jwtSecret); err != nil {
+			log.Fatal("生成JWT密钥失败:", err)
+		}
+	})
+	return jwtSecret
+}
+
+// CreateSession 登录成功后创建会话，同一设备重复登录时注销该设备原有的会话
+func (s *SessionService) CreateSession(userID string, device DeviceInfo) (*TokenPair, error) {
+	refreshToken, tokenHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.UserSession{
+		ID:               uuid.New().String(),
+		UserID:           userID,
+		DeviceID:         truncateString(device.DeviceID, 64),
+		DeviceName:       truncateString(device.DeviceName, 100),
+		UserAgent:        truncateString(device.UserAgent, 255),
+		IPAddress:        device.IPAddress,
+		RefreshTokenHash: tokenHash,
+		ExpiresAt:        now.Add(s.refreshTTL),
+		LastUsedAt:       now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if session.DeviceID != "" {
+			query := tx.Where("user_id = ? AND device_id = ?", userID, session.DeviceID)
+			if err := revokeSessions(query, "设备重新登录"); err != nil {
+				return err
+			}
+		}
+		return tx.Create(session).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建会话失败: %v", err)
+	}
+
+	return s.issue(session, refreshToken)
+}
+
+// Refresh 轮换刷新令牌并签发新的访问令牌，旧的刷新令牌立即失效。
+// 已轮换的刷新令牌被再次使用时视为泄露，注销整个会话
+func (s *SessionService) Refresh(refreshToken string, device DeviceInfo) (*TokenPair, error) {
+	tokenHash := hashToken(refreshToken)
+
+	var session models.UserSession
+	if err := config.DB.Where("refresh_token_hash = ?", tokenHash).First(&session).Error; err != nil {
+		if err := config.DB.Where("previous_token_hash = ?", tokenHash).First(&session).Error; err == nil {
+			revokeSessions(config.DB.Where("id = ?", session.ID), "刷新令牌重复使用")
+			log.Printf("刷新令牌重复使用，已注销会话: user=%s session=%s", session.UserID, session.ID)
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrInvalidToken
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if err := s.checkUser(session.UserID); err != nil {
+		return nil, err
+	}
+
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"refresh_token_hash":  newHash,
+		"previous_token_hash": tokenHash,
+		"expires_at":          now.Add(s.refreshTTL),
+		"last_used_at":        now,
+		"updated_at":          now,
+	}
+	if device.UserAgent != "" {
+		updates["user_agent"] = truncateString(device.UserAgent, 255)
+	}
+	if device.IPAddress != "" {
+		updates["ip_address"] = device.IPAddress
+	}
+
+	// 条件更新，并发刷新时只有一个请求成功
+	result := config.DB.Model(&models.UserSession{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, tokenHash).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("刷新会话失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return s.issue(&session, newToken)
+}
+
+// Authenticate 校验访问令牌并确认会话未被注销，返回令牌声明。
+// 用户已被停用时注销其全部会话
+func (s *SessionService) Authenticate(accessToken string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrInvalidToken
+	}
+
+	var row struct {
+		UserID     string
+		RevokedAt  *time.Time
+		UserStatus string
+	}
+	err = config.DB.Table("user_sessions").
+		Select("user_sessions.user_id, user_sessions.revoked_at, users.status AS user_status").
+		Joins("JOIN users ON users.id = user_sessions.user_id").
+		Where("user_sessions.id = ?", claims.SessionID).
+		Scan(&row).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询会话失败: %v", err)
+	}
+	if row.UserID == "" || row.UserID != claims.Subject || row.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+	if row.UserStatus != "active" {
+		s.RevokeUserSessions(row.UserID, "账号已停用")
+		if row.UserStatus == "suspended" {
+			return nil, ErrUserSuspended
+		}
+		return nil, ErrUserInactive
+	}
+
+	return claims, nil
+}
+
+// ListSessions 获取用户当前有效的会话，按最近使用时间倒序
+func (s *SessionService) ListSessions(userID string) ([]models.UserSession, error) {
+	var sessions []models.UserSession
+	err := config.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取会话列表失败: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession 注销用户的某个会话
+func (s *SessionService) RevokeSession(userID, sessionID, reason string) error {
+	result := config.DB.Model(&models.UserSession{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("注销会话失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions 注销用户的全部会话，用于退出所有设备和停用账号
+func (s *SessionService) RevokeUserSessions(userID, reason string) error {
+	if err := revokeSessions(config.DB.Where("user_id = ?", userID), reason); err != nil {
+		return fmt.Errorf("注销会话失败: %v", err)
+	}
+	return nil
+}
+
+// CleanupSessions 删除过期或注销已久的会话
+func (s *SessionService) CleanupSessions() {
+	cutoff := time.Now().Add(-sessionRetention)
+	result := config.DB.Where("expires_at < ? OR revoked_at < ?", cutoff, cutoff).Delete(&models.UserSession{})
+	if result.Error != nil {
+		log.Printf("清理会话失败: %v", result.Error)
+	}
+}
+
+// StartCleanup 定期清理失效会话
+func (s *SessionService) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.CleanupSessions()
+		}
+	}()
+}
+
+// checkUser 用户已停用时注销其全部会话
+func (s *SessionService) checkUser(userID string) error {
+	var user models.User
+	if err := config.DB.Select("id", "status").First(&user, "id = ?", userID).Error; err != nil {
+		return ErrSessionRevoked
+	}
+	if user.Status != "active" {
+		s.RevokeUserSessions(userID, "账号已停用")
+		if user.Status == "suspended" {
+			return ErrUserSuspended
+		}
+		return ErrUserInactive
+	}
+	return nil
+}
+
+// issue 为会话签发访问令牌
+func (s *SessionService) issue(session *models.UserSession, refreshToken string) (*TokenPair, error) {
+	now := time.Now()
+	claims := AccessClaims{
+		SessionID: session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   session.UserID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+		},
+	}
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return nil, fmt.Errorf("签发令牌失败: %v", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTTL / time.Second),
+		SessionID:    session.ID,
+	}, nil
+}
+
+func revokeSessions(query *gorm.DB, reason string) error {
+	return query.Model(&models.UserSession{}).
+		Where("revoked_at IS NULL").
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		}).Error
+}
+
+// newRefreshToken 生成随机刷新令牌及其哈希，数据库中只保存哈希
+func newRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("生成刷新令牌失败: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
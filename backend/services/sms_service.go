@@ -2,25 +2,41 @@ package services
 
 import (
 	"fmt"
-	"os"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
+	"anonymous-messaging-backend/config"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/dysmsapi"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 )
 
-type SMSService struct {
-	client       *dysmsapi.Client
-	signName     string
-	templateCode string
+type SMSService struct{}
+
+// smsClient 按当前配置创建的短信客户端，配置变更后整体替换
+type smsClient struct {
+	client        *dysmsapi.Client
+	signName      string
+	templateCode  string
+	intlSenderID  string
+	contentParams []string // 默认模板中承载正文的变量名
 }
 
+var (
+	smsClientMu      sync.RWMutex
+	currentSMSClient *smsClient
+)
+
 type SMSRequest struct {
-	PhoneNumber  string `json:"phone_number"`
-	Content      string `json:"content"`
-	TemplateCode string `json:"template_code,omitempty"`
-	SignName     string `json:"sign_name,omitempty"`
+	PhoneNumber    string            `json:"phone_number"`
+	Content        string            `json:"content"`
+	TemplateCode   string            `json:"template_code,omitempty"`
+	TemplateParams map[string]string `json:"template_params,omitempty"`
+	SignName       string            `json:"sign_name,omitempty"`
+	ExtendCode     string            `json:"extend_code,omitempty"` // 上行扩展码，用于接收回复
 }
 
 type SMSResponse struct {
@@ -28,75 +44,338 @@ type SMSResponse struct {
 	MessageID string `json:"message_id,omitempty"`
 	Error     string `json:"error,omitempty"`
 	Code      string `json:"code,omitempty"`
+	SentParts int    `json:"sent_parts,omitempty"` // 拆分发送中途失败时已发出的条数
 }
 
+// SMSTemplateStatus 服务商模板审核状态
+type SMSTemplateStatus struct {
+	TemplateCode string `json:"template_code"`
+	Status       string `json:"status"` // pending / approved / rejected
+	Reason       string `json:"reason,omitempty"`
+}
+
+// NewSMSService 首次调用时按短信模式创建客户端：mock 模式不调用阿里云，
+// live 模式缺少密钥时返回错误。短信配置变更后按新的模式重建客户端
 func NewSMSService() (*SMSService, error) {
-	accessKeyId := os.Getenv("ALIYUN_ACCESS_KEY_ID")
-	accessKeySecret := os.Getenv("ALIYUN_ACCESS_KEY_SECRET")
-	region := os.Getenv("ALIYUN_SMS_REGION")
-	signName := os.Getenv("ALIYUN_SMS_SIGN_NAME")
-	templateCode := os.Getenv("ALIYUN_SMS_TEMPLATE_CODE")
+	smsClientMu.Lock()
+	defer smsClientMu.Unlock()
+
+	if currentSMSClient == nil {
+		client, err := buildSMSClient()
+		if err != nil {
+			return nil, err
+		}
+		currentSMSClient = client
+		RuntimeConfig().OnChange(ConfigGroupSMS, reloadSMSClient)
+	}
+
+	return &SMSService{}, nil
+}
+
+// buildSMSClient 按当前生效的短信模式创建客户端，mock 模式的客户端不调用阿里云
+func buildSMSClient() (*smsClient, error) {
+	cfg := RuntimeConfig().SMS()
+	if SMSMode() == config.ModeMock {
+		log.Println("短信服务使用模拟模式")
+		return &smsClient{contentParams: cfg.ContentParams}, nil
+	}
+	return newSMSClient(cfg)
+}
+
+// SMSMode 当前生效的短信模式，未指定 SMS_MODE 时按是否配置了阿里云密钥选择
+func SMSMode() string {
+	cfg := RuntimeConfig().SMS()
+	return config.ResolveMode(config.App.SMS.Mode, cfg.AccessKeyID != "" && cfg.AccessKeySecret != "")
+}
 
-	if accessKeyId == "" || accessKeySecret == "" {
+func newSMSClient(cfg SMSConfig) (*smsClient, error) {
+	if cfg.AccessKeyID == "" || cfg.AccessKeySecret == "" {
 		return nil, fmt.Errorf("阿里云短信服务配置不完整")
 	}
 
-	config := sdk.NewConfig()
-	credential := credentials.NewAccessKeyCredential(accessKeyId, accessKeySecret)
-	client, err := dysmsapi.NewClientWithOptions(region, config, credential)
+	sdkConfig := sdk.NewConfig()
+	credential := credentials.NewAccessKeyCredential(cfg.AccessKeyID, cfg.AccessKeySecret)
+	client, err := dysmsapi.NewClientWithOptions(cfg.Region, sdkConfig, credential)
 	if err != nil {
 		return nil, fmt.Errorf("创建阿里云短信客户端失败: %v", err)
 	}
 
-	return &SMSService{
-		client:       client,
-		signName:     signName,
-		templateCode: templateCode,
+	return &smsClient{
+		client:        client,
+		signName:      cfg.SignName,
+		templateCode:  cfg.TemplateCode,
+		intlSenderID:  cfg.IntlSenderID,
+		contentParams: cfg.ContentParams,
 	}, nil
 }
 
+// reloadSMSClient 短信配置变更后按新的模式重建客户端，新配置不可用时继续使用原客户端
+func reloadSMSClient() {
+	client, err := buildSMSClient()
+	if err != nil {
+		log.Printf("短信配置已变更，重建客户端失败: %v", err)
+		return
+	}
+
+	smsClientMu.Lock()
+	currentSMSClient = client
+	smsClientMu.Unlock()
+	log.Println("短信配置已变更，客户端已重建")
+}
+
+func (s *SMSService) current() *smsClient {
+	smsClientMu.RLock()
+	defer smsClientMu.RUnlock()
+	return currentSMSClient
+}
+
 func (s *SMSService) SendSMS(request SMSRequest) (*SMSResponse, error) {
+	c := s.current()
+
 	// 如果没有配置阿里云信息，返回模拟成功
-	if s.client == nil {
+	if c.client == nil {
 		return &SMSResponse{
 			Success:   true,
 			MessageID: fmt.Sprintf("mock_%d", time.Now().Unix()),
 		}, nil
 	}
 
-	req := dysmsapi.CreateSendSmsRequest()
-	req.Scheme = "https"
-	req.PhoneNumbers = request.PhoneNumber
-	req.SignName = s.signName
-	if request.SignName != "" {
-		req.SignName = request.SignName
+	phone, err := ParsePhoneNumber(request.PhoneNumber)
+	if err != nil {
+		return &SMSResponse{
+			Success: false,
+			Error:   err.Error(),
+		}, err
 	}
-	req.TemplateCode = s.templateCode
-	if request.TemplateCode != "" {
-		req.TemplateCode = request.TemplateCode
+
+	// 港澳台及国际号码走国际短信接口
+	if !phone.IsDomestic() {
+		return c.sendInternationalSMS(phone, request.Content)
 	}
-	req.TemplateParam = fmt.Sprintf(`{"content":"%s"}`, request.Content)
 
-	response, err := s.client.SendSms(req)
+	templateParams, err := buildTemplateParams(request, c.contentParams)
 	if err != nil {
 		return &SMSResponse{
 			Success: false,
-			Error:   fmt.Sprintf("发送短信失败: %v", err),
+			Error:   err.Error(),
 		}, err
 	}
 
-	if response.Code == "OK" {
+	// 正文超过模板变量长度时拆分为多条短信依次发送
+	var bizIDs []string
+	for _, templateParam := range templateParams {
+		req := dysmsapi.CreateSendSmsRequest()
+		req.Scheme = "https"
+		req.PhoneNumbers = phone.National
+		req.SignName = c.signName
+		if request.SignName != "" {
+			req.SignName = request.SignName
+		}
+		req.TemplateCode = c.templateCode
+		if request.TemplateCode != "" {
+			req.TemplateCode = request.TemplateCode
+		}
+		req.TemplateParam = templateParam
+		req.SmsUpExtendCode = request.ExtendCode
+
+		response, err := c.client.SendSms(req)
+		if err != nil {
+			return &SMSResponse{
+				Success:   false,
+				Error:     fmt.Sprintf("发送短信失败: %v", err),
+				SentParts: len(bizIDs),
+			}, err
+		}
+
+		if response.Code != "OK" {
+			return &SMSResponse{
+				Success:   false,
+				Error:     response.Message,
+				Code:      response.Code,
+				SentParts: len(bizIDs),
+			}, fmt.Errorf("短信发送失败: %s", response.Message)
+		}
+		bizIDs = append(bizIDs, response.BizId)
+	}
+
+	return &SMSResponse{
+		Success:   true,
+		MessageID: strings.Join(bizIDs, ","),
+	}, nil
+}
+
+// ContentParts 正文使用默认模板发送时拆分的短信条数，超过上限时返回 ErrContentTooLong
+func (s *SMSService) ContentParts(content string) (int, error) {
+	params, err := BuildContentParams(content, s.current().contentParams)
+	if err != nil {
+		return 0, err
+	}
+	return len(params), nil
+}
+
+// buildTemplateParams 生成每条短信的模板参数：指定了模板参数时原样校验，
+// 否则将正文填入默认模板的正文变量
+func buildTemplateParams(request SMSRequest, contentParams []string) ([]string, error) {
+	if request.TemplateParams == nil {
+		return BuildContentParams(request.Content, contentParams)
+	}
+
+	builder := NewTemplateParamBuilder()
+	for name, value := range request.TemplateParams {
+		if err := builder.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	params, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return []string{params}, nil
+}
+
+// sendInternationalSMS 通过阿里云国际短信接口发送，无需模板
+func (c *smsClient) sendInternationalSMS(phone *PhoneNumber, content string) (*SMSResponse, error) {
+	req := dysmsapi.CreateSendMessageToGlobeRequest()
+	req.Scheme = "https"
+	req.To = phone.E164
+	req.Message = content
+	if c.intlSenderID != "" {
+		req.From = c.intlSenderID
+	}
+
+	response, err := c.client.SendMessageToGlobe(req)
+	if err != nil {
 		return &SMSResponse{
-			Success:   true,
-			MessageID: response.BizId,
-		}, nil
-	} else {
+			Success: false,
+			Error:   fmt.Sprintf("发送国际短信失败: %v", err),
+		}, err
+	}
+
+	if response.ResponseCode != "OK" {
 		return &SMSResponse{
 			Success: false,
-			Error:   response.Message,
-			Code:    response.Code,
-		}, fmt.Errorf("短信发送失败: %s", response.Message)
+			Error:   response.ResponseDescription,
+			Code:    response.ResponseCode,
+		}, fmt.Errorf("国际短信发送失败: %s", response.ResponseDescription)
+	}
+
+	return &SMSResponse{
+		Success:   true,
+		MessageID: response.MessageId,
+	}, nil
+}
+
+// AddTemplate 向服务商提交短信模板审核，返回模板代码
+func (s *SMSService) AddTemplate(templateType int, name, content, remark string) (string, error) {
+	client := s.current().client
+	if client == nil {
+		return fmt.Sprintf("SMS_MOCK_%d", time.Now().UnixNano()), nil
+	}
+
+	req := dysmsapi.CreateAddSmsTemplateRequest()
+	req.Scheme = "https"
+	req.TemplateType = requests.NewInteger(templateType)
+	req.TemplateName = name
+	req.TemplateContent = content
+	req.Remark = remark
+
+	response, err := client.AddSmsTemplate(req)
+	if err != nil {
+		return "", fmt.Errorf("提交短信模板失败: %v", err)
+	}
+	if response.Code != "OK" {
+		return "", fmt.Errorf("提交短信模板失败: %s", response.Message)
+	}
+
+	return response.TemplateCode, nil
+}
+
+// ModifyTemplate 修改未通过审核的模板并重新提交
+func (s *SMSService) ModifyTemplate(templateType int, templateCode, name, content, remark string) error {
+	client := s.current().client
+	if client == nil {
+		return nil
+	}
+
+	req := dysmsapi.CreateModifySmsTemplateRequest()
+	req.Scheme = "https"
+	req.TemplateType = requests.NewInteger(templateType)
+	req.TemplateCode = templateCode
+	req.TemplateName = name
+	req.TemplateContent = content
+	req.Remark = remark
+
+	response, err := client.ModifySmsTemplate(req)
+	if err != nil {
+		return fmt.Errorf("修改短信模板失败: %v", err)
 	}
+	if response.Code != "OK" {
+		return fmt.Errorf("修改短信模板失败: %s", response.Message)
+	}
+
+	return nil
+}
+
+// QueryTemplate 查询模板审核状态
+func (s *SMSService) QueryTemplate(templateCode string) (*SMSTemplateStatus, error) {
+	client := s.current().client
+	// 如果没有配置阿里云信息，模拟审核通过
+	if client == nil {
+		return &SMSTemplateStatus{
+			TemplateCode: templateCode,
+			Status:       "approved",
+		}, nil
+	}
+
+	req := dysmsapi.CreateQuerySmsTemplateRequest()
+	req.Scheme = "https"
+	req.TemplateCode = templateCode
+
+	response, err := client.QuerySmsTemplate(req)
+	if err != nil {
+		return nil, fmt.Errorf("查询短信模板失败: %v", err)
+	}
+	if response.Code != "OK" {
+		return nil, fmt.Errorf("查询短信模板失败: %s", response.Message)
+	}
+
+	status := &SMSTemplateStatus{
+		TemplateCode: templateCode,
+		Reason:       response.Reason,
+	}
+	switch response.TemplateStatus {
+	case 1:
+		status.Status = "approved"
+	case 2:
+		status.Status = "rejected"
+	default:
+		status.Status = "pending"
+	}
+
+	return status, nil
+}
+
+// DeleteTemplate 删除服务商模板
+func (s *SMSService) DeleteTemplate(templateCode string) error {
+	client := s.current().client
+	if client == nil {
+		return nil
+	}
+
+	req := dysmsapi.CreateDeleteSmsTemplateRequest()
+	req.Scheme = "https"
+	req.TemplateCode = templateCode
+
+	response, err := client.DeleteSmsTemplate(req)
+	if err != nil {
+		return fmt.Errorf("删除短信模板失败: %v", err)
+	}
+	if response.Code != "OK" {
+		return fmt.Errorf("删除短信模板失败: %s", response.Message)
+	}
+
+	return nil
 }
 
 func (s *SMSService) QuerySMSStatus(messageID string) (*SMSResponse, error) {
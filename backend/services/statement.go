@@ -0,0 +1,184 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/go-pdf/fpdf"
+)
+
+const statementFontFamily = "statement"
+
+// Statement 月度对账单
+type Statement struct {
+	Phone          string
+	Start          time.Time
+	End            time.Time
+	OpeningBalance float64
+	ClosingBalance float64
+	Totals         map[string]float64 // 按账单类型汇总的金额
+	MessageCount   int64
+	MessageCost    float64
+	Bills          []models.Bill
+}
+
+// buildStatement 汇总 [start, end) 内的账单，期初余额取期内首笔账单变动前余额，
+// 期内没有账单时沿用之前最后一笔账单的余额
+func buildStatement(userID string, start, end time.Time) (*Statement, error) {
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("用户不存在")
+	}
+
+	statement := &Statement{
+		Phone:  MaskPhoneNumber(phoneE164(stringValue(user.Phone))),
+		Start:  start,
+		End:    end,
+		Totals: make(map[string]float64),
+	}
+
+	err := config.DB.Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, start, end).
+		Order("created_at ASC, id ASC").
+		Find(&statement.Bills).Error
+	if err != nil {
+		return nil, fmt.Errorf("读取账单失败: %v", err)
+	}
+
+	if len(statement.Bills) > 0 {
+		statement.OpeningBalance = statement.Bills[0].BalanceBefore
+		statement.ClosingBalance = statement.Bills[len(statement.Bills)-1].BalanceAfter
+	} else {
+		var previous models.Bill
+		if config.DB.Where("user_id = ? AND created_at < ?", userID, start).
+			Order("created_at DESC, id DESC").First(&previous).Error == nil {
+			statement.OpeningBalance = previous.BalanceAfter
+		} else {
+			var next models.Bill
+			if config.DB.Where("user_id = ? AND created_at >= ?", userID, end).
+				Order("created_at ASC, id ASC").First(&next).Error == nil {
+				statement.OpeningBalance = next.BalanceBefore
+			} else {
+				statement.OpeningBalance = user.Balance
+			}
+		}
+		statement.ClosingBalance = statement.OpeningBalance
+	}
+
+	for _, bill := range statement.Bills {
+		statement.Totals[bill.Type] += bill.Amount
+	}
+
+	var usage struct {
+		Count int64
+		Cost  float64
+	}
+	config.DB.Model(&models.Message{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(cost), 0) AS cost").
+		Where("user_id = ? AND direction = ? AND status = ? AND created_at >= ? AND created_at < ?",
+			userID, "outbound", "sent", start, end).
+		Scan(&usage)
+	statement.MessageCount = usage.Count
+	statement.MessageCost = usage.Cost
+
+	return statement, nil
+}
+
+// writeStatementPDF 生成A4对账单，中文需通过 EXPORT_PDF_FONT 指定TTF字体
+func writeStatementPDF(w io.Writer, statement *Statement, fontPath string) error {
+	if fontPath == "" {
+		return fmt.Errorf("未配置PDF字体，请设置 EXPORT_PDF_FONT")
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8Font(statementFontFamily, "", fontPath)
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("加载PDF字体失败: %v", err)
+	}
+	pdf.SetTitle("对账单 "+statement.Start.Format("2006-01"), true)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont(statementFontFamily, "", 8)
+		pdf.CellFormat(0, 6, fmt.Sprintf("第 %d / {nb} 页", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pdf.SetFont(statementFontFamily, "", 18)
+	pdf.CellFormat(0, 12, "月度对账单", "", 1, "C", false, 0, "")
+
+	pdf.SetFont(statementFontFamily, "", 10)
+	last := statement.End.AddDate(0, 0, -1)
+	pdf.CellFormat(0, 6, fmt.Sprintf("账户：%s", statement.Phone), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("账单周期：%s 至 %s", statement.Start.Format("2006-01-02"), last.Format("2006-01-02")), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("生成时间：%s", time.Now().Format(exportTimeLayout)), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	// 汇总
+	summary := [][2]string{
+		{"期初余额", formatAmount(statement.OpeningBalance)},
+		{"充值", formatAmount(statement.Totals["recharge"])},
+		{"支付", formatAmount(statement.Totals["payment"])},
+		{"消费", formatAmount(statement.Totals["consumption"])},
+		{"退款", formatAmount(statement.Totals["refund"])},
+		{"期末余额", formatAmount(statement.ClosingBalance)},
+		{"发送短信", fmt.Sprintf("%d 条，共 %s", statement.MessageCount, formatAmount(statement.MessageCost))},
+	}
+	pdf.SetFillColor(240, 240, 240)
+	for _, row := range summary {
+		pdf.CellFormat(40, 7, row[0], "1", 0, "L", true, 0, "")
+		pdf.CellFormat(60, 7, row[1], "1", 1, "R", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// 明细
+	pdf.SetFont(statementFontFamily, "", 12)
+	pdf.CellFormat(0, 8, "账单明细", "", 1, "L", false, 0, "")
+	pdf.SetFont(statementFontFamily, "", 9)
+
+	widths := []float64{38, 18, 74, 30, 30}
+	headers := []string{"时间", "类型", "说明", "金额", "余额"}
+	writeHeader := func() {
+		for i, h := range headers {
+			pdf.CellFormat(widths[i], 7, h, "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	writeHeader()
+
+	if len(statement.Bills) == 0 {
+		pdf.CellFormat(190, 7, "本期无账单记录", "1", 1, "C", false, 0, "")
+	}
+	_, pageHeight := pdf.GetPageSize()
+	for _, bill := range statement.Bills {
+		if pdf.GetY()+7 > pageHeight-15 {
+			pdf.AddPage()
+			writeHeader()
+		}
+		pdf.CellFormat(widths[0], 7, bill.CreatedAt.Format(exportTimeLayout), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, labelOf(billTypeLabels, bill.Type), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[2], 7, truncateString(bill.Description, 30), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[3], 7, formatAmount(bill.Amount), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 7, formatAmount(bill.BalanceAfter), "1", 1, "R", false, 0, "")
+	}
+
+	if err := pdf.Output(w); err != nil {
+		return fmt.Errorf("生成PDF失败: %v", err)
+	}
+	return nil
+}
+
+func formatAmount(amount float64) string {
+	return fmt.Sprintf("¥%.2f", amount)
+}
+
+// phoneE164 将用户登录手机号转为完整号码，无法识别时原样返回
+func phoneE164(phone string) string {
+	if parsed, err := ParsePhoneNumber(phone); err == nil {
+		return parsed.E164
+	}
+	return phone
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/models"
+	"anonymous-messaging-backend/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	appealTokenTTL      = time.Hour
+	appealTokenAudience = "appeal"
+	maxAppealLength     = 1000
+)
+
+var (
+	ErrUserSuspended       = errors.New("账号已被停用")
+	ErrSuspensionNotFound  = errors.New("停用记录不存在")
+	ErrAppealPending       = errors.New("申诉正在处理中")
+	ErrAppealNotFound      = errors.New("申诉不存在")
+	ErrAppealContentLength = fmt.Errorf("申诉内容不能为空且不超过%d字", maxAppealLength)
+)
+
+// SuspendedError 账号停用错误，附带停用记录
+type SuspendedError struct {
+	Suspension *models.UserSuspension
+}
+
+func (e *SuspendedError) Error() string {
+	if e.Suspension == nil {
+		return ErrUserSuspended.Error()
+	}
+
+	message := fmt.Sprintf("%s：%s", ErrUserSuspended.Error(), e.Suspension.Reason)
+	if e.Suspension.ExpiresAt != nil {
+		message += fmt.Sprintf("，将于%s解除", e.Suspension.ExpiresAt.Format("2006-01-02 15:04"))
+	}
+	return message
+}
+
+func (e *SuspendedError) Is(target error) bool {
+	return target == ErrUserSuspended
+}
+
+type SuspensionService struct {
+	db *gorm.DB
+}
+
+func NewSuspensionService(db *gorm.DB) *SuspensionService {
+	return &SuspensionService{db: db}
+}
+
+// CheckUser 检查用户是否可以使用服务，停用已到期时自动解除
+func (s *SuspensionService) CheckUser(userID string) error {
+	var user models.User
+	if err := s.db.Select("id", "status").First(&user, "id = ?", userID).Error; err != nil {
+		return ErrUserNotFound
+	}
+
+	switch user.Status {
+	case "active":
+		return nil
+	case "suspended":
+		suspension, err := s.ActiveSuspension(userID)
+		if err != nil {
+			// 没有停用记录（如直接修改数据库停用）时视为永久停用
+			return &SuspendedError{}
+		}
+		if suspension.ExpiresAt != nil && !suspension.ExpiresAt.After(time.Now()) {
+			s.expire(suspension)
+			return s.CheckUser(userID)
+		}
+		return &SuspendedError{Suspension: suspension}
+	}
+	return ErrUserInactive
+}
+
+// ActiveSuspension 获取用户当前生效的停用记录，有多条时取解除时间最晚的
+func (s *SuspensionService) ActiveSuspension(userID string) (*models.UserSuspension, error) {
+	var suspension models.UserSuspension
+	err := s.db.Where("user_id = ? AND status = ?", userID, "active").
+		Order("expires_at IS NULL DESC, expires_at DESC").
+		First(&suspension).Error
+	if err != nil {
+		return nil, ErrSuspensionNotFound
+	}
+	return &suspension, nil
+}
+
+// Suspend 停用账号并注销其全部会话，duration为0表示永久停用
+func (s *SuspensionService) Suspend(actor Actor, userID, reason, source, signal string, duration time.Duration) (*models.UserSuspension, error) {
+	now := time.Now()
+	suspension := &models.UserSuspension{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Reason:    truncateString(reason, 255),
+		Source:    source,
+		Signal:    signal,
+		Status:    "active",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		suspension.ExpiresAt = &expiresAt
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := lockUser(tx, &user, "id = ?", userID); err != nil {
+			return ErrUserNotFound
+		}
+		if user.Status == "deleted" {
+			return ErrUserInactive
+		}
+
+		if err := tx.Create(suspension).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{"status": "suspended", "updated_at": now}).Error; err != nil {
+			return err
+		}
+		if err := revokeSessions(tx.Where("user_id = ?", userID), "账号已停用"); err != nil {
+			return err
+		}
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "user.suspend",
+			TargetType: "user",
+			TargetID:   userID,
+			Before:     map[string]interface{}{"status": user.Status},
+			After:      suspension,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrUserInactive) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("停用账号失败: %v", err)
+	}
+
+	log.Printf("账号已停用: user=%s source=%s reason=%s", userID, source, reason)
+	return suspension, nil
+}
+
+// Lift 解除用户全部生效中的停用
+func (s *SuspensionService) Lift(actor Actor, userID, note string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := liftSuspensions(tx, tx.Where("user_id = ? AND status = ?", userID, "active"), userID, "lifted", note); err != nil {
+			return err
+		}
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "user.unsuspend",
+			TargetType: "user",
+			TargetID:   userID,
+			After:      map[string]interface{}{"note": note},
+		})
+	})
+}
+
+// ExpireSuspensions 解除已到期的停用
+func (s *SuspensionService) ExpireSuspensions() {
+	var suspensions []models.UserSuspension
+	err := s.db.Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", "active", time.Now()).
+		Find(&suspensions).Error
+	if err != nil {
+		log.Printf("查询到期停用失败: %v", err)
+		return
+	}
+
+	for i := range suspensions {
+		s.expire(&suspensions[i])
+	}
+}
+
+// StartExpiry 定期解除到期的停用
+func (s *SuspensionService) StartExpiry(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.ExpireSuspensions()
+		}
+	}()
+}
+
+func (s *SuspensionService) expire(suspension *models.UserSuspension) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := liftSuspensions(tx, tx.Where("id = ? AND status = ?", suspension.ID, "active"), suspension.UserID, "expired", ""); err != nil {
+			return err
+		}
+		return RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      SystemActor("suspension_expiry"),
+			Action:     "user.suspension_expire",
+			TargetType: "user",
+			TargetID:   suspension.UserID,
+			After:      map[string]interface{}{"suspension_id": suspension.ID},
+		})
+	})
+	if err != nil {
+		log.Printf("解除到期停用失败: suspension=%s err=%v", suspension.ID, err)
+	}
+}
+
+// liftSuspensions 结束指定的停用记录，用户没有其他生效中的停用时恢复为正常状态
+func liftSuspensions(tx *gorm.DB, query *gorm.DB, userID, status, note string) error {
+	now := time.Now()
+	err := query.Model(&models.UserSuspension{}).Updates(map[string]interface{}{
+		"status":     status,
+		"lifted_at":  now,
+		"lift_note":  truncateString(note, 255),
+		"updated_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var remaining int64
+	tx.Model(&models.UserSuspension{}).Where("user_id = ? AND status = ?", userID, "active").Count(&remaining)
+	if remaining > 0 {
+		return nil
+	}
+	return tx.Model(&models.User{}).
+		Where("id = ? AND status = ?", userID, "suspended").
+		Updates(map[string]interface{}{"status": "active", "updated_at": now}).Error
+}
+
+// RecordComplaint 记录针对发送方的投诉，一定时间内投诉的收件人数达到阈值时自动停用。
+// threadID 为投诉的收件人所在会话，同一收件人对同一条消息的重复投诉只记一次
+func (s *SuspensionService) RecordComplaint(userID, messageID, threadID, source, reason string) error {
+	complaint := &models.MessageComplaint{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		MessageID: messageID,
+		Source:    source,
+		Reason:    truncateString(reason, 255),
+		CreatedAt: time.Now(),
+	}
+	if threadID != "" {
+		complaint.ThreadID = &threadID
+	}
+	if err := s.db.Create(complaint).Error; err != nil {
+		if isDuplicateKey(err) {
+			return nil
+		}
+		return fmt.Errorf("记录投诉失败: %v", err)
+	}
+
+	s.evaluateComplaints(userID)
+	return nil
+}
+
+// evaluateComplaints 检查投诉信号，同一收件人的多次投诉只计一次，
+// 没有关联收件人的投诉各计一次。阈值和停用时长可在系统配置中调整
+func (s *SuspensionService) evaluateComplaints(userID string) {
+	threshold := int64(getSystemConfigFloat("abuse_complaint_threshold", 5))
+	windowDays := int(getSystemConfigFloat("abuse_complaint_window_days", 7))
+	suspendDays := int(getSystemConfigFloat("abuse_suspension_days", 7))
+	if threshold <= 0 {
+		return
+	}
+
+	var count int64
+	s.db.Model(&models.MessageComplaint{}).
+		Where("user_id = ? AND created_at >= ?", userID, time.Now().AddDate(0, 0, -windowDays)).
+		Select("COUNT(DISTINCT COALESCE(thread_id, id))").
+		Scan(&count)
+	if count < threshold {
+		return
+	}
+
+	if _, err := s.ActiveSuspension(userID); err == nil {
+		return
+	}
+	reason := fmt.Sprintf("%d天内收到%d位收件人的投诉", windowDays, count)
+	if _, err := s.Suspend(SystemActor("abuse_detection"), userID, reason, "auto", "complaints", time.Duration(suspendDays)*24*time.Hour); err != nil {
+		log.Printf("自动停用账号失败: user=%s err=%v", userID, err)
+	}
+}
+
+// IsComplaint 回复内容是否包含投诉关键词
+func IsComplaint(content string) bool {
+	keywords := getSystemConfig("abuse_complaint_keywords", "举报,投诉,骚扰")
+	for _, keyword := range strings.Split(keywords, ",") {
+		keyword = strings.TrimSpace(keyword)
+		if keyword != "" && strings.Contains(content, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// appealClaims 申诉令牌，停用用户登录时签发，仅用于提交申诉
+type appealClaims struct {
+	SuspensionID string `json:"suspension_id"`
+	jwt.RegisteredClaims
+}
+
+// IssueAppealToken 为停用记录签发申诉令牌
+func (s *SuspensionService) IssueAppealToken(suspension *models.UserSuspension) (string, error) {
+	now := time.Now()
+	claims := appealClaims{
+		SuspensionID: suspension.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   suspension.UserID,
+			Audience:  jwt.ClaimStrings{appealTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(appealTokenTTL)),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(loadJWTSecret())
+	if err != nil {
+		return "", fmt.Errorf("签发申诉令牌失败: %v", err)
+	}
+	return token, nil
+}
+
+// SubmitAppeal 使用申诉令牌提交申诉，每次停用同时只能有一条待处理的申诉
+func (s *SuspensionService) SubmitAppeal(appealToken, content string) (*models.SuspensionAppeal, error) {
+	claims := &appealClaims{}
+	_, err := jwt.ParseWithClaims(appealToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return loadJWTSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(appealTokenAudience))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" || len([]rune(content)) > maxAppealLength {
+		return nil, ErrAppealContentLength
+	}
+
+	var suspension models.UserSuspension
+	if err := s.db.Where("id = ? AND user_id = ?", claims.SuspensionID, claims.Subject).First(&suspension).Error; err != nil {
+		return nil, ErrSuspensionNotFound
+	}
+	if suspension.Status != "active" {
+		return nil, ErrSuspensionNotFound
+	}
+
+	var pending int64
+	s.db.Model(&models.SuspensionAppeal{}).
+		Where("suspension_id = ? AND status = ?", suspension.ID, "pending").
+		Count(&pending)
+	if pending > 0 {
+		return nil, ErrAppealPending
+	}
+
+	appeal := &models.SuspensionAppeal{
+		ID:           uuid.New().String(),
+		SuspensionID: suspension.ID,
+		UserID:       suspension.UserID,
+		Content:      content,
+		Status:       "pending",
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := s.db.Create(appeal).Error; err != nil {
+		return nil, fmt.Errorf("提交申诉失败: %v", err)
+	}
+	return appeal, nil
+}
+
+// LatestAppeal 获取停用记录最近一次申诉
+func (s *SuspensionService) LatestAppeal(suspensionID string) (*models.SuspensionAppeal, error) {
+	var appeal models.SuspensionAppeal
+	if err := s.db.Where("suspension_id = ?", suspensionID).Order("created_at DESC").First(&appeal).Error; err != nil {
+		return nil, ErrAppealNotFound
+	}
+	return &appeal, nil
+}
+
+// ReviewAppeal 处理申诉，通过时解除对应的停用
+func (s *SuspensionService) ReviewAppeal(actor Actor, appealID string, approve bool, reply string) (*models.SuspensionAppeal, error) {
+	var appeal models.SuspensionAppeal
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND status = ?", appealID, "pending").First(&appeal).Error; err != nil {
+			return ErrAppealNotFound
+		}
+
+		now := time.Now()
+		appeal.Status = "rejected"
+		if approve {
+			appeal.Status = "approved"
+		}
+		appeal.Reply = truncateString(reply, 255)
+		appeal.ReviewedAt = &now
+		appeal.UpdatedAt = now
+		if err := tx.Save(&appeal).Error; err != nil {
+			return err
+		}
+		err := RecordAudit(repository.NewGormAudit(tx), AuditEntry{
+			Actor:      actor,
+			Action:     "appeal.review",
+			TargetType: "suspension_appeal",
+			TargetID:   appeal.ID,
+			Before:     map[string]interface{}{"status": "pending"},
+			After:      map[string]interface{}{"status": appeal.Status, "reply": appeal.Reply, "suspension_id": appeal.SuspensionID},
+		})
+		if err != nil {
+			return err
+		}
+
+		if !approve {
+			return nil
+		}
+		query := tx.Where("id = ? AND status = ?", appeal.SuspensionID, "active")
+		return liftSuspensions(tx, query, appeal.UserID, "lifted", "申诉通过")
+	})
+	if err != nil {
+		if errors.Is(err, ErrAppealNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("处理申诉失败: %v", err)
+	}
+	return &appeal, nil
+}
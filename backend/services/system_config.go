@@ -0,0 +1,26 @@
+package services
+
+import (
+	"strconv"
+)
+
+// getSystemConfig 从运行时配置读取，未配置时返回默认值
+func getSystemConfig(key, defaultValue string) string {
+	return RuntimeConfig().String(key, defaultValue)
+}
+
+func getSystemConfigFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(getSystemConfig(key, ""), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getSystemConfigBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(getSystemConfig(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+)
+
+const (
+	// MaxTemplateParamLength 服务商单个模板变量的最大长度
+	MaxTemplateParamLength = 35
+	// maxSMSParts 单条消息最多拆分的短信条数
+	maxSMSParts = 10
+)
+
+// ErrContentTooLong 正文拆分后超过单条消息允许的短信条数
+var ErrContentTooLong = fmt.Errorf("短信内容过长，最多拆分为%d条短信", maxSMSParts)
+
+// TemplateParamBuilder 构造短信模板参数JSON，校验变量长度并正确转义
+type TemplateParamBuilder struct {
+	maxLength int
+	params    map[string]string
+}
+
+func NewTemplateParamBuilder() *TemplateParamBuilder {
+	return &TemplateParamBuilder{
+		maxLength: MaxTemplateParamLength,
+		params:    make(map[string]string),
+	}
+}
+
+// Set 设置模板变量，超过服务商长度限制时返回错误
+func (b *TemplateParamBuilder) Set(name, value string) error {
+	if length := templateParamLength(value); length > b.maxLength {
+		return fmt.Errorf("模板参数 %s 长度为%d，超过%d个字符限制", name, length, b.maxLength)
+	}
+	b.params[name] = value
+	return nil
+}
+
+// Build 生成模板参数JSON
+func (b *TemplateParamBuilder) Build() (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(b.params); err != nil {
+		return "", fmt.Errorf("模板参数格式错误: %v", err)
+	}
+	return string(bytes.TrimRight(buf.Bytes(), "\n")), nil
+}
+
+// BuildContentParams 将正文按变量长度限制依次填入names中的变量，
+// 变量不够用时拆分为多条短信，每条短信返回一份模板参数JSON
+func BuildContentParams(content string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("未配置短信正文模板变量")
+	}
+
+	chunks := splitTemplateParam(content, MaxTemplateParamLength)
+	parts := (len(chunks) + len(names) - 1) / len(names)
+	if parts > maxSMSParts {
+		return nil, ErrContentTooLong
+	}
+
+	var result []string
+	for len(chunks) > 0 {
+		builder := NewTemplateParamBuilder()
+		for _, name := range names {
+			value := ""
+			if len(chunks) > 0 {
+				value, chunks = chunks[0], chunks[1:]
+			}
+			if err := builder.Set(name, value); err != nil {
+				return nil, err
+			}
+		}
+
+		params, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, params)
+	}
+
+	return result, nil
+}
+
+// templateParamLength 按UTF-16编码单元计算长度，emoji等补充平面字符计为2，与服务商计数一致
+func templateParamLength(value string) int {
+	return len(utf16.Encode([]rune(value)))
+}
+
+// splitTemplateParam 将文本拆分为不超过maxLength的片段，不会拆开emoji组合序列或组合字符
+func splitTemplateParam(value string, maxLength int) []string {
+	runes := []rune(value)
+	if len(runes) == 0 {
+		return []string{""}
+	}
+
+	var chunks []string
+	start, length := 0, 0
+	for i := 0; i < len(runes); {
+		// 找到下一个不可拆分的字符簇
+		end := i + 1
+		for end < len(runes) && joinsPrevious(runes[i:end], runes[end]) {
+			end++
+		}
+
+		clusterLength := templateParamLength(string(runes[i:end]))
+		if length+clusterLength > maxLength && length > 0 {
+			chunks = append(chunks, string(runes[start:i]))
+			start, length = i, 0
+		}
+		length += clusterLength
+		i = end
+	}
+
+	return append(chunks, string(runes[start:]))
+}
+
+// joinsPrevious 判断next是否必须与已组成的字符簇cluster保持在同一片段
+func joinsPrevious(cluster []rune, next rune) bool {
+	prev := cluster[len(cluster)-1]
+	switch {
+	case prev == '\u200d' || next == '\u200d': // 零宽连接符
+		return true
+	case next == '\ufe0e' || next == '\ufe0f': // 变体选择符
+		return true
+	case next >= 0x1f3fb && next <= 0x1f3ff: // 肤色修饰符
+		return true
+	case isRegionalIndicator(next): // 国旗由两个区域指示符组成，连续的国旗两两配对
+		paired := 0
+		for i := len(cluster) - 1; i >= 0 && isRegionalIndicator(cluster[i]); i-- {
+			paired++
+		}
+		return paired%2 == 1
+	case next == '\u20e3': // 键帽符号
+		return true
+	}
+	return unicode.Is(unicode.Mn, next) || unicode.Is(unicode.Me, next)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	family    = "\U0001F468\u200d\U0001F469\u200d\U0001F467" // 👨‍👩‍👧，8个编码单元
+	thumbsUp  = "\U0001F44D\U0001F3FD"                       // 👍🏽，4个编码单元
+	grinning  = "\U0001F600"                                 // 😀，2个编码单元
+	keycap    = "1\ufe0f\u20e3"                              // 1️⃣，3个编码单元
+	flagCN    = "\U0001F1E8\U0001F1F3"                       // 🇨🇳，4个编码单元
+	flagUS    = "\U0001F1FA\U0001F1F8"                       // 🇺🇸，4个编码单元
+	combining = "e\u0301"                                    // é，2个编码单元
+)
+
+func TestTemplateParamLength(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"你好", 2},
+		{grinning, 2},
+		{family, 8},
+		{thumbsUp, 4},
+		{keycap, 3},
+		{`"\<>&`, 5},
+	}
+	for _, tt := range tests {
+		if got := templateParamLength(tt.value); got != tt.want {
+			t.Errorf("templateParamLength(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestJoinsPrevious(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster []rune
+		next    rune
+		want    bool
+	}{
+		{"普通字符", []rune{'a'}, 'b', false},
+		{"相邻emoji", []rune{0x1f600}, 0x1f600, false},
+		{"零宽连接符在后", []rune{0x1f468}, '\u200d', true},
+		{"零宽连接符在前", []rune{0x1f468, '\u200d'}, 0x1f469, true},
+		{"变体选择符", []rune{'1'}, '\ufe0f', true},
+		{"文本变体选择符", []rune{0x2764}, '\ufe0e', true},
+		{"肤色修饰符", []rune{0x1f44d}, 0x1f3fd, true},
+		{"肤色修饰符上限", []rune{0x1f44d}, 0x1f3ff, true},
+		{"国旗区域指示符", []rune{0x1f1e8}, 0x1f1f3, true},
+		{"国旗已配对", []rune{0x1f1e8, 0x1f1f3}, 0x1f1fa, false},
+		{"区域指示符前为普通字符", []rune{'a'}, 0x1f1e8, false},
+		{"键帽符号", []rune{'1', '\ufe0f'}, '\u20e3', true},
+		{"组合音标", []rune{'e'}, '\u0301', true},
+		{"汉字", []rune{'你'}, '好', false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinsPrevious(tt.cluster, tt.next); got != tt.want {
+				t.Errorf("joinsPrevious(%U, %U) = %v, want %v", tt.cluster, tt.next, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTemplateParam(t *testing.T) {
+	a := func(n int) string { return strings.Repeat("a", n) }
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"空字符串", "", []string{""}},
+		{"未超长", "hello", []string{"hello"}},
+		{"恰好35个编码单元", a(35), []string{a(35)}},
+		{"36个编码单元", a(36), []string{a(35), "a"}},
+		{"两段满长", a(70), []string{a(35), a(35)}},
+		{"代理对恰好填满", a(33) + grinning, []string{a(33) + grinning}},
+		{"代理对不拆开", a(34) + grinning, []string{a(34), grinning}},
+		{"零宽连接序列不拆开", a(30) + family, []string{a(30), family}},
+		{"零宽连接序列恰好填满", a(27) + family, []string{a(27) + family}},
+		{"肤色修饰符不拆开", a(32) + thumbsUp, []string{a(32), thumbsUp}},
+		{"键帽序列不拆开", a(33) + keycap, []string{a(33), keycap}},
+		{"国旗不拆开", a(33) + flagCN, []string{a(33), flagCN}},
+		{"连续国旗两两配对", a(31) + flagCN + flagUS, []string{a(31) + flagCN, flagUS}},
+		{"组合字符不拆开", a(34) + combining, []string{a(34), combining}},
+		{"连续emoji", strings.Repeat(grinning, 18), []string{strings.Repeat(grinning, 17), grinning}},
+		{"引号按原始字符计数", strings.Repeat(`"`, 35), []string{strings.Repeat(`"`, 35)}},
+		{"反斜杠按原始字符计数", strings.Repeat(`\`, 36), []string{strings.Repeat(`\`, 35), `\`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitTemplateParam(tt.value, MaxTemplateParamLength)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitTemplateParam(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+			if strings.Join(got, "") != tt.value {
+				t.Errorf("拆分后拼接与原文不一致: %q", got)
+			}
+			for _, chunk := range got {
+				if length := templateParamLength(chunk); length > MaxTemplateParamLength {
+					t.Errorf("片段 %q 长度为%d，超过限制", chunk, length)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildContentParams(t *testing.T) {
+	a := func(n int) string { return strings.Repeat("a", n) }
+
+	tests := []struct {
+		name    string
+		content string
+		names   []string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "单个变量",
+			content: "hello",
+			names:   []string{"content"},
+			want:    []string{`{"content":"hello"}`},
+		},
+		{
+			name:    "引号和反斜杠转义，HTML字符不转义",
+			content: `他说"你好"\n<b>&</b>`,
+			names:   []string{"content"},
+			want:    []string{`{"content":"他说\"你好\"\\n<b>&</b>"}`},
+		},
+		{
+			name:    "emoji原样输出",
+			content: family + thumbsUp,
+			names:   []string{"content"},
+			want:    []string{`{"content":"` + family + thumbsUp + `"}`},
+		},
+		{
+			name:    "恰好35个编码单元为一条",
+			content: a(35),
+			names:   []string{"content"},
+			want:    []string{`{"content":"` + a(35) + `"}`},
+		},
+		{
+			name:    "36个编码单元拆为两条",
+			content: a(36),
+			names:   []string{"content"},
+			want:    []string{`{"content":"` + a(35) + `"}`, `{"content":"a"}`},
+		},
+		{
+			name:    "多个变量依次填入",
+			content: a(70),
+			names:   []string{"c1", "c2"},
+			want:    []string{`{"c1":"` + a(35) + `","c2":"` + a(35) + `"}`},
+		},
+		{
+			name:    "变量不够用时拆分，剩余变量为空",
+			content: a(71),
+			names:   []string{"c1", "c2"},
+			want:    []string{`{"c1":"` + a(35) + `","c2":"` + a(35) + `"}`, `{"c1":"a","c2":""}`},
+		},
+		{
+			name:    "引号转义后仍按原始长度拆分",
+			content: strings.Repeat(`"`, 36),
+			names:   []string{"content"},
+			want:    []string{`{"content":"` + strings.Repeat(`\"`, 35) + `"}`, `{"content":"\""}`},
+		},
+		{
+			name:    "恰好达到最多条数",
+			content: a(MaxTemplateParamLength * maxSMSParts),
+			names:   []string{"content"},
+		},
+		{
+			name:    "超过最多条数",
+			content: a(MaxTemplateParamLength*maxSMSParts + 1),
+			names:   []string{"content"},
+			wantErr: ErrContentTooLong,
+		},
+		{
+			name:    "emoji超过最多条数",
+			content: strings.Repeat(grinning, 17*maxSMSParts+1),
+			names:   []string{"content"},
+			wantErr: ErrContentTooLong,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildContentParams(tt.content, tt.names)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildContentParams() error: %v", err)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("BuildContentParams() = %q, want %q", got, tt.want)
+			}
+			if len(got) > maxSMSParts {
+				t.Errorf("拆分为%d条，超过%d条", len(got), maxSMSParts)
+			}
+
+			// 每份参数都是合法JSON，按变量顺序拼接后与原文一致
+			var rebuilt strings.Builder
+			for _, params := range got {
+				var values map[string]string
+				if err := json.Unmarshal([]byte(params), &values); err != nil {
+					t.Fatalf("参数不是合法JSON %s: %v", params, err)
+				}
+				for _, name := range tt.names {
+					rebuilt.WriteString(values[name])
+				}
+			}
+			if rebuilt.String() != tt.content {
+				t.Errorf("还原后的正文与原文不一致")
+			}
+		})
+	}
+}
+
+func TestBuildContentParamsWithoutNames(t *testing.T) {
+	if _, err := BuildContentParams("hello", nil); err == nil {
+		t.Fatal("未配置变量时应返回错误")
+	}
+}
+
+func TestTemplateParamBuilderSet(t *testing.T) {
+	builder := NewTemplateParamBuilder()
+	if err := builder.Set("name", strings.Repeat(grinning, 17)+"a"); err != nil {
+		t.Fatalf("35个编码单元应允许: %v", err)
+	}
+	if err := builder.Set("name", strings.Repeat(grinning, 18)); err == nil {
+		t.Fatal("36个编码单元应返回错误")
+	}
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTemplateNotFound    = errors.New("模板不存在")
+	ErrTemplateNotApproved = errors.New("模板尚未通过审核")
+	ErrTemplateParams      = errors.New("模板参数错误")
+)
+
+// TemplateParamError 模板参数校验失败，Message 说明具体哪个参数不符合要求
+type TemplateParamError struct {
+	Message string
+}
+
+func (e *TemplateParamError) Error() string {
+	return e.Message
+}
+
+func (e *TemplateParamError) Is(target error) bool {
+	return target == ErrTemplateParams
+}
+
+func templateParamError(format string, args ...interface{}) error {
+	return &TemplateParamError{Message: fmt.Sprintf(format, args...)}
+}
+
+// IsTemplateError 是否为模板或正文校验失败，由请求内容引起，不是服务端错误
+func IsTemplateError(err error) bool {
+	for _, target := range []error{
+		ErrTemplateNotFound, ErrTemplateNotApproved, ErrTemplateParams, ErrContentTooLong,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// 模板变量格式与阿里云一致，如 ${name}
+var templateVariablePattern = regexp.MustCompile(`\$\{([A-Za-z][A-Za-z0-9_]*)\}`)
+
+// 阿里云模板类型：1 短信通知，2 推广短信，3 国际/港澳台消息
+var templateTypeCodes = map[string]int{
+	"notification":  1,
+	"marketing":     2,
+	"international": 3,
+}
+
+// TemplateVariable 模板变量定义
+type TemplateVariable struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // string / number / phone / date
+	MaxLength int    `json:"max_length,omitempty"`
+}
+
+// TemplateInput 创建或修改模板的参数
+type TemplateInput struct {
+	Name         string
+	TemplateType string
+	Content      string
+	Remark       string
+	Variables    []TemplateVariable // 可选，未声明类型的变量按string处理
+}
+
+type TemplateService struct {
+	smsService *SMSService
+}
+
+func NewTemplateService(smsService *SMSService) *TemplateService {
+	return &TemplateService{
+		smsService: smsService,
+	}
+}
+
+// CreateTemplate 创建模板并提交服务商审核
+func (t *TemplateService) CreateTemplate(userID string, input TemplateInput) (*models.SMSTemplate, error) {
+	variables, err := buildTemplateVariables(input.Content, input.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.TemplateType == "" {
+		input.TemplateType = "notification"
+	}
+	templateType, ok := templateTypeCodes[input.TemplateType]
+	if !ok {
+		return nil, fmt.Errorf("不支持的模板类型: %s", input.TemplateType)
+	}
+
+	variablesJSON, err := json.Marshal(variables)
+	if err != nil {
+		return nil, fmt.Errorf("模板变量格式错误: %v", err)
+	}
+
+	templateCode, err := t.smsService.AddTemplate(templateType, input.Name, input.Content, input.Remark)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &models.SMSTemplate{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		Name:         input.Name,
+		TemplateType: input.TemplateType,
+		TemplateCode: templateCode,
+		Content:      input.Content,
+		Variables:    string(variablesJSON),
+		Remark:       input.Remark,
+		Status:       "pending",
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+
+	if err := config.DB.Create(template).Error; err != nil {
+		return nil, fmt.Errorf("保存模板失败: %v", err)
+	}
+
+	return template, nil
+}
+
+// UpdateTemplate 修改未通过审核的模板并重新提交
+func (t *TemplateService) UpdateTemplate(userID, templateID string, input TemplateInput) (*models.SMSTemplate, error) {
+	var template models.SMSTemplate
+	if err := config.DB.Where("id = ? AND user_id = ? AND status <> ?", templateID, userID, "deleted").First(&template).Error; err != nil {
+		return nil, ErrTemplateNotFound
+	}
+
+	if template.Status != "rejected" {
+		return nil, fmt.Errorf("只能修改未通过审核的模板")
+	}
+
+	variables, err := buildTemplateVariables(input.Content, input.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	variablesJSON, err := json.Marshal(variables)
+	if err != nil {
+		return nil, fmt.Errorf("模板变量格式错误: %v", err)
+	}
+
+	err = t.smsService.ModifyTemplate(templateTypeCodes[template.TemplateType], template.TemplateCode, input.Name, input.Content, input.Remark)
+	if err != nil {
+		return nil, err
+	}
+
+	template.Name = input.Name
+	template.Content = input.Content
+	template.Variables = string(variablesJSON)
+	template.Remark = input.Remark
+	template.Status = "pending"
+	template.RejectReason = ""
+	template.UpdatedAt = time.Now()
+
+	if err := config.DB.Save(&template).Error; err != nil {
+		return nil, fmt.Errorf("保存模板失败: %v", err)
+	}
+
+	return &template, nil
+}
+
+// DeleteTemplate 删除服务商模板，本地记录保留以便历史消息关联
+func (t *TemplateService) DeleteTemplate(userID, templateID string) error {
+	var template models.SMSTemplate
+	if err := config.DB.Where("id = ? AND user_id = ? AND status <> ?", templateID, userID, "deleted").First(&template).Error; err != nil {
+		return ErrTemplateNotFound
+	}
+
+	if err := t.smsService.DeleteTemplate(template.TemplateCode); err != nil {
+		return err
+	}
+
+	template.Status = "deleted"
+	template.UpdatedAt = time.Now()
+	if err := config.DB.Save(&template).Error; err != nil {
+		return fmt.Errorf("删除模板失败: %v", err)
+	}
+
+	return nil
+}
+
+// GetTemplate 获取用户自己的模板或系统模板，审核中的模板会同步最新状态
+func (t *TemplateService) GetTemplate(userID, templateID string) (*models.SMSTemplate, error) {
+	var template models.SMSTemplate
+	err := config.DB.Where("id = ? AND (user_id = ? OR user_id = '') AND status <> ?", templateID, userID, "deleted").
+		First(&template).Error
+	if err != nil {
+		return nil, ErrTemplateNotFound
+	}
+
+	t.syncTemplateStatus(&template)
+	return &template, nil
+}
+
+// templateByID 按ID获取模板，不校验所属用户，用于发送已创建的消息
+func (t *TemplateService) templateByID(templateID string) (*models.SMSTemplate, error) {
+	var template models.SMSTemplate
+	if err := config.DB.First(&template, "id = ?", templateID).Error; err != nil {
+		return nil, ErrTemplateNotFound
+	}
+	return &template, nil
+}
+
+// ListTemplates 获取用户可用的模板列表，status为空时返回全部
+func (t *TemplateService) ListTemplates(userID, status string) ([]models.SMSTemplate, error) {
+	query := config.DB.Where("(user_id = ? OR user_id = '') AND status <> ?", userID, "deleted")
+
+	var templates []models.SMSTemplate
+	if err := query.Order("created_at DESC").Find(&templates).Error; err != nil {
+		return nil, fmt.Errorf("获取模板列表失败: %v", err)
+	}
+
+	result := make([]models.SMSTemplate, 0, len(templates))
+	for i := range templates {
+		t.syncTemplateStatus(&templates[i])
+		if status == "" || templates[i].Status == status {
+			result = append(result, templates[i])
+		}
+	}
+
+	return result, nil
+}
+
+// RenderTemplate 校验模板参数并生成短信正文
+func (t *TemplateService) RenderTemplate(userID, templateID string, params map[string]string) (*models.SMSTemplate, string, error) {
+	template, err := t.GetTemplate(userID, templateID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if template.Status != "approved" {
+		return nil, "", ErrTemplateNotApproved
+	}
+
+	content, err := renderTemplateContent(template, params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return template, content, nil
+}
+
+// renderTemplateContent 校验参数并替换模板中的变量
+func renderTemplateContent(template *models.SMSTemplate, params map[string]string) (string, error) {
+	var variables []TemplateVariable
+	if template.Variables != "" {
+		if err := json.Unmarshal([]byte(template.Variables), &variables); err != nil {
+			return "", fmt.Errorf("模板变量格式错误: %v", err)
+		}
+	}
+
+	if err := validateTemplateParams(variables, params); err != nil {
+		return "", err
+	}
+
+	return templateVariablePattern.ReplaceAllStringFunc(template.Content, func(match string) string {
+		name := templateVariablePattern.FindStringSubmatch(match)[1]
+		return params[name]
+	}), nil
+}
+
+// syncTemplateStatus 向服务商查询审核中模板的最新状态
+func (t *TemplateService) syncTemplateStatus(template *models.SMSTemplate) {
+	if template.Status != "pending" || template.TemplateCode == "" {
+		return
+	}
+
+	status, err := t.smsService.QueryTemplate(template.TemplateCode)
+	if err != nil {
+		log.Printf("同步模板状态失败 %s: %v", template.TemplateCode, err)
+		return
+	}
+	if status.Status == template.Status {
+		return
+	}
+
+	template.Status = status.Status
+	template.RejectReason = status.Reason
+	template.UpdatedAt = time.Now()
+	config.DB.Save(template)
+}
+
+// buildTemplateVariables 从模板内容中提取变量，并合并用户声明的类型
+func buildTemplateVariables(content string, declared []TemplateVariable) ([]TemplateVariable, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, fmt.Errorf("模板内容不能为空")
+	}
+
+	declaredByName := make(map[string]TemplateVariable, len(declared))
+	for _, v := range declared {
+		declaredByName[v.Name] = v
+	}
+
+	var variables []TemplateVariable
+	seen := make(map[string]bool)
+	for _, match := range templateVariablePattern.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		variable, ok := declaredByName[name]
+		if !ok {
+			variable = TemplateVariable{Name: name}
+		}
+		if variable.Type == "" {
+			variable.Type = "string"
+		}
+		switch variable.Type {
+		case "string", "number", "phone", "date":
+		default:
+			return nil, fmt.Errorf("变量 %s 的类型 %s 不受支持", name, variable.Type)
+		}
+		variables = append(variables, variable)
+	}
+
+	for name := range declaredByName {
+		if !seen[name] {
+			return nil, fmt.Errorf("变量 %s 未在模板内容中使用", name)
+		}
+	}
+
+	return variables, nil
+}
+
+// validateTemplateParams 校验参数是否与模板变量一一对应且类型正确
+func validateTemplateParams(variables []TemplateVariable, params map[string]string) error {
+	known := make(map[string]bool, len(variables))
+	for _, v := range variables {
+		known[v.Name] = true
+
+		value, ok := params[v.Name]
+		if !ok || strings.TrimSpace(value) == "" {
+			return templateParamError("缺少模板参数: %s", v.Name)
+		}
+		if v.MaxLength > 0 && len([]rune(value)) > v.MaxLength {
+			return templateParamError("模板参数 %s 超过%d个字符", v.Name, v.MaxLength)
+		}
+		if templateParamLength(value) > MaxTemplateParamLength {
+			return templateParamError("模板参数 %s 超过服务商%d个字符限制", v.Name, MaxTemplateParamLength)
+		}
+
+		switch v.Type {
+		case "number":
+			if strings.Trim(value, "0123456789.") != "" {
+				return templateParamError("模板参数 %s 必须为数字", v.Name)
+			}
+		case "phone":
+			if strings.Trim(value, "0123456789+-") != "" {
+				return templateParamError("模板参数 %s 必须为电话号码", v.Name)
+			}
+		case "date":
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				if _, err := time.Parse("2006-01-02 15:04", value); err != nil {
+					return templateParamError("模板参数 %s 必须为日期", v.Name)
+				}
+			}
+		}
+	}
+
+	for name := range params {
+		if !known[name] {
+			return templateParamError("未知的模板参数: %s", name)
+		}
+	}
+
+	return nil
+}
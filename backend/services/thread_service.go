@@ -0,0 +1,283 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"gorm.io/gorm"
+)
+
+var ErrThreadNotFound = errors.New("会话不存在")
+
+// ThreadSummary 会话列表项
+type ThreadSummary struct {
+	models.RelayThread
+	Recipient   string         `json:"recipient"` // 脱敏后的收件人号码
+	LastMessage *ThreadMessage `json:"last_message,omitempty"`
+	UnreadCount int64          `json:"unread_count"`
+}
+
+// ThreadMessage 会话中的一条收发消息，收件人号码已脱敏
+type ThreadMessage struct {
+	ID             string     `json:"id"`
+	ThreadID       string     `json:"thread_id"`
+	ReplyToID      string     `json:"reply_to_id"`
+	Direction      string     `json:"direction"`
+	Recipient      string     `json:"recipient"` // 收到的回复不保存号码，为空
+	CountryCode    string     `json:"country_code"`
+	Content        string     `json:"content"`
+	CharacterCount int        `json:"character_count"`
+	Cost           float64    `json:"cost"`
+	Status         string     `json:"status"`
+	ScheduledAt    *time.Time `json:"scheduled_at"`
+	SentAt         *time.Time `json:"sent_at"`
+	FailedReason   string     `json:"failed_reason"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// ThreadList 会话列表分页结果，按 (last_activity_at, id) 倒序翻页
+type ThreadList struct {
+	ListPage
+	Threads     []ThreadSummary
+	TotalUnread int64 // 未静音会话的未读总数
+}
+
+// ThreadMessages 会话消息分页结果，按 (created_at, id) 倒序翻页
+type ThreadMessages struct {
+	ListPage
+	Thread   ThreadSummary
+	Messages []ThreadMessage
+}
+
+type ThreadService struct{}
+
+func NewThreadService() *ThreadService {
+	return &ThreadService{}
+}
+
+// ListThreads 按最近活动时间获取会话列表，archived为true时只返回已归档的会话
+func (t *ThreadService) ListThreads(userID string, archived bool, opts ListOptions) (*ThreadList, error) {
+	query := config.DB.Model(&models.RelayThread{}).
+		Where("user_id = ? AND archived = ?", userID, archived)
+
+	list := &ThreadList{}
+	if opts.IncludeTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("获取会话列表失败: %v", err)
+		}
+		list.Total = &total
+	}
+
+	query, limit, err := applyCursor(query, opts, "last_activity_at")
+	if err != nil {
+		return nil, err
+	}
+
+	var threads []models.RelayThread
+	if err := query.Find(&threads).Error; err != nil {
+		return nil, fmt.Errorf("获取会话列表失败: %v", err)
+	}
+	if len(threads) > limit {
+		threads = threads[:limit]
+		last := threads[limit-1]
+		list.HasMore = true
+		list.NextCursor = encodeListCursor(last.LastActivityAt, last.ID)
+	}
+
+	unread, err := t.unreadCounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	list.Threads = make([]ThreadSummary, 0, len(threads))
+	for _, thread := range threads {
+		summary := t.summarize(thread, unread[thread.ID])
+
+		var last models.Message
+		if config.DB.Where("thread_id = ?", thread.ID).Order("created_at DESC, id DESC").First(&last).Error == nil {
+			message := threadMessage(last)
+			summary.LastMessage = &message
+		}
+		list.Threads = append(list.Threads, summary)
+	}
+
+	var muted []string
+	config.DB.Model(&models.RelayThread{}).Where("user_id = ? AND muted = ?", userID, true).Pluck("id", &muted)
+	mutedSet := make(map[string]bool, len(muted))
+	for _, id := range muted {
+		mutedSet[id] = true
+	}
+	for threadID, count := range unread {
+		if !mutedSet[threadID] {
+			list.TotalUnread += count
+		}
+	}
+
+	return list, nil
+}
+
+// GetThreadMessages 获取会话中的收发消息，游标为空时从最新一条开始
+func (t *ThreadService) GetThreadMessages(userID, threadID string, opts ListOptions) (*ThreadMessages, error) {
+	thread, err := t.getThread(userID, threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := applyDateRange(config.DB.Model(&models.Message{}).Where("thread_id = ? AND user_id = ?", thread.ID, userID), opts.StartDate, opts.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ThreadMessages{}
+	if opts.IncludeTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("获取会话消息失败: %v", err)
+		}
+		result.Total = &total
+	}
+
+	query, limit, err := applyListOptions(query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []models.Message
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("获取会话消息失败: %v", err)
+	}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[limit-1]
+		result.HasMore = true
+		result.NextCursor = encodeListCursor(last.CreatedAt, last.ID)
+	}
+
+	result.Messages = make([]ThreadMessage, 0, len(messages))
+	for _, message := range messages {
+		result.Messages = append(result.Messages, threadMessage(message))
+	}
+
+	unread, err := t.unreadCounts(userID)
+	if err != nil {
+		return nil, err
+	}
+	result.Thread = t.summarize(*thread, unread[thread.ID])
+
+	return result, nil
+}
+
+// MarkRead 将会话标记为已读
+func (t *ThreadService) MarkRead(userID, threadID string) error {
+	now := time.Now()
+	return t.updateThread(userID, threadID, map[string]interface{}{"last_read_at": &now})
+}
+
+// SetArchived 归档或取消归档会话
+func (t *ThreadService) SetArchived(userID, threadID string, archived bool) error {
+	return t.updateThread(userID, threadID, map[string]interface{}{"archived": archived})
+}
+
+// SetMuted 静音或取消静音会话，静音会话的未读数不计入总数
+func (t *ThreadService) SetMuted(userID, threadID string, muted bool) error {
+	return t.updateThread(userID, threadID, map[string]interface{}{"muted": muted})
+}
+
+func (t *ThreadService) getThread(userID, threadID string) (*models.RelayThread, error) {
+	var thread models.RelayThread
+	if err := config.DB.Where("id = ? AND user_id = ?", threadID, userID).First(&thread).Error; err != nil {
+		return nil, ErrThreadNotFound
+	}
+	return &thread, nil
+}
+
+func (t *ThreadService) updateThread(userID, threadID string, updates map[string]interface{}) error {
+	if _, err := t.getThread(userID, threadID); err != nil {
+		return err
+	}
+
+	updates["updated_at"] = time.Now()
+	if err := config.DB.Model(&models.RelayThread{}).Where("id = ?", threadID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("更新会话失败: %v", err)
+	}
+	return nil
+}
+
+// unreadCounts 统计用户各会话中最后阅读时间之后收到的回复数
+func (t *ThreadService) unreadCounts(userID string) (map[string]int64, error) {
+	var rows []struct {
+		ThreadID string
+		Count    int64
+	}
+	err := config.DB.Table("messages").
+		Select("messages.thread_id AS thread_id, COUNT(*) AS count").
+		Joins("JOIN relay_threads ON relay_threads.id = messages.thread_id").
+		Where("relay_threads.user_id = ? AND messages.direction = ?", userID, "inbound").
+		Where("relay_threads.last_read_at IS NULL OR messages.created_at > relay_threads.last_read_at").
+		Group("messages.thread_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("统计未读消息失败: %v", err)
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.ThreadID] = row.Count
+	}
+	return counts, nil
+}
+
+// threadMessage 转换为会话消息，不返回完整号码、模板参数和服务商信息
+func threadMessage(message models.Message) ThreadMessage {
+	result := ThreadMessage{
+		ID:             message.ID,
+		ThreadID:       message.ThreadID,
+		ReplyToID:      message.ReplyToID,
+		Direction:      message.Direction,
+		CountryCode:    message.CountryCode,
+		Content:        message.Content,
+		CharacterCount: message.CharacterCount,
+		Cost:           message.Cost,
+		Status:         message.Status,
+		ScheduledAt:    message.ScheduledAt,
+		SentAt:         message.SentAt,
+		FailedReason:   message.FailedReason,
+		CreatedAt:      message.CreatedAt,
+	}
+	if message.RecipientPhone != "" {
+		result.Recipient = MaskPhoneNumber(message.RecipientPhone)
+	}
+	return result
+}
+
+func (t *ThreadService) summarize(thread models.RelayThread, unread int64) ThreadSummary {
+	return ThreadSummary{
+		RelayThread: thread,
+		Recipient:   MaskPhoneNumber(thread.RecipientPhone),
+		UnreadCount: unread,
+	}
+}
+
+// MaskPhoneNumber 将完整号码脱敏，国内号码如 138****8000，其他如 +852 ****5678
+func MaskPhoneNumber(e164 string) string {
+	country := matchCountry(e164)
+	if country == nil {
+		return "****"
+	}
+
+	national := strings.TrimPrefix(e164, country.DialCode)
+	if len(national) <= 4 {
+		return "****"
+	}
+
+	tail := national[len(national)-4:]
+	if country.Code == DomesticCountryCode && len(national) == 11 {
+		return national[:3] + "****" + tail
+	}
+	return "+" + country.DialCode + " ****" + tail
+}
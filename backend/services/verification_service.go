@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"anonymous-messaging-backend/config"
+	"anonymous-messaging-backend/models"
+	"github.com/google/uuid"
+)
+
+const (
+	verificationCodeTTL       = 5 * time.Minute
+	verificationResendAfter   = time.Minute
+	verificationDailyLimit    = 10 // 每个号码每天最多发送次数
+	verificationMaxAttempts   = 5  // 每个验证码最多校验次数
+	verificationCodeLength    = 6
+	verificationTemplateParam = "code"
+)
+
+// VerifyPurposeLogin 手机号登录验证码
+const VerifyPurposeLogin = "login"
+
+var (
+	ErrVerificationTooFrequent = errors.New("验证码发送过于频繁，请稍后再试")
+	ErrVerificationLimit       = errors.New("今日验证码发送次数已达上限")
+	ErrVerificationInvalid     = errors.New("验证码错误或已过期")
+)
+
+type VerificationService struct {
+	smsService *SMSService
+}
+
+func NewVerificationService() (*VerificationService, error) {
+	smsService, err := NewSMSService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerificationService{
+		smsService: smsService,
+	}, nil
+}
+
+// SendCode 向号码发送指定用途的验证码，返回规范化后的号码
+func (v *VerificationService) SendCode(phone, purpose string) (string, error) {
+	parsed, err := ParsePhoneNumber(phone)
+	if err != nil {
+		return "", err
+	}
+	phone = parsed.String()
+
+	var last models.VerificationCode
+	err = config.DB.Where("phone = ? AND purpose = ?", phone, purpose).Order("created_at DESC").First(&last).Error
+	if err == nil && time.Since(last.CreatedAt) < verificationResendAfter {
+		return "", ErrVerificationTooFrequent
+	}
+
+	var sentToday int64
+	config.DB.Model(&models.VerificationCode{}).
+		Where("phone = ? AND created_at >= ?", phone, time.Now().Add(-24*time.Hour)).
+		Count(&sentToday)
+	if sentToday >= verificationDailyLimit {
+		return "", ErrVerificationLimit
+	}
+
+	code, err := randomDigits(verificationCodeLength)
+	if err != nil {
+		return "", err
+	}
+
+	record := &models.VerificationCode{
+		ID:        uuid.New().String(),
+		Phone:     phone,
+		Purpose:   purpose,
+		CodeHash:  hashToken(purpose + ":" + code),
+		ExpiresAt: time.Now().Add(verificationCodeTTL),
+		CreatedAt: time.Now(),
+	}
+	if err := config.DB.Create(record).Error; err != nil {
+		return "", fmt.Errorf("保存验证码失败: %v", err)
+	}
+
+	request := SMSRequest{
+		PhoneNumber: phone,
+		Content:     fmt.Sprintf("您的验证码为%s，%d分钟内有效，请勿泄露给他人。", code, int(verificationCodeTTL/time.Minute)),
+	}
+	if templateCode := RuntimeConfig().SMS().VerifyTemplateCode; templateCode != "" && parsed.IsDomestic() {
+		request.TemplateCode = templateCode
+		request.TemplateParams = map[string]string{verificationTemplateParam: code}
+	}
+
+	response, err := v.smsService.SendSMS(request)
+	if err != nil {
+		config.DB.Delete(record)
+		return "", fmt.Errorf("验证码发送失败: %v", err)
+	}
+	if !response.Success {
+		config.DB.Delete(record)
+		return "", fmt.Errorf("验证码发送失败: %s", response.Error)
+	}
+	if SMSMode() == config.ModeMock {
+		log.Printf("短信模拟模式，验证码未实际发送: phone=%s purpose=%s code=%s", phone, purpose, code)
+	}
+
+	return phone, nil
+}
+
+// VerifyCode 校验验证码，成功后验证码立即失效，返回规范化后的号码
+func (v *VerificationService) VerifyCode(phone, purpose, code string) (string, error) {
+	parsed, err := ParsePhoneNumber(phone)
+	if err != nil {
+		return "", err
+	}
+	phone = parsed.String()
+
+	var record models.VerificationCode
+	err = config.DB.Where("phone = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", phone, purpose, time.Now()).
+		Order("created_at DESC").
+		First(&record).Error
+	if err != nil || record.Attempts >= verificationMaxAttempts {
+		return "", ErrVerificationInvalid
+	}
+
+	if record.CodeHash != hashToken(purpose+":"+code) {
+		config.DB.Model(&record).Update("attempts", record.Attempts+1)
+		return "", ErrVerificationInvalid
+	}
+
+	// 条件更新，同一验证码只能使用一次
+	result := config.DB.Model(&models.VerificationCode{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return "", ErrVerificationInvalid
+	}
+
+	return phone, nil
+}
+
+func randomDigits(length int) (string, error) {
+	digits := make([]byte, length)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("生成验证码失败: %v", err)
+		}
+		digits[i] = byte('0' + n.Int64())
+	}
+	return string(digits), nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	wechatCode2SessionURL = "https://api.weixin.qq.com/sns/jscode2session"
+	wechatRequestTimeout  = 10 * time.Second
+)
+
+var (
+	ErrWechatLoginUnavailable = errors.New("未配置微信登录")
+	ErrWechatCodeInvalid      = errors.New("微信登录凭证无效或已过期")
+)
+
+// 微信返回的登录凭证错误码：40029 code 无效，40163 code 已被使用
+var wechatInvalidCodeErrors = map[int]bool{40029: true, 40163: true}
+
+type code2SessionResponse struct {
+	OpenID  string `json:"openid"`
+	UnionID string `json:"unionid"`
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// WechatAuthService 用小程序 wx.login 返回的 code 向微信换取 openid，
+// 不信任客户端直接传入的 openid
+type WechatAuthService struct {
+	client *http.Client
+}
+
+func NewWechatAuthService() *WechatAuthService {
+	return &WechatAuthService{
+		client: &http.Client{Timeout: wechatRequestTimeout},
+	}
+}
+
+// Exchange 用登录凭证换取微信身份，每个 code 只能使用一次
+func (w *WechatAuthService) Exchange(code string) (*WechatIdentity, error) {
+	if code == "" {
+		return nil, ErrWechatCodeInvalid
+	}
+
+	cfg := RuntimeConfig().Payment()
+	if cfg.AppID == "" || cfg.AppSecret == "" {
+		return nil, ErrWechatLoginUnavailable
+	}
+
+	query := url.Values{
+		"appid":      {cfg.AppID},
+		"secret":     {cfg.AppSecret},
+		"js_code":    {code},
+		"grant_type": {"authorization_code"},
+	}
+	resp, err := w.client.Get(wechatCode2SessionURL + "?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("请求微信登录接口失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result code2SessionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析微信登录结果失败: %v", err)
+	}
+	if wechatInvalidCodeErrors[result.ErrCode] {
+		return nil, ErrWechatCodeInvalid
+	}
+	if result.ErrCode != 0 || result.OpenID == "" {
+		return nil, fmt.Errorf("微信登录失败: %d %s", result.ErrCode, result.ErrMsg)
+	}
+
+	return &WechatIdentity{
+		OpenID:  result.OpenID,
+		UnionID: result.UnionID,
+	}, nil
+}
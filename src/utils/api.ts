@@ -23,7 +23,7 @@ export async function login(phone: string, loginType: 'phone' | 'wechat' = 'phon
     body: JSON.stringify({
       phone,
       login_type: loginType,
-      wechat_openid: wechatData?.openid,
+      code: wechatData?.code,
       nickname: wechatData?.nickname,
       avatar_url: wechatData?.avatar_url,
     }),
@@ -45,7 +45,7 @@ export async function login(phone: string, loginType: 'phone' | 'wechat' = 'phon
       data: {
         phone,
         login_type: loginType,
-        wechat_openid: wechatData?.openid,
+        code: wechatData?.code,
         nickname: wechatData?.nickname,
         avatar_url: wechatData?.avatar_url,
       }